@@ -15,20 +15,58 @@ import (
 	"matcha/internal/config"
 	"matcha/internal/database"
 	"matcha/internal/handlers"
+	"matcha/internal/services"
+	"matcha/internal/store"
 
 	"goji.io"
 	"goji.io/pat"
 )
 
 func main() {
+	// `matcha migrate up|down|status|force` manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrateCommand(os.Args[2:]))
+	}
+	// `matcha set-role USERNAME ROLE` grants or removes admin access and exits
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		os.Exit(runSetRoleCommand(os.Args[2:]))
+	}
+
 	// Load configuration
 	cfg := config.Load()
 
 	// Initialize database
-	if err := database.Init(cfg.DBPath); err != nil {
+	if err := database.Init(cfg.DBDriver, cfg.DSN()); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.Close()
+	if err := store.Init(cfg.DBDriver, database.DB); err != nil {
+		log.Fatalf("Failed to initialize store: %v", err)
+	}
+
+	// Bring the schema up to date, and refuse to run on a dirty, outdated or newer-than-binary schema
+	if cfg.AutoMigrate {
+		if n, err := database.MigrateUp(); err != nil {
+			log.Fatalf("Failed to apply migrations: %v", err)
+		} else if n > 0 {
+			log.Printf("Applied %d migration(s)", n)
+		}
+	}
+	if err := database.CheckSchema(); err != nil {
+		log.Fatalf("Database schema check failed: %v", err)
+	}
+
+	// Keys access tokens are signed and verified with
+	if err := services.InitJWTKeys(cfg); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	// External login providers (none is fine)
+	if err := services.InitOIDC(cfg); err != nil {
+		log.Fatalf("Failed to load OIDC providers: %v", err)
+	}
+	if err := services.InitMailer(cfg); err != nil {
+		log.Fatalf("Failed to set up mailer: %v", err)
+	}
 
 	// Create data directory if it doesn't exist
 	if err := os.MkdirAll("data", 0755); err != nil {
@@ -40,6 +78,16 @@ func main() {
 		log.Fatalf("Failed to create uploads directory: %v", err)
 	}
 
+	// Background jobs: retries for side effects stored in the outbox (notifications, fame, bot logs),
+	// removal of old login sessions, delivery of queued emails, and email digests
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	handlers.RegisterOutboxHandlers()
+	services.StartOutboxDispatcher(bgCtx)
+	services.StartSessionCleanup(bgCtx)
+	services.StartMailWorker(bgCtx, cfg)
+	services.StartDigestJob(bgCtx, cfg)
+
 	// Setup routes
 	mux := goji.NewMux()
 
@@ -79,8 +127,8 @@ func main() {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Set CORS headers for all requests
 			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 			// Handle preflight OPTIONS requests
@@ -116,6 +164,7 @@ func main() {
 	// Wait for interrupt signal
 	<-sigChan
 	log.Println("Shutting down server...")
+	stopBackground()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"matcha/internal/config"
+	"matcha/internal/database"
+)
+
+const migrateUsage = `Usage: matcha migrate <command>
+
+Commands:
+  up             Apply all pending migrations
+  down [N]       Revert the last N migrations (default 1)
+  status         List migrations and whether each is applied
+  force VERSION  Record the schema as clean at VERSION without running SQL (after fixing a dirty schema by hand)`
+
+// runMigrateCommand handles `matcha migrate ...` and returns the process exit code
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	cfg := config.Load()
+	if err := database.Init(cfg.DBDriver, cfg.DSN()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "up":
+		n, err := database.MigrateUp()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed after %d applied: %v\n", n, err)
+			return 1
+		}
+		fmt.Printf("Applied %d migration(s)\n", n)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			s, err := strconv.Atoi(args[1])
+			if err != nil || s < 1 {
+				fmt.Fprintln(os.Stderr, "down: N must be a positive number")
+				return 2
+			}
+			steps = s
+		}
+		n, err := database.MigrateDown(steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Rollback failed after %d reverted: %v\n", n, err)
+			return 1
+		}
+		fmt.Printf("Reverted %d migration(s)\n", n)
+
+	case "status":
+		states, err := database.MigrationStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read migration status: %v\n", err)
+			return 1
+		}
+		for _, s := range states {
+			status := "pending"
+			switch {
+			case s.Dirty:
+				status = "DIRTY"
+			case s.Applied && s.Up == "":
+				status = "applied (unknown to this binary)"
+			case s.Skipped:
+				status = "skipped (this binary lacks what it requires)"
+			case s.Applied:
+				status = "applied " + s.AppliedAt.String
+			}
+			fmt.Printf("%04d  %-28s %s\n", s.Version, s.Name, status)
+		}
+		if err := database.CheckSchema(); err != nil {
+			fmt.Println(err)
+		}
+
+	case "force":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "force: VERSION is required")
+			return 2
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			fmt.Fprintln(os.Stderr, "force: VERSION must be a number")
+			return 2
+		}
+		if err := database.ForceMigrationVersion(version); err != nil {
+			fmt.Fprintf(os.Stderr, "Force failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Schema recorded at version %d\n", version)
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
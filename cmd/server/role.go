@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"matcha/internal/config"
+	"matcha/internal/database"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+const setRoleUsage = `Usage: matcha set-role USERNAME ROLE
+
+ROLE is "admin" (may use the admin API routes) or "user".`
+
+// runSetRoleCommand handles `matcha set-role ...` and returns the process exit code
+func runSetRoleCommand(args []string) int {
+	if len(args) != 2 || (args[1] != models.RoleUser && args[1] != models.RoleAdmin) {
+		fmt.Fprintln(os.Stderr, setRoleUsage)
+		return 2
+	}
+
+	cfg := config.Load()
+	if err := database.Init(cfg.DBDriver, cfg.DSN()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+	if err := database.CheckSchema(); err != nil {
+		fmt.Fprintf(os.Stderr, "Database schema check failed: %v\n", err)
+		return 1
+	}
+	if err := store.Init(cfg.DBDriver, database.DB); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize store: %v\n", err)
+		return 1
+	}
+
+	found, err := store.Get().Users.SetRole(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set role: %v\n", err)
+		return 1
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "No user named %q\n", args[0])
+		return 1
+	}
+	fmt.Printf("%s is now %s\n", args[0], args[1])
+	return 0
+}
@@ -7,6 +7,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.18
 	goji.io v1.0.0
 	golang.org/x/crypto v0.28.0
+	golang.org/x/net v0.28.0
 )
 
-require golang.org/x/net v0.28.0 // indirect
+require github.com/lib/pq v1.10.9
@@ -2,15 +2,42 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
+	"github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
-// Init initializes the database connection
-func Init(dbPath string) error {
+// Supported values of config.Config.DBDriver
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Driver is the backend DB was opened with (DriverSQLite or DriverPostgres)
+var Driver = DriverSQLite
+
+// Init opens the database for the given driver. dsn is a file path for SQLite and a
+// connection URL (postgres://...) for PostgreSQL.
+func Init(driver, dsn string) error {
+	switch driver {
+	case DriverSQLite, "":
+		return initSQLite(dsn)
+	case DriverPostgres:
+		return initPostgres(dsn)
+	}
+	return fmt.Errorf("unknown database driver %q (use %q or %q)", driver, DriverSQLite, DriverPostgres)
+}
+
+// IsPostgres reports whether DB is a PostgreSQL connection
+func IsPostgres() bool {
+	return Driver == DriverPostgres
+}
+
+func initSQLite(dbPath string) error {
 	var err error
 	// Enable WAL mode for better concurrency (allows concurrent reads)
 	// Use _journal_mode=WAL to enable Write-Ahead Logging
@@ -18,6 +45,7 @@ func Init(dbPath string) error {
 	if err != nil {
 		return err
 	}
+	Driver = DriverSQLite
 
 	// Set connection pool settings for better concurrency
 	DB.SetMaxOpenConns(25) // Allow multiple connections
@@ -48,6 +76,40 @@ func Init(dbPath string) error {
 	return nil
 }
 
+func initPostgres(url string) error {
+	if url == "" {
+		return fmt.Errorf("DATABASE_URL is required for the %s driver", DriverPostgres)
+	}
+	connector, err := pq.NewConnector(url)
+	if err != nil {
+		return err
+	}
+	// Queries keep SQLite's ? placeholders; the connector rewrites them to $1, $2...
+	DB = sql.OpenDB(rebindConnector{connector})
+	Driver = DriverPostgres
+
+	DB.SetMaxOpenConns(25)
+	DB.SetMaxIdleConns(5)
+
+	if err = DB.Ping(); err != nil {
+		return err
+	}
+
+	// Postgres handles concurrent writers itself; the queue is kept so callers don't change
+	InitWriteQueue(DB)
+
+	log.Println("Database connection established (PostgreSQL)")
+	return nil
+}
+
+// HasFTS5 reports whether db's SQLite library has the FTS5 module, which go-sqlite3 only compiles in
+// with -tags sqlite_fts5. Chat search needs it.
+func HasFTS5(db *sql.DB) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_compile_options WHERE compile_options = 'ENABLE_FTS5'`).Scan(&n)
+	return n > 0, err
+}
+
 // Close closes the database connection
 func Close() error {
 	if DB != nil {
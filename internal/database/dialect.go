@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+)
+
+// The application writes SQL once, with ? placeholders and syntax both SQLite and PostgreSQL accept.
+// What differs per driver lives here (placeholders, generated ids) or in internal/store.
+
+// Rebind rewrites ? placeholders to PostgreSQL's $1, $2... Quoted strings, quoted identifiers
+// and comments are left alone.
+func Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	inLineComment, inBlockComment := false, false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case inLineComment:
+			if c == '\n' {
+				inLineComment = false
+			}
+		case inBlockComment:
+			if c == '*' && i+1 < len(query) && query[i+1] == '/' {
+				inBlockComment = false
+				b.WriteByte(c)
+				i++
+				c = query[i]
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			inLineComment = true
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			inBlockComment = true
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Execer is what *sql.DB and *sql.Tx have in common
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// InsertReturningID runs an INSERT into a table with an id primary key and returns the new id.
+// PostgreSQL has no LastInsertId, so there the id comes back through RETURNING.
+func InsertReturningID(ex Execer, query string, args ...interface{}) (int64, error) {
+	if IsPostgres() {
+		var id int64
+		err := ex.QueryRow(strings.TrimRight(strings.TrimSpace(query), ";")+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	res, err := ex.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// rebindConnector wraps the lib/pq connector so every connection rebinds placeholders
+type rebindConnector struct {
+	driver.Connector
+}
+
+func (c rebindConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &rebindConn{conn}, nil
+}
+
+// rebindConn forwards to the wrapped connection, rewriting the query of anything that takes arguments.
+// Queries without arguments (e.g. multi-statement migration scripts) are passed through untouched.
+type rebindConn struct {
+	driver.Conn
+}
+
+func (c *rebindConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(Rebind(query))
+}
+
+func (c *rebindConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, Rebind(query))
+	}
+	return c.Conn.Prepare(Rebind(query))
+}
+
+func (c *rebindConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if len(args) > 0 {
+		query = Rebind(query)
+	}
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c *rebindConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if len(args) > 0 {
+		query = Rebind(query)
+	}
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *rebindConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *rebindConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *rebindConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *rebindConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
@@ -0,0 +1,558 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"matcha/migrations"
+)
+
+// Migration is one versioned schema change from migrations/NNNN_name.{up,down}.sql
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // Empty if the migration can't be reverted
+}
+
+// MigrationState is a migration plus whether it is applied to the database
+type MigrationState struct {
+	Migration
+	Applied   bool
+	Dirty     bool
+	AppliedAt sql.NullString
+	// Skipped is an applied migration whose script didn't run because this SQLite lacks what it requires
+	Skipped bool
+}
+
+// noTransactionMarker, as the first line of a migration, runs it outside a transaction
+// (needed for statements SQLite refuses inside one, e.g. PRAGMA foreign_keys). If such a
+// migration fails halfway the schema is left dirty and the server refuses to start.
+const noTransactionMarker = "-- migrate:no-transaction"
+
+// requiresMarker, in the leading comments of a SQLite migration, names an optional SQLite feature it
+// needs (only "fts5" so far: go build -tags sqlite_fts5). Without it the migration is recorded as
+// skipped (applied_at NULL) and runs on the first `migrate up` by a binary that has the feature.
+const requiresMarker = "-- migrate:requires "
+
+// legacyBaselineVersion is the version a database set up by the old loose scripts is at once adopted
+const legacyBaselineVersion = 7
+
+// legacyScripts are the pre-versioning migrations in the order `make run-migrations` applied them
+var legacyScripts = []string{
+	"schema.sql",
+	"add_username_and_verification.sql",
+	"add_is_setup.sql",
+	"add_personality_fields.sql",
+	"add_location_updated_at.sql",
+	"add_is_bot.sql",
+	"add_blocks_and_reports.sql",
+	"add_notifications_related_user_id.sql",
+	"add_bot_activity_log.sql",
+	"remove_set_up_column.sql",
+	"add_password_reset.sql",
+	"add_message_read_receipts.sql",
+	"add_message_edits.sql",
+	"add_message_attachments.sql",
+	"add_messages_fts.sql",
+	"add_conversation_settings.sql",
+	"add_outbox.sql",
+}
+
+var (
+	ErrSchemaDirty = errors.New("database schema is dirty")
+	ErrSchemaNewer = errors.New("database schema is newer than this binary")
+	ErrSchemaOld   = errors.New("database schema has pending migrations")
+)
+
+// migrationFS holds the migration scripts (a variable so tests can use their own)
+var migrationFS fs.FS = migrations.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// migrationsDir is where the current driver's migrations live in migrationFS. PostgreSQL has its
+// own copy of every version (same numbers and names) since the DDL differs.
+func migrationsDir() string {
+	if IsPostgres() {
+		return "postgres"
+	}
+	return "."
+}
+
+// LoadMigrations reads the embedded migrations for the current driver, sorted by version
+func LoadMigrations() ([]Migration, error) {
+	dir := migrationsDir()
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := migrationFileRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		content, err := fs.ReadFile(migrationFS, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration version %d used by both %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(content)
+		} else {
+			mig.Down = string(content)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no .up.sql", mig.Version, mig.Name)
+		}
+		list = append(list, *mig)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// ensureMigrationsTable creates schema_migrations if needed
+func ensureMigrationsTable() error {
+	timestampType := "DATETIME"
+	if IsPostgres() {
+		timestampType = "TIMESTAMPTZ"
+	}
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			dirty INTEGER NOT NULL DEFAULT 0,
+			applied_at ` + timestampType + ` DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
+
+// appliedMigrations returns the rows of schema_migrations by version
+func appliedMigrations() (map[int]MigrationState, error) {
+	rows, err := DB.Query(`SELECT version, name, dirty, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationState)
+	for rows.Next() {
+		var s MigrationState
+		if err := rows.Scan(&s.Version, &s.Name, &s.Dirty, &s.AppliedAt); err != nil {
+			return nil, err
+		}
+		s.Applied = true
+		applied[s.Version] = s
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists every known migration (and any unknown applied version) with its state
+func MigrationStatus() ([]MigrationState, error) {
+	list, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	states := []MigrationState{}
+	for _, mig := range list {
+		state := MigrationState{Migration: mig}
+		if a, ok := applied[mig.Version]; ok {
+			state.Applied, state.Dirty, state.AppliedAt = true, a.Dirty, a.AppliedAt
+			delete(applied, mig.Version)
+			if !a.Dirty && !a.AppliedAt.Valid {
+				// Skipped for a missing feature: pending again once this binary has it
+				available, err := requirementAvailable(mig.Up)
+				if err != nil {
+					return nil, err
+				}
+				state.Applied, state.Skipped = !available, !available
+			}
+		}
+		states = append(states, state)
+	}
+	// Versions applied by a newer binary
+	for _, a := range applied {
+		states = append(states, a)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+// CheckSchema returns an error unless every migration this binary knows is applied and the schema is clean
+func CheckSchema() error {
+	states, err := MigrationStatus()
+	if err != nil {
+		return err
+	}
+	return checkStates(states, true)
+}
+
+// checkStates rejects dirty or newer-than-binary schemas, and (if requireCurrent) pending migrations
+func checkStates(states []MigrationState, requireCurrent bool) error {
+	for _, s := range states {
+		if s.Dirty {
+			return fmt.Errorf("%w at version %d (%s): fix it by hand, then run `matcha migrate force %d`",
+				ErrSchemaDirty, s.Version, s.Name, s.Version)
+		}
+		if s.Applied && s.Up == "" {
+			return fmt.Errorf("%w: version %d (%s) is applied but unknown", ErrSchemaNewer, s.Version, s.Name)
+		}
+		if requireCurrent && !s.Applied {
+			return fmt.Errorf("%w: version %d (%s) is not applied; run `matcha migrate up`", ErrSchemaOld, s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration in order, each in its own transaction. Returns how many ran.
+func MigrateUp() (int, error) {
+	if err := adoptLegacyDatabase(); err != nil {
+		return 0, err
+	}
+
+	states, err := MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkStates(states, false); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, s := range states {
+		if s.Applied {
+			continue
+		}
+		available, err := requirementAvailable(s.Up)
+		if err != nil {
+			return count, err
+		}
+		if !available {
+			if err := recordSkipped(s.Migration); err != nil {
+				return count, err
+			}
+			log.Printf("Skipped migration %04d_%s: SQLite has no %s (build with -tags sqlite_%s)",
+				s.Version, s.Name, migrationRequirement(s.Up), migrationRequirement(s.Up))
+			continue
+		}
+		if err := applyMigration(s.Migration, s.Up, true); err != nil {
+			return count, err
+		}
+		log.Printf("Applied migration %04d_%s", s.Version, s.Name)
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown reverts the latest `steps` applied migrations. Returns how many were reverted.
+func MigrateDown(steps int) (int, error) {
+	states, err := MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkStates(states, false); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(states) - 1; i >= 0 && count < steps; i-- {
+		s := states[i]
+		if !s.Applied {
+			continue
+		}
+		if s.Skipped {
+			// Nothing ran, so there's nothing to undo
+			if _, err := DB.Exec(`DELETE FROM schema_migrations WHERE version = ?`, s.Version); err != nil {
+				return count, err
+			}
+			log.Printf("Reverted skipped migration %04d_%s", s.Version, s.Name)
+			count++
+			continue
+		}
+		if s.Down == "" {
+			return count, fmt.Errorf("migration %04d_%s has no .down.sql and can't be reverted", s.Version, s.Name)
+		}
+		if err := applyMigration(s.Migration, s.Down, false); err != nil {
+			return count, err
+		}
+		log.Printf("Reverted migration %04d_%s", s.Version, s.Name)
+		count++
+	}
+	return count, nil
+}
+
+// ForceMigrationVersion records the schema as exactly at version (all known migrations up to it applied,
+// none after, nothing dirty) without running any SQL. For recovering from a dirty schema by hand.
+func ForceMigrationVersion(version int) error {
+	list, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	if err := ensureMigrationsTable(); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version > ?`, version); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE schema_migrations SET dirty = 0`); err != nil {
+		return err
+	}
+	for _, mig := range list {
+		if mig.Version > version {
+			break
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO schema_migrations (version, name, dirty) VALUES (?, ?, 0)
+			ON CONFLICT (version) DO NOTHING
+		`, mig.Version, mig.Name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// applyMigration runs one up or down script and records it in schema_migrations
+func applyMigration(mig Migration, script string, up bool) error {
+	record := func(exec func(string, ...interface{}) (sql.Result, error)) error {
+		var err error
+		if up {
+			_, err = exec(`
+				INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES (?, ?, 0, ?)
+				ON CONFLICT (version) DO UPDATE SET name = excluded.name, dirty = 0, applied_at = excluded.applied_at
+			`, mig.Version, mig.Name, time.Now().UTC().Truncate(time.Second))
+		} else {
+			_, err = exec(`DELETE FROM schema_migrations WHERE version = ?`, mig.Version)
+		}
+		return err
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(script), noTransactionMarker) {
+		// Mark dirty first: if the script fails halfway, the next start refuses to run on a half-migrated schema
+		if _, err := DB.Exec(`
+			INSERT INTO schema_migrations (version, name, dirty) VALUES (?, ?, 1)
+			ON CONFLICT (version) DO UPDATE SET name = excluded.name, dirty = 1
+		`, mig.Version, mig.Name); err != nil {
+			return err
+		}
+		if _, err := DB.Exec(script); err != nil {
+			return migrationError(mig, up, err)
+		}
+		return record(DB.Exec)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(script); err != nil {
+		return migrationError(mig, up, err)
+	}
+	if err := record(tx.Exec); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordSkipped records a migration as applied without running it, with a NULL applied_at so
+// MigrationStatus can tell it apart
+func recordSkipped(mig Migration) error {
+	_, err := DB.Exec(`
+		INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES (?, ?, 0, NULL)
+		ON CONFLICT (version) DO UPDATE SET name = excluded.name, dirty = 0, applied_at = NULL
+	`, mig.Version, mig.Name)
+	return err
+}
+
+// migrationRequirement returns the feature named by a requiresMarker among the leading comments of
+// script, or "" if it has none
+func migrationRequirement(script string) string {
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, requiresMarker) {
+			return strings.TrimSpace(strings.TrimPrefix(line, requiresMarker))
+		}
+		if line != "" && !strings.HasPrefix(line, "--") {
+			break
+		}
+	}
+	return ""
+}
+
+// requirementAvailable reports whether the database has the feature script requires (true if none)
+func requirementAvailable(script string) (bool, error) {
+	switch requirement := migrationRequirement(script); requirement {
+	case "":
+		return true, nil
+	case "fts5":
+		return HasFTS5(DB)
+	default:
+		return false, fmt.Errorf("unknown migration requirement %q", requirement)
+	}
+}
+
+// migrationError wraps a failed script's error with the migration it came from
+func migrationError(mig Migration, up bool, err error) error {
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	return fmt.Errorf("migration %04d_%s %s failed: %w", mig.Version, mig.Name, direction, err)
+}
+
+// adoptLegacyDatabase brings a database created by the old loose scripts (tables present, no
+// schema_migrations rows) up to date with those same scripts once, then records it at legacyBaselineVersion.
+// Like the old Makefile target, "duplicate column" / "already exists" errors are expected and skipped,
+// but only statement by statement, so nothing else in a script is lost.
+// The scripts were SQLite-only, so PostgreSQL databases never need this.
+func adoptLegacyDatabase() error {
+	if IsPostgres() {
+		return nil
+	}
+	if err := ensureMigrationsTable(); err != nil {
+		return err
+	}
+	var versions, userTables int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&versions); err != nil {
+		return err
+	}
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&userTables); err != nil {
+		return err
+	}
+	if versions > 0 || userTables == 0 {
+		return nil
+	}
+
+	log.Printf("Adopting database created before versioned migrations (baseline version %d)", legacyBaselineVersion)
+	for _, name := range legacyScripts {
+		content, err := fs.ReadFile(migrationFS, path.Join("legacy", name))
+		if err != nil {
+			return err
+		}
+		// The search index, without FTS5; its migration is marked skipped below
+		available, err := requirementAvailable(string(content))
+		if err != nil {
+			return err
+		}
+		if !available {
+			continue
+		}
+		for _, stmt := range splitSQLStatements(string(content)) {
+			if _, err := DB.Exec(stmt); err != nil {
+				msg := err.Error()
+				if strings.Contains(msg, "duplicate column name") || strings.Contains(msg, "already exists") {
+					continue
+				}
+				return fmt.Errorf("legacy migration %s failed: %w", name, err)
+			}
+		}
+	}
+	if err := ForceMigrationVersion(legacyBaselineVersion); err != nil {
+		return err
+	}
+
+	list, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	for _, mig := range list {
+		if mig.Version > legacyBaselineVersion {
+			break
+		}
+		available, err := requirementAvailable(mig.Up)
+		if err != nil {
+			return err
+		}
+		if !available {
+			if err := recordSkipped(mig); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitSQLStatements splits a script on semicolons outside quotes and comments (enough for our
+// migrations: no triggers). Comment-only statements are dropped.
+func splitSQLStatements(script string) []string {
+	statements := []string{}
+	var current strings.Builder
+	hasCode := false
+	var quote rune
+	inLineComment := false
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case inLineComment:
+			if r == '\n' {
+				inLineComment = false
+			}
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			inLineComment = true
+		case r == '\'' || r == '"':
+			quote = r
+			hasCode = true
+		case r == ';':
+			if hasCode {
+				statements = append(statements, strings.TrimSpace(current.String()))
+			}
+			current.Reset()
+			hasCode = false
+			continue
+		case !isSQLSpace(r):
+			hasCode = true
+		}
+		current.WriteRune(r)
+	}
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(current.String()))
+	}
+	return statements
+}
+
+func isSQLSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
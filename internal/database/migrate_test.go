@@ -0,0 +1,247 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"matcha/migrations"
+)
+
+// useTestDB points the runner at a fresh in-memory SQLite database and the given migrations
+func useTestDB(t *testing.T, files fs.FS) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is its own database
+	db.SetMaxOpenConns(1)
+
+	previousDB, previousFS, previousDriver := DB, migrationFS, Driver
+	DB, migrationFS, Driver = db, files, DriverSQLite
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		DB, migrationFS, Driver = previousDB, previousFS, previousDriver
+		log.SetOutput(os.Stderr)
+		db.Close()
+	})
+}
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_users.up.sql":   {Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)},
+		"0001_users.down.sql": {Data: []byte(`DROP TABLE users;`)},
+		"0002_posts.up.sql": {Data: []byte(`
+			CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER);
+			CREATE INDEX idx_posts_user ON posts(user_id);
+		`)},
+		"0002_posts.down.sql": {Data: []byte(`DROP TABLE posts;`)},
+	}
+}
+
+func tableExists(t *testing.T, name string) bool {
+	t.Helper()
+	var n int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	useTestDB(t, testMigrations())
+
+	if err := CheckSchema(); !errors.Is(err, ErrSchemaOld) {
+		t.Errorf("CheckSchema of an empty database = %v, want %v", err, ErrSchemaOld)
+	}
+	if n, err := MigrateUp(); err != nil || n != 2 {
+		t.Fatalf("MigrateUp = %d, %v; want 2", n, err)
+	}
+	if !tableExists(t, "users") || !tableExists(t, "posts") {
+		t.Fatal("tables missing after MigrateUp")
+	}
+	if err := CheckSchema(); err != nil {
+		t.Errorf("CheckSchema after MigrateUp: %v", err)
+	}
+	if n, err := MigrateUp(); err != nil || n != 0 {
+		t.Errorf("second MigrateUp = %d, %v; want 0", n, err)
+	}
+
+	if n, err := MigrateDown(1); err != nil || n != 1 {
+		t.Fatalf("MigrateDown(1) = %d, %v; want 1", n, err)
+	}
+	if tableExists(t, "posts") || !tableExists(t, "users") {
+		t.Error("MigrateDown(1) didn't revert only the latest migration")
+	}
+	if err := CheckSchema(); !errors.Is(err, ErrSchemaOld) {
+		t.Errorf("CheckSchema after MigrateDown = %v, want %v", err, ErrSchemaOld)
+	}
+
+	if n, err := MigrateDown(10); err != nil || n != 1 {
+		t.Fatalf("MigrateDown(10) = %d, %v; want 1", n, err)
+	}
+	if tableExists(t, "users") {
+		t.Error("users still exists after reverting everything")
+	}
+	if n, err := MigrateUp(); err != nil || n != 2 {
+		t.Errorf("MigrateUp after reverting = %d, %v; want 2", n, err)
+	}
+}
+
+func TestMigrateFailedScriptLeavesSchemaDirty(t *testing.T) {
+	files := testMigrations()
+	// Fails after its first statement, outside a transaction, so the table stays
+	files["0003_tags.up.sql"] = &fstest.MapFile{Data: []byte(`-- migrate:no-transaction
+		CREATE TABLE tags (id INTEGER PRIMARY KEY);
+		INSERT INTO missing_table VALUES (1);
+	`)}
+	useTestDB(t, files)
+
+	if n, err := MigrateUp(); err == nil || n != 2 {
+		t.Fatalf("MigrateUp = %d, %v; want 2 and an error", n, err)
+	}
+	if err := CheckSchema(); !errors.Is(err, ErrSchemaDirty) {
+		t.Errorf("CheckSchema = %v, want %v", err, ErrSchemaDirty)
+	}
+	if _, err := MigrateUp(); !errors.Is(err, ErrSchemaDirty) {
+		t.Errorf("MigrateUp on a dirty schema = %v, want %v", err, ErrSchemaDirty)
+	}
+	if _, err := MigrateDown(1); !errors.Is(err, ErrSchemaDirty) {
+		t.Errorf("MigrateDown on a dirty schema = %v, want %v", err, ErrSchemaDirty)
+	}
+
+	// Fixed by hand, then forced
+	if _, err := DB.Exec(`CREATE TABLE missing_table (id INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ForceMigrationVersion(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(); err != nil {
+		t.Errorf("CheckSchema after forcing: %v", err)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	useTestDB(t, testMigrations())
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An older binary, which only knows the first migration
+	migrationFS = fstest.MapFS{
+		"0001_users.up.sql":   {Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)},
+		"0001_users.down.sql": {Data: []byte(`DROP TABLE users;`)},
+	}
+	if err := CheckSchema(); !errors.Is(err, ErrSchemaNewer) {
+		t.Errorf("CheckSchema = %v, want %v", err, ErrSchemaNewer)
+	}
+	if _, err := MigrateUp(); !errors.Is(err, ErrSchemaNewer) {
+		t.Errorf("MigrateUp = %v, want %v", err, ErrSchemaNewer)
+	}
+	if _, err := MigrateDown(1); !errors.Is(err, ErrSchemaNewer) {
+		t.Errorf("MigrateDown = %v, want %v", err, ErrSchemaNewer)
+	}
+	if !tableExists(t, "posts") {
+		t.Error("the newer migration was reverted")
+	}
+}
+
+func TestMigrateSkipsUnavailableFeature(t *testing.T) {
+	files := testMigrations()
+	files["0003_search.up.sql"] = &fstest.MapFile{Data: []byte(`-- migrate:requires fts5
+		CREATE VIRTUAL TABLE posts_fts USING fts5(body);
+	`)}
+	files["0003_search.down.sql"] = &fstest.MapFile{Data: []byte(`DROP TABLE posts_fts;`)}
+	useTestDB(t, files)
+	hasFTS5, err := HasFTS5(DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasFTS5 {
+		if n != 3 || !tableExists(t, "posts_fts") {
+			t.Errorf("with FTS5: MigrateUp = %d, want 3 with posts_fts created", n)
+		}
+	} else {
+		if n != 2 || tableExists(t, "posts_fts") {
+			t.Errorf("without FTS5: MigrateUp = %d, want 2 without posts_fts", n)
+		}
+		states, err := MigrationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := states[2]; !s.Applied || !s.Skipped {
+			t.Errorf("status of the search migration: applied %v, skipped %v; want both", s.Applied, s.Skipped)
+		}
+	}
+	if err := CheckSchema(); err != nil {
+		t.Errorf("CheckSchema: %v", err)
+	}
+	if n, err := MigrateDown(1); err != nil || n != 1 {
+		t.Errorf("MigrateDown(1) = %d, %v; want 1", n, err)
+	}
+	if tableExists(t, "posts_fts") || !tableExists(t, "posts") {
+		t.Error("MigrateDown(1) didn't revert only the search migration")
+	}
+}
+
+func TestMigrateAdoptsLegacyDatabase(t *testing.T) {
+	useTestDB(t, migrations.FS)
+
+	// A database set up by `make run-migrations` before versioning, with a few of the scripts applied
+	// (which overlap, so it ignored "duplicate column" and "already exists" errors too)
+	for _, name := range legacyScripts[:4] {
+		content, err := fs.ReadFile(migrations.FS, path.Join("legacy", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stmt := range splitSQLStatements(string(content)) {
+			if _, err := DB.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column name") &&
+				!strings.Contains(err.Error(), "already exists") {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+	}
+	if _, err := DB.Exec(`INSERT INTO users (username, email, password_hash, first_name, last_name) VALUES ('old', 'old@example.com', 'x', 'Old', 'User')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if err := CheckSchema(); err != nil {
+		t.Errorf("CheckSchema after adoption: %v", err)
+	}
+
+	var baseline int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version <= ?`, legacyBaselineVersion).Scan(&baseline); err != nil {
+		t.Fatal(err)
+	}
+	if baseline != legacyBaselineVersion {
+		t.Errorf("%d versions recorded up to the baseline, want %d", baseline, legacyBaselineVersion)
+	}
+	// Tables of the legacy scripts that hadn't run, and the user that was there
+	if !tableExists(t, "conversation_settings") || !tableExists(t, "outbox") {
+		t.Error("tables of the remaining legacy scripts are missing")
+	}
+	var users int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM users WHERE username = 'old'`).Scan(&users); err != nil {
+		t.Fatal(err)
+	}
+	if users != 1 {
+		t.Error("the existing user was lost")
+	}
+}
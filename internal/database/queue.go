@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -20,9 +21,16 @@ type WriteQueue struct {
 type WriteOperation struct {
 	Query  string
 	Args   []interface{}
+	Tx     func(tx *sql.Tx) error // If set, run inside one transaction instead of Query
 	Result chan WriteResult
 }
 
+// Statement is one query of a batch submitted with EnqueueBatch
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
 // WriteResult contains the result of a write operation
 type WriteResult struct {
 	LastInsertID int64
@@ -33,6 +41,9 @@ type WriteResult struct {
 var writeQueue *WriteQueue
 var queueOnce sync.Once
 
+// ErrWriteQueueFull is returned when a write couldn't be queued in time; it was not run
+var ErrWriteQueueFull = errors.New("database write queue is full")
+
 // InitWriteQueue initializes the write queue system
 func InitWriteQueue(db *sql.DB) {
 	queueOnce.Do(func() {
@@ -52,77 +63,101 @@ func (wq *WriteQueue) start() {
 		for op := range wq.queue {
 			result := WriteResult{}
 			
-			// Execute the write operation
-			res, err := wq.db.Exec(op.Query, op.Args...)
-			if err != nil {
-				result.Error = err
-				log.Printf("WriteQueue error executing query: %v, error: %v", op.Query, err)
+			if op.Tx != nil {
+				result.Error = wq.runTx(op.Tx)
 			} else {
-				result.LastInsertID, _ = res.LastInsertId()
-				result.RowsAffected, _ = res.RowsAffected()
+				// Execute the write operation
+				res, err := wq.db.Exec(op.Query, op.Args...)
+				if err != nil {
+					result.Error = err
+					log.Printf("WriteQueue error executing query: %v, error: %v", op.Query, err)
+				} else {
+					result.LastInsertID, _ = res.LastInsertId()
+					result.RowsAffected, _ = res.RowsAffected()
+				}
 			}
 			
-			// Send result back (non-blocking)
-			select {
-			case op.Result <- result:
-			default:
-				// Receiver not waiting, skip
-			}
+			// Result is buffered and the submitter always waits for it, so this doesn't block
+			op.Result <- result
 		}
 	}()
 }
 
+// runTx runs fn inside a transaction, committing if it returns nil and rolling back otherwise
+func (wq *WriteQueue) runTx(fn func(tx *sql.Tx) error) error {
+	tx, err := wq.db.Begin()
+	if err != nil {
+		log.Printf("WriteQueue error starting transaction: %v", err)
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("WriteQueue error rolling back transaction: %v", rbErr)
+		}
+		log.Printf("WriteQueue transaction rolled back: %v", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("WriteQueue error committing transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
 // Enqueue adds a write operation to the queue
 func (wq *WriteQueue) Enqueue(query string, args ...interface{}) WriteResult {
-	wq.mu.Lock()
-	if wq.stopped {
-		wq.mu.Unlock()
-		return WriteResult{Error: sql.ErrConnDone}
-	}
-	wq.mu.Unlock()
+	return wq.submit(WriteOperation{Query: query, Args: args})
+}
 
-	resultChan := make(chan WriteResult, 1)
-	op := WriteOperation{
-		Query:  query,
-		Args:   args,
-		Result: resultChan,
-	}
+// EnqueueTx runs fn inside one transaction on the writer and waits for commit or rollback.
+// fn must use only tx: calling Enqueue from inside it would deadlock the queue.
+func (wq *WriteQueue) EnqueueTx(fn func(tx *sql.Tx) error) error {
+	return wq.submit(WriteOperation{Tx: fn}).Error
+}
 
-	// Try to enqueue with timeout
-	select {
-	case wq.queue <- op:
-		// Wait for result with timeout
-		select {
-		case result := <-resultChan:
-			return result
-		case <-time.After(5 * time.Second):
-			return WriteResult{Error: sql.ErrConnDone}
+// EnqueueBatch runs the statements in order inside one transaction: all are committed or none are.
+// LastInsertID is the last statement's; RowsAffected is the total.
+func (wq *WriteQueue) EnqueueBatch(statements ...Statement) WriteResult {
+	var result WriteResult
+	err := wq.EnqueueTx(func(tx *sql.Tx) error {
+		result = WriteResult{}
+		for _, stmt := range statements {
+			res, err := tx.Exec(stmt.Query, stmt.Args...)
+			if err != nil {
+				log.Printf("WriteQueue error executing batch query: %v, error: %v", stmt.Query, err)
+				return err
+			}
+			result.LastInsertID, _ = res.LastInsertId()
+			n, _ := res.RowsAffected()
+			result.RowsAffected += n
 		}
-	case <-time.After(5 * time.Second):
-		return WriteResult{Error: sql.ErrConnDone}
+		return nil
+	})
+	if err != nil {
+		return WriteResult{Error: err}
 	}
+	return result
 }
 
-// EnqueueAsync adds a write operation to the queue without waiting for result
-func (wq *WriteQueue) EnqueueAsync(query string, args ...interface{}) {
+// submit queues op and waits for its result. Once queued, op runs whatever happens, so submit waits for
+// it to finish: giving up earlier would report a failure for a write that may still be committed.
+func (wq *WriteQueue) submit(op WriteOperation) WriteResult {
 	wq.mu.Lock()
 	if wq.stopped {
 		wq.mu.Unlock()
-		return
+		return WriteResult{Error: sql.ErrConnDone}
 	}
 	wq.mu.Unlock()
 
 	resultChan := make(chan WriteResult, 1)
-	op := WriteOperation{
-		Query:  query,
-		Args:   args,
-		Result: resultChan,
-	}
+	op.Result = resultChan
 
+	// Only waiting for a place in the queue times out; op hasn't run then
 	select {
 	case wq.queue <- op:
-	default:
-		log.Printf("WriteQueue full, dropping operation: %s", query)
+		return <-resultChan
+	case <-time.After(5 * time.Second):
+		return WriteResult{Error: ErrWriteQueueFull}
 	}
 }
 
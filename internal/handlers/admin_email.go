@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"matcha/internal/models"
+	"matcha/internal/services"
+)
+
+// emailOutboxListLimit is how many emails GET /api/admin/email-outbox returns
+const emailOutboxListLimit = 50
+
+// EmailOutboxAPI handles GET /api/admin/email-outbox: the number of queued emails per status, and the
+// latest emails (filtered with ?status=pending|sent|dead)
+func EmailOutboxAPI(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.EmailPending, models.EmailSent, models.EmailDead:
+	default:
+		SendError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+	outbox, err := services.GetEmailOutboxStatus(status, emailOutboxListLimit)
+	if err != nil {
+		log.Printf("Error loading email outbox: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to load email outbox")
+		return
+	}
+	SendSuccess(w, outbox)
+}
+
+// RetryEmailAPI handles POST /api/admin/email-outbox/:id/retry, which queues a dead email again
+func RetryEmailAPI(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid email ID")
+		return
+	}
+	requeued, err := services.RetryEmail(id)
+	if err != nil {
+		log.Printf("Error requeueing email %d: %v", id, err)
+		SendError(w, http.StatusInternalServerError, "Failed to retry email")
+		return
+	}
+	if !requeued {
+		SendError(w, http.StatusNotFound, "No failed email with this ID")
+		return
+	}
+	SendSuccess(w, map[string]string{"message": "Email queued again"})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -9,74 +10,127 @@ import (
 	"goji.io/pat"
 )
 
-// SetupAPIRoutes configures all API routes (JSON responses)
+// SetupAPIRoutes configures all API routes (JSON responses). Each route declares who may call it
+// (see the access levels in middleware.go); handlers behind accessUser or above can rely on
+// requestUserID being set.
 func SetupAPIRoutes(mux *goji.Mux) {
+	handle := func(p *pat.Pattern, h http.HandlerFunc) {
+		mux.HandleFuncC(p, func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			h(w, withRouteParams(ctx, r))
+		})
+	}
+	route := func(level accessLevel, p *pat.Pattern, h http.HandlerFunc) {
+		handle(p, requireAccess(level, false, h))
+	}
+	// For URLs the browser opens without custom headers, the token may also be passed as ?token=
+	queryTokenRoute := func(level accessLevel, p *pat.Pattern, h http.HandlerFunc) {
+		handle(p, requireAccess(level, true, h))
+	}
+
 	// Health check (no auth) - for server status / devtools
-	mux.HandleFunc(pat.Get("/api/health"), HealthAPI)
-
-	// Authentication API
-	mux.HandleFunc(pat.Post("/api/register"), RegisterAPI)
-	mux.HandleFunc(pat.Post("/api/login"), LoginAPI)
-	mux.HandleFunc(pat.Post("/api/logout"), LogoutAPI)
-	mux.HandleFunc(pat.Get("/api/verify-email"), VerifyEmailAPI)
-	mux.HandleFunc(pat.Post("/api/resend-verification"), ResendVerificationAPI)
-	mux.HandleFunc(pat.Post("/api/forgot-password/send-code"), ForgotPasswordSendCodeAPI)
-	mux.HandleFunc(pat.Post("/api/forgot-password/verify"), ForgotPasswordVerifyAPI)
-	mux.HandleFunc(pat.Post("/api/forgot-password/reset"), ForgotPasswordResetAPI)
-
-	// Profile API
-	mux.HandleFunc(pat.Get("/api/profile"), ProfileAPI)
-	mux.HandleFunc(pat.Post("/api/profile"), ProfileUpdateAPI)
-	mux.HandleFunc(pat.Post("/api/profile/setup-complete"), SetupCompleteAPI)
-	mux.HandleFunc(pat.Post("/api/profile/reset"), ResetProfileAPI)
-	mux.HandleFunc(pat.Post("/api/profile/change-password"), ChangePasswordAPI)
-	mux.HandleFunc(pat.Post("/api/profile/send-password-reset-link"), SendPasswordResetLinkAPI)
-	mux.HandleFunc(pat.Post("/api/profile/upload-image"), UploadImageAPI)
-	mux.HandleFunc(pat.Post("/api/profile/reorder-images"), ReorderImagesAPI)
-	mux.HandleFunc(pat.Get("/api/profile/visitors"), ProfileVisitorsAPI)
-
-	// Browse/Search API
-	mux.HandleFunc(pat.Get("/api/browse"), BrowseAPI)
-	mux.HandleFunc(pat.Get("/api/search"), SearchAPI)
+	route(accessPublic, pat.Get("/api/health"), HealthAPI)
+
+	// Authentication API (logout finds the session itself, so it also works with an expired access token)
+	route(accessPublic, pat.Post("/api/register"), RegisterAPI)
+	route(accessPublic, pat.Post("/api/login"), LoginAPI)
+	route(accessPublic, pat.Post("/api/login/mfa"), LoginMFAAPI)
+	route(accessPublic, pat.Post("/api/login/magic-link"), MagicLinkAPI)
+	route(accessPublic, pat.Post("/api/login/magic-link/verify"), MagicLinkVerifyAPI)
+	// OpenID Connect login (start is optional-auth: a logged in user may link instead of logging in)
+	route(accessPublic, pat.Get("/api/oidc/providers"), OIDCProvidersAPI)
+	route(accessOptional, pat.Post("/api/oidc/:provider/start"), OIDCStartAPI)
+	route(accessPublic, pat.Post("/api/oidc/:provider/callback"), OIDCCallbackAPI)
+	route(accessPublic, pat.Post("/api/logout"), LogoutAPI)
+	route(accessUser, pat.Post("/api/logout/all"), LogoutAllAPI)
+	route(accessPublic, pat.Post("/api/token/refresh"), RefreshTokenAPI)
+	// Public keys for verifying access tokens (standard location, outside /api)
+	route(accessPublic, pat.Get("/.well-known/jwks.json"), JWKSAPI)
+	route(accessPublic, pat.Get("/api/verify-email"), VerifyEmailAPI)
+	route(accessPublic, pat.Post("/api/resend-verification"), ResendVerificationAPI)
+	route(accessPublic, pat.Post("/api/forgot-password/send-code"), ForgotPasswordSendCodeAPI)
+	route(accessPublic, pat.Post("/api/forgot-password/verify"), ForgotPasswordVerifyAPI)
+	route(accessPublic, pat.Post("/api/forgot-password/reset"), ForgotPasswordResetAPI)
+	route(accessPublic, pat.Post("/api/email-change/confirm"), EmailChangeConfirmAPI)
+	route(accessPublic, pat.Post("/api/email-change/revert"), EmailChangeRevertAPI)
+	route(accessPublic, pat.Post("/api/email-digest/unsubscribe"), DigestUnsubscribeAPI)
+
+	// Profile API (also used during profile setup)
+	route(accessUser, pat.Get("/api/profile"), ProfileAPI)
+	route(accessUser, pat.Post("/api/profile"), ProfileUpdateAPI)
+	route(accessUser, pat.Post("/api/profile/setup-complete"), SetupCompleteAPI)
+	route(accessUser, pat.Post("/api/profile/reset"), ResetProfileAPI)
+	route(accessUser, pat.Post("/api/profile/change-password"), ChangePasswordAPI)
+	route(accessUser, pat.Post("/api/profile/send-password-reset-link"), SendPasswordResetLinkAPI)
+	route(accessUser, pat.Delete("/api/profile/email-change"), CancelEmailChangeAPI)
+	route(accessUser, pat.Get("/api/profile/2fa"), TwoFactorStatusAPI)
+	route(accessUser, pat.Post("/api/profile/2fa/setup"), TwoFactorSetupAPI)
+	route(accessUser, pat.Post("/api/profile/2fa/confirm"), TwoFactorConfirmAPI)
+	route(accessUser, pat.Post("/api/profile/2fa/disable"), TwoFactorDisableAPI)
+	route(accessUser, pat.Post("/api/profile/2fa/recovery-codes"), TwoFactorRecoveryCodesAPI)
+	route(accessUser, pat.Get("/api/profile/identities"), IdentitiesAPI)
+	route(accessUser, pat.Delete("/api/profile/identities/:provider"), UnlinkIdentityAPI)
+	route(accessUser, pat.Post("/api/profile/upload-image"), UploadImageAPI)
+	route(accessUser, pat.Post("/api/profile/reorder-images"), ReorderImagesAPI)
+	route(accessMember, pat.Get("/api/profile/visitors"), ProfileVisitorsAPI)
+
+	// Browse/Search API (results are personalized when logged in)
+	route(accessOptional, pat.Get("/api/browse"), BrowseAPI)
+	route(accessOptional, pat.Get("/api/search"), SearchAPI)
 
 	// User profile API
-	mux.HandleFunc(pat.Get("/api/user/:id"), UserProfileAPI)
+	route(accessOptional, pat.Get("/api/user/:id"), UserProfileAPI)
 
 	// Like/Unlike API
-	mux.HandleFunc(pat.Post("/api/like/:id"), LikeAPI)
-	mux.HandleFunc(pat.Post("/api/unlike/:id"), UnlikeAPI)
-	mux.HandleFunc(pat.Get("/api/connections"), ConnectionsAPI)
+	route(accessMember, pat.Post("/api/like/:id"), LikeAPI)
+	route(accessMember, pat.Post("/api/unlike/:id"), UnlikeAPI)
+	route(accessMember, pat.Get("/api/connections"), ConnectionsAPI)
 
 	// Block/Report API
-	mux.HandleFunc(pat.Post("/api/block/:id"), BlockUserAPI)
-	mux.HandleFunc(pat.Post("/api/unblock/:id"), UnblockUserAPI)
-	mux.HandleFunc(pat.Post("/api/report/:id"), ReportUserAPI)
-	mux.HandleFunc(pat.Post("/api/simulate-connection/:id"), SimulateConnectionAPI)
+	route(accessMember, pat.Post("/api/block/:id"), BlockUserAPI)
+	route(accessMember, pat.Post("/api/unblock/:id"), UnblockUserAPI)
+	route(accessMember, pat.Post("/api/report/:id"), ReportUserAPI)
+	route(accessAdmin, pat.Post("/api/simulate-connection/:id"), SimulateConnectionAPI)
+
+	// Email queue (admin)
+	route(accessAdmin, pat.Get("/api/admin/email-outbox"), EmailOutboxAPI)
+	route(accessAdmin, pat.Post("/api/admin/email-outbox/:id/retry"), RetryEmailAPI)
 
 	// Chat API
-	mux.HandleFunc(pat.Get("/api/chat"), ChatListAPI)
-	mux.HandleFunc(pat.Get("/api/messages/:id"), MessagesAPI)
-	mux.HandleFunc(pat.Post("/api/messages/:id"), SendMessageAPI)
+	route(accessMember, pat.Get("/api/chat"), ChatListAPI)
+	route(accessMember, pat.Get("/api/chat/search"), ChatSearchAPI)
+	route(accessMember, pat.Patch("/api/chat/:id"), ConversationSettingsAPI)
+	route(accessMember, pat.Get("/api/messages/:id"), MessagesAPI)
+	route(accessMember, pat.Post("/api/messages/:id"), SendMessageAPI)
+	route(accessMember, pat.Post("/api/messages/:id/read"), ReadReceiptAPI)
+	route(accessMember, pat.Post("/api/messages/:id/typing"), TypingAPI)
+	route(accessMember, pat.Post("/api/messages/:id/attachments"), SendAttachmentAPI)
+	queryTokenRoute(accessMember, pat.Get("/api/attachments/:id"), AttachmentAPI)
+	route(accessMember, pat.Patch("/api/messages/:id/:message_id"), EditMessageAPI)
+	route(accessMember, pat.Delete("/api/messages/:id/:message_id"), DeleteMessageAPI)
+
+	// Real-time chat events (WebSocket)
+	queryTokenRoute(accessMember, pat.Get("/api/ws"), WebSocketAPI)
 
 	// Notifications API (mark-all-read is literal; :id/read is parameterized)
-	mux.HandleFunc(pat.Get("/api/notifications"), NotificationsAPI)
-	mux.HandleFunc(pat.Post("/api/notifications/mark-all-read"), MarkAllNotificationsReadAPI)
-	mux.HandleFunc(pat.Post("/api/notifications/:id/read"), MarkNotificationReadAPI)
+	route(accessUser, pat.Get("/api/notifications"), NotificationsAPI)
+	queryTokenRoute(accessUser, pat.Get("/api/notifications/stream"), NotificationsStreamAPI)
+	route(accessUser, pat.Post("/api/notifications/mark-all-read"), MarkAllNotificationsReadAPI)
+	route(accessUser, pat.Post("/api/notifications/:id/read"), MarkNotificationReadAPI)
 
 	// Tags API
-	mux.HandleFunc(pat.Get("/api/tags/popular"), PopularTagsAPI)
-	mux.HandleFunc(pat.Get("/api/tags/user-match"), UserTagMatchAPI)
-	mux.HandleFunc(pat.Post("/api/tags/add"), AddTagAPI)
-	mux.HandleFunc(pat.Post("/api/tags/remove"), RemoveTagAPI)
+	route(accessPublic, pat.Get("/api/tags/popular"), PopularTagsAPI)
+	route(accessUser, pat.Get("/api/tags/user-match"), UserTagMatchAPI)
+	route(accessUser, pat.Post("/api/tags/add"), AddTagAPI)
+	route(accessUser, pat.Post("/api/tags/remove"), RemoveTagAPI)
 
 	// Trends API (popular tags, personality, gender, orientation)
-	mux.HandleFunc(pat.Get("/api/trends"), TrendsAPI)
+	route(accessPublic, pat.Get("/api/trends"), TrendsAPI)
 
 	// Bot Activity API
-	mux.HandleFunc(pat.Get("/api/bot-activity"), BotActivityLogAPI)
+	route(accessMember, pat.Get("/api/bot-activity"), BotActivityLogAPI)
 
 	// Ranking API
-	mux.HandleFunc(pat.Get("/api/ranking"), RankingAPI)
+	route(accessPublic, pat.Get("/api/ranking"), RankingAPI)
 }
 
 // SetupFrontendRoutes serves the React app (catch-all)
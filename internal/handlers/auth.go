@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"errors"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"matcha/internal/config"
-	"matcha/internal/database"
+	"matcha/internal/models"
 	"matcha/internal/services"
+	"matcha/internal/store"
 )
 
 // RegisterRequest represents registration request
@@ -19,8 +26,9 @@ type RegisterRequest struct {
 
 // LoginRequest represents login request
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"` // Username or email
+	Password   string `json:"password"`
+	UseCookies bool   `json:"use_cookies"` // Return the tokens as HttpOnly cookies (see auth_cookies.go)
 }
 
 // RegisterAPI handles registration API endpoint
@@ -37,6 +45,12 @@ func RegisterAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Users log in with their username or email, so a username must not look like an email
+	if strings.Contains(req.Username, "@") {
+		SendError(w, http.StatusBadRequest, "Username cannot contain @")
+		return
+	}
+
 	// Validate password strength (basic check - at least 8 characters)
 	if len(req.Password) < 8 {
 		SendError(w, http.StatusBadRequest, "Password must be at least 8 characters long")
@@ -51,7 +65,8 @@ func RegisterAPI(w http.ResponseWriter, r *http.Request) {
 
 	// Create user
 	cfg := config.Load()
-	user, token, err := services.CreateUser(req.Username, req.Email, req.Password, req.FirstName, req.LastName)
+	user, token, err := services.CreateUser(req.Username, req.Email, req.Password, req.FirstName, req.LastName,
+		services.MatchLocale(r.Header.Get("Accept-Language")))
 	if err != nil {
 		if err.Error() == "username already exists" {
 			SendError(w, http.StatusConflict, "Username already taken")
@@ -67,7 +82,7 @@ func RegisterAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Send verification email
-	if err := services.SendVerificationEmail(cfg, user.Email, token); err != nil {
+	if err := services.SendVerificationEmail(cfg, user.Email, user.Locale, token); err != nil {
 		log.Printf("Error sending verification email: %v", err)
 		// Don't fail registration if email fails - user can request resend
 	}
@@ -88,7 +103,7 @@ func LoginAPI(w http.ResponseWriter, r *http.Request) {
 
 	// Validate input - just check required fields
 	if req.Username == "" {
-		SendError(w, http.StatusBadRequest, "Username is required")
+		SendError(w, http.StatusBadRequest, "Username or email is required")
 		return
 	}
 
@@ -97,9 +112,18 @@ func LoginAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rateLimited(w, services.LimitLoginPerIP, clientIP(r)) || rateLimited(w, services.LimitLoginPerAccount, req.Username) {
+		return
+	}
+
 	// Authenticate user - this will check if username exists and password matches
 	user, err := services.AuthenticateUser(req.Username, req.Password)
 	if err != nil {
+		var locked *services.AccountLockedError
+		if errors.As(err, &locked) {
+			sendTooManyRequests(w, time.Until(locked.Until), err.Error())
+			return
+		}
 		SendError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
@@ -114,23 +138,160 @@ func LoginAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := services.GenerateToken(user.ID)
+	completeLogin(w, r, user, req.UseCookies)
+}
+
+// MagicLinkRequest for POST /api/login/magic-link
+type MagicLinkRequest struct {
+	Username string `json:"username"` // Username or email
+}
+
+// MagicLinkVerifyRequest for POST /api/login/magic-link/verify
+type MagicLinkVerifyRequest struct {
+	Token      string `json:"token"`
+	UseCookies bool   `json:"use_cookies"`
+}
+
+// MagicLinkAPI handles POST /api/login/magic-link: emails a passwordless sign-in link. The answer is
+// the same whether or not the account exists.
+func MagicLinkAPI(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Load()
+	if !cfg.MagicLinkLogin {
+		SendError(w, http.StatusNotFound, "Sign-in links are disabled")
+		return
+	}
+	var req MagicLinkRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(req.Username) == "" {
+		SendError(w, http.StatusBadRequest, "Username or email is required")
+		return
+	}
+	if rateLimited(w, services.LimitMagicLinkPerIP, clientIP(r)) ||
+		rateLimited(w, services.LimitMagicLinkPerAccount, strings.TrimSpace(req.Username)) {
+		return
+	}
+
+	if err := services.SendMagicLinkEmail(cfg, req.Username, clientIP(r)); err != nil && err != services.ErrNoMagicLinkAccount {
+		log.Printf("Error sending sign-in link: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to send sign-in link")
+		return
+	}
+	SendSuccess(w, map[string]interface{}{"message": "If a verified account exists, a sign-in link is on its way."})
+}
+
+// MagicLinkVerifyAPI handles POST /api/login/magic-link/verify: uses up a sign-in link and logs in.
+// It's a POST (made by the frontend page the link opens) so that mail scanners fetching the link don't
+// use it up.
+func MagicLinkVerifyAPI(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Load()
+	if !cfg.MagicLinkLogin {
+		SendError(w, http.StatusNotFound, "Sign-in links are disabled")
+		return
+	}
+	var req MagicLinkVerifyRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if rateLimited(w, services.LimitLoginPerIP, clientIP(r)) {
+		return
+	}
+
+	user, err := services.ConsumeMagicLink(req.Token)
+	if err == services.ErrMagicLinkInvalid {
+		SendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error using sign-in link: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+	completeLogin(w, r, user, req.UseCookies)
+}
+
+// LoginMFARequest for POST /api/login/mfa
+type LoginMFARequest struct {
+	MFAToken   string `json:"mfa_token"`
+	Code       string `json:"code"` // TOTP code or recovery code
+	UseCookies bool   `json:"use_cookies"`
+}
+
+// LoginMFAAPI handles POST /api/login/mfa, the second step of logging in with two-factor authentication
+func LoginMFAAPI(w http.ResponseWriter, r *http.Request) {
+	var req LoginMFARequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.MFAToken == "" || req.Code == "" {
+		SendError(w, http.StatusBadRequest, "mfa_token and code are required")
+		return
+	}
+	if rateLimited(w, services.LimitLoginPerIP, clientIP(r)) {
+		return
+	}
+
+	user, err := services.CompleteMFALogin(req.MFAToken, req.Code)
+	if err != nil {
+		var locked *services.AccountLockedError
+		switch {
+		case errors.As(err, &locked):
+			sendTooManyRequests(w, time.Until(locked.Until), err.Error())
+		case err == services.ErrMFAChallengeInvalid, err == services.ErrInvalidTOTPCode:
+			SendError(w, http.StatusUnauthorized, err.Error())
+		default:
+			log.Printf("Error completing MFA login: %v", err)
+			SendError(w, http.StatusInternalServerError, "Failed to log in")
+		}
+		return
+	}
+	finishLogin(w, r, user, req.UseCookies)
+}
+
+// completeLogin starts a session for an authenticated user and sends its tokens, as JSON or cookies.
+// If the user has two-factor authentication it sends an MFA challenge instead; the client has to come
+// back with a code (POST /api/login/mfa).
+func completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, useCookies bool) {
+	if user.TOTPEnabled {
+		challenge, err := services.IssueMFAChallenge(user.ID)
+		if err != nil {
+			log.Printf("Error issuing MFA challenge: %v", err)
+			SendError(w, http.StatusInternalServerError, "Failed to generate session")
+			return
+		}
+		SendSuccess(w, map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"expires_in":   int(services.MFAChallengeTTL.Seconds()),
+		})
+		return
+	}
+	finishLogin(w, r, user, useCookies)
+}
+
+// finishLogin starts the session of a user who passed every login step
+func finishLogin(w http.ResponseWriter, r *http.Request, user *models.User, useCookies bool) {
+	// Start a session: short-lived access token plus refresh token
+	cfg := config.Load()
+	tokens, err := services.StartSession(cfg, user.ID, r.UserAgent(), clientIP(r))
 	if err != nil {
-		log.Printf("Error generating token: %v", err)
+		log.Printf("Error starting session: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to generate session")
 		return
 	}
 
 	// Set user as online and update last_seen
-	_, err = database.DB.Exec("UPDATE users SET is_online = 1, last_seen = CURRENT_TIMESTAMP WHERE id = ?", user.ID)
-	if err != nil {
+	if err := store.Get().Users.SetPresence(user.ID, true); err != nil {
 		log.Printf("Error setting user online: %v", err)
 		// Don't fail login if online status update fails
 	}
 
-	SendSuccess(w, map[string]interface{}{
-		"token": token,
+	resp := map[string]interface{}{
+		"expires_in": tokens.ExpiresIn,
 		"user": map[string]interface{}{
 			"id":            user.ID,
 			"username":      user.Username,
@@ -138,7 +299,74 @@ func LoginAPI(w http.ResponseWriter, r *http.Request) {
 			"is_setup":      user.IsSetup,
 			"email_verified": true,
 		},
-	})
+	}
+	if useCookies {
+		csrfToken, err := setAuthCookies(w, cfg, tokens)
+		if err != nil {
+			log.Printf("Error generating CSRF token: %v", err)
+			SendError(w, http.StatusInternalServerError, "Failed to generate session")
+			return
+		}
+		resp["csrf_token"] = csrfToken
+	} else {
+		resp["token"] = tokens.AccessToken
+		resp["refresh_token"] = tokens.RefreshToken
+	}
+	SendSuccess(w, resp)
+}
+
+// RefreshTokenRequest for POST /api/token/refresh and POST /api/logout
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokenAPI handles POST /api/token/refresh: exchanges a refresh token for a new access and
+// refresh token. The old refresh token stops working; using it again ends the session. Cookie clients
+// send no body and get new cookies back.
+func RefreshTokenAPI(w http.ResponseWriter, r *http.Request) {
+	var req RefreshTokenRequest
+	fromCookie := false
+	if err := ParseJSONBody(r, &req); err != nil || req.RefreshToken == "" {
+		req.RefreshToken = cookieValue(r, refreshTokenCookie)
+		if req.RefreshToken == "" && err != nil {
+			SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		fromCookie = req.RefreshToken != ""
+	}
+	if fromCookie {
+		if err := checkCSRF(r); err != nil {
+			SendError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	cfg := config.Load()
+	tokens, err := services.RefreshSession(cfg, req.RefreshToken)
+	if err == services.ErrRefreshTokenInvalid || err == services.ErrRefreshTokenReused {
+		if fromCookie {
+			clearAuthCookies(w, cfg)
+		}
+		SendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error refreshing session: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to refresh session")
+		return
+	}
+
+	if fromCookie {
+		csrfToken, err := setAuthCookies(w, cfg, tokens)
+		if err != nil {
+			log.Printf("Error generating CSRF token: %v", err)
+			SendError(w, http.StatusInternalServerError, "Failed to refresh session")
+			return
+		}
+		SendSuccess(w, map[string]interface{}{"expires_in": tokens.ExpiresIn, "csrf_token": csrfToken})
+		return
+	}
+	SendSuccess(w, tokens)
 }
 
 // ResendVerificationAPI handles resending verification email
@@ -155,6 +383,9 @@ func ResendVerificationAPI(w http.ResponseWriter, r *http.Request) {
 		SendError(w, http.StatusBadRequest, "Username is required")
 		return
 	}
+	if rateLimited(w, services.LimitResendVerification, clientIP(r)) {
+		return
+	}
 
 	cfg := config.Load()
 	if err := services.ResendVerificationEmail(req.Username, cfg); err != nil {
@@ -214,6 +445,10 @@ func ForgotPasswordSendCodeAPI(w http.ResponseWriter, r *http.Request) {
 		SendError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if rateLimited(w, services.LimitResetSendPerIP, clientIP(r)) ||
+		rateLimited(w, services.LimitResetSendPerAccount, strings.TrimSpace(req.Email)) {
+		return
+	}
 	cfg := config.Load()
 	if err := services.ForgotPasswordSendCode(req.Email, cfg); err != nil {
 		msg := err.Error()
@@ -235,6 +470,10 @@ func ForgotPasswordVerifyAPI(w http.ResponseWriter, r *http.Request) {
 		SendError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if rateLimited(w, services.LimitResetVerifyPerIP, clientIP(r)) ||
+		rateLimited(w, services.LimitResetVerifyPerAccount, strings.TrimSpace(req.Email)) {
+		return
+	}
 	resetToken, err := services.ForgotPasswordVerify(req.Email, req.Code)
 	if err != nil {
 		SendError(w, http.StatusBadRequest, err.Error())
@@ -257,21 +496,154 @@ func ForgotPasswordResetAPI(w http.ResponseWriter, r *http.Request) {
 	SendSuccess(w, map[string]interface{}{"message": "Password has been reset. You can now log in."})
 }
 
-// LogoutAPI handles logout API endpoint
+// LogoutAPI handles logout API endpoint. It ends the session of the access token, or of the refresh
+// token in the body or cookie (so a client whose access token already expired can still log out),
+// and clears the auth cookies.
 func LogoutAPI(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from token to update online status
-	userID, err := getUserIDFromRequest(r)
-	if err == nil && userID > 0 {
+	var userID, sessionID int64
+	claims, err := getClaimsFromRequest(r)
+	if err == errCSRFMismatch {
+		SendError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err == nil {
+		userID, sessionID = claims.UserID, claims.SessionID
+	} else {
+		var req RefreshTokenRequest
+		if ParseJSONBody(r, &req) != nil || req.RefreshToken == "" {
+			// A cookie client whose access token expired
+			req.RefreshToken = cookieValue(r, refreshTokenCookie)
+			if req.RefreshToken != "" && checkCSRF(r) != nil {
+				SendError(w, http.StatusForbidden, errCSRFMismatch.Error())
+				return
+			}
+		}
+		if req.RefreshToken != "" {
+			if session, err := services.SessionForRefreshToken(req.RefreshToken); err == nil {
+				userID, sessionID = session.UserID, session.ID
+			}
+		}
+	}
+	clearAuthCookies(w, config.Load())
+
+	if sessionID > 0 {
+		if err := services.EndSession(sessionID); err != nil {
+			log.Printf("Error ending session %d: %v", sessionID, err)
+			SendError(w, http.StatusInternalServerError, "Failed to log out")
+			return
+		}
+	}
+	if userID > 0 {
 		// Set user as offline and update last_seen
-		_, err = database.DB.Exec("UPDATE users SET is_online = 0, last_seen = CURRENT_TIMESTAMP WHERE id = ?", userID)
-		if err != nil {
+		if err := store.Get().Users.SetPresence(userID, false); err != nil {
 			log.Printf("Error setting user offline: %v", err)
 			// Don't fail logout if online status update fails
 		}
 	}
 
-	// JWT tokens are stateless, so logout is handled client-side by removing the token
 	SendSuccess(w, map[string]interface{}{
 		"message": "Logged out successfully",
 	})
 }
+
+// LogoutAllAPI handles POST /api/logout/all: ends every session of the current user, this one included
+func LogoutAllAPI(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	ended, err := services.EndAllSessions(userID, 0, services.SessionRevokedLogoutAll)
+	if err != nil {
+		log.Printf("Error ending sessions of user %d: %v", userID, err)
+		SendError(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+	clearAuthCookies(w, config.Load())
+	if err := store.Get().Users.SetPresence(userID, false); err != nil {
+		log.Printf("Error setting user offline: %v", err)
+	}
+
+	SendSuccess(w, map[string]interface{}{
+		"message":        "Logged out on all devices",
+		"sessions_ended": ended,
+	})
+}
+
+// JWKSAPI handles GET /.well-known/jwks.json: the public keys access tokens are signed with, so other
+// services can verify them. Empty when only HS256 keys are configured.
+func JWKSAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	SendJSON(w, http.StatusOK, map[string]interface{}{"keys": services.JWKS()})
+}
+
+// rateLimited takes a token from the bucket of rule for key and, if there was none, answers 429
+func rateLimited(w http.ResponseWriter, rule services.RateLimit, key string) bool {
+	ok, retryAfter := services.AllowRequest(rule, key)
+	if ok {
+		return false
+	}
+	sendTooManyRequests(w, retryAfter, "Too many requests, try again later")
+	return true
+}
+
+// sendTooManyRequests answers 429 with a Retry-After header in whole seconds
+func sendTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	SendError(w, http.StatusTooManyRequests, message)
+}
+
+// clientIP returns the address the request came from, without the port. When that is a trusted proxy
+// (config TrustedProxies), it is the address the proxies report instead: the last one in
+// X-Forwarded-For that isn't a trusted proxy itself, or X-Real-IP.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies := config.Load().TrustedProxies
+	if !isTrustedProxy(host, proxies) {
+		return host
+	}
+
+	// Each proxy appends the address it got the request from, so read right to left. Anything left of
+	// the first untrusted address was written by the client and can't be believed.
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := ""
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		client = addr
+		if !isTrustedProxy(addr, proxies) {
+			return addr
+		}
+	}
+	if client != "" {
+		return client
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return host
+}
+
+// isTrustedProxy reports whether addr is one of the proxies, given as addresses or CIDR ranges
+func isTrustedProxy(addr string, proxies []string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"matcha/internal/config"
+	"matcha/internal/services"
+)
+
+// Cookie authentication: a client that logs in with "use_cookies": true gets its tokens as HttpOnly
+// cookies instead of in the response body, so page scripts never see them. Because the browser sends
+// those cookies by itself, state-changing requests authenticated by cookie must also echo the CSRF
+// cookie in the X-CSRF-Token header (double submit): a cross-site page can make the browser send the
+// cookies, but can't read the CSRF cookie to copy it into a header.
+
+const (
+	accessTokenCookie  = "matcha_access"
+	refreshTokenCookie = "matcha_refresh"
+	csrfCookie         = "matcha_csrf" // Readable by scripts, so they can send it back as csrfHeader
+	csrfHeader         = "X-CSRF-Token"
+)
+
+var errCSRFMismatch = errors.New("missing or invalid CSRF token")
+
+// setAuthCookies stores a token pair in cookies, with a new CSRF token, and returns the CSRF token
+func setAuthCookies(w http.ResponseWriter, cfg *config.Config, tokens *services.TokenPair) (string, error) {
+	csrfToken, err := services.GenerateVerificationToken()
+	if err != nil {
+		return "", err
+	}
+	maxAge := int(cfg.RefreshTokenTTL.Seconds())
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		MaxAge:   tokens.ExpiresIn,
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Only ever needed by the refresh and logout endpoints
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    tokens.RefreshToken,
+		Path:     "/api",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   cfg.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return csrfToken, nil
+}
+
+// clearAuthCookies removes the cookies set by setAuthCookies
+func clearAuthCookies(w http.ResponseWriter, cfg *config.Config) {
+	for name, path := range map[string]string{accessTokenCookie: "/", refreshTokenCookie: "/api", csrfCookie: "/"} {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: path, MaxAge: -1, Secure: cfg.CookieSecure})
+	}
+}
+
+// cookieValue returns the value of a cookie, "" if the request doesn't have it
+func cookieValue(r *http.Request, name string) string {
+	if c, err := r.Cookie(name); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// checkCSRF verifies the double-submit token of a cookie-authenticated request. Safe methods don't
+// change anything, so they pass without one.
+func checkCSRF(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	cookie := cookieValue(r, csrfCookie)
+	header := r.Header.Get(csrfHeader)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+		return errCSRFMismatch
+	}
+	return nil
+}
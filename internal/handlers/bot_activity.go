@@ -1,12 +1,12 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
-	"matcha/internal/database"
+	"matcha/internal/store"
 )
 
 // BotActivityLogAPI handles GET /api/bot-activity
@@ -28,10 +28,7 @@ func BotActivityLogAPI(w http.ResponseWriter, r *http.Request) {
 	offset := (page - 1) * limit
 
 	// Get total count
-	var total int
-	err := database.DB.QueryRow(`
-		SELECT COUNT(*) FROM bot_activity_log
-	`).Scan(&total)
+	total, err := store.Get().BotActivity.Count()
 	if err != nil {
 		log.Printf("Error counting bot activity: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load activity log")
@@ -39,91 +36,44 @@ func BotActivityLogAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get activity logs with user names
-	rows, err := database.DB.Query(`
-		SELECT 
-			bal.id,
-			bal.bot_id,
-			bal.bot_username,
-			COALESCE(bot_user.first_name || ' ' || bot_user.last_name, bal.bot_username) as bot_display_name,
-			bal.action_type,
-			bal.target_user_id,
-			bal.target_username,
-			COALESCE(target_user.first_name || ' ' || target_user.last_name, bal.target_username) as target_display_name,
-			bal.details,
-			bal.created_at
-		FROM bot_activity_log bal
-		LEFT JOIN users bot_user ON bal.bot_id = bot_user.id
-		LEFT JOIN users target_user ON bal.target_user_id = target_user.id
-		ORDER BY bal.created_at DESC
-		LIMIT ? OFFSET ?
-	`, limit, offset)
+	logged, err := store.Get().BotActivity.List(limit, offset)
 	if err != nil {
 		log.Printf("Error querying bot activity: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load activity log")
 		return
 	}
-	defer rows.Close()
 
 	activities := []map[string]interface{}{}
-	for rows.Next() {
-		var activity struct {
-			ID                int64
-			BotID             int64
-			BotUsername       string
-			BotDisplayName    sql.NullString
-			ActionType        string
-			TargetUserID      sql.NullInt64
-			TargetUsername    sql.NullString
-			TargetDisplayName sql.NullString
-			Details           sql.NullString
-			CreatedAt         string
-		}
-
-		err := rows.Scan(
-			&activity.ID,
-			&activity.BotID,
-			&activity.BotUsername,
-			&activity.BotDisplayName,
-			&activity.ActionType,
-			&activity.TargetUserID,
-			&activity.TargetUsername,
-			&activity.TargetDisplayName,
-			&activity.Details,
-			&activity.CreatedAt,
-		)
-		if err != nil {
-			continue
-		}
-
+	for _, activity := range logged {
 		activityMap := map[string]interface{}{
-			"id":            activity.ID,
-			"bot_id":        activity.BotID,
-			"bot_username":  activity.BotUsername,
-			"action_type":   activity.ActionType,
-			"created_at":    activity.CreatedAt,
+			"id":           activity.ID,
+			"bot_id":       activity.BotID,
+			"bot_username": activity.BotUsername,
+			"action_type":  activity.ActionType,
+			"created_at":   activity.CreatedAt.Format(time.RFC3339Nano),
 		}
 
 		// Use display name if available, otherwise fall back to username
-		if activity.BotDisplayName.Valid && activity.BotDisplayName.String != "" {
-			activityMap["bot_display_name"] = activity.BotDisplayName.String
+		if activity.BotDisplayName != "" {
+			activityMap["bot_display_name"] = activity.BotDisplayName
 		} else {
 			activityMap["bot_display_name"] = activity.BotUsername
 		}
 
-		if activity.TargetUserID.Valid {
-			activityMap["target_user_id"] = activity.TargetUserID.Int64
+		if activity.TargetUserID != nil {
+			activityMap["target_user_id"] = *activity.TargetUserID
 		}
-		if activity.TargetUsername.Valid {
-			activityMap["target_username"] = activity.TargetUsername.String
+		if activity.TargetUsername != nil {
+			activityMap["target_username"] = *activity.TargetUsername
 			// Use display name if available, otherwise fall back to username
-			if activity.TargetDisplayName.Valid && activity.TargetDisplayName.String != "" {
-				activityMap["target_display_name"] = activity.TargetDisplayName.String
+			if activity.TargetDisplayName != "" {
+				activityMap["target_display_name"] = activity.TargetDisplayName
 			} else {
-				activityMap["target_display_name"] = activity.TargetUsername.String
+				activityMap["target_display_name"] = *activity.TargetUsername
 			}
 		}
-		if activity.Details.Valid {
-			activityMap["details"] = activity.Details.String
+		if activity.Details != nil {
+			activityMap["details"] = *activity.Details
 		}
 
 		activities = append(activities, activityMap)
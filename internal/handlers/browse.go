@@ -10,8 +10,8 @@ import (
 	"strings"
 	"time"
 
-	"matcha/internal/database"
-	"matcha/internal/services"
+	"matcha/internal/models"
+	"matcha/internal/store"
 )
 
 // normalizeEmptyString returns "-" if the string is empty, otherwise returns the string
@@ -22,6 +22,36 @@ func normalizeEmptyString(s string) string {
 	return s
 }
 
+// optionalTime returns nil for the zero time, so it is left out of responses
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// newProfileCard lists u with its tags
+func newProfileCard(u *models.User, tags []string) models.ProfileCard {
+	if tags == nil {
+		tags = []string{}
+	}
+	return models.ProfileCard{
+		ID:             u.ID,
+		Username:       normalizeEmptyString(u.Username),
+		FirstName:      normalizeEmptyString(u.FirstName),
+		LastName:       normalizeEmptyString(u.LastName),
+		Age:            u.Age(),
+		Gender:         normalizeEmptyString(u.Gender),
+		Biography:      normalizeEmptyString(u.Biography),
+		Location:       normalizeEmptyString(u.Location),
+		FameRating:     u.FameRating,
+		IsOnline:       u.IsOnline,
+		LastSeen:       optionalTime(u.LastSeen),
+		ProfilePicture: u.ProfilePicture,
+		Tags:           tags,
+	}
+}
+
 // haversineDistance calculates the distance between two points on Earth in kilometers
 func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	const earthRadiusKm = 6371.0
@@ -107,7 +137,7 @@ func isMBTIHarmonic(mbti1, mbti2 string) bool {
 
 // profileWithScore holds a profile with its sorting scores
 type profileWithScore struct {
-	profile        map[string]interface{}
+	profile        models.ProfileCard
 	distanceZone   int
 	distanceKm     float64
 	tagMatches     int
@@ -115,10 +145,82 @@ type profileWithScore struct {
 	isMBTIHarmonic bool
 }
 
+// loadViewer returns the logged-in user (nil if there is none) and their tags, for filtering and sorting
+func loadViewer(currentUserID int64) (*models.User, []string) {
+	if currentUserID <= 0 {
+		return nil, nil
+	}
+	viewer, err := store.Get().Users.GetByID(currentUserID)
+	if err != nil {
+		log.Printf("Error loading user %d for browsing: %v", currentUserID, err)
+		// Still leave out the viewer, their connections and blocks
+		return &models.User{ID: currentUserID}, nil
+	}
+	tags, err := store.Get().Tags.List(currentUserID)
+	if err != nil {
+		tags = nil
+	}
+	return viewer, tags
+}
+
+// discoverFilter builds the store filter shared by browse and search from the viewer and the query parameters
+func discoverFilter(viewer *models.User, minAge, maxAge, fameRatingMin string) store.DiscoverFilter {
+	var f store.DiscoverFilter
+
+	// Orientation filtering: limit results by current user's sexual preference (and optionally gender) from profile
+	// - If current user wants "male": show profiles with gender="male" AND (they want current user's gender OR "both" OR NULL)
+	// - If current user wants "female": show profiles with gender="female" AND (they want current user's gender OR "both" OR NULL)
+	// - If current user wants "both" or NULL: show profiles that want current user's gender OR "both" OR NULL (requires current user gender)
+	// If only sexual_preference is set (no gender): still filter by preferred gender so /matcha and discover respect preference.
+	if viewer != nil {
+		f.ViewerID = viewer.ID
+		if pref := strings.ToLower(strings.TrimSpace(viewer.SexualPreference)); pref == "male" || pref == "female" {
+			f.Gender = pref
+		}
+		f.SeekingGender = strings.ToLower(strings.TrimSpace(viewer.Gender))
+	}
+
+	if n, err := strconv.Atoi(minAge); err == nil {
+		f.MinAge = n
+	}
+	if n, err := strconv.Atoi(maxAge); err == nil {
+		f.MaxAge = n
+	}
+	if v, err := strconv.ParseFloat(fameRatingMin, 64); err == nil {
+		f.MinFame = v
+	}
+	return f
+}
+
+// scoreProfile lists u and computes the scores browse and search sort by
+func scoreProfile(viewer *models.User, viewerTags []string, u *models.User, tags []string) profileWithScore {
+	p := profileWithScore{
+		profile:      newProfileCard(u, tags),
+		distanceZone: 3, // Default to Antarctica
+	}
+	if viewer == nil {
+		return p
+	}
+
+	if viewer.HasLocation() && u.HasLocation() {
+		p.distanceKm = haversineDistance(*viewer.Latitude, *viewer.Longitude, *u.Latitude, *u.Longitude)
+		p.distanceZone = getDistanceZone(p.distanceKm)
+		rounded := math.Round(p.distanceKm*10) / 10
+		p.profile.DistanceKm = &rounded
+	}
+
+	if len(viewerTags) > 0 && len(tags) > 0 {
+		p.tagMatches, p.hasCommonTags = calculateTagSimilarity(viewerTags, tags)
+	}
+
+	p.isMBTIHarmonic = isMBTIHarmonic(viewer.MBTI, u.MBTI)
+	return p
+}
+
 // BrowseAPI handles GET /api/browse
 func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 	// Get current user ID (optional - for filtering)
-	currentUserID, _ := getUserIDFromRequest(r)
+	currentUserID := requestUserID(r)
 
 	// Update online status and last_seen sporadically if user is authenticated
 	if currentUserID > 0 {
@@ -150,275 +252,32 @@ func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get current user's location, MBTI, gender, and sexual_preference for filtering and sorting
-	var currentUserLat, currentUserLon sql.NullFloat64
-	var currentUserMBTI sql.NullString
-	var currentUserGender, currentUserSexualPreference sql.NullString
-	var currentUserTags []string
-
-	if currentUserID > 0 {
-		err := database.DB.QueryRow(`
-			SELECT latitude, longitude, mbti, gender, sexual_preference
-			FROM users
-			WHERE id = ?
-		`, currentUserID).Scan(&currentUserLat, &currentUserLon, &currentUserMBTI, &currentUserGender, &currentUserSexualPreference)
-		if err == nil {
-			// Get current user's tags
-			tagRows, err := database.DB.Query("SELECT tag FROM user_tags WHERE user_id = ?", currentUserID)
-			if err == nil {
-				defer tagRows.Close()
-				for tagRows.Next() {
-					var tag string
-					if err := tagRows.Scan(&tag); err == nil {
-						currentUserTags = append(currentUserTags, tag)
-					}
-				}
-			}
-		}
-	}
-
-	// Build query - get all users first, then sort in memory
-	query := `
-		SELECT 
-			u.id, u.username, u.first_name, u.last_name, u.gender, u.biography,
-			u.birth_date, u.location, u.fame_rating, u.is_online, u.last_seen,
-			u.latitude, u.longitude, u.mbti, u.sexual_preference,
-			(SELECT file_path FROM user_pictures WHERE user_id = u.id AND is_profile = 1 AND order_index = 0 LIMIT 1) as profile_picture
-		FROM users u
-		WHERE u.is_setup = 1 AND u.is_email_verified = 1
-	`
-	args := []interface{}{}
-
-	// Exclude current user if logged in
-	if currentUserID > 0 {
-		query += " AND u.id != ?"
-		args = append(args, currentUserID)
-
-		// Exclude users who have a mutual like (connection) with current user
-		// A mutual like means: current user liked them AND they liked current user back
-		query += ` AND NOT EXISTS (
-			SELECT 1 FROM likes l1
-			WHERE l1.from_user_id = ? AND l1.to_user_id = u.id
-			AND EXISTS (
-				SELECT 1 FROM likes l2
-				WHERE l2.from_user_id = u.id AND l2.to_user_id = ?
-			)
-		)`
-		args = append(args, currentUserID, currentUserID)
-
-		// Exclude blocked users: neither I block them nor they block me
-		query += ` AND NOT EXISTS (
-			SELECT 1 FROM blocks b
-			WHERE (b.blocker_id = ? AND b.blocked_id = u.id)
-			OR (b.blocker_id = u.id AND b.blocked_id = ?)
-		)`
-		args = append(args, currentUserID, currentUserID)
-	}
-
-	// Orientation filtering: limit results by current user's sexual preference (and optionally gender) from profile
-	// - If current user wants "male": show profiles with gender="male" AND (they want current user's gender OR "both" OR NULL)
-	// - If current user wants "female": show profiles with gender="female" AND (they want current user's gender OR "both" OR NULL)
-	// - If current user wants "both" or NULL: show profiles that want current user's gender OR "both" OR NULL (requires current user gender)
-	// If only sexual_preference is set (no gender): still filter by preferred gender so /matcha and discover respect preference.
-	if currentUserID > 0 {
-		currentUserPref := strings.ToLower(strings.TrimSpace(currentUserSexualPreference.String))
-		currentUserGen := ""
-		if currentUserGender.Valid {
-			currentUserGen = strings.ToLower(strings.TrimSpace(currentUserGender.String))
-		}
-		if currentUserPref == "" {
-			currentUserPref = "both"
-		}
-
-		if currentUserPref == "male" {
-			query += " AND u.gender = 'male'"
-			if currentUserGen != "" {
-				query += " AND (u.sexual_preference = ? OR u.sexual_preference = 'both' OR u.sexual_preference IS NULL OR u.sexual_preference = '')"
-				args = append(args, currentUserGen)
-			}
-		} else if currentUserPref == "female" {
-			query += " AND u.gender = 'female'"
-			if currentUserGen != "" {
-				query += " AND (u.sexual_preference = ? OR u.sexual_preference = 'both' OR u.sexual_preference IS NULL OR u.sexual_preference = '')"
-				args = append(args, currentUserGen)
-			}
-		} else {
-			if currentUserGen != "" {
-				query += " AND (u.sexual_preference = ? OR u.sexual_preference = 'both' OR u.sexual_preference IS NULL OR u.sexual_preference = '')"
-				args = append(args, currentUserGen)
-			}
-		}
-	}
-
-	// Age filtering
-	if minAge != "" {
-		if minAgeInt, err := strconv.Atoi(minAge); err == nil {
-			maxBirthYear := time.Now().Year() - minAgeInt
-			query += " AND (u.birth_date IS NULL OR CAST(strftime('%Y', u.birth_date) AS INTEGER) <= ?)"
-			args = append(args, maxBirthYear)
-		}
-	}
-	if maxAge != "" {
-		if maxAgeInt, err := strconv.Atoi(maxAge); err == nil {
-			minBirthYear := time.Now().Year() - maxAgeInt
-			query += " AND (u.birth_date IS NULL OR CAST(strftime('%Y', u.birth_date) AS INTEGER) >= ?)"
-			args = append(args, minBirthYear)
-		}
-	}
-
-	// Fame rating minimum filter (SQL-based)
-	if fameRatingMinStr != "" {
-		if fameRatingMin, err := strconv.ParseFloat(fameRatingMinStr, 64); err == nil && fameRatingMin > 0 {
-			query += " AND u.fame_rating >= ?"
-			args = append(args, fameRatingMin)
-		}
-	}
+	// Get current user's location, MBTI, gender, sexual_preference and tags for filtering and sorting
+	viewer, viewerTags := loadViewer(currentUserID)
 
+	// Get all users first, then sort in memory
+	filter := discoverFilter(viewer, minAge, maxAge, fameRatingMinStr)
 	// For SQL-based sorting (fame), use SQL ORDER BY
 	// For age, location and tags, we'll sort in memory to handle nulls properly
-	if sortParam == "fame" {
-		query += " ORDER BY u.fame_rating DESC"
-	}
-	// age, location and tags sorting will be done in memory
+	filter.OrderByFame = sortParam == "fame"
 
-	rows, err := database.DB.Query(query, args...)
+	users, err := store.Get().Users.Discover(filter)
 	if err != nil {
 		log.Printf("Error querying users: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load profiles")
 		return
 	}
-	defer rows.Close()
 
 	profilesWithScores := []profileWithScore{}
-
-	for rows.Next() {
-		var user struct {
-			ID               int64
-			Username         string
-			FirstName        string
-			LastName         string
-			Gender           sql.NullString
-			Biography        sql.NullString
-			BirthDate        sql.NullString
-			Location         sql.NullString
-			FameRating       float64
-			IsOnline         bool
-			LastSeen         sql.NullString
-			Latitude         sql.NullFloat64
-			Longitude        sql.NullFloat64
-			MBTI             sql.NullString
-			SexualPreference sql.NullString
-			ProfilePicture   sql.NullString
-		}
-
-		err := rows.Scan(
-			&user.ID, &user.Username, &user.FirstName, &user.LastName,
-			&user.Gender, &user.Biography, &user.BirthDate, &user.Location,
-			&user.FameRating, &user.IsOnline, &user.LastSeen,
-			&user.Latitude, &user.Longitude, &user.MBTI, &user.SexualPreference, &user.ProfilePicture,
-		)
-		if err != nil {
-			log.Printf("Error scanning user: %v", err)
-			continue
-		}
-
-		// Calculate age
-		age := 0
-		if user.BirthDate.Valid {
-			if birthDate, err := time.Parse("2006-01-02", user.BirthDate.String); err == nil {
-				age = time.Now().Year() - birthDate.Year()
-				if time.Now().Before(time.Date(time.Now().Year(), birthDate.Month(), birthDate.Day(), 0, 0, 0, 0, time.UTC)) {
-					age--
-				}
-			}
-		}
-
+	for i := range users {
 		// Get tags
-		tagRows, err := database.DB.Query("SELECT tag FROM user_tags WHERE user_id = ?", user.ID)
-		tags := []string{}
-		if err == nil {
-			defer tagRows.Close()
-			for tagRows.Next() {
-				var tag string
-				if err := tagRows.Scan(&tag); err == nil {
-					tags = append(tags, tag)
-				}
-			}
+		tags, err := store.Get().Tags.List(users[i].ID)
+		if err != nil {
+			tags = []string{}
 		}
 
 		// Calculate distance, tag similarity, and MBTI harmony
-		distanceKm := 0.0
-		distanceZone := 3 // Default to Antarctica
-		tagMatches := 0
-		hasCommonTags := false
-		mbtiHarmonic := false
-
-		if currentUserLat.Valid && currentUserLon.Valid && user.Latitude.Valid && user.Longitude.Valid {
-			distanceKm = haversineDistance(
-				currentUserLat.Float64, currentUserLon.Float64,
-				user.Latitude.Float64, user.Longitude.Float64,
-			)
-			distanceZone = getDistanceZone(distanceKm)
-		}
-
-		if len(currentUserTags) > 0 && len(tags) > 0 {
-			tagMatches, hasCommonTags = calculateTagSimilarity(currentUserTags, tags)
-		}
-
-		if currentUserMBTI.Valid && user.MBTI.Valid {
-			mbtiHarmonic = isMBTIHarmonic(currentUserMBTI.String, user.MBTI.String)
-		}
-
-		profile := map[string]interface{}{
-			"id":          user.ID,
-			"username":    normalizeEmptyString(user.Username),
-			"first_name":  normalizeEmptyString(user.FirstName),
-			"last_name":   normalizeEmptyString(user.LastName),
-			"age":         age,
-			"fame_rating": user.FameRating,
-			"is_online":   user.IsOnline,
-			"tags":        tags,
-			"distance_km": distanceKm,
-		}
-
-		// Handle nullable fields
-		if user.Location.Valid {
-			profile["location"] = normalizeEmptyString(user.Location.String)
-		} else {
-			profile["location"] = "-"
-		}
-
-		if user.LastSeen.Valid {
-			profile["last_seen"] = normalizeEmptyString(user.LastSeen.String)
-		} else {
-			profile["last_seen"] = "-"
-		}
-
-		if user.ProfilePicture.Valid {
-			profile["profile_picture"] = normalizeEmptyString(user.ProfilePicture.String)
-		} else {
-			profile["profile_picture"] = ""
-		}
-
-		if user.Gender.Valid {
-			profile["gender"] = normalizeEmptyString(user.Gender.String)
-		} else {
-			profile["gender"] = "-"
-		}
-		if user.Biography.Valid {
-			profile["biography"] = normalizeEmptyString(user.Biography.String)
-		} else {
-			profile["biography"] = "-"
-		}
-
-		profilesWithScores = append(profilesWithScores, profileWithScore{
-			profile:        profile,
-			distanceZone:   distanceZone,
-			distanceKm:     distanceKm,
-			tagMatches:     tagMatches,
-			hasCommonTags:  hasCommonTags,
-			isMBTIHarmonic: mbtiHarmonic,
-		})
+		profilesWithScores = append(profilesWithScores, scoreProfile(viewer, viewerTags, &users[i], tags))
 	}
 
 	// Apply filters
@@ -467,8 +326,8 @@ func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 		// Sort by age - youngest first, users with no age last
 		sort.Slice(profilesWithScores, func(i, j int) bool {
 			pi, pj := profilesWithScores[i], profilesWithScores[j]
-			piAge := pi.profile["age"].(int)
-			pjAge := pj.profile["age"].(int)
+			piAge := pi.profile.Age
+			pjAge := pj.profile.Age
 
 			// Users with no age (age == 0) go last
 			if piAge == 0 && pjAge > 0 {
@@ -485,8 +344,8 @@ func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 		// Sort by age - oldest first, users with no age last
 		sort.Slice(profilesWithScores, func(i, j int) bool {
 			pi, pj := profilesWithScores[i], profilesWithScores[j]
-			piAge := pi.profile["age"].(int)
-			pjAge := pj.profile["age"].(int)
+			piAge := pi.profile.Age
+			pjAge := pj.profile.Age
 
 			// Users with no age (age == 0) go last
 			if piAge == 0 && pjAge > 0 {
@@ -502,7 +361,7 @@ func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 	} else if sortParam == "location" {
 		// Sort by location (distance) - closest first
 		// Only sort by location if current user has location
-		if currentUserID > 0 && currentUserLat.Valid && currentUserLon.Valid {
+		if viewer != nil && viewer.HasLocation() {
 			sort.Slice(profilesWithScores, func(i, j int) bool {
 				pi, pj := profilesWithScores[i], profilesWithScores[j]
 				// Check if distance was actually calculated
@@ -537,8 +396,8 @@ func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 		// Sort by common tags - most matches first, users with no tags last
 		sort.Slice(profilesWithScores, func(i, j int) bool {
 			pi, pj := profilesWithScores[i], profilesWithScores[j]
-			piTags := pi.profile["tags"].([]string)
-			pjTags := pj.profile["tags"].([]string)
+			piTags := pi.profile.Tags
+			pjTags := pj.profile.Tags
 
 			// Users with no tags go last
 			if len(piTags) == 0 && len(pjTags) > 0 {
@@ -553,12 +412,12 @@ func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 				return pi.tagMatches > pj.tagMatches
 			}
 			// If same tag matches, sort by fame rating
-			return pi.profile["fame_rating"].(float64) > pj.profile["fame_rating"].(float64)
+			return pi.profile.FameRating > pj.profile.FameRating
 		})
 	} else if sortParam == "" {
 		// Default sorting: distance zone, then tag similarity, then MBTI harmony, then fame
 		// Only apply if user is logged in and has location
-		if currentUserID > 0 && currentUserLat.Valid && currentUserLon.Valid {
+		if viewer != nil && viewer.HasLocation() {
 			sort.Slice(profilesWithScores, func(i, j int) bool {
 				pi, pj := profilesWithScores[i], profilesWithScores[j]
 
@@ -585,14 +444,14 @@ func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 				}
 
 				// 4. Remainder: sort by fame rating
-				return pi.profile["fame_rating"].(float64) > pj.profile["fame_rating"].(float64)
+				return pi.profile.FameRating > pj.profile.FameRating
 			})
 		}
 	}
 	// For "fame", "age_asc", "age_desc" - already sorted by SQL
 
 	// Extract profiles and apply pagination
-	profiles := []map[string]interface{}{}
+	profiles := []models.ProfileCard{}
 	start := offset
 	end := offset + limit
 	if start > len(profilesWithScores) {
@@ -606,13 +465,13 @@ func BrowseAPI(w http.ResponseWriter, r *http.Request) {
 		profiles = append(profiles, profilesWithScores[i].profile)
 	}
 
-	SendSuccess(w, map[string]interface{}{
-		"profiles": profiles,
-		"sort":     sortParam,
-		"minAge":   minAge,
-		"maxAge":   maxAge,
-		"limit":    limit,
-		"offset":   offset,
+	SendSuccess(w, models.DiscoverPage{
+		Profiles: profiles,
+		Sort:     sortParam,
+		MinAge:   minAge,
+		MaxAge:   maxAge,
+		Limit:    limit,
+		Offset:   offset,
 	})
 }
 
@@ -637,7 +496,7 @@ func SearchAPI(w http.ResponseWriter, r *http.Request) {
 	// The cleanest approach: extract buildBrowseQuery + processResults, call from both.
 	// For minimal change: implement SearchAPI inline, reusing the query structure.
 
-	currentUserID, _ := getUserIDFromRequest(r)
+	currentUserID := requestUserID(r)
 	if currentUserID > 0 {
 		ensureUserIsOnline(currentUserID)
 		updateLastSeenSporadically(currentUserID)
@@ -666,248 +525,64 @@ func SearchAPI(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var currentUserLat, currentUserLon sql.NullFloat64
-	var currentUserMBTI sql.NullString
-	var currentUserGender, currentUserSexualPreference sql.NullString
-	var currentUserTags []string
-
-	if currentUserID > 0 {
-		err := database.DB.QueryRow(`
-			SELECT latitude, longitude, mbti, gender, sexual_preference
-			FROM users WHERE id = ?
-		`, currentUserID).Scan(&currentUserLat, &currentUserLon, &currentUserMBTI, &currentUserGender, &currentUserSexualPreference)
-		if err == nil {
-			tagRows, err := database.DB.Query("SELECT tag FROM user_tags WHERE user_id = ?", currentUserID)
-			if err == nil {
-				defer tagRows.Close()
-				for tagRows.Next() {
-					var tag string
-					if err := tagRows.Scan(&tag); err == nil {
-						currentUserTags = append(currentUserTags, tag)
-					}
-				}
-			}
-		}
-	}
-
-	query := `
-		SELECT u.id, u.username, u.first_name, u.last_name, u.gender, u.biography,
-			u.birth_date, u.location, u.fame_rating, u.is_online, u.last_seen,
-			u.latitude, u.longitude, u.mbti, u.sexual_preference,
-			(SELECT file_path FROM user_pictures WHERE user_id = u.id AND is_profile = 1 AND order_index = 0 LIMIT 1) as profile_picture
-		FROM users u
-		WHERE u.is_setup = 1 AND u.is_email_verified = 1
-	`
-	args := []interface{}{}
-
-	if currentUserID > 0 {
-		query += " AND u.id != ?"
-		args = append(args, currentUserID)
-		query += ` AND NOT EXISTS (
-			SELECT 1 FROM likes l1 WHERE l1.from_user_id = ? AND l1.to_user_id = u.id
-			AND EXISTS (SELECT 1 FROM likes l2 WHERE l2.from_user_id = u.id AND l2.to_user_id = ?)
-		)`
-		args = append(args, currentUserID, currentUserID)
-		query += ` AND NOT EXISTS (
-			SELECT 1 FROM blocks b
-			WHERE (b.blocker_id = ? AND b.blocked_id = u.id) OR (b.blocker_id = u.id AND b.blocked_id = ?)
-		)`
-		args = append(args, currentUserID, currentUserID)
-	}
-
-	if currentUserID > 0 {
-		currentUserPref := strings.ToLower(strings.TrimSpace(currentUserSexualPreference.String))
-		currentUserGen := ""
-		if currentUserGender.Valid {
-			currentUserGen = strings.ToLower(strings.TrimSpace(currentUserGender.String))
-		}
-		if currentUserPref == "" {
-			currentUserPref = "both"
-		}
-		if currentUserPref == "male" {
-			query += " AND u.gender = 'male'"
-			if currentUserGen != "" {
-				query += " AND (u.sexual_preference = ? OR u.sexual_preference = 'both' OR u.sexual_preference IS NULL OR u.sexual_preference = '')"
-				args = append(args, currentUserGen)
-			}
-		} else if currentUserPref == "female" {
-			query += " AND u.gender = 'female'"
-			if currentUserGen != "" {
-				query += " AND (u.sexual_preference = ? OR u.sexual_preference = 'both' OR u.sexual_preference IS NULL OR u.sexual_preference = '')"
-				args = append(args, currentUserGen)
-			}
-		} else {
-			if currentUserGen != "" {
-				query += " AND (u.sexual_preference = ? OR u.sexual_preference = 'both' OR u.sexual_preference IS NULL OR u.sexual_preference = '')"
-				args = append(args, currentUserGen)
-			}
-		}
-	}
+	viewer, viewerTags := loadViewer(currentUserID)
 
-	if minAge != "" {
-		if minAgeInt, err := strconv.Atoi(minAge); err == nil {
-			maxBirthYear := time.Now().Year() - minAgeInt
-			query += " AND (u.birth_date IS NULL OR CAST(strftime('%Y', u.birth_date) AS INTEGER) <= ?)"
-			args = append(args, maxBirthYear)
-		}
-	}
-	if maxAge != "" {
-		if maxAgeInt, err := strconv.Atoi(maxAge); err == nil {
-			minBirthYear := time.Now().Year() - maxAgeInt
-			query += " AND (u.birth_date IS NULL OR CAST(strftime('%Y', u.birth_date) AS INTEGER) >= ?)"
-			args = append(args, minBirthYear)
-		}
-	}
-	if fameRatingMinStr != "" {
-		if fameRatingMin, err := strconv.ParseFloat(fameRatingMinStr, 64); err == nil && fameRatingMin > 0 {
-			query += " AND u.fame_rating >= ?"
-			args = append(args, fameRatingMin)
-		}
-	}
+	filter := discoverFilter(viewer, minAge, maxAge, fameRatingMinStr)
+	filter.OrderByFame = sortParam == "fame"
 
 	// Search-specific: filter by tags (user must have at least one)
-	if tagsParam != "" {
-		tagList := strings.Split(tagsParam, ",")
-		var validTags []string
-		for _, t := range tagList {
-			t = strings.TrimSpace(strings.ToLower(t))
-			if t != "" {
-				validTags = append(validTags, t)
-			}
-		}
-		if len(validTags) > 0 {
-			placeholders := make([]string, len(validTags))
-			for i := range validTags {
-				placeholders[i] = "?"
-				args = append(args, validTags[i])
-			}
-			query += " AND EXISTS (SELECT 1 FROM user_tags ut WHERE ut.user_id = u.id AND LOWER(ut.tag) IN (" + strings.Join(placeholders, ",") + "))"
+	for _, t := range strings.Split(tagsParam, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			filter.AnyTags = append(filter.AnyTags, t)
 		}
 	}
 
 	// Search-specific: filter by location (case-insensitive contains)
-	if locationParam != "" {
-		query += " AND (u.location IS NOT NULL AND u.location != '' AND LOWER(u.location) LIKE ?)"
-		args = append(args, "%"+strings.ToLower(locationParam)+"%")
-	}
-
-	if sortParam == "fame" {
-		query += " ORDER BY u.fame_rating DESC"
-	}
+	filter.LocationContains = locationParam
 
-	rows, err := database.DB.Query(query, args...)
+	users, err := store.Get().Users.Discover(filter)
 	if err != nil {
 		log.Printf("SearchAPI: query error %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to search")
 		return
 	}
-	defer rows.Close()
 
-	profilesWithScores := []profileWithScore{}
-	for rows.Next() {
-		var user struct {
-			ID               int64
-			Username         string
-			FirstName        string
-			LastName         string
-			Gender           sql.NullString
-			Biography        sql.NullString
-			BirthDate        sql.NullString
-			Location         sql.NullString
-			FameRating       float64
-			IsOnline         bool
-			LastSeen         sql.NullString
-			Latitude         sql.NullFloat64
-			Longitude        sql.NullFloat64
-			MBTI             sql.NullString
-			SexualPreference sql.NullString
-			ProfilePicture   sql.NullString
-		}
-		err := rows.Scan(
-			&user.ID, &user.Username, &user.FirstName, &user.LastName,
-			&user.Gender, &user.Biography, &user.BirthDate, &user.Location,
-			&user.FameRating, &user.IsOnline, &user.LastSeen,
-			&user.Latitude, &user.Longitude, &user.MBTI, &user.SexualPreference, &user.ProfilePicture,
-		)
-		if err != nil {
-			continue
+	minDist, maxDist := 0.0, 10000.0
+	if minDistanceStr != "" {
+		if d, err := strconv.ParseFloat(minDistanceStr, 64); err == nil {
+			minDist = d
 		}
-		age := 0
-		if user.BirthDate.Valid {
-			if birthDate, err := time.Parse("2006-01-02", user.BirthDate.String); err == nil {
-				age = time.Now().Year() - birthDate.Year()
-				if time.Now().Before(time.Date(time.Now().Year(), birthDate.Month(), birthDate.Day(), 0, 0, 0, 0, time.UTC)) {
-					age--
-				}
-			}
-		}
-		tagRows, _ := database.DB.Query("SELECT tag FROM user_tags WHERE user_id = ?", user.ID)
-		tags := []string{}
-		if tagRows != nil {
-			for tagRows.Next() {
-				var tag string
-				if tagRows.Scan(&tag) == nil {
-					tags = append(tags, tag)
-				}
-			}
-			tagRows.Close()
-		}
-		distanceKm := 0.0
-		distanceZone := 3
-		tagMatches := 0
-		hasCommonTags := false
-		mbtiHarmonic := false
-		if currentUserLat.Valid && currentUserLon.Valid && user.Latitude.Valid && user.Longitude.Valid {
-			distanceKm = haversineDistance(currentUserLat.Float64, currentUserLon.Float64, user.Latitude.Float64, user.Longitude.Float64)
-			distanceZone = getDistanceZone(distanceKm)
+	}
+	if maxDistanceStr != "" {
+		if d, err := strconv.ParseFloat(maxDistanceStr, 64); err == nil {
+			maxDist = d
 		}
-		tagMatches, hasCommonTags = calculateTagSimilarity(currentUserTags, tags)
-		if currentUserMBTI.Valid && user.MBTI.Valid {
-			mbtiHarmonic = isMBTIHarmonic(currentUserMBTI.String, user.MBTI.String)
+	}
+
+	profilesWithScores := []profileWithScore{}
+	for i := range users {
+		tags, err := store.Get().Tags.List(users[i].ID)
+		if err != nil {
+			tags = []string{}
 		}
-		if onlyCommonTagsStr == "true" && !hasCommonTags {
+		p := scoreProfile(viewer, viewerTags, &users[i], tags)
+		if onlyCommonTagsStr == "true" && !p.hasCommonTags {
 			continue
 		}
-		minDist, maxDist := 0.0, 10000.0
-		if minDistanceStr != "" {
-			if d, err := strconv.ParseFloat(minDistanceStr, 64); err == nil {
-				minDist = d
-			}
-		}
-		if maxDistanceStr != "" {
-			if d, err := strconv.ParseFloat(maxDistanceStr, 64); err == nil {
-				maxDist = d
-			}
-		}
-		if distanceKm < minDist || (maxDist < 10000 && distanceKm > maxDist) {
+		if p.distanceKm < minDist || (maxDist < 10000 && p.distanceKm > maxDist) {
 			continue
 		}
-		profilePic := ""
-		if user.ProfilePicture.Valid {
-			profilePic = normalizeEmptyString(user.ProfilePicture.String)
-		}
-		profileMap := map[string]interface{}{
-			"id": user.ID, "username": user.Username, "first_name": user.FirstName, "last_name": user.LastName,
-			"age": age, "location": normalizeEmptyString(user.Location.String), "biography": normalizeEmptyString(user.Biography.String),
-			"fame_rating": user.FameRating, "is_online": user.IsOnline, "last_seen": user.LastSeen.String,
-			"profile_picture": profilePic,
-			"tags": tags, "gender": normalizeEmptyString(user.Gender.String),
-			"distance_km": math.Round(distanceKm*10) / 10,
-		}
-		profilesWithScores = append(profilesWithScores, profileWithScore{
-			profile: profileMap, distanceZone: distanceZone, distanceKm: distanceKm,
-			tagMatches: tagMatches, hasCommonTags: hasCommonTags, isMBTIHarmonic: mbtiHarmonic,
-		})
+		profilesWithScores = append(profilesWithScores, p)
 	}
 
 	// Sort
 	if sortParam == "age_asc" {
 		sort.Slice(profilesWithScores, func(i, j int) bool {
-			return profilesWithScores[i].profile["age"].(int) < profilesWithScores[j].profile["age"].(int)
+			return profilesWithScores[i].profile.Age < profilesWithScores[j].profile.Age
 		})
 	} else if sortParam == "age_desc" {
 		sort.Slice(profilesWithScores, func(i, j int) bool {
-			return profilesWithScores[i].profile["age"].(int) > profilesWithScores[j].profile["age"].(int)
+			return profilesWithScores[i].profile.Age > profilesWithScores[j].profile.Age
 		})
 	} else if sortParam == "location" {
 		sort.Slice(profilesWithScores, func(i, j int) bool {
@@ -923,11 +598,11 @@ func SearchAPI(w http.ResponseWriter, r *http.Request) {
 			if tmI != tmJ {
 				return tmI > tmJ
 			}
-			return profilesWithScores[i].profile["fame_rating"].(float64) > profilesWithScores[j].profile["fame_rating"].(float64)
+			return profilesWithScores[i].profile.FameRating > profilesWithScores[j].profile.FameRating
 		})
 	}
 
-	profiles := []map[string]interface{}{}
+	profiles := []models.ProfileCard{}
 	start, end := offset, offset+limit
 	if start > len(profilesWithScores) {
 		start = len(profilesWithScores)
@@ -939,96 +614,37 @@ func SearchAPI(w http.ResponseWriter, r *http.Request) {
 		profiles = append(profiles, profilesWithScores[i].profile)
 	}
 
-	SendSuccess(w, map[string]interface{}{
-		"profiles": profiles,
-		"sort":     sortParam,
-		"minAge":   minAge,
-		"maxAge":   maxAge,
-		"tags":     tagsParam,
-		"location": locationParam,
-		"limit":    limit,
-		"offset":   offset,
+	SendSuccess(w, models.SearchPage{
+		DiscoverPage: models.DiscoverPage{
+			Profiles: profiles,
+			Sort:     sortParam,
+			MinAge:   minAge,
+			MaxAge:   maxAge,
+			Limit:    limit,
+			Offset:   offset,
+		},
+		Tags:     tagsParam,
+		Location: locationParam,
 	})
 }
 
 // UserProfileAPI handles GET /api/user/:id
 func UserProfileAPI(w http.ResponseWriter, r *http.Request) {
-	// Extract user ID from URL path directly (more reliable than pat.Param)
-	urlPath := strings.Trim(r.URL.Path, "/")
-	parts := strings.Split(urlPath, "/")
-
-	// Expected format: api/user/123
-	var userIDStr string
-	if len(parts) >= 3 && parts[0] == "api" && parts[1] == "user" {
-		userIDStr = parts[2]
-		// Remove query parameters if any
-		if idx := strings.Index(userIDStr, "?"); idx != -1 {
-			userIDStr = userIDStr[:idx]
-		}
-	}
-
-	if userIDStr == "" {
-		log.Printf("UserProfileAPI: Could not extract user ID from URL: %s", r.URL.Path)
-		SendError(w, http.StatusBadRequest, "Missing user ID parameter")
-		return
-	}
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil || userID <= 0 {
-		log.Printf("UserProfileAPI: Invalid user ID '%s': %v", userIDStr, err)
+	userID, err := pathID(r, "id")
+	if err != nil {
 		SendError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	// Get current user ID (optional)
-	currentUserID, _ := getUserIDFromRequest(r)
+	currentUserID := requestUserID(r)
 
 	// Load user profile from database
-	var user struct {
-		ID                int64
-		Username          string
-		FirstName         string
-		LastName          string
-		Gender            sql.NullString
-		SexualPreference  sql.NullString
-		Biography         sql.NullString
-		BirthDate         sql.NullString
-		Location          sql.NullString
-		Latitude          sql.NullFloat64
-		Longitude         sql.NullFloat64
-		FameRating        float64
-		IsOnline          bool
-		LastSeen          sql.NullString
-		IsSetup           bool
-		Openness          sql.NullString
-		Conscientiousness sql.NullString
-		Extraversion      sql.NullString
-		Agreeableness     sql.NullString
-		Neuroticism       sql.NullString
-		Siblings          sql.NullString
-		MBTI              sql.NullString
-		CaliperProfile    sql.NullString
+	user, err := store.Get().Users.GetByID(userID)
+	if err == nil && !user.IsSetup {
+		err = store.ErrNotFound
 	}
-
-	err = database.DB.QueryRow(`
-		SELECT 
-			id, username, first_name, last_name, gender, sexual_preference,
-			biography, birth_date, location, latitude, longitude,
-			fame_rating, is_online, last_seen, is_setup,
-			openness, conscientiousness, extraversion, agreeableness, neuroticism,
-			siblings, mbti, caliper_profile
-		FROM users 
-		WHERE id = ? AND is_setup = 1
-	`, userID).Scan(
-		&user.ID, &user.Username, &user.FirstName, &user.LastName,
-		&user.Gender, &user.SexualPreference, &user.Biography, &user.BirthDate,
-		&user.Location, &user.Latitude, &user.Longitude,
-		&user.FameRating, &user.IsOnline, &user.LastSeen, &user.IsSetup,
-		&user.Openness, &user.Conscientiousness, &user.Extraversion,
-		&user.Agreeableness, &user.Neuroticism, &user.Siblings, &user.MBTI, &user.CaliperProfile,
-	)
-
-	if err == sql.ErrNoRows {
+	if err == store.ErrNotFound {
 		SendError(w, http.StatusNotFound, "User not found")
 		return
 	}
@@ -1038,274 +654,86 @@ func UserProfileAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Calculate age
-	age := 0
-	if user.BirthDate.Valid {
-		if birthDate, err := time.Parse("2006-01-02", user.BirthDate.String); err == nil {
-			age = time.Now().Year() - birthDate.Year()
-			if time.Now().Before(time.Date(time.Now().Year(), birthDate.Month(), birthDate.Day(), 0, 0, 0, 0, time.UTC)) {
-				age--
-			}
-		}
-	}
-
-	// Get profile picture
-	var profilePicture sql.NullString
-	err = database.DB.QueryRow(`
-		SELECT file_path FROM user_pictures 
-		WHERE user_id = ? AND is_profile = 1 AND order_index = 0 LIMIT 1
-	`, userID).Scan(&profilePicture)
-	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Error getting profile picture: %v", err)
-	}
-
 	// Get all pictures
 	images := []string{} // Initialize as empty slice, never nil
-	imageRows, err := database.DB.Query(`
-		SELECT file_path FROM user_pictures 
-		WHERE user_id = ? 
-		ORDER BY order_index ASC
-	`, userID)
-	if err == nil {
-		defer imageRows.Close()
-		for imageRows.Next() {
-			var imgPath sql.NullString
-			if err := imageRows.Scan(&imgPath); err == nil && imgPath.Valid {
-				images = append(images, imgPath.String)
-			}
+	if pictures, err := store.Get().Pictures.List(userID); err == nil {
+		for _, picture := range pictures {
+			images = append(images, picture.FilePath)
 		}
 	} else {
 		log.Printf("Error querying images: %v", err)
 	}
 
 	// Get tags
-	tags := []string{} // Initialize as empty slice, never nil
-	tagRows, err := database.DB.Query("SELECT tag FROM user_tags WHERE user_id = ?", userID)
-	if err == nil {
-		defer tagRows.Close()
-		for tagRows.Next() {
-			var tag string
-			if err := tagRows.Scan(&tag); err == nil {
-				tags = append(tags, tag)
-			}
-		}
-	} else {
+	tags, err := store.Get().Tags.List(userID)
+	if err != nil {
 		log.Printf("Error querying tags: %v", err)
+		tags = []string{} // Never nil
+	}
+
+	// Build response - normalize empty strings to "-"
+	profile := models.PublicProfile{
+		ProfileCard:      newProfileCard(user, tags),
+		SexualPreference: normalizeEmptyString(user.SexualPreference),
+		Images:           images,
+		BigFive: models.BigFive{
+			Openness:          normalizeEmptyString(user.Openness),
+			Conscientiousness: normalizeEmptyString(user.Conscientiousness),
+			Extraversion:      normalizeEmptyString(user.Extraversion),
+			Agreeableness:     normalizeEmptyString(user.Agreeableness),
+			Neuroticism:       normalizeEmptyString(user.Neuroticism),
+		},
+		Siblings:       normalizeEmptyString(user.Siblings),
+		MBTI:           normalizeEmptyString(user.MBTI),
+		CaliperProfile: normalizeEmptyString(user.CaliperProfile),
+	}
+	if user.HasLocation() {
+		profile.Latitude, profile.Longitude = user.Latitude, user.Longitude
 	}
 
 	// Check if liked and if it's a mutual like (connection)
-	isLiked := false
-	isConnected := false
-	hasViewedYourProfile := false
-	isBlocked := false
-	blockedAt := ""
 	if currentUserID > 0 {
-		var likeID int64
-		err = database.DB.QueryRow(`
-			SELECT id FROM likes 
-			WHERE from_user_id = ? AND to_user_id = ?
-		`, currentUserID, userID).Scan(&likeID)
-		isLiked = (err == nil)
+		profile.IsLiked, _ = store.Get().Likes.Exists(currentUserID, userID)
 
 		// Check if it's a mutual like (connection)
-		if isLiked {
-			var mutualLikeID int64
-			err = database.DB.QueryRow(`
-				SELECT id FROM likes 
-				WHERE from_user_id = ? AND to_user_id = ?
-			`, userID, currentUserID).Scan(&mutualLikeID)
-			isConnected = (err == nil)
+		if profile.IsLiked {
+			profile.IsConnected, _ = store.Get().Likes.Exists(userID, currentUserID)
 		}
 
 		// Check if this user has viewed your profile
-		var viewID int64
-		err = database.DB.QueryRow(`
-			SELECT id FROM views 
-			WHERE viewer_id = ? AND viewed_id = ?
-		`, userID, currentUserID).Scan(&viewID)
-		hasViewedYourProfile = (err == nil)
+		profile.HasViewedYourProfile, _ = store.Get().Views.Exists(userID, currentUserID)
 
 		// Check if current user has blocked this profile (I block them)
-		var blockCreatedAt sql.NullString
-		err = database.DB.QueryRow(`
-			SELECT created_at FROM blocks 
-			WHERE blocker_id = ? AND blocked_id = ?
-		`, currentUserID, userID).Scan(&blockCreatedAt)
-		if err == nil {
-			isBlocked = true
-			if blockCreatedAt.Valid {
-				blockedAt = blockCreatedAt.String
-			}
+		if blockedAt, err := store.Get().Blocks.BlockedAt(currentUserID, userID); err == nil && blockedAt != nil {
+			profile.IsBlocked = true
+			profile.BlockedAt = blockedAt
 		}
 	}
 
 	// Check if this profile user has blocked the current user (they block me)
-	theyBlockMe := false
 	if currentUserID > 0 && currentUserID != userID {
-		var blockID int64
-		err = database.DB.QueryRow(`
-			SELECT id FROM blocks 
-			WHERE blocker_id = ? AND blocked_id = ?
-		`, userID, currentUserID).Scan(&blockID)
-		theyBlockMe = (err == nil)
+		blockedAt, err := store.Get().Blocks.BlockedAt(userID, currentUserID)
+		profile.TheyBlockMe = err == nil && blockedAt != nil
 	}
 
 	// Record view (if current user is viewing)
 	if currentUserID > 0 && currentUserID != userID {
-		// Use write queue for concurrent safety
-		result := database.GetWriteQueue().Enqueue(`
-			INSERT OR IGNORE INTO views (viewer_id, viewed_id, created_at)
-			VALUES (?, ?, CURRENT_TIMESTAMP)
-		`, currentUserID, userID)
-
-		// Update fame rating for viewed user if view was actually inserted (new view)
-		if result.Error == nil && result.RowsAffected > 0 {
-			insertNotification(userID, "view", getDisplayName(currentUserID)+" viewed your profile", currentUserID)
-			go func() {
-				if err := services.UpdateFameRating(userID); err != nil {
-					log.Printf("Error updating fame rating for user %d: %v", userID, err)
-				}
-			}()
-		}
-
-		// Check if current user is a bot and log activity
-		var isBot int
-		var botUsername string
-		err = database.DB.QueryRow("SELECT is_bot, username FROM users WHERE id = ?", currentUserID).Scan(&isBot, &botUsername)
-		if err == nil && isBot == 1 {
-			// Log bot activity asynchronously
-			go func() {
-				database.GetWriteQueue().EnqueueAsync(`
-					INSERT INTO bot_activity_log (bot_id, bot_username, action_type, target_user_id, target_username, details)
-					VALUES (?, ?, 'view_profile', ?, ?, '')
-				`, currentUserID, botUsername, userID, user.Username)
-			}()
-		}
-	}
-
-	// Build response - normalize empty strings to "-"
-	response := map[string]interface{}{
-		"id":                      user.ID,
-		"username":                normalizeEmptyString(user.Username),
-		"first_name":              normalizeEmptyString(user.FirstName),
-		"last_name":               normalizeEmptyString(user.LastName),
-		"age":                     age,
-		"fame_rating":             user.FameRating,
-		"is_online":               user.IsOnline,
-		"images":                  images,
-		"tags":                    tags,
-		"is_liked":                isLiked,
-		"is_connected":            isConnected,
-		"has_viewed_your_profile": hasViewedYourProfile,
-		"is_blocked":              isBlocked,
-		"they_block_me":           theyBlockMe,
-	}
-
-	if blockedAt != "" {
-		response["blocked_at"] = blockedAt
-	}
-
-	// Handle nullable string fields - replace empty strings with "-"
-	if user.Location.Valid {
-		response["location"] = normalizeEmptyString(user.Location.String)
-	} else {
-		response["location"] = "-"
-	}
-
-	if user.Biography.Valid {
-		response["biography"] = normalizeEmptyString(user.Biography.String)
-	} else {
-		response["biography"] = "-"
-	}
-
-	if user.LastSeen.Valid {
-		response["last_seen"] = normalizeEmptyString(user.LastSeen.String)
-	} else {
-		response["last_seen"] = "-"
-	}
-
-	if profilePicture.Valid {
-		response["profile_picture"] = normalizeEmptyString(profilePicture.String)
-	} else {
-		response["profile_picture"] = "-"
-	}
-
-	if user.Gender.Valid {
-		response["gender"] = normalizeEmptyString(user.Gender.String)
-	} else {
-		response["gender"] = "-"
-	}
-	if user.SexualPreference.Valid {
-		response["sexual_preference"] = normalizeEmptyString(user.SexualPreference.String)
-	} else {
-		response["sexual_preference"] = "-"
-	}
-	if user.MBTI.Valid {
-		response["mbti"] = normalizeEmptyString(user.MBTI.String)
-	} else {
-		response["mbti"] = "-"
-	}
-	if user.Latitude.Valid && user.Longitude.Valid {
-		response["latitude"] = user.Latitude.Float64
-		response["longitude"] = user.Longitude.Float64
-	}
-
-	// Big Five - replace empty strings with "-"
-	bigFive := map[string]string{}
-	if user.Openness.Valid {
-		bigFive["openness"] = normalizeEmptyString(user.Openness.String)
-	} else {
-		bigFive["openness"] = "-"
-	}
-	if user.Conscientiousness.Valid {
-		bigFive["conscientiousness"] = normalizeEmptyString(user.Conscientiousness.String)
-	} else {
-		bigFive["conscientiousness"] = "-"
-	}
-	if user.Extraversion.Valid {
-		bigFive["extraversion"] = normalizeEmptyString(user.Extraversion.String)
-	} else {
-		bigFive["extraversion"] = "-"
-	}
-	if user.Agreeableness.Valid {
-		bigFive["agreeableness"] = normalizeEmptyString(user.Agreeableness.String)
-	} else {
-		bigFive["agreeableness"] = "-"
-	}
-	if user.Neuroticism.Valid {
-		bigFive["neuroticism"] = normalizeEmptyString(user.Neuroticism.String)
-	} else {
-		bigFive["neuroticism"] = "-"
-	}
-	response["big_five"] = bigFive
-
-	if user.Siblings.Valid {
-		response["siblings"] = normalizeEmptyString(user.Siblings.String)
-	} else {
-		response["siblings"] = "-"
-	}
-	if user.CaliperProfile.Valid {
-		response["caliper_profile"] = normalizeEmptyString(user.CaliperProfile.String)
-	} else {
-		response["caliper_profile"] = "-"
-	}
-
-	// Final pass: ensure all string fields are never nil or empty - replace with "-"
-	for key, value := range response {
-		if str, ok := value.(string); ok {
-			if str == "" {
-				response[key] = "-"
-			}
+		// A new view notifies the viewed user and updates their fame rating; views by bots are logged
+		name := getDisplayName(currentUserID)
+		botEvents := botActivityOutboxEvents(currentUserID, userID, "view_profile", "")
+		_, err := writeWithOutbox(func(tx *sql.Tx) (bool, []outboxEvent, error) {
+			recorded, err := store.Get().Views.RecordTx(tx, currentUserID, userID)
+			if err != nil || !recorded {
+				return recorded, nil, err
+			}
+			events := []outboxEvent{notificationOutboxEvent(userID, "view", name+" viewed your profile", currentUserID)}
+			events = append(events, botEvents...)
+			return true, append(events, fameOutboxEvent(userID)), nil
+		})
+		if err != nil {
+			log.Printf("Error recording view of user %d: %v", userID, err)
 		}
 	}
 
-	// Ensure arrays are never nil
-	if response["images"] == nil {
-		response["images"] = []string{}
-	}
-	if response["tags"] == nil {
-		response["tags"] = []string{}
-	}
-
-	SendSuccess(w, response)
+	SendSuccess(w, profile)
 }
@@ -1,104 +1,93 @@
 package handlers
 
 import (
-	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
-	"matcha/internal/database"
+	"matcha/internal/models"
 	"matcha/internal/services"
+	"matcha/internal/store"
 )
 
 // ChatListAPI handles GET /api/chat
 func ChatListAPI(w http.ResponseWriter, r *http.Request) {
-	// Get current user ID from authentication token
-	currentUserID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	currentUserID := requestUserID(r)
 
-	// Get all connected users with their last message
-	rows, err := database.DB.Query(`
-		SELECT DISTINCT
-			u.id, u.username, u.first_name, u.last_name,
-			(SELECT file_path FROM user_pictures WHERE user_id = u.id AND is_profile = 1 AND order_index = 0 LIMIT 1) as profile_picture,
-			(SELECT content FROM messages 
-			 WHERE (from_user_id = ? AND to_user_id = u.id) OR (from_user_id = u.id AND to_user_id = ?)
-			 ORDER BY created_at DESC LIMIT 1) as last_message,
-			(SELECT created_at FROM messages 
-			 WHERE (from_user_id = ? AND to_user_id = u.id) OR (from_user_id = u.id AND to_user_id = ?)
-			 ORDER BY created_at DESC LIMIT 1) as last_message_time,
-			(SELECT COUNT(*) FROM messages 
-			 WHERE from_user_id = u.id AND to_user_id = ? AND is_read = 0) as unread_count
-		FROM users u
-		INNER JOIN likes l1 ON l1.from_user_id = ? AND l1.to_user_id = u.id
-		INNER JOIN likes l2 ON l2.from_user_id = u.id AND l2.to_user_id = ?
-		WHERE u.is_setup = 1 AND u.is_email_verified = 1
-		ORDER BY CASE WHEN last_message_time IS NULL THEN 1 ELSE 0 END, last_message_time DESC
-	`, currentUserID, currentUserID, currentUserID, currentUserID, currentUserID, currentUserID, currentUserID)
+	// Archived conversations are hidden from the main list and listed on their own with ?archived=true
+	archived := r.URL.Query().Get("archived") == "true"
+
+	// Get all connected users with their last message; pinned conversations first
+	list, err := store.Get().Conversations.List(currentUserID, archived)
 	if err != nil {
 		log.Printf("Error querying chat list: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load chat list")
 		return
 	}
-	defer rows.Close()
-
-	conversations := []map[string]interface{}{}
-	for rows.Next() {
-		var conv struct {
-			ID            int64
-			Username      string
-			FirstName     string
-			LastName      string
-			ProfilePicture sql.NullString
-			LastMessage   sql.NullString
-			LastMessageTime sql.NullString
-			UnreadCount   int64
-		}
-
-		err := rows.Scan(
-			&conv.ID, &conv.Username, &conv.FirstName, &conv.LastName,
-			&conv.ProfilePicture, &conv.LastMessage, &conv.LastMessageTime, &conv.UnreadCount,
-		)
-		if err != nil {
-			log.Printf("Error scanning conversation: %v", err)
-			continue
-		}
 
+	conversations := []models.ConversationCard{}
+	for _, conv := range list {
 		// Normalize empty strings
-		firstName := normalizeEmptyString(conv.FirstName)
-		lastName := normalizeEmptyString(conv.LastName)
-		
-		conversationData := map[string]interface{}{
-			"id":            conv.ID,
-			"name":          firstName + " " + lastName,
-			"unread_count": conv.UnreadCount,
-		}
-
-		if conv.ProfilePicture.Valid && conv.ProfilePicture.String != "" {
-			conversationData["avatar"] = normalizeEmptyString(conv.ProfilePicture.String)
-		} else {
-			conversationData["avatar"] = ""
-		}
+		firstName := normalizeEmptyString(conv.User.FirstName)
+		lastName := normalizeEmptyString(conv.User.LastName)
+
+		conversations = append(conversations, models.ConversationCard{
+			ID:                   conv.User.ID,
+			Name:                 firstName + " " + lastName,
+			Avatar:               conv.User.ProfilePicture,
+			UnreadCount:          conv.UnreadCount,
+			LastMessage:          conv.LastMessage,
+			ConversationSettings: conversationSettings(conv.Settings).response(),
+		})
+	}
 
-		if conv.LastMessage.Valid && conv.LastMessage.String != "" {
-			conversationData["last_message"] = normalizeEmptyString(conv.LastMessage.String)
-		} else {
-			conversationData["last_message"] = ""
-		}
+	SendSuccess(w, models.ConversationList{Conversations: conversations})
+}
 
-		conversations = append(conversations, conversationData)
+// chatMessage is the API shape of a message for userID, who is one of its two participants
+// (GET /api/messages/:id and the "message" WebSocket event). Deleted messages keep their place
+// but lose their content and attachments.
+func chatMessage(msg *models.Message, userID int64, attachments []models.AttachmentInfo) models.ChatMessage {
+	chatID := msg.ToUserID
+	if msg.ToUserID == userID {
+		chatID = msg.FromUserID
+	}
+	isDeleted := msg.DeletedAt != nil
+	content := msg.Content
+	if isDeleted {
+		content = ""
+		attachments = nil
+	} else if content == "" && len(attachments) == 0 {
+		content = "-" // Default for empty content (attachment-only messages stay empty)
+	}
+	if attachments == nil {
+		attachments = []models.AttachmentInfo{}
+	}
+	return models.ChatMessage{
+		ID:                msg.ID,
+		ChatID:            chatID,
+		Content:           content,
+		IsFromCurrentUser: msg.FromUserID == userID,
+		CreatedAt:         msg.CreatedAt,
+		IsRead:            msg.IsRead,
+		ReadAt:            msg.ReadAt,
+		EditedAt:          msg.EditedAt,
+		IsDeleted:         isDeleted,
+		Attachments:       attachments,
 	}
-
-	SendSuccess(w, map[string]interface{}{
-		"conversations": conversations,
-	})
 }
 
-// MessagesAPI handles GET /api/messages/:id
+// Page sizes for GET /api/messages/:id
+const (
+	defaultMessagesPageSize = 50
+	maxMessagesPageSize     = 100
+)
+
+// MessagesAPI handles GET /api/messages/:id. It marks nothing as read: clients acknowledge the
+// messages they show with POST /api/messages/:id/read
 func MessagesAPI(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -107,113 +96,115 @@ func MessagesAPI(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Get current user ID from authentication token
-	currentUserID, err := getUserIDFromRequest(r)
+	currentUserID := requestUserID(r)
+
+	otherUserID, err := pathID(r, "id")
 	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
+		SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	// Verify they are connected (mutual likes)
+	if !areConnected(currentUserID, otherUserID) {
+		SendError(w, http.StatusForbidden, "You are not connected with this user")
 		return
 	}
 
-	// Extract user ID from URL path
-	urlPath := strings.Trim(r.URL.Path, "/")
-	parts := strings.Split(urlPath, "/")
-	
-	var userIDStr string
-	if len(parts) >= 3 && parts[0] == "api" && parts[1] == "messages" {
-		userIDStr = parts[2]
-		if idx := strings.Index(userIDStr, "?"); idx != -1 {
-			userIDStr = userIDStr[:idx]
+	// Pagination: ?before=<message id> loads older messages, ?after=<message id> newer ones,
+	// neither loads the latest page. ?limit= sets the page size.
+	query := r.URL.Query()
+	limit := defaultMessagesPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxMessagesPageSize {
+			SendError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxMessagesPageSize))
+			return
 		}
 	}
-	
-	if userIDStr == "" {
-		log.Printf("MessagesAPI: Could not extract user ID from URL: %s", r.URL.Path)
-		SendError(w, http.StatusBadRequest, "Missing user ID parameter")
-		return
+	var beforeID, afterID int64
+	forward := false
+	if beforeStr := query.Get("before"); beforeStr != "" {
+		beforeID, err = strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || beforeID <= 0 {
+			SendError(w, http.StatusBadRequest, "Invalid before cursor")
+			return
+		}
 	}
-	
-	otherUserID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil || otherUserID <= 0 {
-		log.Printf("MessagesAPI: Invalid user ID '%s': %v", userIDStr, err)
-		SendError(w, http.StatusBadRequest, "Invalid user ID")
-		return
+	if afterStr := query.Get("after"); afterStr != "" {
+		afterID, err = strconv.ParseInt(afterStr, 10, 64)
+		if err != nil || afterID < 0 {
+			SendError(w, http.StatusBadRequest, "Invalid after cursor")
+			return
+		}
+		forward = true
 	}
-
-	// Verify they are connected (mutual likes)
-	var likeID1, likeID2 int64
-	err1 := database.DB.QueryRow(`
-		SELECT id FROM likes WHERE from_user_id = ? AND to_user_id = ?
-	`, currentUserID, otherUserID).Scan(&likeID1)
-	err2 := database.DB.QueryRow(`
-		SELECT id FROM likes WHERE from_user_id = ? AND to_user_id = ?
-	`, otherUserID, currentUserID).Scan(&likeID2)
-
-	if err1 != nil || err2 != nil {
-		SendError(w, http.StatusForbidden, "You are not connected with this user")
+	if beforeID > 0 && forward {
+		SendError(w, http.StatusBadRequest, "Use either before or after, not both")
 		return
 	}
 
-	// Load message history
-	rows, err := database.DB.Query(`
-		SELECT id, from_user_id, to_user_id, content, is_read, created_at
-		FROM messages
-		WHERE (from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?)
-		ORDER BY created_at ASC
-	`, currentUserID, otherUserID, otherUserID, currentUserID)
+	// Load one page of message history (one extra row tells us whether there is more)
+	page, err := store.Get().Messages.List(currentUserID, otherUserID, store.MessagePage{
+		Before:  beforeID,
+		After:   afterID,
+		Forward: forward,
+		Limit:   limit + 1,
+	})
 	if err != nil {
 		log.Printf("Error querying messages: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load messages")
 		return
 	}
-	defer rows.Close()
-
-	messages := []map[string]interface{}{}
-	for rows.Next() {
-		var msg struct {
-			ID        int64
-			FromUserID int64
-			ToUserID  int64
-			Content   string
-			IsRead    bool
-			CreatedAt string
-		}
 
-		err := rows.Scan(&msg.ID, &msg.FromUserID, &msg.ToUserID, &msg.Content, &msg.IsRead, &msg.CreatedAt)
-		if err != nil {
-			log.Printf("Error scanning message: %v", err)
-			continue
+	// Attachment metadata, for messages that are not deleted (files themselves are fetched through
+	// GET /api/attachments/:id)
+	visible := []int64{}
+	for _, msg := range page {
+		if msg.DeletedAt == nil {
+			visible = append(visible, msg.ID)
 		}
+	}
+	attachments, err := loadMessageAttachments(visible)
+	if err != nil {
+		log.Printf("Error loading message attachments: %v", err)
+	}
 
-		// Normalize empty strings
-		content := normalizeEmptyString(msg.Content)
-		if content == "" {
-			content = "-" // Default for empty content
-		}
+	messages := []models.ChatMessage{}
+	messageIDs := []int64{}
+	for i := range page {
+		messages = append(messages, chatMessage(&page[i], currentUserID, attachments[page[i].ID]))
+		messageIDs = append(messageIDs, page[i].ID)
+	}
 
-		createdAt := normalizeEmptyString(msg.CreatedAt)
-		if createdAt == "" {
-			createdAt = "-"
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+		messageIDs = messageIDs[:limit]
+	}
+	// Backward pages were read newest-first; the client always gets them oldest-first
+	if !forward {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+			messageIDs[i], messageIDs[j] = messageIDs[j], messageIDs[i]
 		}
-
-		messages = append(messages, map[string]interface{}{
-			"id":                   msg.ID,
-			"content":              content,
-			"is_from_current_user": msg.FromUserID == currentUserID,
-			"created_at":           createdAt,
-			"is_read":              msg.IsRead,
-		})
 	}
 
-	// Mark messages as read
-	_, _ = database.DB.Exec(`
-		UPDATE messages 
-		SET is_read = 1 
-		WHERE from_user_id = ? AND to_user_id = ? AND is_read = 0
-	`, otherUserID, currentUserID)
+	// next_cursor continues in the same direction: the oldest id for backward pages
+	// (pass as ?before=), the newest id for forward pages (pass as ?after=)
+	var nextCursor *int64
+	if hasMore && len(messageIDs) > 0 {
+		if forward {
+			nextCursor = &messageIDs[len(messageIDs)-1]
+		} else {
+			nextCursor = &messageIDs[0]
+		}
+	}
 
-	SendSuccess(w, map[string]interface{}{
-		"messages": messages,
-		"chat_id":  otherUserID,
+	SendSuccess(w, models.MessagePage{
+		Messages:   messages,
+		ChatID:     otherUserID,
+		NextCursor: nextCursor,
+		HasMore:    hasMore,
 	})
 }
 
@@ -226,52 +217,20 @@ func SendMessageAPI(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Get current user ID from authentication token
-	currentUserID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	currentUserID := requestUserID(r)
 
 	// Update online status and last_seen sporadically
 	ensureUserIsOnline(currentUserID)
 	updateLastSeenSporadically(currentUserID)
 
-	// Extract user ID from URL path
-	urlPath := strings.Trim(r.URL.Path, "/")
-	parts := strings.Split(urlPath, "/")
-	
-	var userIDStr string
-	if len(parts) >= 3 && parts[0] == "api" && parts[1] == "messages" {
-		userIDStr = parts[2]
-		if idx := strings.Index(userIDStr, "?"); idx != -1 {
-			userIDStr = userIDStr[:idx]
-		}
-	}
-	
-	if userIDStr == "" {
-		log.Printf("SendMessageAPI: Could not extract user ID from URL: %s", r.URL.Path)
-		SendError(w, http.StatusBadRequest, "Missing user ID parameter")
-		return
-	}
-	
-	targetUserID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil || targetUserID <= 0 {
-		log.Printf("SendMessageAPI: Invalid user ID '%s': %v", userIDStr, err)
+	targetUserID, err := pathID(r, "id")
+	if err != nil {
 		SendError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	// Verify they are connected
-	var likeID1, likeID2 int64
-	err1 := database.DB.QueryRow(`
-		SELECT id FROM likes WHERE from_user_id = ? AND to_user_id = ?
-	`, currentUserID, targetUserID).Scan(&likeID1)
-	err2 := database.DB.QueryRow(`
-		SELECT id FROM likes WHERE from_user_id = ? AND to_user_id = ?
-	`, targetUserID, currentUserID).Scan(&likeID2)
-
-	if err1 != nil || err2 != nil {
+	if !areConnected(currentUserID, targetUserID) {
 		SendError(w, http.StatusForbidden, "You are not connected with this user")
 		return
 	}
@@ -296,44 +255,14 @@ func SendMessageAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Insert message using write queue — parameterized query only (SQL injection protection)
-	writeResult := database.GetWriteQueue().Enqueue(`
-		INSERT INTO messages (from_user_id, to_user_id, content, is_read, created_at)
-		VALUES (?, ?, ?, 0, CURRENT_TIMESTAMP)
-	`, currentUserID, targetUserID, req.Content)
-	if writeResult.Error != nil {
-		log.Printf("Error inserting message: %v", writeResult.Error)
+	// Insert message and its side effects (outbox) in one transaction — parameterized queries only (SQL injection protection)
+	messageID, outboxEventIDs, err := insertMessage(currentUserID, targetUserID, req.Content, nil)
+	if err != nil {
 		SendError(w, http.StatusInternalServerError, "Failed to send message")
 		return
 	}
 
-	messageID := writeResult.LastInsertID
-
-	// Check if current user is a bot and log activity
-	var isBot int
-	var botUsername string
-	err = database.DB.QueryRow("SELECT is_bot, username FROM users WHERE id = ?", currentUserID).Scan(&isBot, &botUsername)
-	if err == nil && isBot == 1 {
-		var targetUsername string
-		database.DB.QueryRow("SELECT username FROM users WHERE id = ?", targetUserID).Scan(&targetUsername)
-		// Log bot activity asynchronously
-		go func() {
-			database.GetWriteQueue().EnqueueAsync(`
-				INSERT INTO bot_activity_log (bot_id, bot_username, action_type, target_user_id, target_username, details)
-				VALUES (?, ?, 'send_message', ?, ?, ?)
-			`, currentUserID, botUsername, targetUserID, targetUsername, req.Content)
-		}()
-	}
-
-	// Notify the recipient (sync so notification exists before response)
-	insertNotificationSync(targetUserID, "message", getDisplayName(currentUserID)+" sent you a message", currentUserID)
-
-	// Update fame rating for message sender (async)
-	go func() {
-		if err := services.UpdateFameRating(currentUserID); err != nil {
-			log.Printf("Error updating fame rating for user %d: %v", currentUserID, err)
-		}
-	}()
+	afterMessageSent(messageID, currentUserID, targetUserID, req.Content, nil, outboxEventIDs)
 
 	SendSuccess(w, map[string]interface{}{
 		"message": "Message sent successfully",
@@ -341,3 +270,22 @@ func SendMessageAPI(w http.ResponseWriter, r *http.Request) {
 		"message_id": messageID,
 	})
 }
+
+// afterMessageSent runs once a message is committed: real-time push, search indexing, then its outbox events
+// (recipient notification, bot activity log, sender fame). Shared by text and attachment messages.
+func afterMessageSent(messageID, currentUserID, targetUserID int64, content string, attachments []models.AttachmentInfo, outboxEventIDs []int64) {
+	// Push the committed message to both users' open WebSocket connections (all tabs/devices)
+	msg, err := store.Get().Messages.Get(messageID)
+	if err != nil {
+		log.Printf("Error loading message %d for the WebSocket push: %v", messageID, err)
+		msg = &models.Message{ID: messageID, FromUserID: currentUserID, ToUserID: targetUserID, Content: content, CreatedAt: time.Now().UTC()}
+	}
+	pushChatMessage(msg, attachments)
+
+	// Make it findable through GET /api/chat/search
+	indexMessageForSearch(messageID, content)
+
+	// Run the side effects now (sync so the notification exists before the response);
+	// anything that fails is retried by the outbox dispatcher
+	services.ProcessOutboxEvents(outboxEventIDs...)
+}
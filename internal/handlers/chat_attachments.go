@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// attachmentsDir is the private root for chat attachments (per-user subdirectories, like uploads/).
+// It is deliberately not under the public /uploads/* file server.
+const attachmentsDir = "data/attachments"
+
+// imageContentType maps an allowed image extension to its MIME type
+func imageContentType(ext string) string {
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	}
+	return "application/octet-stream"
+}
+
+// attachmentJSON is the API shape of one attachment
+func attachmentJSON(id int64, contentType string, size int64) models.AttachmentInfo {
+	return models.AttachmentInfo{
+		ID:          id,
+		URL:         fmt.Sprintf("/api/attachments/%d", id),
+		ContentType: contentType,
+		Size:        size,
+	}
+}
+
+// loadMessageAttachments returns attachments grouped by message id
+func loadMessageAttachments(messageIDs []int64) (map[int64][]models.AttachmentInfo, error) {
+	result := make(map[int64][]models.AttachmentInfo)
+	attachments, err := store.Get().Messages.Attachments(messageIDs)
+	for messageID, list := range attachments {
+		for _, a := range list {
+			result[messageID] = append(result[messageID], attachmentJSON(a.ID, a.ContentType, a.SizeBytes))
+		}
+	}
+	return result, err
+}
+
+// SendAttachmentAPI handles POST /api/messages/:id/attachments - sends an image message (multipart: image, optional content caption)
+func SendAttachmentAPI(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("Recovered from panic in SendAttachmentAPI: %v", rec)
+			SendError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+	}()
+
+	currentUserID := requestUserID(r)
+
+	ensureUserIsOnline(currentUserID)
+	updateLastSeenSporadically(currentUserID)
+
+	targetUserID, err := pathID(r, "id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if !areConnected(currentUserID, targetUserID) {
+		SendError(w, http.StatusForbidden, "You are not connected with this user")
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxImageUploadSize); err != nil {
+		SendError(w, http.StatusBadRequest, "Failed to parse form data")
+		return
+	}
+
+	file, handler, err := r.FormFile("image")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "No image file provided")
+		return
+	}
+	defer file.Close()
+
+	// Same validation as profile pictures
+	ext, err := validateImageUpload(handler)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Optional caption, same limit as text messages
+	caption := strings.TrimSpace(r.FormValue("content"))
+	if len(caption) > MaxMessageContentLength {
+		SendError(w, http.StatusBadRequest, "Message too long")
+		return
+	}
+
+	// Same per-user layout as uploads/: data/attachments/user_id/
+	userDir := filepath.Join(attachmentsDir, strconv.FormatInt(currentUserID, 10))
+	filename, err := saveImageUpload(file, userDir, "chat", ext)
+	if err != nil {
+		log.Printf("Error saving attachment: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to save file")
+		return
+	}
+	filePath := filepath.Join(userDir, filename)
+	contentType := imageContentType(ext)
+
+	// Message, attachment and outbox events are committed together, so a failure leaves no empty message behind
+	var attachmentID int64
+	messageID, outboxEventIDs, err := insertMessage(currentUserID, targetUserID, caption, func(tx *sql.Tx, messageID int64) error {
+		var err error
+		attachmentID, err = store.Get().Messages.AddAttachmentTx(tx, &models.MessageAttachment{
+			MessageID:    messageID,
+			UploaderID:   currentUserID,
+			FilePath:     filePath,
+			OriginalName: filepath.Base(handler.Filename),
+			ContentType:  contentType,
+			SizeBytes:    handler.Size,
+		})
+		return err
+	})
+	if err != nil {
+		os.Remove(filePath)
+		SendError(w, http.StatusInternalServerError, "Failed to send message")
+		return
+	}
+
+	attachments := []models.AttachmentInfo{attachmentJSON(attachmentID, contentType, handler.Size)}
+	afterMessageSent(messageID, currentUserID, targetUserID, caption, attachments, outboxEventIDs)
+
+	SendSuccess(w, map[string]interface{}{
+		"message":     "Message sent successfully",
+		"chat_id":     targetUserID,
+		"message_id":  messageID,
+		"attachments": attachments,
+	})
+}
+
+// AttachmentAPI handles GET /api/attachments/:id - serves the file only to the two participants of its conversation.
+// Token via Authorization header or ?token= so it works in <img src>.
+func AttachmentAPI(w http.ResponseWriter, r *http.Request) {
+	currentUserID := requestUserID(r)
+
+	attachmentID, err := pathID(r, "id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid attachment ID")
+		return
+	}
+
+	attachment, msg, err := store.Get().Messages.GetAttachment(attachmentID)
+	if err == store.ErrNotFound {
+		SendError(w, http.StatusNotFound, "Attachment not found")
+		return
+	} else if err != nil {
+		log.Printf("Error loading attachment %d: %v", attachmentID, err)
+		SendError(w, http.StatusInternalServerError, "Failed to load attachment")
+		return
+	}
+
+	// Same 404 for "not yours" and "deleted" so ids can't be probed
+	if (currentUserID != msg.FromUserID && currentUserID != msg.ToUserID) || msg.DeletedAt != nil {
+		SendError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	f, err := os.Open(attachment.FilePath)
+	if err != nil {
+		log.Printf("Error opening attachment %d (%s): %v", attachmentID, attachment.FilePath, err)
+		SendError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, "Failed to load attachment")
+		return
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Private: only the participants may see it, so no shared caches
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"matcha/internal/config"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// loadOwnMessage loads a message in the conversation with otherUserID that was sent by currentUserID.
+// Any other message is store.ErrNotFound.
+func loadOwnMessage(messageID, currentUserID, otherUserID int64) (*models.Message, error) {
+	msg, err := store.Get().Messages.Get(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.FromUserID != currentUserID || msg.ToUserID != otherUserID {
+		return nil, store.ErrNotFound
+	}
+	return msg, nil
+}
+
+// EditMessageAPI handles PATCH /api/messages/:id/:message_id - sender edits their own message within the edit window
+func EditMessageAPI(w http.ResponseWriter, r *http.Request) {
+	currentUserID := requestUserID(r)
+
+	otherUserID, err := pathID(r, "id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	messageID, err := pathID(r, "message_id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" {
+		SendError(w, http.StatusBadRequest, "Message content cannot be empty")
+		return
+	}
+	if len(req.Content) > MaxMessageContentLength {
+		SendError(w, http.StatusBadRequest, "Message too long")
+		return
+	}
+
+	msg, err := loadOwnMessage(messageID, currentUserID, otherUserID)
+	if err == store.ErrNotFound {
+		SendError(w, http.StatusNotFound, "Message not found")
+		return
+	} else if err != nil {
+		log.Printf("Error loading message %d: %v", messageID, err)
+		SendError(w, http.StatusInternalServerError, "Failed to edit message")
+		return
+	}
+	if msg.DeletedAt != nil {
+		SendError(w, http.StatusConflict, "Message has been deleted")
+		return
+	}
+
+	cfg := config.Load()
+	if time.Since(msg.CreatedAt) > cfg.MessageEditWindow {
+		SendError(w, http.StatusForbidden, "Edit window has expired")
+		return
+	}
+	if msg.Content == req.Content {
+		SendSuccess(w, map[string]interface{}{
+			"message":    "Message unchanged",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	// The previous version is kept in the edit history, in the same transaction
+	editedAt := time.Now().UTC().Truncate(time.Second)
+	edited, err := store.Get().Messages.Edit(messageID, currentUserID, req.Content, msg.Content, editedAt)
+	if err != nil {
+		log.Printf("Error editing message %d: %v", messageID, err)
+		SendError(w, http.StatusInternalServerError, "Failed to edit message")
+		return
+	}
+	if !edited {
+		SendError(w, http.StatusConflict, "Message has been deleted")
+		return
+	}
+
+	indexMessageForSearch(messageID, req.Content)
+
+	pushMessageChange("message_updated", messageID, currentUserID, otherUserID, map[string]interface{}{
+		"content":   req.Content,
+		"edited_at": editedAt.Format(time.RFC3339),
+	})
+
+	SendSuccess(w, map[string]interface{}{
+		"message":    "Message edited successfully",
+		"message_id": messageID,
+		"content":    req.Content,
+		"edited_at":  editedAt.Format(time.RFC3339),
+	})
+}
+
+// DeleteMessageAPI handles DELETE /api/messages/:id/:message_id - soft-deletes the sender's own message.
+// The row stays (the other side sees "message deleted"); its content moves to message_edits.
+func DeleteMessageAPI(w http.ResponseWriter, r *http.Request) {
+	currentUserID := requestUserID(r)
+
+	otherUserID, err := pathID(r, "id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	messageID, err := pathID(r, "message_id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	msg, err := loadOwnMessage(messageID, currentUserID, otherUserID)
+	if err == store.ErrNotFound {
+		SendError(w, http.StatusNotFound, "Message not found")
+		return
+	} else if err != nil {
+		log.Printf("Error loading message %d: %v", messageID, err)
+		SendError(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+	if msg.DeletedAt != nil {
+		SendSuccess(w, map[string]interface{}{
+			"message":    "Message already deleted",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	deletedAt := time.Now().UTC().Truncate(time.Second)
+	deleted, err := store.Get().Messages.Delete(messageID, currentUserID, msg.Content, deletedAt)
+	if err != nil {
+		log.Printf("Error deleting message %d: %v", messageID, err)
+		SendError(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+	if !deleted {
+		SendSuccess(w, map[string]interface{}{
+			"message":    "Message already deleted",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	removeMessageFromSearch(messageID)
+
+	pushMessageChange("message_deleted", messageID, currentUserID, otherUserID, map[string]interface{}{
+		"deleted_at": deletedAt.Format(time.RFC3339),
+	})
+
+	SendSuccess(w, map[string]interface{}{
+		"message":    "Message deleted successfully",
+		"message_id": messageID,
+		"deleted_at": deletedAt.Format(time.RFC3339),
+	})
+}
+
+// pushMessageChange notifies both participants that a message was edited or deleted
+func pushMessageChange(eventType string, messageID, fromUserID, toUserID int64, fields map[string]interface{}) {
+	for _, userID := range []int64{fromUserID, toUserID} {
+		chatID := toUserID
+		if userID == toUserID {
+			chatID = fromUserID
+		}
+		data := map[string]interface{}{
+			"id":      messageID,
+			"chat_id": chatID,
+		}
+		for k, v := range fields {
+			data[k] = v
+		}
+		hub.sendToUser(userID, WSEvent{Type: eventType, Data: data})
+	}
+}
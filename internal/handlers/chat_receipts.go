@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"matcha/internal/store"
+)
+
+// areConnected reports whether both users like each other (required to chat)
+func areConnected(userA, userB int64) bool {
+	connected, err := store.Get().Likes.AreConnected(userA, userB)
+	return err == nil && connected
+}
+
+// markMessagesRead marks messages from senderID to readerID with fromID <= id <= upToID as read
+// (upToID 0 = everything) and pushes a read receipt to both users. Returns how many were marked.
+func markMessagesRead(readerID, senderID, fromID, upToID int64) (int64, time.Time, error) {
+	readAt := time.Now().UTC().Truncate(time.Second)
+	marked, err := store.Get().Messages.MarkRead(readerID, senderID, fromID, upToID, readAt)
+	if err != nil {
+		return 0, readAt, err
+	}
+	if marked > 0 {
+		pushReadReceipt(readerID, senderID, fromID, upToID, readAt)
+	}
+	return marked, readAt, nil
+}
+
+// pushReadReceipt tells the sender their messages were seen, and the reader's other tabs to clear unread state.
+// Each side gets the conversation as chat_id (the other participant) and the range that was read:
+// from_message_id through up_to_message_id, where 0 means from the start / up to the latest message.
+func pushReadReceipt(readerID, senderID, fromID, upToID int64, readAt time.Time) {
+	for _, userID := range []int64{senderID, readerID} {
+		chatID := readerID
+		if userID == readerID {
+			chatID = senderID
+		}
+		hub.sendToUser(userID, WSEvent{
+			Type: "read",
+			Data: map[string]interface{}{
+				"chat_id":          chatID,
+				"reader_id":        readerID,
+				"from_message_id":  fromID,
+				"up_to_message_id": upToID,
+				"read_at":          readAt.Format(time.RFC3339),
+			},
+		})
+	}
+}
+
+// pushTyping forwards an ephemeral typing state to the other user (nothing is stored)
+func pushTyping(fromUserID, toUserID int64, isTyping bool) {
+	hub.sendToUser(toUserID, WSEvent{
+		Type: "typing",
+		Data: map[string]interface{}{
+			"chat_id":   fromUserID,
+			"is_typing": isTyping,
+		},
+	})
+}
+
+// ReadReceiptAPI handles POST /api/messages/:id/read - acknowledge messages from :id up to a message id.
+// Reading is a state change, so it isn't done by GET /api/messages/:id (which CSRF checks let through).
+func ReadReceiptAPI(w http.ResponseWriter, r *http.Request) {
+	currentUserID := requestUserID(r)
+
+	otherUserID, err := pathID(r, "id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if !areConnected(currentUserID, otherUserID) {
+		SendError(w, http.StatusForbidden, "You are not connected with this user")
+		return
+	}
+
+	// up_to_message_id omitted or 0 = acknowledge the whole conversation;
+	// from_message_id limits it to a loaded page (first and last id of the page)
+	var req struct {
+		FromMessageID int64 `json:"from_message_id"`
+		UpToMessageID int64 `json:"up_to_message_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := ParseJSONBody(r, &req); err != nil {
+			SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	if req.UpToMessageID < 0 || req.FromMessageID < 0 ||
+		(req.UpToMessageID > 0 && req.FromMessageID > req.UpToMessageID) {
+		SendError(w, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	marked, readAt, err := markMessagesRead(currentUserID, otherUserID, req.FromMessageID, req.UpToMessageID)
+	if err != nil {
+		log.Printf("Error acknowledging messages: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to mark messages as read")
+		return
+	}
+
+	SendSuccess(w, map[string]interface{}{
+		"chat_id":          otherUserID,
+		"from_message_id":  req.FromMessageID,
+		"up_to_message_id": req.UpToMessageID,
+		"marked":           marked,
+		"read_at":          readAt.Format(time.RFC3339),
+	})
+}
+
+// TypingAPI handles POST /api/messages/:id/typing - HTTP fallback for clients without a WebSocket
+func TypingAPI(w http.ResponseWriter, r *http.Request) {
+	currentUserID := requestUserID(r)
+
+	otherUserID, err := pathID(r, "id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if !areConnected(currentUserID, otherUserID) {
+		SendError(w, http.StatusForbidden, "You are not connected with this user")
+		return
+	}
+
+	// is_typing defaults to true so an empty POST means "still typing"
+	var req struct {
+		IsTyping *bool `json:"is_typing"`
+	}
+	if r.ContentLength != 0 {
+		if err := ParseJSONBody(r, &req); err != nil {
+			SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	isTyping := req.IsTyping == nil || *req.IsTyping
+
+	pushTyping(currentUserID, otherUserID, isTyping)
+
+	SendSuccess(w, map[string]interface{}{
+		"chat_id":   otherUserID,
+		"is_typing": isTyping,
+	})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"matcha/internal/store"
+)
+
+const maxSearchQueryLength = 200
+
+// indexMessageForSearch adds (or replaces, after an edit) a message in the full-text index.
+// Failures are logged only: the message itself is already saved.
+func indexMessageForSearch(messageID int64, content string) {
+	if err := store.Get().Messages.Index(messageID, content); err != nil {
+		log.Printf("Error indexing message %d for search: %v", messageID, err)
+	}
+}
+
+// removeMessageFromSearch drops a message from the full-text index (e.g. when it is deleted)
+func removeMessageFromSearch(messageID int64) {
+	if err := store.Get().Messages.Unindex(messageID); err != nil {
+		log.Printf("Error removing message %d from search index: %v", messageID, err)
+	}
+}
+
+// parseSnippetHighlights strips the highlight markers from a search snippet and returns the clean
+// text plus the highlighted ranges. Offsets and lengths are in UTF-16 code units (JavaScript string indices).
+func parseSnippetHighlights(marked string) (string, []map[string]int) {
+	var clean strings.Builder
+	highlights := []map[string]int{}
+	pos := 0 // UTF-16 offset in the clean text
+	start := -1
+	for _, r := range marked {
+		switch r {
+		case rune(store.SnippetStart[0]):
+			start = pos
+		case rune(store.SnippetEnd[0]):
+			if start >= 0 {
+				highlights = append(highlights, map[string]int{"start": start, "length": pos - start})
+				start = -1
+			}
+		default:
+			clean.WriteRune(r)
+			pos += len(utf16.Encode([]rune{r}))
+		}
+	}
+	return clean.String(), highlights
+}
+
+// ChatSearchAPI handles GET /api/chat/search?q= - full-text search over the current user's own conversations
+func ChatSearchAPI(w http.ResponseWriter, r *http.Request) {
+	currentUserID := requestUserID(r)
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		SendError(w, http.StatusBadRequest, "Search query is required")
+		return
+	}
+	if len(q) > maxSearchQueryLength {
+		SendError(w, http.StatusBadRequest, "Search query too long")
+		return
+	}
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 50 {
+			limit = l
+		}
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	// Only messages the user sent or received, and not deleted; newest first
+	matches, err := store.Get().Messages.Search(currentUserID, q, limit+1, offset)
+	if errors.Is(err, store.ErrSearchUnavailable) {
+		SendError(w, http.StatusServiceUnavailable, "Message search is not available on this server")
+		return
+	}
+	if err != nil {
+		log.Printf("Error searching messages: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to search messages")
+		return
+	}
+
+	results := []map[string]interface{}{}
+	for _, match := range matches {
+		msg := match.Message
+
+		// The conversation is identified by the other participant's user id (same as chat_id elsewhere)
+		conversationID := msg.ToUserID
+		if msg.ToUserID == currentUserID {
+			conversationID = msg.FromUserID
+		}
+
+		text, highlights := parseSnippetHighlights(match.Snippet)
+		results = append(results, map[string]interface{}{
+			"message_id":           msg.ID,
+			"chat_id":              conversationID,
+			"snippet":              text,
+			"highlights":           highlights,
+			"is_from_current_user": msg.FromUserID == currentUserID,
+			"created_at":           msg.CreatedAt.Format(time.RFC3339Nano),
+		})
+	}
+
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+	}
+
+	SendSuccess(w, map[string]interface{}{
+		"results":  results,
+		"has_more": hasMore,
+		"query":    q,
+	})
+}
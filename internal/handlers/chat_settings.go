@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// mutedForever is stored in muted_until for "mute until I unmute"
+var mutedForever = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// conversationSettings is one user's preferences for one conversation (defaults when never set)
+type conversationSettings store.ConversationSettings
+
+// isMuted reports whether message notifications are currently suppressed
+func (s conversationSettings) isMuted() bool {
+	return s.MutedUntil != nil && s.MutedUntil.After(time.Now())
+}
+
+// response is the API shape of the settings (also embedded in each GET /api/chat conversation)
+func (s conversationSettings) response() models.ConversationSettings {
+	out := models.ConversationSettings{
+		IsMuted:    s.isMuted(),
+		IsArchived: s.IsArchived,
+		IsPinned:   s.IsPinned,
+	}
+	if s.isMuted() {
+		until := s.MutedUntil.UTC().Truncate(time.Second)
+		out.MutedUntil = &until
+	}
+	return out
+}
+
+// loadConversationSettings returns userID's settings for the conversation with otherUserID
+func loadConversationSettings(userID, otherUserID int64) (conversationSettings, error) {
+	s, err := store.Get().Conversations.GetSettings(userID, otherUserID)
+	return conversationSettings(s), err
+}
+
+// isConversationMuted reports whether userID muted the conversation with otherUserID.
+// On a lookup error the conversation is treated as not muted so notifications aren't lost.
+func isConversationMuted(userID, otherUserID int64) bool {
+	s, err := loadConversationSettings(userID, otherUserID)
+	if err != nil {
+		log.Printf("Error loading conversation settings for user %d / %d: %v", userID, otherUserID, err)
+		return false
+	}
+	return s.isMuted()
+}
+
+// ConversationSettingsAPI handles PATCH /api/chat/:id - mute, archive or pin the conversation with user :id.
+// Only the fields present in the body change.
+func ConversationSettingsAPI(w http.ResponseWriter, r *http.Request) {
+	currentUserID := requestUserID(r)
+
+	otherUserID, err := pathID(r, "id")
+	if err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if !areConnected(currentUserID, otherUserID) {
+		SendError(w, http.StatusForbidden, "You are not connected with this user")
+		return
+	}
+
+	var req struct {
+		Muted      *bool   `json:"muted"`       // true = until unmuted, false = unmute
+		MutedUntil *string `json:"muted_until"` // RFC3339, mute until then
+		Archived   *bool   `json:"archived"`
+		Pinned     *bool   `json:"pinned"`
+	}
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Muted != nil && req.MutedUntil != nil {
+		SendError(w, http.StatusBadRequest, "Use either muted or muted_until, not both")
+		return
+	}
+	// An archived chat is hidden from the main list, so it can't also be pinned there
+	if req.Archived != nil && *req.Archived && req.Pinned != nil && *req.Pinned {
+		SendError(w, http.StatusBadRequest, "A conversation cannot be both archived and pinned")
+		return
+	}
+
+	settings, err := loadConversationSettings(currentUserID, otherUserID)
+	if err != nil {
+		log.Printf("Error loading conversation settings: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to update conversation")
+		return
+	}
+
+	if req.Muted != nil {
+		settings.MutedUntil = nil
+		if *req.Muted {
+			forever := mutedForever
+			settings.MutedUntil = &forever
+		}
+	}
+	if req.MutedUntil != nil {
+		until, err := time.Parse(time.RFC3339, *req.MutedUntil)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, "muted_until must be an RFC3339 timestamp")
+			return
+		}
+		if !until.After(time.Now()) {
+			SendError(w, http.StatusBadRequest, "muted_until must be in the future")
+			return
+		}
+		until = until.UTC()
+		settings.MutedUntil = &until
+	}
+	if req.Archived != nil {
+		settings.IsArchived = *req.Archived
+		if settings.IsArchived {
+			settings.IsPinned = false
+		}
+	}
+	if req.Pinned != nil {
+		settings.IsPinned = *req.Pinned
+		if settings.IsPinned {
+			settings.IsArchived = false
+		}
+	}
+
+	err = store.Get().Conversations.SaveSettings(currentUserID, otherUserID, store.ConversationSettings(settings))
+	if err != nil {
+		log.Printf("Error saving conversation settings: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to update conversation")
+		return
+	}
+
+	SendSuccess(w, struct {
+		ChatID int64 `json:"chat_id"`
+		models.ConversationSettings
+	}{otherUserID, settings.response()})
+}
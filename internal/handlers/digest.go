@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"matcha/internal/models"
+	"matcha/internal/services"
+)
+
+// DigestFrequencyRequest for POST /api/email-digest/unsubscribe
+type DigestFrequencyRequest struct {
+	Token     string `json:"token"`
+	Frequency string `json:"frequency"` // off (default), daily or weekly
+}
+
+// DigestUnsubscribeAPI handles POST /api/email-digest/unsubscribe, made by the page the unsubscribe
+// link in email digests opens. The token stands in for a login; it can also switch to another
+// frequency.
+func DigestUnsubscribeAPI(w http.ResponseWriter, r *http.Request) {
+	var req DigestFrequencyRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Frequency == "" {
+		req.Frequency = models.DigestOff
+	}
+	if !services.IsDigestFrequency(req.Frequency) {
+		SendError(w, http.StatusBadRequest, "Frequency must be off, daily or weekly")
+		return
+	}
+	if rateLimited(w, services.LimitResetVerifyPerIP, clientIP(r)) {
+		return
+	}
+
+	switch err := services.UpdateDigestFrequency(req.Token, req.Frequency); err {
+	case nil:
+	case services.ErrDigestTokenInvalid:
+		SendError(w, http.StatusBadRequest, "This unsubscribe link is invalid")
+		return
+	default:
+		log.Printf("Error updating digest frequency: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to update email digests")
+		return
+	}
+	message := "You won't get email digests anymore"
+	if req.Frequency != models.DigestOff {
+		message = "You'll get a " + req.Frequency + " email digest"
+	}
+	SendSuccess(w, map[string]string{"message": message, "frequency": req.Frequency})
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"matcha/internal/services"
+)
+
+// EmailChangeTokenRequest for POST /api/email-change/confirm and /revert
+type EmailChangeTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailChangeConfirmAPI handles POST /api/email-change/confirm, made by the page the link sent to the
+// new address opens. Works without a login, since the link may be opened in another browser.
+func EmailChangeConfirmAPI(w http.ResponseWriter, r *http.Request) {
+	var req EmailChangeTokenRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if rateLimited(w, services.LimitResetVerifyPerIP, clientIP(r)) {
+		return
+	}
+
+	email, err := services.ConfirmEmailChange(req.Token)
+	switch err {
+	case nil:
+	case services.ErrEmailChangeInvalid:
+		SendError(w, http.StatusBadRequest, "This link is invalid, already used or expired")
+		return
+	case services.ErrEmailTaken:
+		SendError(w, http.StatusConflict, "This email is now used by another account")
+		return
+	default:
+		log.Printf("Error confirming email change: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+	SendSuccess(w, map[string]interface{}{
+		"message": "Your email is now " + email,
+		"email":   email,
+	})
+}
+
+// EmailChangeRevertAPI handles POST /api/email-change/revert, made by the page the link sent to the
+// old address opens
+func EmailChangeRevertAPI(w http.ResponseWriter, r *http.Request) {
+	var req EmailChangeTokenRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if rateLimited(w, services.LimitResetVerifyPerIP, clientIP(r)) {
+		return
+	}
+
+	undone, err := services.RevertEmailChange(req.Token)
+	switch err {
+	case nil:
+	case services.ErrEmailChangeInvalid:
+		SendError(w, http.StatusBadRequest, "This link is invalid, already used or expired")
+		return
+	case services.ErrEmailTaken:
+		SendError(w, http.StatusConflict, "Your old email is now used by another account")
+		return
+	default:
+		log.Printf("Error reverting email change: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to revert email change")
+		return
+	}
+	message := "The email change was cancelled"
+	if undone {
+		message = "Your email was changed back and you were logged out everywhere. Reset your password to make sure only you can log in."
+	}
+	SendSuccess(w, map[string]interface{}{"message": message, "reverted": undone})
+}
+
+// CancelEmailChangeAPI handles DELETE /api/profile/email-change: drops the pending email change
+func CancelEmailChangeAPI(w http.ResponseWriter, r *http.Request) {
+	cancelled, err := services.CancelEmailChange(requestUserID(r))
+	if err != nil {
+		log.Printf("Error cancelling email change: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to cancel email change")
+		return
+	}
+	if !cancelled {
+		SendError(w, http.StatusNotFound, "No pending email change")
+		return
+	}
+	SendSuccess(w, map[string]interface{}{"message": "Email change cancelled"})
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"matcha/internal/models"
+	"matcha/internal/services"
+	"matcha/internal/store"
+
+	"goji.io/pat"
+	"goji.io/pattern"
+)
+
+// Route access levels, declared per route in SetupAPIRoutes. The middleware authenticates the request
+// once and puts the caller in the request context, where handlers read it with requestUserID/requestAuth.
+type accessLevel int
+
+const (
+	accessPublic   accessLevel = iota // No authentication
+	accessOptional                    // The caller is known if they send a valid token, anonymous otherwise
+	accessUser                        // Logged in (profile setup may be pending)
+	accessMember                      // Logged in, email verified and profile set up
+	accessAdmin                       // A member with the admin role
+)
+
+// Auth is the authenticated caller of a request
+type Auth struct {
+	UserID    int64
+	SessionID int64
+	IsBot     bool
+	Role      string
+}
+
+type authContextKey struct{}
+
+// requestAuth returns the caller the middleware authenticated, nil for anonymous requests
+func requestAuth(r *http.Request) *Auth {
+	auth, _ := r.Context().Value(authContextKey{}).(*Auth)
+	return auth
+}
+
+// requestUserID returns the id of the authenticated caller, 0 for anonymous requests
+func requestUserID(r *http.Request) int64 {
+	if auth := requestAuth(r); auth != nil {
+		return auth.UserID
+	}
+	return 0
+}
+
+// requireAccess wraps a handler with authentication and authorization for the given level. With
+// queryToken the access token may also come from ?token= (WebSocket, EventSource, <img src>).
+func requireAccess(level accessLevel, queryToken bool, h http.HandlerFunc) http.HandlerFunc {
+	if level == accessPublic {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r, queryToken)
+		if err != nil {
+			if level == accessOptional && err != errCSRFMismatch {
+				h(w, r)
+				return
+			}
+			if err == errCSRFMismatch {
+				SendError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
+			return
+		}
+
+		user, err := store.Get().Users.GetByID(claims.UserID)
+		if err != nil {
+			SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
+			return
+		}
+		if level >= accessMember {
+			if !user.IsEmailVerified {
+				SendError(w, http.StatusForbidden, "Please verify your email first")
+				return
+			}
+			if !user.IsSetup {
+				SendError(w, http.StatusForbidden, "Please complete your profile setup first")
+				return
+			}
+		}
+		if level == accessAdmin && user.Role != models.RoleAdmin {
+			SendError(w, http.StatusForbidden, "Admin access required")
+			return
+		}
+
+		auth := &Auth{UserID: user.ID, SessionID: claims.SessionID, IsBot: user.IsBot, Role: user.Role}
+		h(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, auth)))
+	}
+}
+
+// authenticateRequest returns the claims of the request's access token: from the Authorization header,
+// else ?token= if allowed, else the access token cookie (see auth_cookies.go)
+func authenticateRequest(r *http.Request, queryToken bool) (*services.Claims, error) {
+	if queryToken && r.Header.Get("Authorization") == "" {
+		if token := strings.TrimSpace(r.URL.Query().Get("token")); token != "" {
+			return services.AuthenticateToken(token)
+		}
+	}
+	return getClaimsFromRequest(r)
+}
+
+// getClaimsFromRequest authenticates the Bearer token of the request, or else its access token cookie
+// (see auth_cookies.go), and returns its claims (user and session)
+func getClaimsFromRequest(r *http.Request) (*services.Claims, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		token := cookieValue(r, accessTokenCookie)
+		if token == "" {
+			return nil, errors.New("missing authorization header")
+		}
+		if err := checkCSRF(r); err != nil {
+			return nil, err
+		}
+		return services.AuthenticateToken(token)
+	}
+
+	// Extract token from "Bearer <token>" format
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, errors.New("invalid authorization header format")
+	}
+
+	return services.AuthenticateToken(parts[1])
+}
+
+// withRouteParams copies the variables goji matched in the route pattern (:id, ...) into the request's
+// context, where pat.Param finds them. goji passes them in a separate context that starts empty, so
+// using that one instead would lose the request's cancellation.
+func withRouteParams(ctx context.Context, r *http.Request) *http.Request {
+	vars, _ := ctx.Value(pattern.AllVariables).(map[pattern.Variable]interface{})
+	if len(vars) == 0 {
+		return r
+	}
+	reqCtx := r.Context()
+	for name, value := range vars {
+		reqCtx = context.WithValue(reqCtx, name, value)
+	}
+	return r.WithContext(reqCtx)
+}
+
+// pathID parses a positive id route parameter (e.g. :id)
+func pathID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(pat.Param(r.Context(), name), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return id, nil
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// NotificationEvent is one notification as sent over the SSE stream, with the recipient's unread count
+type NotificationEvent struct {
+	models.NotificationItem
+	UnreadCount int `json:"unread_count"`
+}
+
+// notificationBroker fans out freshly inserted notifications to the recipient's open streams
+type notificationBroker struct {
+	mu          sync.RWMutex
+	subscribers map[int64]map[chan NotificationEvent]struct{}
+}
+
+var notificationStreams = &notificationBroker{subscribers: make(map[int64]map[chan NotificationEvent]struct{})}
+
+const (
+	sseSubscriberBuffer  = 32               // Pending events per stream; overflow is recovered via Last-Event-ID on reconnect
+	sseKeepAliveInterval = 25 * time.Second // Comment line to keep proxies from closing idle streams
+	sseReplayPageSize    = 100              // Missed notifications loaded per query on reconnect (all are replayed)
+)
+
+// subscribe registers a new stream for the user
+func (b *notificationBroker) subscribe(userID int64) chan NotificationEvent {
+	ch := make(chan NotificationEvent, sseSubscriberBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan NotificationEvent]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes a stream for the user
+func (b *notificationBroker) unsubscribe(userID int64, ch chan NotificationEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers[userID], ch)
+	if len(b.subscribers[userID]) == 0 {
+		delete(b.subscribers, userID)
+	}
+}
+
+// publish sends the event to every open stream of the user without blocking
+func (b *notificationBroker) publish(userID int64, event NotificationEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers[userID] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Notification stream buffer full for user %d, dropping event %d", userID, event.ID)
+		}
+	}
+}
+
+// hasSubscribers reports whether the user has at least one open stream
+func (b *notificationBroker) hasSubscribers(userID int64) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers[userID]) > 0
+}
+
+// publishNotification loads a just-inserted notification and pushes it to the recipient's streams
+func publishNotification(notificationID, userID int64) {
+	if !notificationStreams.hasSubscribers(userID) {
+		return
+	}
+	n, err := store.Get().Notifications.Get(notificationID)
+	if err != nil {
+		log.Printf("Error loading notification %d for stream: %v", notificationID, err)
+		return
+	}
+	event := notificationEvent(n)
+	event.UnreadCount = countUnreadNotifications(userID)
+	notificationStreams.publish(userID, event)
+}
+
+// countUnreadNotifications returns the badge count for the user
+func countUnreadNotifications(userID int64) int {
+	unread, _ := store.Get().Notifications.CountUnread(userID)
+	return unread
+}
+
+// NotificationsStreamAPI handles GET /api/notifications/stream (Server-Sent Events).
+// Each event's id is the notification id, so a reconnecting EventSource resumes via Last-Event-ID.
+func NotificationsStreamAPI(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		SendError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	// Last-Event-ID header (set by EventSource on reconnect), or ?last_event_id= for manual clients
+	lastEventIDStr := r.Header.Get("Last-Event-ID")
+	if lastEventIDStr == "" {
+		lastEventIDStr = r.URL.Query().Get("last_event_id")
+	}
+	var lastEventID int64
+	if lastEventIDStr != "" {
+		if id, err := strconv.ParseInt(strings.TrimSpace(lastEventIDStr), 10, 64); err == nil && id > 0 {
+			lastEventID = id
+		}
+	}
+
+	// Subscribe before replaying so nothing inserted in between is missed (duplicates are skipped by id)
+	events := notificationStreams.subscribe(userID)
+	defer notificationStreams.unsubscribe(userID, events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	// Replay everything missed, a page at a time; the badge count is the same for all of them
+	if lastEventID > 0 {
+		unread := countUnreadNotifications(userID)
+		for {
+			missed, err := loadNotificationsAfter(userID, lastEventID, unread)
+			if err != nil {
+				log.Printf("Error loading missed notifications for user %d: %v", userID, err)
+				break
+			}
+			for _, event := range missed {
+				if err := writeSSEEvent(w, event); err != nil {
+					return
+				}
+				lastEventID = event.ID
+			}
+			flusher.Flush()
+			if len(missed) < sseReplayPageSize {
+				break
+			}
+		}
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if event.ID <= lastEventID {
+				continue
+			}
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+			lastEventID = event.ID
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprintf(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// loadNotificationsAfter returns up to sseReplayPageSize of the user's notifications with id > afterID,
+// oldest first, with the given unread count
+func loadNotificationsAfter(userID, afterID int64, unread int) ([]NotificationEvent, error) {
+	list, err := store.Get().Notifications.ListAfter(userID, afterID, sseReplayPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []NotificationEvent{}
+	for i := range list {
+		event := notificationEvent(&list[i])
+		event.UnreadCount = unread
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// notificationEvent is the stream payload for a stored notification (UnreadCount is set by the caller)
+func notificationEvent(n *models.Notification) NotificationEvent {
+	return NotificationEvent{NotificationItem: notificationItem(n)}
+}
+
+// writeSSEEvent writes one notification in text/event-stream format
+func writeSSEEvent(w http.ResponseWriter, event NotificationEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: notification\ndata: %s\n\n", event.ID, payload)
+	return err
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"matcha/internal/config"
+	"matcha/internal/services"
+
+	"goji.io/pat"
+)
+
+// oidcStateCookie holds the state of the browser's OIDC login attempt (see services.FinishOIDCLogin)
+const oidcStateCookie = "matcha_oidc_state"
+
+// OIDCStartRequest for POST /api/oidc/:provider/start
+type OIDCStartRequest struct {
+	Link bool `json:"link"` // Link the provider account to the logged in user instead of logging in
+}
+
+// OIDCCallbackRequest for POST /api/oidc/:provider/callback
+type OIDCCallbackRequest struct {
+	Code       string `json:"code"`
+	State      string `json:"state"`
+	UseCookies bool   `json:"use_cookies"`
+}
+
+// OIDCProvidersAPI handles GET /api/oidc/providers: the providers to show login buttons for
+func OIDCProvidersAPI(w http.ResponseWriter, r *http.Request) {
+	providers := []map[string]string{}
+	for _, p := range services.ListOIDCProviders() {
+		providers = append(providers, map[string]string{"name": p.Name, "display_name": p.DisplayName})
+	}
+	SendSuccess(w, map[string]interface{}{"providers": providers})
+}
+
+// OIDCStartAPI handles POST /api/oidc/:provider/start: returns the provider URL to send the browser to
+func OIDCStartAPI(w http.ResponseWriter, r *http.Request) {
+	var req OIDCStartRequest
+	if r.ContentLength != 0 {
+		if err := ParseJSONBody(r, &req); err != nil {
+			SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	var linkUserID int64
+	if req.Link {
+		if linkUserID = requestUserID(r); linkUserID == 0 {
+			SendError(w, http.StatusUnauthorized, "Log in to link an account")
+			return
+		}
+	}
+
+	cfg := config.Load()
+	authURL, state, err := services.StartOIDCLogin(cfg, pat.Param(r.Context(), "provider"), linkUserID)
+	if err == services.ErrOIDCUnknownProvider {
+		SendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting OIDC login: %v", err)
+		SendError(w, http.StatusBadGateway, "Login provider is unavailable")
+		return
+	}
+	// Ties the attempt to this browser; the callback checks it
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc",
+		MaxAge:   int(services.OIDCStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	SendSuccess(w, map[string]interface{}{"authorization_url": authURL})
+}
+
+// OIDCCallbackAPI handles POST /api/oidc/:provider/callback, made by the frontend page the provider
+// redirects to. Logs in like /api/login, or reports the link when linking.
+func OIDCCallbackAPI(w http.ResponseWriter, r *http.Request) {
+	var req OIDCCallbackRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if rateLimited(w, services.LimitLoginPerIP, clientIP(r)) {
+		return
+	}
+
+	cfg := config.Load()
+	provider := pat.Param(r.Context(), "provider")
+	browserState := cookieValue(r, oidcStateCookie)
+	// One attempt per cookie, whatever the outcome
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/api/oidc", MaxAge: -1, Secure: cfg.CookieSecure})
+	result, err := services.FinishOIDCLogin(cfg, provider, req.Code, req.State, browserState)
+	switch err {
+	case nil:
+	case services.ErrOIDCUnknownProvider:
+		SendError(w, http.StatusNotFound, err.Error())
+		return
+	case services.ErrOIDCStateInvalid, services.ErrOIDCNoEmail:
+		SendError(w, http.StatusBadRequest, err.Error())
+		return
+	case services.ErrOIDCEmailTaken, services.ErrOIDCLinkedElsewhere:
+		SendError(w, http.StatusConflict, err.Error())
+		return
+	default:
+		log.Printf("Error finishing OIDC login: %v", err)
+		SendError(w, http.StatusBadGateway, "Login with the provider failed")
+		return
+	}
+
+	if result.Linked {
+		SendSuccess(w, map[string]interface{}{"linked": true, "provider": provider})
+		return
+	}
+	if !result.User.IsEmailVerified {
+		SendSuccess(w, map[string]interface{}{
+			"email_verified": false,
+			"message":        "Email not verified",
+			"username":       result.User.Username,
+		})
+		return
+	}
+	completeLogin(w, r, result.User, req.UseCookies)
+}
+
+// IdentitiesAPI handles GET /api/profile/identities: the provider accounts linked to the user
+func IdentitiesAPI(w http.ResponseWriter, r *http.Request) {
+	identities, err := services.ListIdentities(requestUserID(r))
+	if err != nil {
+		log.Printf("Error listing identities: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to load linked accounts")
+		return
+	}
+	SendSuccess(w, map[string]interface{}{"identities": identities})
+}
+
+// UnlinkIdentityAPI handles DELETE /api/profile/identities/:provider
+func UnlinkIdentityAPI(w http.ResponseWriter, r *http.Request) {
+	removed, err := services.UnlinkIdentity(requestUserID(r), pat.Param(r.Context(), "provider"))
+	if err == services.ErrOIDCLastLogin {
+		SendError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error unlinking identity: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to unlink account")
+		return
+	}
+	if !removed {
+		SendError(w, http.StatusNotFound, "No linked account for this provider")
+		return
+	}
+	SendSuccess(w, map[string]interface{}{"message": "Account unlinked"})
+}
@@ -5,7 +5,7 @@ import (
 	"sync"
 	"time"
 
-	"matcha/internal/database"
+	"matcha/internal/store"
 )
 
 var (
@@ -30,8 +30,7 @@ func updateLastSeenSporadically(userID int64) {
 
 	if shouldUpdate {
 		// Update last_seen in database
-		_, err := database.DB.Exec("UPDATE users SET last_seen = CURRENT_TIMESTAMP WHERE id = ?", userID)
-		if err != nil {
+		if err := store.Get().Users.TouchLastSeen(userID); err != nil {
 			log.Printf("Error updating last_seen for user %d: %v", userID, err)
 			return
 		}
@@ -49,8 +48,7 @@ func ensureUserIsOnline(userID int64) {
 		return
 	}
 
-	_, err := database.DB.Exec("UPDATE users SET is_online = 1 WHERE id = ?", userID)
-	if err != nil {
+	if err := store.Get().Users.MarkOnline(userID); err != nil {
 		log.Printf("Error setting user %d online: %v", userID, err)
 	}
 }
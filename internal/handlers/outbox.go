@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"matcha/internal/models"
+	"matcha/internal/services"
+	"matcha/internal/store"
+)
+
+// Outbox event types (see services/outbox.go)
+const (
+	outboxNotification = "notification"
+	outboxFameUpdate   = "fame_update"
+	outboxBotActivity  = "bot_activity"
+)
+
+type notificationOutboxPayload struct {
+	UserID        int64  `json:"user_id"`
+	Type          string `json:"type"`
+	Message       string `json:"message"`
+	RelatedUserID int64  `json:"related_user_id"`
+}
+
+type fameOutboxPayload struct {
+	UserID int64 `json:"user_id"`
+}
+
+type botActivityOutboxPayload struct {
+	BotID          int64  `json:"bot_id"`
+	BotUsername    string `json:"bot_username"`
+	ActionType     string `json:"action_type"`
+	TargetUserID   int64  `json:"target_user_id"`
+	TargetUsername string `json:"target_username"`
+	Details        string `json:"details"`
+}
+
+// RegisterOutboxHandlers wires the outbox event types to their side effects. Call before starting the dispatcher.
+func RegisterOutboxHandlers() {
+	services.RegisterOutboxHandler(outboxNotification, handleNotificationOutbox)
+	services.RegisterOutboxHandler(outboxFameUpdate, handleFameOutbox)
+	services.RegisterOutboxHandler(outboxBotActivity, handleBotActivityOutbox)
+}
+
+// handleNotificationOutbox inserts the notification exactly once, then pushes it to open SSE streams
+func handleNotificationOutbox(event services.OutboxEvent) error {
+	var p notificationOutboxPayload
+	if err := json.Unmarshal(event.Payload, &p); err != nil {
+		return err
+	}
+
+	var notificationID int64
+	err := store.Get().Tx(func(tx *sql.Tx) error {
+		claimed, err := services.ClaimOutboxEvent(tx, event.ID)
+		if err != nil || !claimed {
+			return err
+		}
+		notificationID, err = store.Get().Notifications.CreateTx(tx, &models.Notification{
+			UserID:        p.UserID,
+			Type:          p.Type,
+			Message:       p.Message,
+			RelatedUserID: p.RelatedUserID,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if notificationID > 0 {
+		publishNotification(notificationID, p.UserID)
+	}
+	return nil
+}
+
+// handleFameOutbox recalculates the user's fame rating (idempotent, so safe to retry)
+func handleFameOutbox(event services.OutboxEvent) error {
+	var p fameOutboxPayload
+	if err := json.Unmarshal(event.Payload, &p); err != nil {
+		return err
+	}
+	return services.UpdateFameRating(p.UserID)
+}
+
+// handleBotActivityOutbox writes one bot_activity_log row exactly once
+func handleBotActivityOutbox(event services.OutboxEvent) error {
+	var p botActivityOutboxPayload
+	if err := json.Unmarshal(event.Payload, &p); err != nil {
+		return err
+	}
+	return store.Get().Tx(func(tx *sql.Tx) error {
+		claimed, err := services.ClaimOutboxEvent(tx, event.ID)
+		if err != nil || !claimed {
+			return err
+		}
+		return store.Get().BotActivity.CreateTx(tx, &store.BotActivity{
+			BotID:          p.BotID,
+			BotUsername:    p.BotUsername,
+			ActionType:     p.ActionType,
+			TargetUserID:   &p.TargetUserID,
+			TargetUsername: &p.TargetUsername,
+			Details:        &p.Details,
+		})
+	})
+}
+
+// outboxEvent is a side effect stored in the transaction of the write that causes it (see writeWithOutbox)
+type outboxEvent struct {
+	eventType string
+	payload   interface{}
+}
+
+func notificationOutboxEvent(toUserID int64, notifType, message string, relatedUserID int64) outboxEvent {
+	return outboxEvent{outboxNotification, notificationOutboxPayload{
+		UserID:        toUserID,
+		Type:          notifType,
+		Message:       message,
+		RelatedUserID: relatedUserID,
+	}}
+}
+
+func fameOutboxEvent(userID int64) outboxEvent {
+	return outboxEvent{outboxFameUpdate, fameOutboxPayload{UserID: userID}}
+}
+
+// messageOutboxEvents returns the side effects of a new message from fromUserID to toUserID, notification
+// first so it exists as soon as possible.
+// Reads happen here, before the write transaction, since SQLite's write transaction can't wait on other queries.
+func messageOutboxEvents(fromUserID, toUserID int64, content string) []outboxEvent {
+	var events []outboxEvent
+
+	// Muted conversations get no notification (the WebSocket push still happens)
+	if !isConversationMuted(toUserID, fromUserID) {
+		events = append(events, notificationOutboxEvent(toUserID, "message", getDisplayName(fromUserID)+" sent you a message", fromUserID))
+	}
+
+	// Bot senders are logged for the simulator dashboard
+	events = append(events, botActivityOutboxEvents(fromUserID, toUserID, "send_message", content)...)
+	return append(events, fameOutboxEvent(fromUserID))
+}
+
+// botActivityOutboxEvents returns the bot_activity event of userID acting on targetUserID, or none if userID
+// isn't a bot. Like messageOutboxEvents, call it before the write transaction.
+func botActivityOutboxEvents(userID, targetUserID int64, actionType, details string) []outboxEvent {
+	bot, err := store.Get().Users.GetByID(userID)
+	if err != nil || !bot.IsBot {
+		return nil
+	}
+	var targetUsername string
+	if target, err := store.Get().Users.GetByID(targetUserID); err == nil {
+		targetUsername = target.Username
+	}
+	return []outboxEvent{{outboxBotActivity, botActivityOutboxPayload{
+		BotID:          userID,
+		BotUsername:    bot.Username,
+		ActionType:     actionType,
+		TargetUserID:   targetUserID,
+		TargetUsername: targetUsername,
+		Details:        details,
+	}}}
+}
+
+// addOutboxEvents stores events in tx, in order, and returns their ids
+func addOutboxEvents(tx *sql.Tx, events []outboxEvent) ([]int64, error) {
+	var ids []int64
+	for _, event := range events {
+		id, err := services.AddOutboxEvent(tx, event.eventType, event.payload)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// writeWithOutbox runs write in a transaction and stores the events it returns in the same transaction, so
+// they happen exactly when the write is committed, then runs them. Returns whether write changed something.
+func writeWithOutbox(write func(tx *sql.Tx) (bool, []outboxEvent, error)) (bool, error) {
+	var changed bool
+	var eventIDs []int64
+	err := store.Get().Tx(func(tx *sql.Tx) error {
+		var events []outboxEvent
+		var err error
+		changed, events, err = write(tx)
+		if err != nil {
+			return err
+		}
+		eventIDs, err = addOutboxEvents(tx, events)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	services.ProcessOutboxEvents(eventIDs...)
+	return changed, nil
+}
+
+// insertMessage stores a message and its outbox events in one transaction. extra, if set, runs in the same
+// transaction (e.g. to insert attachments). Returns the message id and the outbox event ids to process.
+func insertMessage(fromUserID, toUserID int64, content string, extra func(tx *sql.Tx, messageID int64) error) (int64, []int64, error) {
+	events := messageOutboxEvents(fromUserID, toUserID, content)
+
+	var messageID int64
+	var eventIDs []int64
+	err := store.Get().Tx(func(tx *sql.Tx) error {
+		eventIDs = nil
+		var err error
+		messageID, err = store.Get().Messages.CreateTx(tx, &models.Message{
+			FromUserID: fromUserID,
+			ToUserID:   toUserID,
+			Content:    content,
+		})
+		if err != nil {
+			return err
+		}
+		if extra != nil {
+			if err := extra(tx, messageID); err != nil {
+				return err
+			}
+		}
+		eventIDs, err = addOutboxEvents(tx, events)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error inserting message from %d to %d: %v", fromUserID, toUserID, err)
+		return 0, nil, err
+	}
+	return messageID, eventIDs, nil
+}
@@ -2,13 +2,13 @@ package handlers
 
 import (
 	"crypto/rand"
-	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,69 +17,21 @@ import (
 	"time"
 
 	"matcha/internal/config"
-	"matcha/internal/database"
+	"matcha/internal/models"
 	"matcha/internal/services"
+	"matcha/internal/store"
 )
 
 // ProfileAPI handles GET /api/profile
 func ProfileAPI(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from token
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	userID := requestUserID(r)
 
 	// Ensure user is online and update last_seen sporadically
 	ensureUserIsOnline(userID)
 	updateLastSeenSporadically(userID)
 
 	// Load user profile from database
-	var user struct {
-		ID                int64
-		Username          string
-		Email             string
-		FirstName         string
-		LastName          string
-		Gender            *string
-		SexualPreference  *string
-		Biography         *string
-		BirthDate         *string
-		Location          *string
-		Latitude          *float64
-		Longitude         *float64
-		LocationUpdatedAt *string
-		FameRating        float64
-		IsSetup           bool
-		Openness          *string
-		Conscientiousness *string
-		Extraversion      *string
-		Agreeableness     *string
-		Neuroticism       *string
-		Siblings          *string
-		MBTI              *string
-		CaliperProfile    *string
-		LastSeen          *string
-		CreatedAt         *string
-	}
-
-	err = database.DB.QueryRow(`
-		SELECT 
-			id, username, email, first_name, last_name,
-			gender, sexual_preference, biography, birth_date,
-			location, latitude, longitude, location_updated_at, fame_rating, is_setup,
-			openness, conscientiousness, extraversion, agreeableness, neuroticism,
-			siblings, mbti, caliper_profile, last_seen, created_at
-		FROM users 
-		WHERE id = ?
-	`, userID).Scan(
-		&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName,
-		&user.Gender, &user.SexualPreference, &user.Biography, &user.BirthDate,
-		&user.Location, &user.Latitude, &user.Longitude, &user.LocationUpdatedAt, &user.FameRating, &user.IsSetup,
-		&user.Openness, &user.Conscientiousness, &user.Extraversion, &user.Agreeableness, &user.Neuroticism,
-		&user.Siblings, &user.MBTI, &user.CaliperProfile, &user.LastSeen, &user.CreatedAt,
-	)
-
+	user, err := store.Get().Users.GetByID(userID)
 	if err != nil {
 		log.Printf("Error loading profile: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load profile")
@@ -87,132 +39,69 @@ func ProfileAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build response
-	response := map[string]interface{}{
-		"id":          user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"first_name":  user.FirstName,
-		"last_name":   user.LastName,
-		"fame_rating": user.FameRating,
-		"is_setup":    user.IsSetup,
-	}
-
-	// Add nullable fields if they exist
-	if user.Gender != nil {
-		response["gender"] = *user.Gender
-	}
-	if user.SexualPreference != nil {
-		response["sexual_preference"] = *user.SexualPreference
-	}
-	if user.Biography != nil {
-		response["biography"] = *user.Biography
-	}
-	if user.BirthDate != nil {
-		response["birth_date"] = *user.BirthDate
-	}
-	if user.Location != nil {
-		response["location"] = *user.Location
-	}
-	if user.Latitude != nil {
-		response["latitude"] = *user.Latitude
-	}
-	if user.Longitude != nil {
-		response["longitude"] = *user.Longitude
-	}
-	if user.LocationUpdatedAt != nil {
-		response["location_updated_at"] = *user.LocationUpdatedAt
-	}
-	if user.LastSeen != nil {
-		response["last_seen"] = *user.LastSeen
-	}
-	if user.CreatedAt != nil {
-		response["created_at"] = *user.CreatedAt
-	}
-
-	// Add Big Five personality traits
-	bigFive := map[string]string{}
-	if user.Openness != nil {
-		bigFive["openness"] = *user.Openness
-	}
-	if user.Conscientiousness != nil {
-		bigFive["conscientiousness"] = *user.Conscientiousness
-	}
-	if user.Extraversion != nil {
-		bigFive["extraversion"] = *user.Extraversion
-	}
-	if user.Agreeableness != nil {
-		bigFive["agreeableness"] = *user.Agreeableness
-	}
-	if user.Neuroticism != nil {
-		bigFive["neuroticism"] = *user.Neuroticism
-	}
-	response["big_five"] = bigFive
-
-	// Add other personality fields
-	if user.Siblings != nil {
-		response["siblings"] = *user.Siblings
-	}
-	if user.MBTI != nil {
-		response["mbti"] = *user.MBTI
-	}
-	if user.CaliperProfile != nil {
-		response["caliper_profile"] = *user.CaliperProfile
-	}
-
-	// Load tags from user_tags table
-	rows, err := database.DB.Query("SELECT tag FROM user_tags WHERE user_id = ?", userID)
-	if err == nil {
-		defer rows.Close()
-		tags := []string{}
-		for rows.Next() {
-			var tag string
-			if err := rows.Scan(&tag); err == nil {
-				tags = append(tags, tag)
-			}
-		}
-		response["tags"] = tags
-	} else {
-		response["tags"] = []string{}
-	}
-
-	// Load images from user_pictures table
-	imageRows, err := database.DB.Query(`
-		SELECT id, file_path, is_profile, order_index 
-		FROM user_pictures 
-		WHERE user_id = ? 
-		ORDER BY order_index ASC
-	`, userID)
-	if err == nil {
-		defer imageRows.Close()
-		images := []map[string]interface{}{}
-		for imageRows.Next() {
-			var id int64
-			var filePath string
-			var isProfile int
-			var orderIndex int
-			if err := imageRows.Scan(&id, &filePath, &isProfile, &orderIndex); err == nil {
-				images = append(images, map[string]interface{}{
-					"id":          id,
-					"file_path":   filePath,
-					"is_profile":  isProfile == 1,
-					"order_index": orderIndex,
-				})
-			}
+	profile := models.OwnProfile{
+		ID:                user.ID,
+		Username:          user.Username,
+		Email:             user.Email,
+		Locale:            user.Locale,
+		DigestFrequency:   user.DigestFrequency,
+		FirstName:         user.FirstName,
+		LastName:          user.LastName,
+		FameRating:        user.FameRating,
+		IsSetup:           user.IsSetup,
+		Gender:            user.Gender,
+		SexualPreference:  user.SexualPreference,
+		Biography:         user.Biography,
+		BirthDate:         optionalTime(user.BirthDate),
+		Location:          user.Location,
+		Latitude:          user.Latitude,
+		Longitude:         user.Longitude,
+		LocationUpdatedAt: user.LocationUpdatedAt,
+		LastSeen:          optionalTime(user.LastSeen),
+		CreatedAt:         user.CreatedAt,
+		// Big Five personality traits
+		BigFive: models.BigFive{
+			Openness:          user.Openness,
+			Conscientiousness: user.Conscientiousness,
+			Extraversion:      user.Extraversion,
+			Agreeableness:     user.Agreeableness,
+			Neuroticism:       user.Neuroticism,
+		},
+		// Other personality fields
+		Siblings:       user.Siblings,
+		MBTI:           user.MBTI,
+		CaliperProfile: user.CaliperProfile,
+		Tags:           []string{},
+		Images:         []models.ProfileImage{},
+	}
+
+	if pendingEmail, err := services.PendingEmail(userID); err == nil {
+		profile.PendingEmail = pendingEmail
+	}
+
+	// Load tags
+	if tags, err := store.Get().Tags.List(userID); err == nil {
+		profile.Tags = tags
+	}
+
+	// Load images
+	if pictures, err := store.Get().Pictures.List(userID); err == nil {
+		for _, picture := range pictures {
+			profile.Images = append(profile.Images, models.ProfileImage{
+				ID:         picture.ID,
+				FilePath:   picture.FilePath,
+				IsProfile:  picture.IsProfile,
+				OrderIndex: picture.Order,
+			})
 		}
-		response["images"] = images
-	} else {
-		response["images"] = []map[string]interface{}{}
 	}
 
 	// Likes received (for profile display)
-	var likesReceived int64
-	if err := database.DB.QueryRow("SELECT COUNT(*) FROM likes WHERE to_user_id = ?", userID).Scan(&likesReceived); err == nil {
-		response["likes_received_count"] = likesReceived
-	} else {
-		response["likes_received_count"] = int64(0)
+	if likesReceived, err := store.Get().Likes.CountReceived(userID); err == nil {
+		profile.LikesReceivedCount = likesReceived
 	}
 
-	SendSuccess(w, response)
+	SendSuccess(w, profile)
 }
 
 // ProfileUpdateRequest represents profile update request
@@ -231,16 +120,13 @@ type ProfileUpdateRequest struct {
 	Latitude         *float64          `json:"latitude"`
 	Longitude        *float64          `json:"longitude"`
 	Location         string            `json:"location"`
+	Locale           string            `json:"locale"`           // Language of the user's emails
+	DigestFrequency  string            `json:"digest_frequency"` // How often to get an email digest: off, daily or weekly
 }
 
 // ProfileUpdateAPI handles POST /api/profile
 func ProfileUpdateAPI(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from token
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	userID := requestUserID(r)
 
 	// Parse request body
 	var req ProfileUpdateRequest
@@ -249,201 +135,136 @@ func ProfileUpdateAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build update query dynamically based on provided fields
-	updates := []string{}
-	args := []interface{}{}
+	// Only the fields provided are updated
+	changes := store.ProfileChanges{}
+	set := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+
+	changes.FirstName = set(req.FirstName)
+	changes.LastName = set(req.LastName)
 
-	if req.FirstName != "" {
-		updates = append(updates, "first_name = ?")
-		args = append(args, req.FirstName)
+	if req.Locale != "" && !services.IsSupportedLocale(req.Locale) {
+		SendError(w, http.StatusBadRequest, "Unsupported locale")
+		return
 	}
+	changes.Locale = set(req.Locale)
 
-	if req.LastName != "" {
-		updates = append(updates, "last_name = ?")
-		args = append(args, req.LastName)
+	if req.DigestFrequency != "" && !services.IsDigestFrequency(req.DigestFrequency) {
+		SendError(w, http.StatusBadRequest, "Digest frequency must be off, daily or weekly")
+		return
 	}
+	changes.DigestFrequency = set(req.DigestFrequency)
 
+	// A new email only replaces the current one once confirmed from the new address
+	var pendingEmail string
 	if req.Email != "" {
-		// Check if email is already taken by another user
-		var existingUserID int64
-		err := database.DB.QueryRow("SELECT id FROM users WHERE email = ? AND id != ?", req.Email, userID).Scan(&existingUserID)
-		if err == nil {
-			SendError(w, http.StatusBadRequest, "Email is already taken")
+		user, err := store.Get().Users.GetByID(userID)
+		if err != nil {
+			log.Printf("Error loading user: %v", err)
+			SendError(w, http.StatusInternalServerError, "Failed to update profile")
 			return
 		}
-		updates = append(updates, "email = ?")
-		args = append(args, req.Email)
-	}
-
-	if req.Gender != "" {
-		updates = append(updates, "gender = ?")
-		args = append(args, req.Gender)
+		if services.NormalizeEmail(req.Email) != user.Email {
+			if rateLimited(w, services.LimitEmailChangePerUser, strconv.FormatInt(userID, 10)) {
+				return
+			}
+			_, err := services.RequestEmailChange(config.Load(), userID, req.Email)
+			switch err {
+			case nil:
+				pendingEmail = services.NormalizeEmail(req.Email)
+			case services.ErrInvalidEmail:
+				SendError(w, http.StatusBadRequest, "Invalid email address")
+				return
+			case services.ErrEmailTaken:
+				SendError(w, http.StatusBadRequest, "Email is already taken")
+				return
+			default:
+				log.Printf("Error requesting email change: %v", err)
+				SendError(w, http.StatusInternalServerError, "Failed to send the email change confirmation")
+				return
+			}
+		}
 	}
 
-	if req.SexualPreference != "" {
-		updates = append(updates, "sexual_preference = ?")
-		args = append(args, req.SexualPreference)
-	}
+	changes.Gender = set(req.Gender)
+	changes.SexualPreference = set(req.SexualPreference)
 
-	if req.Biography != "" {
-		if len(req.Biography) > MaxBiographyLength {
-			SendError(w, http.StatusBadRequest, "Biography too long")
-			return
-		}
-		updates = append(updates, "biography = ?")
-		args = append(args, req.Biography)
+	if len(req.Biography) > MaxBiographyLength {
+		SendError(w, http.StatusBadRequest, "Biography too long")
+		return
 	}
+	changes.Biography = set(req.Biography)
 
 	// Big Five personality traits
 	if req.BigFive != nil {
-		if openness, ok := req.BigFive["openness"]; ok && openness != "" {
-			updates = append(updates, "openness = ?")
-			args = append(args, openness)
-		}
-		if conscientiousness, ok := req.BigFive["conscientiousness"]; ok && conscientiousness != "" {
-			updates = append(updates, "conscientiousness = ?")
-			args = append(args, conscientiousness)
-		}
-		if extraversion, ok := req.BigFive["extraversion"]; ok && extraversion != "" {
-			updates = append(updates, "extraversion = ?")
-			args = append(args, extraversion)
-		}
-		if agreeableness, ok := req.BigFive["agreeableness"]; ok && agreeableness != "" {
-			updates = append(updates, "agreeableness = ?")
-			args = append(args, agreeableness)
-		}
-		if neuroticism, ok := req.BigFive["neuroticism"]; ok && neuroticism != "" {
-			updates = append(updates, "neuroticism = ?")
-			args = append(args, neuroticism)
-		}
+		changes.Openness = set(req.BigFive["openness"])
+		changes.Conscientiousness = set(req.BigFive["conscientiousness"])
+		changes.Extraversion = set(req.BigFive["extraversion"])
+		changes.Agreeableness = set(req.BigFive["agreeableness"])
+		changes.Neuroticism = set(req.BigFive["neuroticism"])
 	}
 
 	// Other personality fields
-	if req.Siblings != "" {
-		updates = append(updates, "siblings = ?")
-		args = append(args, req.Siblings)
-	}
-	if req.MBTI != "" {
-		updates = append(updates, "mbti = ?")
-		args = append(args, req.MBTI)
-	}
-	if req.CaliperProfile != "" {
-		updates = append(updates, "caliper_profile = ?")
-		args = append(args, req.CaliperProfile)
-	}
-
-	// Location fields
-	locationUpdated := false
-	if req.Latitude != nil {
-		updates = append(updates, "latitude = ?")
-		args = append(args, *req.Latitude)
-		locationUpdated = true
-	}
-	if req.Longitude != nil {
-		updates = append(updates, "longitude = ?")
-		args = append(args, *req.Longitude)
-		locationUpdated = true
-	}
-	if req.Location != "" {
-		updates = append(updates, "location = ?")
-		args = append(args, req.Location)
-	}
-	// Update location_updated_at when location coordinates are updated
-	if locationUpdated {
-		updates = append(updates, "location_updated_at = CURRENT_TIMESTAMP")
-	}
-
-	// Always update updated_at
-	updates = append(updates, "updated_at = CURRENT_TIMESTAMP")
-
-	// Only update if there are fields to update
-	if len(updates) > 0 {
-		args = append(args, userID)
-		query := "UPDATE users SET " + strings.Join(updates, ", ") + " WHERE id = ?"
-
-		_, err := database.DB.Exec(query, args...)
-		if err != nil {
-			log.Printf("Error updating profile: %v", err)
-			SendError(w, http.StatusInternalServerError, "Failed to update profile")
+	changes.Siblings = set(req.Siblings)
+	changes.MBTI = set(req.MBTI)
+	changes.CaliperProfile = set(req.CaliperProfile)
+
+	// Location fields (location_updated_at follows the coordinates)
+	changes.Latitude = req.Latitude
+	changes.Longitude = req.Longitude
+	changes.Location = set(req.Location)
+
+	// Validate tags before writing anything
+	tags := []string{}
+	for _, tag := range req.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if len(tag) > MaxTagLength {
+			SendError(w, http.StatusBadRequest, "Tag too long")
 			return
 		}
+		tags = append(tags, tag)
 	}
 
-	// Update tags if provided (sync so refetch after save sees them). Parameterized queries only (SQL injection protection).
-	_, err = database.DB.Exec("DELETE FROM user_tags WHERE user_id = ?", userID)
-	if err != nil {
-		log.Printf("Error deleting tags: %v", err)
-	} else if len(req.Tags) > 0 {
-		for _, tag := range req.Tags {
-			tag = strings.TrimSpace(tag)
-			if tag != "" {
-				if len(tag) > MaxTagLength {
-					SendError(w, http.StatusBadRequest, "Tag too long")
-					return
-				}
-				_, err = database.DB.Exec("INSERT INTO user_tags (user_id, tag) VALUES (?, ?)", userID, tag)
-				if err != nil {
-					log.Printf("Error inserting tag '%s': %v", tag, err)
-				}
-			}
-		}
+	// Profile fields and tags are replaced in one transaction, so a failure never leaves a user without tags.
+	// Parameterized queries only (SQL injection protection).
+	if err := store.Get().Users.UpdateProfile(userID, changes, tags); err != nil {
+		log.Printf("Error updating profile: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to update profile")
+		return
 	}
 
+	if pendingEmail != "" {
+		SendSuccess(w, map[string]interface{}{
+			"message":       "Profile updated. Follow the link sent to " + pendingEmail + " to confirm your new email.",
+			"pending_email": pendingEmail,
+		})
+		return
+	}
 	SendSuccess(w, map[string]interface{}{
 		"message": "Profile updated successfully",
 	})
 }
 
-// getUserIDFromRequest extracts user ID from Authorization header
-func getUserIDFromRequest(r *http.Request) (int64, error) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return 0, errors.New("missing authorization header")
-	}
-
-	// Extract token from "Bearer <token>" format
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return 0, errors.New("invalid authorization header format")
-	}
-
-	token := parts[1]
-	userID, err := services.ValidateToken(token)
-	if err != nil {
-		return 0, err
-	}
-
-	return userID, nil
-}
-
 // SetupCompleteAPI handles POST /api/profile/setup-complete
 func SetupCompleteAPI(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from token
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	userID := requestUserID(r)
 
 	// Update is_setup to 1 in database
-	result, err := database.DB.Exec(
-		"UPDATE users SET is_setup = 1 WHERE id = ?",
-		userID,
-	)
+	updated, err := store.Get().Users.CompleteSetup(userID)
 	if err != nil {
 		log.Printf("Error updating is_setup: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to complete profile setup")
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("Error checking rows affected: %v", err)
-		SendError(w, http.StatusInternalServerError, "Failed to complete profile setup")
-		return
-	}
-
-	if rowsAffected == 0 {
+	if !updated {
 		SendError(w, http.StatusNotFound, "User not found")
 		return
 	}
@@ -456,52 +277,16 @@ func SetupCompleteAPI(w http.ResponseWriter, r *http.Request) {
 
 // ResetProfileAPI handles POST /api/profile/reset
 func ResetProfileAPI(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from token
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	userID := requestUserID(r)
 
-	// Reset all profile fields except: first_name, last_name, username, email, password_hash, is_email_verified
-	// Set is_setup to 0 to require profile setup again
-	_, err = database.DB.Exec(
-		`UPDATE users SET 
-			gender = NULL,
-			sexual_preference = NULL,
-			biography = NULL,
-			birth_date = NULL,
-			fame_rating = 0.0,
-			latitude = NULL,
-			longitude = NULL,
-			location = NULL,
-			is_setup = 0,
-			profile_picture_id = NULL,
-			openness = NULL,
-			conscientiousness = NULL,
-			extraversion = NULL,
-			agreeableness = NULL,
-			neuroticism = NULL,
-			siblings = NULL,
-			mbti = NULL,
-			caliper_profile = NULL,
-			updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`,
-		userID,
-	)
-	if err != nil {
+	// Reset all profile fields except: first_name, last_name, username, email, password_hash, is_email_verified,
+	// and delete the user's tags. is_setup goes back to 0 to require profile setup again.
+	if err := store.Get().Users.ResetProfile(userID); err != nil {
 		log.Printf("Error resetting profile: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to reset profile")
 		return
 	}
 
-	// Delete user tags
-	_, err = database.DB.Exec("DELETE FROM user_tags WHERE user_id = ?", userID)
-	if err != nil {
-		log.Printf("Error deleting tags during reset: %v", err)
-		// Don't fail the reset if tag deletion fails
-	}
-
 	SendSuccess(w, map[string]interface{}{
 		"message": "Profile reset successfully",
 	})
@@ -514,12 +299,8 @@ type ChangePasswordRequest struct {
 
 // ChangePasswordAPI handles POST /api/profile/change-password
 func ChangePasswordAPI(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from token
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	auth := requestAuth(r)
+	userID := auth.UserID
 
 	// Parse request body
 	var req ChangePasswordRequest
@@ -554,16 +335,17 @@ func ChangePasswordAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update password in database
-	_, err = database.DB.Exec(
-		"UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
-		passwordHash, userID,
-	)
-	if err != nil {
+	if err := store.Get().Users.UpdatePassword(userID, passwordHash); err != nil {
 		log.Printf("Error updating password: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to update password")
 		return
 	}
 
+	// Log out other devices; this one stays logged in
+	if _, err := services.EndAllSessions(userID, auth.SessionID, services.SessionRevokedPasswordChange); err != nil {
+		log.Printf("Error revoking other sessions of user %d: %v", userID, err)
+	}
+
 	SendSuccess(w, map[string]interface{}{
 		"message": "Password updated successfully",
 	})
@@ -572,11 +354,7 @@ func ChangePasswordAPI(w http.ResponseWriter, r *http.Request) {
 // SendPasswordResetLinkAPI handles POST /api/profile/send-password-reset-link
 // Sends an email to the current user with a link to set a new password (e.g. /reset-password?token=...).
 func SendPasswordResetLinkAPI(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	userID := requestUserID(r)
 	cfg := config.Load()
 	if err := services.SendPasswordResetLinkForUser(userID, cfg); err != nil {
 		log.Printf("Error sending password reset link: %v", err)
@@ -598,16 +376,10 @@ func UploadImageAPI(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Get user ID from token
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	userID := requestUserID(r)
 
 	// Parse multipart form (max 10MB)
-	err = r.ParseMultipartForm(10 << 20) // 10MB
-	if err != nil {
+	if err := r.ParseMultipartForm(maxImageUploadSize); err != nil {
 		SendError(w, http.StatusBadRequest, "Failed to parse form data")
 		return
 	}
@@ -631,60 +403,18 @@ func UploadImageAPI(w http.ResponseWriter, r *http.Request) {
 	isProfileStr := r.FormValue("is_profile")
 	isProfile := isProfileStr == "1"
 
-	// Validate file type
-	ext := strings.ToLower(filepath.Ext(handler.Filename))
-	allowedExts := []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
-	validExt := false
-	for _, allowedExt := range allowedExts {
-		if ext == allowedExt {
-			validExt = true
-			break
-		}
-	}
-	if !validExt {
-		SendError(w, http.StatusBadRequest, "Invalid file type. Only JPG, PNG, GIF, and WebP are allowed")
-		return
-	}
-
-	// Validate file size (max 10MB)
-	if handler.Size > 10<<20 {
-		SendError(w, http.StatusBadRequest, "File size exceeds 10MB limit")
+	// Validate file type and size
+	ext, err := validateImageUpload(handler)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Create organized directory structure: uploads/user_id/
 	userUploadDir := filepath.Join("uploads", fmt.Sprintf("%d", userID))
-	err = os.MkdirAll(userUploadDir, 0755)
+	filename, err := saveImageUpload(file, userUploadDir, strconv.Itoa(slot), ext)
 	if err != nil {
-		log.Printf("Error creating user upload directory: %v", err)
-		SendError(w, http.StatusInternalServerError, "Failed to create upload directory")
-		return
-	}
-
-	// Generate unique filename using random bytes + timestamp + slot
-	// Format: {random_hex}_{timestamp}_{slot}{ext}
-	randomBytes := make([]byte, 8)
-	if _, err := rand.Read(randomBytes); err != nil {
-		log.Printf("Error generating random bytes: %v", err)
-		SendError(w, http.StatusInternalServerError, "Failed to generate filename")
-		return
-	}
-	randomHex := hex.EncodeToString(randomBytes)
-	timestamp := time.Now().Unix()
-	filename := fmt.Sprintf("%s_%d_%d%s", randomHex, timestamp, slot, ext)
-	filePath := filepath.Join(userUploadDir, filename)	// Save file
-	dst, err := os.Create(filePath)
-	if err != nil {
-		log.Printf("Error creating file: %v", err)
-		SendError(w, http.StatusInternalServerError, "Failed to save file")
-		return
-	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		log.Printf("Error copying file: %v", err)
-		os.Remove(filePath) // Clean up on error
+		log.Printf("Error saving uploaded image: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to save file")
 		return
 	}
@@ -692,64 +422,23 @@ func UploadImageAPI(w http.ResponseWriter, r *http.Request) {
 	// Get relative path for database: /uploads/user_id/filename
 	relativePath := fmt.Sprintf("/uploads/%d/%s", userID, filename)
 
-	// Check if image already exists at this slot
-	var existingID int64
-	var existingFilePath string
-	err = database.DB.QueryRow(`
-		SELECT id, file_path FROM user_pictures 
-		WHERE user_id = ? AND order_index = ?
-	`, userID, slot).Scan(&existingID, &existingFilePath)
-
-	if err == nil {
-		// Update existing image
-		_, err = database.DB.Exec(`
-			UPDATE user_pictures 
-			SET file_path = ?, is_profile = ?
-			WHERE id = ?
-		`, relativePath, isProfile, existingID)
-		if err != nil {
-			log.Printf("Error updating image: %v", err)
-			SendError(w, http.StatusInternalServerError, "Failed to update image")
-			return
-		}
-
-		// Delete old file if it exists
-		if existingFilePath != "" && !strings.HasPrefix(existingFilePath, "http") {
-			// Remove leading /uploads/ to get relative path
-			oldPath := strings.TrimPrefix(existingFilePath, "/uploads/")
-			if oldPath != "" {
-				fullOldPath := filepath.Join("uploads", oldPath)
-				if err := os.Remove(fullOldPath); err != nil && !os.IsNotExist(err) {
-					log.Printf("Warning: Failed to delete old file %s: %v", fullOldPath, err)
-				}
-			}
-		}
-	} else {
-		// Insert new image
-		_, err = database.DB.Exec(`
-			INSERT INTO user_pictures (user_id, file_path, is_profile, order_index, created_at)
-			VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
-		`, userID, relativePath, isProfile, slot)
-		if err != nil {
-			log.Printf("Error inserting image: %v", err)
-			SendError(w, http.StatusInternalServerError, "Failed to save image record")
-			return
-		}
+	// Picture row and profile_picture_id change together in one transaction
+	existingFilePath, err := store.Get().Pictures.Save(userID, slot, relativePath, isProfile)
+	if err != nil {
+		log.Printf("Error saving image record: %v", err)
+		os.Remove(filepath.Join(userUploadDir, filename))
+		SendError(w, http.StatusInternalServerError, "Failed to save image record")
+		return
 	}
 
-	// If this is the profile image, update user's profile_picture_id
-	if isProfile {
-		var pictureID int64
-		err = database.DB.QueryRow(`
-			SELECT id FROM user_pictures 
-			WHERE user_id = ? AND order_index = 0
-		`, userID).Scan(&pictureID)
-		if err == nil {
-			_, err = database.DB.Exec(`
-				UPDATE users SET profile_picture_id = ? WHERE id = ?
-			`, pictureID, userID)
-			if err != nil {
-				log.Printf("Error updating profile_picture_id: %v", err)
+	// Delete the replaced file only once the new record is committed
+	if existingFilePath != "" && !strings.HasPrefix(existingFilePath, "http") {
+		// Remove leading /uploads/ to get relative path
+		oldPath := strings.TrimPrefix(existingFilePath, "/uploads/")
+		if oldPath != "" {
+			fullOldPath := filepath.Join("uploads", oldPath)
+			if err := os.Remove(fullOldPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: Failed to delete old file %s: %v", fullOldPath, err)
 			}
 		}
 	}
@@ -767,6 +456,58 @@ func UploadImageAPI(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Image uploads (profile pictures and chat attachments)
+const maxImageUploadSize = 10 << 20 // 10MB
+
+var allowedImageExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// validateImageUpload checks the file type and size of an uploaded image and returns its lowercase extension.
+// The error message is safe to show to the user.
+func validateImageUpload(handler *multipart.FileHeader) (string, error) {
+	ext := strings.ToLower(filepath.Ext(handler.Filename))
+	validExt := false
+	for _, allowedExt := range allowedImageExts {
+		if ext == allowedExt {
+			validExt = true
+			break
+		}
+	}
+	if !validExt {
+		return "", errors.New("Invalid file type. Only JPG, PNG, GIF, and WebP are allowed")
+	}
+	if handler.Size > maxImageUploadSize {
+		return "", errors.New("File size exceeds 10MB limit")
+	}
+	return ext, nil
+}
+
+// saveImageUpload writes an uploaded image into dir (created if missing) under a unique name and returns that name.
+// Format: {random_hex}_{timestamp}_{suffix}{ext}
+func saveImageUpload(file io.Reader, dir, suffix, ext string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create upload directory: %v", err)
+	}
+
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("generate filename: %v", err)
+	}
+	filename := fmt.Sprintf("%s_%d_%s%s", hex.EncodeToString(randomBytes), time.Now().Unix(), suffix, ext)
+	filePath := filepath.Join(dir, filename)
+
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("create file: %v", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, file); err != nil {
+		os.Remove(filePath) // Clean up on error
+		return "", fmt.Errorf("copy file: %v", err)
+	}
+	return filename, nil
+}
+
 // ReorderImagesRequest represents image reorder request
 type ReorderImagesRequest struct {
 	Slot1 int `json:"slot1"`
@@ -775,12 +516,7 @@ type ReorderImagesRequest struct {
 
 // ReorderImagesAPI handles POST /api/profile/reorder-images
 func ReorderImagesAPI(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from token
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	userID := requestUserID(r)
 
 	// Parse request body
 	var req ReorderImagesRequest
@@ -800,73 +536,13 @@ func ReorderImagesAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get images at both slots
-	var id1, id2 int64
-	var filePath1, filePath2 string
-	var isProfile1, isProfile2 int
-
-	_ = database.DB.QueryRow(`
-		SELECT id, file_path, is_profile FROM user_pictures 
-		WHERE user_id = ? AND order_index = ?
-	`, userID, req.Slot1).Scan(&id1, &filePath1, &isProfile1)
-
-	_ = database.DB.QueryRow(`
-		SELECT id, file_path, is_profile FROM user_pictures 
-		WHERE user_id = ? AND order_index = ?
-	`, userID, req.Slot2).Scan(&id2, &filePath2, &isProfile2)
-
-	// Swap the order_index values
-	// Use a temporary value to avoid conflicts
-	_, err = database.DB.Exec(`
-		UPDATE user_pictures 
-		SET order_index = ? 
-		WHERE user_id = ? AND order_index = ?
-	`, -1, userID, req.Slot1)
-	if err != nil {
-		log.Printf("Error updating slot1: %v", err)
-		SendError(w, http.StatusInternalServerError, "Failed to reorder images")
-		return
-	}
-
-	_, err = database.DB.Exec(`
-		UPDATE user_pictures 
-		SET order_index = ? 
-		WHERE user_id = ? AND order_index = ?
-	`, req.Slot1, userID, req.Slot2)
-	if err != nil {
-		log.Printf("Error updating slot2: %v", err)
+	// Swap the two slots (and profile_picture_id if slot 0 is one of them) in one transaction
+	if err := store.Get().Pictures.Swap(userID, req.Slot1, req.Slot2); err != nil {
+		log.Printf("Error reordering images: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to reorder images")
 		return
 	}
 
-	_, err = database.DB.Exec(`
-		UPDATE user_pictures 
-		SET order_index = ? 
-		WHERE user_id = ? AND order_index = ?
-	`, req.Slot2, userID, -1)
-	if err != nil {
-		log.Printf("Error finalizing swap: %v", err)
-		SendError(w, http.StatusInternalServerError, "Failed to reorder images")
-		return
-	}
-
-	// If slot 0 (profile image) changed, update profile_picture_id
-	if req.Slot1 == 0 || req.Slot2 == 0 {
-		var pictureID int64
-		err = database.DB.QueryRow(`
-			SELECT id FROM user_pictures 
-			WHERE user_id = ? AND order_index = 0
-		`, userID).Scan(&pictureID)
-		if err == nil {
-			_, err = database.DB.Exec(`
-				UPDATE users SET profile_picture_id = ? WHERE id = ?
-			`, pictureID, userID)
-			if err != nil {
-				log.Printf("Error updating profile_picture_id: %v", err)
-			}
-		}
-	}
-
 	SendSuccess(w, map[string]interface{}{
 		"message": "Images reordered successfully",
 	})
@@ -874,122 +550,51 @@ func ReorderImagesAPI(w http.ResponseWriter, r *http.Request) {
 
 // ProfileVisitorsAPI handles GET /api/profile/visitors
 func ProfileVisitorsAPI(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from token
-	userID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	userID := requestUserID(r)
 
 	// Get current user's location for distance calculation
-	var userLat, userLng sql.NullFloat64
-	database.DB.QueryRow("SELECT latitude, longitude FROM users WHERE id = ?", userID).Scan(&userLat, &userLng)
+	var userLat, userLng *float64
+	if user, err := store.Get().Users.GetByID(userID); err == nil {
+		userLat, userLng = user.Latitude, user.Longitude
+	}
 
 	// Get visitors (people who viewed this profile) - only first time for each
-	rows, err := database.DB.Query(`
-		SELECT 
-			v.viewer_id,
-			v.created_at as viewed_at,
-			u.first_name,
-			u.last_name,
-			u.username,
-			u.latitude,
-			u.longitude,
-			u.location,
-			(SELECT file_path FROM user_pictures WHERE user_id = u.id AND is_profile = 1 AND order_index = 0 LIMIT 1) as profile_picture
-		FROM views v
-		INNER JOIN users u ON v.viewer_id = u.id
-		INNER JOIN (
-			SELECT viewer_id, MIN(created_at) as first_viewed_at
-			FROM views
-			WHERE viewed_id = ?
-			GROUP BY viewer_id
-		) first_views ON v.viewer_id = first_views.viewer_id AND v.created_at = first_views.first_viewed_at
-		WHERE v.viewed_id = ?
-		ORDER BY v.created_at DESC
-		LIMIT 50
-	`, userID, userID)
-
+	list, err := store.Get().Views.Visitors(userID, 50)
 	if err != nil {
 		log.Printf("Error querying visitors: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load visitors")
 		return
 	}
-	defer rows.Close()
 
 	visitors := []map[string]interface{}{}
-	for rows.Next() {
-		var visitor struct {
-			ViewerID       int64
-			ViewedAt       string
-			FirstName      string
-			LastName       string
-			Username       string
-			Latitude       sql.NullFloat64
-			Longitude      sql.NullFloat64
-			Location       sql.NullString
-			ProfilePicture sql.NullString
-		}
-
-		err := rows.Scan(
-			&visitor.ViewerID, &visitor.ViewedAt,
-			&visitor.FirstName, &visitor.LastName, &visitor.Username,
-			&visitor.Latitude, &visitor.Longitude, &visitor.Location,
-			&visitor.ProfilePicture,
-		)
-		if err != nil {
-			log.Printf("Error scanning visitor: %v", err)
-			continue
-		}
-
+	for _, visitor := range list {
 		// Calculate distance if both users have coordinates
 		distance := -1.0
-		if userLat.Valid && userLng.Valid && visitor.Latitude.Valid && visitor.Longitude.Valid {
-			distance = calculateDistance(
-				userLat.Float64, userLng.Float64,
-				visitor.Latitude.Float64, visitor.Longitude.Float64,
-			)
+		if userLat != nil && userLng != nil && visitor.User.Latitude != nil && visitor.User.Longitude != nil {
+			distance = calculateDistance(*userLat, *userLng, *visitor.User.Latitude, *visitor.User.Longitude)
 		}
 
-		// Check if visitor liked current user
-		var visitorLikedID int64
-		var visitorLikedAt sql.NullString
-		visitorLikedErr := database.DB.QueryRow(`
-			SELECT l.id, l.created_at
-			FROM likes l
-			WHERE l.from_user_id = ? AND l.to_user_id = ?
-		`, visitor.ViewerID, userID).Scan(&visitorLikedID, &visitorLikedAt)
-		visitorLikedMe := (visitorLikedErr == nil)
-
-		// Check if current user liked visitor back (mutual like = connected)
-		var currentUserLikedID int64
-		currentUserLikedErr := database.DB.QueryRow(`
-			SELECT l.id
-			FROM likes l
-			WHERE l.from_user_id = ? AND l.to_user_id = ?
-		`, userID, visitor.ViewerID).Scan(&currentUserLikedID)
-		currentUserLikedThem := (currentUserLikedErr == nil)
-
 		// Connected only if both liked each other
-		isConnected := visitorLikedMe && currentUserLikedThem
+		visitorLikedMe := visitor.LikedAt != nil
+		isConnected := visitorLikedMe && visitor.LikedBack
 
 		visitorData := map[string]interface{}{
-			"viewer_id":       visitor.ViewerID,
-			"first_name":      visitor.FirstName,
-			"last_name":       visitor.LastName,
-			"username":        visitor.Username,
-			"viewed_at":       visitor.ViewedAt,
-			"profile_picture": visitor.ProfilePicture.String,
+			"viewer_id":       visitor.User.ID,
+			"first_name":      visitor.User.FirstName,
+			"last_name":       visitor.User.LastName,
+			"username":        visitor.User.Username,
+			"viewed_at":       visitor.ViewedAt.Format(time.RFC3339Nano),
+			"profile_picture": visitor.User.ProfilePicture,
 			"distance":        distance,
 			"is_connected":    isConnected,
-			"gave_like":       visitorLikedMe && !currentUserLikedThem, // They liked me but I didn't like them back
+			"gave_like":       visitorLikedMe && !visitor.LikedBack, // They liked me but I didn't like them back
 		}
 
-		if visitor.Location.Valid {
-			visitorData["location"] = visitor.Location.String
+		if visitor.User.Location != "" {
+			visitorData["location"] = visitor.User.Location
 		}
-		if isConnected && visitorLikedAt.Valid {
-			visitorData["connected_at"] = visitorLikedAt.String
+		if isConnected {
+			visitorData["connected_at"] = visitor.LikedAt.Format(time.RFC3339Nano)
 		}
 
 		visitors = append(visitors, visitorData)
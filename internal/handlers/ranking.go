@@ -5,7 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
-	"matcha/internal/database"
+	"matcha/internal/store"
 )
 
 // RankingAPI handles GET /api/ranking
@@ -27,10 +27,7 @@ func RankingAPI(w http.ResponseWriter, r *http.Request) {
 	offset := (page - 1) * limit
 
 	// Get total count
-	var total int
-	err := database.DB.QueryRow(`
-		SELECT COUNT(*) FROM users WHERE is_setup = 1
-	`).Scan(&total)
+	total, err := store.Get().Users.CountSetUp()
 	if err != nil {
 		log.Printf("Error counting users: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load ranking")
@@ -38,55 +35,29 @@ func RankingAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get users ranked by fame_rating
-	rows, err := database.DB.Query(`
-		SELECT 
-			id,
-			username,
-			first_name,
-			last_name,
-			fame_rating,
-			is_bot
-		FROM users
-		WHERE is_setup = 1
-		ORDER BY fame_rating DESC, id ASC
-		LIMIT ? OFFSET ?
-	`, limit, offset)
+	ranked, err := store.Get().Users.Ranking(limit, offset)
 	if err != nil {
 		log.Printf("Error querying ranking: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load ranking")
 		return
 	}
-	defer rows.Close()
 
 	users := []map[string]interface{}{}
-	rank := offset + 1
-	for rows.Next() {
-		var id int64
-		var username, firstName, lastName string
-		var fameRating float64
-		var isBot int
-
-		err := rows.Scan(&id, &username, &firstName, &lastName, &fameRating, &isBot)
-		if err != nil {
-			log.Printf("Error scanning user row: %v", err)
-			continue
-		}
-
+	for i, u := range ranked {
 		// Calculate level from fame rating
-		level := int(fameRating)
+		level := int(u.FameRating)
 
 		user := map[string]interface{}{
-			"rank":        rank,
-			"id":          id,
-			"username":    username,
-			"first_name":  firstName,
-			"last_name":   lastName,
-			"fame_rating": fameRating,
+			"rank":        offset + i + 1,
+			"id":          u.ID,
+			"username":    u.Username,
+			"first_name":  u.FirstName,
+			"last_name":   u.LastName,
+			"fame_rating": u.FameRating,
 			"level":       level,
-			"is_bot":      isBot == 1,
+			"is_bot":      u.IsBot,
 		}
 		users = append(users, user)
-		rank++
 	}
 
 	SendSuccess(w, map[string]interface{}{
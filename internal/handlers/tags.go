@@ -1,48 +1,22 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
 	"strings"
 
-	"matcha/internal/database"
+	"matcha/internal/store"
 )
 
 // PopularTagsAPI handles GET /api/tags/popular
 func PopularTagsAPI(w http.ResponseWriter, r *http.Request) {
 	// Get top 5 most popular tags with user counts
-	rows, err := database.DB.Query(`
-		SELECT tag, COUNT(DISTINCT user_id) as user_count
-		FROM user_tags
-		WHERE tag IS NOT NULL AND tag != ''
-		GROUP BY tag
-		ORDER BY user_count DESC
-		LIMIT 5
-	`)
+	popularTags, err := store.Get().Tags.Popular(5)
 	if err != nil {
 		log.Printf("Error querying popular tags: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load popular tags")
 		return
 	}
-	defer rows.Close()
-
-	popularTags := []map[string]interface{}{}
-	for rows.Next() {
-		var tag string
-		var userCount int64
-
-		err := rows.Scan(&tag, &userCount)
-		if err != nil {
-			log.Printf("Error scanning popular tag: %v", err)
-			continue
-		}
-
-		popularTags = append(popularTags, map[string]interface{}{
-			"tag":        tag,
-			"user_count": userCount,
-		})
-	}
 
 	SendSuccess(w, map[string]interface{}{
 		"popular_tags": popularTags,
@@ -51,12 +25,7 @@ func PopularTagsAPI(w http.ResponseWriter, r *http.Request) {
 
 // AddTagAPI handles POST /api/tags/add
 func AddTagAPI(w http.ResponseWriter, r *http.Request) {
-	// Get current user ID from authentication token
-	currentUserID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	currentUserID := requestUserID(r)
 
 	// Parse request body
 	var req struct {
@@ -82,10 +51,7 @@ func AddTagAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if user already has 5 tags
-	var tagCount int64
-	err = database.DB.QueryRow(`
-		SELECT COUNT(*) FROM user_tags WHERE user_id = ?
-	`, currentUserID).Scan(&tagCount)
+	tagCount, err := store.Get().Tags.Count(currentUserID)
 	if err != nil {
 		log.Printf("Error counting user tags: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to check tag count")
@@ -97,34 +63,20 @@ func AddTagAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if tag already exists for user
-	var existingTagID int64
-	err = database.DB.QueryRow(`
-		SELECT id FROM user_tags WHERE user_id = ? AND tag = ?
-	`, currentUserID, tag).Scan(&existingTagID)
-
-	if err == nil {
+	// Insert tag; one the user already has is left as it is
+	added, err := store.Get().Tags.Add(currentUserID, tag)
+	if err != nil {
+		log.Printf("Error inserting tag: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to add tag")
+		return
+	}
+	if !added {
 		// Tag already exists
 		SendSuccess(w, map[string]interface{}{
 			"message": "Tag already exists",
 			"tag":     tag,
 		})
 		return
-	} else if err != sql.ErrNoRows {
-		log.Printf("Error checking existing tag: %v", err)
-		SendError(w, http.StatusInternalServerError, "Failed to check tag")
-		return
-	}
-
-	// Insert tag
-	_, err = database.DB.Exec(`
-		INSERT INTO user_tags (user_id, tag)
-		VALUES (?, ?)
-	`, currentUserID, tag)
-	if err != nil {
-		log.Printf("Error inserting tag: %v", err)
-		SendError(w, http.StatusInternalServerError, "Failed to add tag")
-		return
 	}
 
 	SendSuccess(w, map[string]interface{}{
@@ -135,12 +87,7 @@ func AddTagAPI(w http.ResponseWriter, r *http.Request) {
 
 // RemoveTagAPI handles POST /api/tags/remove (parameterized query only — SQL injection protection).
 func RemoveTagAPI(w http.ResponseWriter, r *http.Request) {
-	// Get current user ID from authentication token
-	currentUserID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	currentUserID := requestUserID(r)
 
 	// Parse request body
 	var req struct {
@@ -166,21 +113,14 @@ func RemoveTagAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete tag
-	result, err := database.DB.Exec(`
-		DELETE FROM user_tags WHERE user_id = ? AND tag = ?
-	`, currentUserID, tag)
+	removed, err := store.Get().Tags.Remove(currentUserID, tag)
 	if err != nil {
 		log.Printf("Error deleting tag: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to remove tag")
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("Error getting rows affected: %v", err)
-	}
-
-	if rowsAffected == 0 {
+	if !removed {
 		SendSuccess(w, map[string]interface{}{
 			"message": "Tag was not found",
 			"tag":     tag,
@@ -196,31 +136,15 @@ func RemoveTagAPI(w http.ResponseWriter, r *http.Request) {
 
 // UserTagMatchAPI handles GET /api/tags/user-match
 func UserTagMatchAPI(w http.ResponseWriter, r *http.Request) {
-	// Get current user ID from authentication token
-	currentUserID, err := getUserIDFromRequest(r)
-	if err != nil {
-		SendError(w, http.StatusUnauthorized, "Invalid or missing authentication token")
-		return
-	}
+	currentUserID := requestUserID(r)
 
 	// Get user's tags
-	userTagRows, err := database.DB.Query(`
-		SELECT tag FROM user_tags WHERE user_id = ?
-	`, currentUserID)
+	userTags, err := store.Get().Tags.List(currentUserID)
 	if err != nil {
 		log.Printf("Error querying user tags: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to check tag matches")
 		return
 	}
-	defer userTagRows.Close()
-
-	userTags := []string{}
-	for userTagRows.Next() {
-		var tag string
-		if err := userTagRows.Scan(&tag); err == nil {
-			userTags = append(userTags, tag)
-		}
-	}
 
 	// If user has no tags, they have no matches
 	if len(userTags) == 0 {
@@ -233,15 +157,7 @@ func UserTagMatchAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if any other users have matching tags (case-insensitive comparison)
-	var matchCount int64
-	err = database.DB.QueryRow(`
-		SELECT COUNT(DISTINCT ut.user_id)
-		FROM user_tags ut
-		INNER JOIN user_tags user_tags ON LOWER(TRIM(ut.tag)) = LOWER(TRIM(user_tags.tag))
-		WHERE ut.user_id != ? 
-		AND user_tags.user_id = ?
-		AND ut.user_id IN (SELECT id FROM users WHERE is_setup = 1 AND is_email_verified = 1)
-	`, currentUserID, currentUserID).Scan(&matchCount)
+	matchCount, err := store.Get().Tags.CountUsersSharingTags(currentUserID)
 	if err != nil {
 		log.Printf("Error checking tag matches: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to check tag matches")
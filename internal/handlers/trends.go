@@ -3,9 +3,8 @@ package handlers
 import (
 	"log"
 	"net/http"
-	"strings"
 
-	"matcha/internal/database"
+	"matcha/internal/store"
 )
 
 // TrendsAPI handles GET /api/trends — returns popular tags, MBTI distribution, gender and orientation counts.
@@ -16,128 +15,44 @@ func TrendsAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Popular tags (top 20)
-	tagRows, err := database.DB.Query(`
-		SELECT tag, COUNT(DISTINCT user_id) as user_count
-		FROM user_tags
-		WHERE tag IS NOT NULL AND TRIM(tag) != ''
-		GROUP BY LOWER(TRIM(tag))
-		ORDER BY user_count DESC
-		LIMIT 20
-	`)
+	tags, err := store.Get().Tags.Popular(20)
 	if err != nil {
 		log.Printf("Error querying popular tags for trends: %v", err)
 		SendError(w, http.StatusInternalServerError, "Failed to load trends")
 		return
 	}
-	defer tagRows.Close()
-
 	popularTags := []map[string]interface{}{}
-	for tagRows.Next() {
-		var tag string
-		var userCount int64
-		if err := tagRows.Scan(&tag, &userCount); err != nil {
-			continue
-		}
+	for _, t := range tags {
 		popularTags = append(popularTags, map[string]interface{}{
-			"tag":         strings.TrimSpace(tag),
-			"user_count": userCount,
+			"tag":        t.Tag,
+			"user_count": t.UserCount,
 		})
 	}
 
-	// Personality type (MBTI) counts
-	mbtiRows, err := database.DB.Query(`
-		SELECT mbti, COUNT(*) as cnt
-		FROM users
-		WHERE mbti IS NOT NULL AND TRIM(mbti) != ''
-		GROUP BY UPPER(TRIM(mbti))
-		ORDER BY cnt DESC
-	`)
-	if err != nil {
-		log.Printf("Error querying MBTI for trends: %v", err)
-	}
-	if mbtiRows != nil {
-		defer mbtiRows.Close()
-	}
-
-	personalityCounts := []map[string]interface{}{}
-	if mbtiRows != nil {
-		for mbtiRows.Next() {
-			var mbti string
-			var cnt int64
-			if err := mbtiRows.Scan(&mbti, &cnt); err != nil {
-				continue
-			}
-			personalityCounts = append(personalityCounts, map[string]interface{}{
-				"type":  strings.TrimSpace(mbti),
-				"count": cnt,
-			})
+	// Personality type (MBTI), gender (male, female, etc.) and sexual preference / orientation
+	// (straight, gay, both) counts; a failed one is left empty
+	traitCounts := func(trait store.Trait, key string) []map[string]interface{} {
+		counts, err := store.Get().Users.CountByTrait(trait)
+		if err != nil {
+			log.Printf("Error querying %s for trends: %v", trait, err)
 		}
-	}
-
-	// Gender counts (male, female, etc.)
-	genderRows, err := database.DB.Query(`
-		SELECT gender, COUNT(*) as cnt
-		FROM users
-		WHERE gender IS NOT NULL AND TRIM(gender) != ''
-		GROUP BY LOWER(TRIM(gender))
-		ORDER BY cnt DESC
-	`)
-	if err != nil {
-		log.Printf("Error querying gender for trends: %v", err)
-	}
-	if genderRows != nil {
-		defer genderRows.Close()
-	}
-
-	genderCounts := []map[string]interface{}{}
-	if genderRows != nil {
-		for genderRows.Next() {
-			var gender string
-			var cnt int64
-			if err := genderRows.Scan(&gender, &cnt); err != nil {
-				continue
-			}
-			genderCounts = append(genderCounts, map[string]interface{}{
-				"gender": strings.TrimSpace(gender),
-				"count":  cnt,
-			})
-		}
-	}
-
-	// Sexual preference / orientation (straight, gay, both)
-	prefRows, err := database.DB.Query(`
-		SELECT sexual_preference, COUNT(*) as cnt
-		FROM users
-		WHERE sexual_preference IS NOT NULL AND TRIM(sexual_preference) != ''
-		GROUP BY LOWER(TRIM(sexual_preference))
-		ORDER BY cnt DESC
-	`)
-	if err != nil {
-		log.Printf("Error querying sexual_preference for trends: %v", err)
-	}
-	if prefRows != nil {
-		defer prefRows.Close()
-	}
-
-	orientationCounts := []map[string]interface{}{}
-	if prefRows != nil {
-		for prefRows.Next() {
-			var pref string
-			var cnt int64
-			if err := prefRows.Scan(&pref, &cnt); err != nil {
-				continue
-			}
-			orientationCounts = append(orientationCounts, map[string]interface{}{
-				"orientation": strings.TrimSpace(pref),
-				"count":       cnt,
+		list := []map[string]interface{}{}
+		for _, c := range counts {
+			list = append(list, map[string]interface{}{
+				key:     c.Value,
+				"count": c.Count,
 			})
 		}
+		return list
 	}
+	personalityCounts := traitCounts(store.TraitMBTI, "type")
+	genderCounts := traitCounts(store.TraitGender, "gender")
+	orientationCounts := traitCounts(store.TraitSexualPreference, "orientation")
 
 	SendSuccess(w, map[string]interface{}{
 		"popular_tags":       popularTags,
-		"personality_types":   personalityCounts,
-		"gender_counts":       genderCounts,
+		"personality_types":  personalityCounts,
+		"gender_counts":      genderCounts,
 		"orientation_counts": orientationCounts,
 	})
 }
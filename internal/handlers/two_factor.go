@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"matcha/internal/services"
+)
+
+// TwoFactorCodeRequest for POST /api/profile/2fa/confirm, /disable and /recovery-codes
+type TwoFactorCodeRequest struct {
+	Password string `json:"password"` // Not needed to confirm setup
+	Code     string `json:"code"`     // TOTP code, or a recovery code to disable or get new codes
+}
+
+// sendTwoFactorError maps errors of the two-factor services to responses
+func sendTwoFactorError(w http.ResponseWriter, err error) {
+	switch err {
+	case services.ErrTOTPAlreadyEnabled, services.ErrTOTPNotEnabled, services.ErrTOTPNotStarted:
+		SendError(w, http.StatusConflict, err.Error())
+	case services.ErrInvalidTOTPCode, services.ErrPasswordIncorrect:
+		SendError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("Error in two-factor setup: %v", err)
+		SendError(w, http.StatusInternalServerError, "Failed to update two-factor authentication")
+	}
+}
+
+// TwoFactorStatusAPI handles GET /api/profile/2fa
+func TwoFactorStatusAPI(w http.ResponseWriter, r *http.Request) {
+	status, err := services.GetTOTPStatus(requestUserID(r))
+	if err != nil {
+		sendTwoFactorError(w, err)
+		return
+	}
+	SendSuccess(w, status)
+}
+
+// TwoFactorSetupAPI handles POST /api/profile/2fa/setup: a new secret to scan, not active until confirmed
+func TwoFactorSetupAPI(w http.ResponseWriter, r *http.Request) {
+	enrollment, err := services.BeginTOTPEnrollment(requestUserID(r))
+	if err != nil {
+		sendTwoFactorError(w, err)
+		return
+	}
+	SendSuccess(w, enrollment)
+}
+
+// TwoFactorConfirmAPI handles POST /api/profile/2fa/confirm: enables two-factor authentication with a
+// code from the new secret and returns the recovery codes (shown only this once)
+func TwoFactorConfirmAPI(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	var req TwoFactorCodeRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if rateLimited(w, services.LimitTwoFactorPerUser, strconv.FormatInt(userID, 10)) {
+		return
+	}
+	codes, err := services.ConfirmTOTPEnrollment(userID, req.Code)
+	if err != nil {
+		sendTwoFactorError(w, err)
+		return
+	}
+	SendSuccess(w, map[string]interface{}{"recovery_codes": codes})
+}
+
+// TwoFactorDisableAPI handles POST /api/profile/2fa/disable
+func TwoFactorDisableAPI(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	var req TwoFactorCodeRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if rateLimited(w, services.LimitTwoFactorPerUser, strconv.FormatInt(userID, 10)) {
+		return
+	}
+	if err := services.DisableTOTP(userID, req.Password, req.Code); err != nil {
+		sendTwoFactorError(w, err)
+		return
+	}
+	SendSuccess(w, map[string]interface{}{"message": "Two-factor authentication disabled"})
+}
+
+// TwoFactorRecoveryCodesAPI handles POST /api/profile/2fa/recovery-codes: replaces the recovery codes
+func TwoFactorRecoveryCodesAPI(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	var req TwoFactorCodeRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		SendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if rateLimited(w, services.LimitTwoFactorPerUser, strconv.FormatInt(userID, 10)) {
+		return
+	}
+	codes, err := services.RegenerateRecoveryCodes(userID, req.Password, req.Code)
+	if err != nil {
+		sendTwoFactorError(w, err)
+		return
+	}
+	SendSuccess(w, map[string]interface{}{"recovery_codes": codes})
+}
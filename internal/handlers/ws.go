@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"matcha/internal/config"
+	"matcha/internal/models"
+)
+
+// WSEvent is the envelope for every event pushed over /api/ws
+type WSEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// wsClient is a single WebSocket connection (one browser tab or device)
+type wsClient struct {
+	userID int64
+	conn   *websocket.Conn
+	send   chan WSEvent
+}
+
+// chatHub keeps track of every open WebSocket connection, grouped by user
+type chatHub struct {
+	mu      sync.RWMutex
+	clients map[int64]map[*wsClient]struct{}
+}
+
+var hub = &chatHub{clients: make(map[int64]map[*wsClient]struct{})}
+
+const (
+	wsSendBuffer   = 64               // Pending events per connection before it is considered stuck
+	wsWriteTimeout = 10 * time.Second // Max time to write a single frame
+	wsPingInterval = 30 * time.Second // Keep-alive interval (also keeps proxies from closing idle sockets)
+)
+
+// register adds a connection to the hub
+func (h *chatHub) register(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c.userID] == nil {
+		h.clients[c.userID] = make(map[*wsClient]struct{})
+	}
+	h.clients[c.userID][c] = struct{}{}
+}
+
+// unregister removes a connection from the hub and closes its send channel
+func (h *chatHub) unregister(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conns, ok := h.clients[c.userID]
+	if !ok {
+		return
+	}
+	if _, ok := conns[c]; !ok {
+		return
+	}
+	delete(conns, c)
+	close(c.send)
+	if len(conns) == 0 {
+		delete(h.clients, c.userID)
+	}
+}
+
+// sendToUser pushes an event to every open connection of the user (all tabs/devices).
+// Never blocks: a connection whose buffer is full is dropped so one slow client can't stall the sender.
+func (h *chatHub) sendToUser(userID int64, event WSEvent) {
+	h.mu.RLock()
+	var stuck []*wsClient
+	for c := range h.clients[userID] {
+		select {
+		case c.send <- event:
+		default:
+			stuck = append(stuck, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range stuck {
+		log.Printf("WebSocket buffer full for user %d, closing connection", c.userID)
+		h.unregister(c)
+		c.conn.Close()
+	}
+}
+
+// WebSocketAPI handles GET /api/ws - authenticated WebSocket for real-time chat events
+func WebSocketAPI(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	server := websocket.Server{
+		Handshake: func(cfg *websocket.Config, req *http.Request) error { return checkWSOrigin(req) },
+		Handler: func(conn *websocket.Conn) {
+			serveWSClient(userID, conn)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// checkWSOrigin refuses WebSocket handshakes from browser pages outside the frontend. CORS doesn't apply
+// to WebSockets and the auth cookie is sent along, so any other site could otherwise open a socket as
+// the user. Clients that send no Origin aren't browsers and authenticate with a bearer token.
+func checkWSOrigin(req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	frontend, err := url.Parse(config.Load().FrontendURL)
+	if err != nil || !strings.EqualFold(originURL.Scheme, frontend.Scheme) || !strings.EqualFold(originURL.Host, frontend.Host) {
+		return fmt.Errorf("origin %q not allowed", origin)
+	}
+	return nil
+}
+
+// serveWSClient runs the read and write loops for one connection until either side closes it
+func serveWSClient(userID int64, conn *websocket.Conn) {
+	client := &wsClient{
+		userID: userID,
+		conn:   conn,
+		send:   make(chan WSEvent, wsSendBuffer),
+	}
+	hub.register(client)
+	ensureUserIsOnline(userID)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wsWriteLoop(client)
+	}()
+
+	// Read loop: handles client events and notices when the client goes away
+	for {
+		var event wsInboundEvent
+		if err := websocket.JSON.Receive(conn, &event); err != nil {
+			break
+		}
+		handleWSInboundEvent(client, event)
+	}
+
+	hub.unregister(client)
+	conn.Close()
+	<-done
+}
+
+// wsInboundEvent is an event sent by the client; Data is decoded according to Type
+type wsInboundEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// handleWSInboundEvent dispatches one client event (ping, typing)
+func handleWSInboundEvent(c *wsClient, event wsInboundEvent) {
+	switch event.Type {
+	case "ping":
+		sendWSEvent(c, WSEvent{Type: "pong"})
+	case "typing":
+		var data struct {
+			ChatID   int64 `json:"chat_id"`
+			IsTyping bool  `json:"is_typing"`
+		}
+		if err := json.Unmarshal(event.Data, &data); err != nil || data.ChatID <= 0 {
+			return
+		}
+		if !areConnected(c.userID, data.ChatID) {
+			return
+		}
+		pushTyping(c.userID, data.ChatID, data.IsTyping)
+	}
+}
+
+// wsWriteLoop writes queued events to the connection and sends periodic pings
+func wsWriteLoop(c *wsClient) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-c.send:
+			if !ok {
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := websocket.JSON.Send(c.conn, event); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := websocket.JSON.Send(c.conn, WSEvent{Type: "ping"}); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// sendWSEvent queues an event for a single connection (used for direct replies like pong)
+func sendWSEvent(c *wsClient, event WSEvent) {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if _, ok := hub.clients[c.userID][c]; !ok {
+		return
+	}
+	select {
+	case c.send <- event:
+	default:
+	}
+}
+
+// pushChatMessage delivers a freshly committed message to both participants.
+// Each side gets it from its own point of view, same shape as MessagesAPI.
+func pushChatMessage(msg *models.Message, attachments []models.AttachmentInfo) {
+	for _, userID := range []int64{msg.FromUserID, msg.ToUserID} {
+		hub.sendToUser(userID, WSEvent{Type: "message", Data: chatMessage(msg, userID, attachments)})
+	}
+}
@@ -0,0 +1,192 @@
+package models
+
+import "time"
+
+// Response bodies of the profile endpoints. Text fields of other users' profiles are "-" when empty,
+// which is what the frontend displays.
+
+// ProfileCard is a user as listed by browse, search and connections
+type ProfileCard struct {
+	ID             int64      `json:"id"`
+	Username       string     `json:"username"`
+	FirstName      string     `json:"first_name"`
+	LastName       string     `json:"last_name"`
+	Age            int        `json:"age"` // 0 if the birth date isn't set
+	Gender         string     `json:"gender"`
+	Biography      string     `json:"biography"`
+	Location       string     `json:"location"`
+	FameRating     float64    `json:"fame_rating"`
+	IsOnline       bool       `json:"is_online"`
+	LastSeen       *time.Time `json:"last_seen,omitempty"`
+	ProfilePicture string     `json:"profile_picture"` // "" if none
+	Tags           []string   `json:"tags"`
+	DistanceKm     *float64   `json:"distance_km,omitempty"` // Only when both users have a location
+}
+
+// DiscoverPage is a page of browse results (GET /api/browse)
+type DiscoverPage struct {
+	Profiles []ProfileCard `json:"profiles"`
+	Sort     string        `json:"sort"`
+	MinAge   string        `json:"minAge"`
+	MaxAge   string        `json:"maxAge"`
+	Limit    int           `json:"limit"`
+	Offset   int           `json:"offset"`
+}
+
+// SearchPage is a page of search results (GET /api/search)
+type SearchPage struct {
+	DiscoverPage
+	Tags     string `json:"tags"`
+	Location string `json:"location"`
+}
+
+// ConnectionCard is a connected user (GET /api/connections)
+type ConnectionCard struct {
+	ProfileCard
+	ConnectedAt time.Time `json:"connected_at"` // When the second of the two likes was given
+}
+
+// ConnectionList is the body of GET /api/connections
+type ConnectionList struct {
+	Connections []ConnectionCard `json:"connections"`
+}
+
+// BigFive holds the Big Five personality traits
+type BigFive struct {
+	Openness          string `json:"openness,omitempty"`
+	Conscientiousness string `json:"conscientiousness,omitempty"`
+	Extraversion      string `json:"extraversion,omitempty"`
+	Agreeableness     string `json:"agreeableness,omitempty"`
+	Neuroticism       string `json:"neuroticism,omitempty"`
+}
+
+// PublicProfile is another user's profile as seen by the viewer (GET /api/user/:id)
+type PublicProfile struct {
+	ProfileCard
+	SexualPreference string   `json:"sexual_preference"`
+	Latitude         *float64 `json:"latitude,omitempty"`
+	Longitude        *float64 `json:"longitude,omitempty"`
+	Images           []string `json:"images"` // File paths by slot
+	BigFive          BigFive  `json:"big_five"`
+	Siblings         string   `json:"siblings"`
+	MBTI             string   `json:"mbti"`
+	CaliperProfile   string   `json:"caliper_profile"`
+
+	IsLiked              bool       `json:"is_liked"`     // The viewer likes them
+	IsConnected          bool       `json:"is_connected"` // They like each other
+	HasViewedYourProfile bool       `json:"has_viewed_your_profile"`
+	IsBlocked            bool       `json:"is_blocked"` // The viewer blocked them
+	BlockedAt            *time.Time `json:"blocked_at,omitempty"`
+	TheyBlockMe          bool       `json:"they_block_me"`
+}
+
+// ProfileImage is one of the current user's pictures (GET /api/profile)
+type ProfileImage struct {
+	ID         int64  `json:"id"`
+	FilePath   string `json:"file_path"`
+	IsProfile  bool   `json:"is_profile"`
+	OrderIndex int    `json:"order_index"`
+}
+
+// OwnProfile is the current user's profile (GET /api/profile); unset fields are left out
+type OwnProfile struct {
+	ID                 int64          `json:"id"`
+	Username           string         `json:"username"`
+	Email              string         `json:"email"`
+	PendingEmail       string         `json:"pending_email,omitempty"` // Email change waiting for confirmation
+	Locale             string         `json:"locale"`                  // Language of the user's emails; "" = default
+	DigestFrequency    string         `json:"digest_frequency"`        // off, daily or weekly
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	FameRating         float64        `json:"fame_rating"`
+	IsSetup            bool           `json:"is_setup"`
+	Gender             string         `json:"gender,omitempty"`
+	SexualPreference   string         `json:"sexual_preference,omitempty"`
+	Biography          string         `json:"biography,omitempty"`
+	BirthDate          *time.Time     `json:"birth_date,omitempty"`
+	Location           string         `json:"location,omitempty"`
+	Latitude           *float64       `json:"latitude,omitempty"`
+	Longitude          *float64       `json:"longitude,omitempty"`
+	LocationUpdatedAt  *time.Time     `json:"location_updated_at,omitempty"`
+	LastSeen           *time.Time     `json:"last_seen,omitempty"`
+	CreatedAt          time.Time      `json:"created_at"`
+	BigFive            BigFive        `json:"big_five"`
+	Siblings           string         `json:"siblings,omitempty"`
+	MBTI               string         `json:"mbti,omitempty"`
+	CaliperProfile     string         `json:"caliper_profile,omitempty"`
+	Tags               []string       `json:"tags"`
+	Images             []ProfileImage `json:"images"`
+	LikesReceivedCount int64          `json:"likes_received_count"`
+}
+
+// Response bodies of the chat and notification endpoints. The WebSocket and SSE streams send the
+// same shapes, from the receiving user's point of view.
+
+// AttachmentInfo is a file sent with a message; the file itself is fetched from URL
+type AttachmentInfo struct {
+	ID          int64  `json:"id"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// ChatMessage is one message (GET /api/messages/:id and the "message" WebSocket event)
+type ChatMessage struct {
+	ID                int64            `json:"id"`
+	ChatID            int64            `json:"chat_id"` // The other participant's user id
+	Content           string           `json:"content"` // "" once deleted, "-" if empty without attachments
+	IsFromCurrentUser bool             `json:"is_from_current_user"`
+	CreatedAt         time.Time        `json:"created_at"`
+	IsRead            bool             `json:"is_read"`
+	ReadAt            *time.Time       `json:"read_at"`   // null until read
+	EditedAt          *time.Time       `json:"edited_at"` // null unless edited
+	IsDeleted         bool             `json:"is_deleted"`
+	Attachments       []AttachmentInfo `json:"attachments"`
+}
+
+// MessagePage is a page of a conversation, oldest message first (GET /api/messages/:id)
+type MessagePage struct {
+	Messages   []ChatMessage `json:"messages"`
+	ChatID     int64         `json:"chat_id"`
+	NextCursor *int64        `json:"next_cursor"` // null when there is nothing more
+	HasMore    bool          `json:"has_more"`
+}
+
+// ConversationSettings is the current user's settings for a conversation
+type ConversationSettings struct {
+	IsMuted    bool       `json:"is_muted"`
+	MutedUntil *time.Time `json:"muted_until"` // null unless muted
+	IsArchived bool       `json:"is_archived"`
+	IsPinned   bool       `json:"is_pinned"`
+}
+
+// ConversationCard is a conversation of the chat list (GET /api/chat)
+type ConversationCard struct {
+	ID          int64  `json:"id"` // The other participant's user id, same as chat_id elsewhere
+	Name        string `json:"name"`
+	Avatar      string `json:"avatar"`
+	UnreadCount int64  `json:"unread_count"`
+	LastMessage string `json:"last_message"` // "" if there are no messages
+	ConversationSettings
+}
+
+// ConversationList is the body of GET /api/chat
+type ConversationList struct {
+	Conversations []ConversationCard `json:"conversations"`
+}
+
+// NotificationItem is one notification (GET /api/notifications and the SSE stream)
+type NotificationItem struct {
+	ID            int64     `json:"id"`
+	Type          string    `json:"type"`
+	Message       string    `json:"message"`
+	IsRead        bool      `json:"is_read"`
+	CreatedAt     time.Time `json:"created_at"`
+	RelatedUserID int64     `json:"related_user_id,omitempty"` // Who liked / viewed / wrote; left out if none
+}
+
+// NotificationList is the body of GET /api/notifications
+type NotificationList struct {
+	Notifications []NotificationItem `json:"notifications"`
+	UnreadCount   int                `json:"unread_count"`
+}
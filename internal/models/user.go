@@ -13,21 +13,62 @@ type User struct {
 	Gender            string    `json:"gender"`
 	SexualPreference  string    `json:"sexual_preference"`
 	Biography         string    `json:"biography"`
-	BirthDate         time.Time `json:"birth_date"`
+	BirthDate         time.Time `json:"birth_date"` // Zero if not set
 	FameRating        float64   `json:"fame_rating"`
-	Latitude          float64   `json:"latitude"`
-	Longitude         float64   `json:"longitude"`
+	Latitude          *float64  `json:"latitude"` // Nil until the user shares a location
+	Longitude         *float64  `json:"longitude"`
+	LocationUpdatedAt *time.Time `json:"location_updated_at"`
 	Location          string    `json:"location"`
+	Openness          string    `json:"openness"`
+	Conscientiousness string    `json:"conscientiousness"`
+	Extraversion      string    `json:"extraversion"`
+	Agreeableness     string    `json:"agreeableness"`
+	Neuroticism       string    `json:"neuroticism"`
+	Siblings          string    `json:"siblings"`
+	MBTI              string    `json:"mbti"`
+	CaliperProfile    string    `json:"caliper_profile"`
+	ProfilePicture    string    `json:"profile_picture"` // File path of the slot 0 picture, "" if none
 	IsEmailVerified       bool      `json:"is_email_verified"`
 	EmailVerificationToken string    `json:"-"`
 	IsSetup                bool      `json:"is_setup"`
 	IsOnline               bool      `json:"is_online"`
-	LastSeen               time.Time `json:"last_seen"`
+	IsBot                  bool      `json:"is_bot"`
+	Role                   string    `json:"role"` // RoleUser or RoleAdmin
+	FailedLoginCount       int        `json:"-"` // Consecutive failed logins
+	LoginLockedUntil       *time.Time `json:"-"` // Logins are refused until then (too many failures)
+	TOTPEnabled            bool       `json:"-"` // Logins need a TOTP or recovery code as well
+	Locale                 string     `json:"-"` // Language of the user's emails; "" = default
+	DigestFrequency        string     `json:"-"` // How often the user gets an email digest (DigestOff, ...)
+	LastSeen               time.Time `json:"last_seen"` // Zero if never seen
 	ProfilePictureID       int64     `json:"profile_picture_id"`
 	CreatedAt              time.Time `json:"created_at"`
 	UpdatedAt              time.Time `json:"updated_at"`
 }
 
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Age returns the user's age in years, 0 if the birth date isn't set
+func (u *User) Age() int {
+	if u.BirthDate.IsZero() {
+		return 0
+	}
+	now := time.Now()
+	age := now.Year() - u.BirthDate.Year()
+	if now.Before(time.Date(now.Year(), u.BirthDate.Month(), u.BirthDate.Day(), 0, 0, 0, 0, time.UTC)) {
+		age--
+	}
+	return age
+}
+
+// HasLocation reports whether the user has shared their coordinates
+func (u *User) HasLocation() bool {
+	return u.Latitude != nil && u.Longitude != nil
+}
+
 // UserPicture represents a user's uploaded picture
 type UserPicture struct {
 	ID        int64     `json:"id"`
@@ -68,9 +109,34 @@ type Message struct {
 	ToUserID  int64     `json:"to_user_id"`
 	Content   string    `json:"content"`
 	IsRead    bool      `json:"is_read"`
+	ReadAt    *time.Time `json:"read_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// MessageEdit is one audit-trail entry for an edited or deleted message
+type MessageEdit struct {
+	ID              int64     `json:"id"`
+	MessageID       int64     `json:"message_id"`
+	EditorID        int64     `json:"editor_id"`
+	Action          string    `json:"action"` // "edit", "delete"
+	PreviousContent string    `json:"previous_content"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// MessageAttachment is an image sent in a chat message (served via /api/attachments/:id, not /uploads)
+type MessageAttachment struct {
+	ID           int64     `json:"id"`
+	MessageID    int64     `json:"message_id"`
+	UploaderID   int64     `json:"uploader_id"`
+	FilePath     string    `json:"-"` // Private path under data/attachments
+	OriginalName string    `json:"original_name"`
+	ContentType  string    `json:"content_type"`
+	SizeBytes    int64     `json:"size"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
 // Notification represents a user notification
 type Notification struct {
 	ID        int64     `json:"id"`
@@ -79,6 +145,81 @@ type Notification struct {
 	Message   string    `json:"message"`
 	IsRead    bool      `json:"is_read"`
 	CreatedAt time.Time `json:"created_at"`
+	RelatedUserID int64 `json:"related_user_id,omitempty"` // Who liked / viewed / wrote (for navigation); 0 = none
 }
 
+// TagCount is a tag and how many users have it
+type TagCount struct {
+	Tag       string `json:"tag"`
+	UserCount int64  `json:"user_count"`
+}
 
+// Session is a login on one device; its refresh tokens are stored separately (hashed)
+type Session struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"user_id"`
+	UserAgent    string     `json:"user_agent"`
+	IPAddress    string     `json:"ip_address"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   time.Time  `json:"last_used_at"` // Last refresh
+	ExpiresAt    time.Time  `json:"expires_at"`   // Refresh tokens stop working after this
+	RevokedAt    *time.Time `json:"revoked_at"`
+	RevokeReason string     `json:"revoke_reason,omitempty"`
+	RefreshKey   string     `json:"-"` // Derives each refresh token from the previous one; "" = random tokens
+}
+
+// UserIdentity is an account at an OpenID Connect provider linked to a user
+type UserIdentity struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"-"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// Active reports whether the session can still be used
+func (s *Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// QueuedEmail is an email in the outbox
+type QueuedEmail struct {
+	ID            int64      `json:"id"`
+	To            string     `json:"to"`
+	Template      string     `json:"template"`
+	Subject       string     `json:"subject"`
+	Message       string     `json:"-"` // MIME message; empty once sent
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+// Outbox email statuses
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead" // Gave up after the last attempt
+)
+
+// Email digest frequencies (User.DigestFrequency)
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestRecipient is a user due for an email digest
+type DigestRecipient struct {
+	UserID       int64
+	Email        string
+	FirstName    string
+	Locale       string
+	Frequency    string
+	Token        string     // Unsubscribe token; "" until the first digest
+	LastDigestAt *time.Time // nil if they never got one
+}
@@ -2,11 +2,14 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"log"
+	"net/url"
 	"matcha/internal/config"
-	"matcha/internal/database"
 	"matcha/internal/models"
+	"matcha/internal/store"
 	"strings"
 	"time"
 
@@ -78,24 +81,27 @@ func GenerateVerificationToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-// CreateUser creates a new user in the database
-func CreateUser(username, email, password, firstName, lastName string) (*models.User, string, error) {
+// CreateUser creates a new user in the database; locale is the language of their emails ("" = default)
+func CreateUser(username, email, password, firstName, lastName, locale string) (*models.User, string, error) {
+	users := store.Get().Users
+	// Stored lower case, as the reset and login lookups expect
+	email = strings.ToLower(strings.TrimSpace(email))
+
 	// Check if username already exists
-	var count int
-	err := database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
+	taken, err := users.UsernameExists(username)
 	if err != nil {
 		return nil, "", fmt.Errorf("database error: %v", err)
 	}
-	if count > 0 {
+	if taken {
 		return nil, "", fmt.Errorf("username already exists")
 	}
 
 	// Check if email already exists
-	err = database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&count)
+	taken, err = users.EmailInUse(email, 0)
 	if err != nil {
 		return nil, "", fmt.Errorf("database error: %v", err)
 	}
-	if count > 0 {
+	if taken {
 		return nil, "", fmt.Errorf("email already exists")
 	}
 
@@ -112,20 +118,19 @@ func CreateUser(username, email, password, firstName, lastName string) (*models.
 	}
 
 	// Insert user with initial fame rating of 1.0 (Level 1)
-	result, err := database.DB.Exec(
-		`INSERT INTO users (username, email, password_hash, first_name, last_name, email_verification_token, is_setup, is_email_verified, fame_rating, created_at, updated_at)
-		 VALUES (?, ?, ?, ?, ?, ?, 0, 0, 1.0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
-		username, email, passwordHash, firstName, lastName, token,
-	)
+	userID, err := users.Create(&models.User{
+		Username:               username,
+		Email:                  email,
+		PasswordHash:           passwordHash,
+		FirstName:              firstName,
+		LastName:               lastName,
+		EmailVerificationToken: token,
+		Locale:                 locale,
+	})
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create user: %v", err)
 	}
 
-	userID, err := result.LastInsertId()
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to get user ID: %v", err)
-	}
-
 	user := &models.User{
 		ID:                    userID,
 		Username:              username,
@@ -133,6 +138,7 @@ func CreateUser(username, email, password, firstName, lastName string) (*models.
 		FirstName:             firstName,
 		LastName:              lastName,
 		EmailVerificationToken: token,
+		Locale:                locale,
 		IsSetup:              false,
 		IsEmailVerified:      false,
 		CreatedAt:             time.Now(),
@@ -144,67 +150,123 @@ func CreateUser(username, email, password, firstName, lastName string) (*models.
 
 // VerifyUser verifies a user's email using the token
 func VerifyUser(token string) error {
-	result, err := database.DB.Exec(
-		"UPDATE users SET is_email_verified = 1, email_verification_token = NULL WHERE email_verification_token = ?",
-		token,
-	)
+	verified, err := store.Get().Users.VerifyEmail(token)
 	if err != nil {
 		return fmt.Errorf("database error: %v", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %v", err)
-	}
-
-	if rowsAffected == 0 {
+	if !verified {
 		return fmt.Errorf("invalid or expired token")
 	}
 
 	return nil
 }
 
-// AuthenticateUser authenticates a user by username and returns the user if successful
+// After loginLockThreshold consecutive failed logins the account is locked, first for loginLockBase,
+// doubling with each further failure up to loginLockMax
+const (
+	loginLockThreshold = 5
+	loginLockBase      = time.Minute
+	loginLockMax       = time.Hour
+)
+
+// maxResetCodeAttempts is how many wrong guesses a password reset code allows before it is discarded
+const maxResetCodeAttempts = 5
+
+// AccountLockedError is returned by AuthenticateUser while an account is locked after failed logins
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
+// loginLockDuration is how long to lock an account after its nth consecutive failure (0 = don't)
+func loginLockDuration(failures int) time.Duration {
+	if failures < loginLockThreshold {
+		return 0
+	}
+	lock := loginLockBase
+	for i := loginLockThreshold; i < failures && lock < loginLockMax; i++ {
+		lock *= 2
+	}
+	if lock > loginLockMax {
+		lock = loginLockMax
+	}
+	return lock
+}
+
+// findUserByLogin looks a user up by what they log in with: their username or their email
+func findUserByLogin(login string) (*models.User, error) {
+	login = strings.TrimSpace(login)
+	users := store.Get().Users
+	if strings.Contains(login, "@") {
+		user, err := users.GetByEmail(strings.ToLower(login))
+		if err != store.ErrNotFound {
+			return user, err
+		}
+		// Usernames can't contain @ any more, but older ones may
+	}
+	return users.GetByUsername(login)
+}
+
+// AuthenticateUser authenticates a user by username or email and returns the user if successful
 // Returns user even if email is not verified (check IsEmailVerified field)
-func AuthenticateUser(username, password string) (*models.User, error) {
-	var user models.User
-	var passwordHash string
-	var isEmailVerified, isSetup int
-
-	err := database.DB.QueryRow(
-		`SELECT id, username, email, password_hash, first_name, last_name, is_email_verified, is_setup, created_at, updated_at
-		 FROM users WHERE username = ?`,
-		username,
-	).Scan(
-		&user.ID, &user.Username, &user.Email, &passwordHash, &user.FirstName, &user.LastName,
-		&isEmailVerified, &isSetup, &user.CreatedAt, &user.UpdatedAt,
-	)
+func AuthenticateUser(login, password string) (*models.User, error) {
+	user, err := findUserByLogin(login)
 	if err != nil {
 		return nil, fmt.Errorf("invalid username or password")
 	}
 
+	// A locked account is refused without checking the password, so guessing gains nothing
+	if user.LoginLockedUntil != nil && time.Now().Before(*user.LoginLockedUntil) {
+		return nil, &AccountLockedError{Until: *user.LoginLockedUntil}
+	}
+
 	// Check password
-	if !CheckPassword(password, passwordHash) {
+	if !CheckPassword(password, user.PasswordHash) {
+		recordLoginFailure(user.ID)
 		return nil, fmt.Errorf("invalid username or password")
 	}
 
-	user.IsEmailVerified = isEmailVerified == 1
-	user.IsSetup = isSetup == 1
+	// With two-factor authentication the login isn't done yet: the count is reset by CompleteMFALogin,
+	// so wrong codes add up even when the password is right
+	if !user.TOTPEnabled {
+		clearLoginFailures(user)
+	}
+	return user, nil
+}
+
+// recordLoginFailure counts a wrong password or code, and locks the account once there are too many
+func recordLoginFailure(userID int64) {
+	users := store.Get().Users
+	failures, err := users.RecordLoginFailure(userID)
+	if err != nil {
+		log.Printf("Error recording failed login of user %d: %v", userID, err)
+		return
+	}
+	if lock := loginLockDuration(failures); lock > 0 {
+		until := time.Now().UTC().Add(lock)
+		if err := users.LockLogin(userID, until); err != nil {
+			log.Printf("Error locking user %d: %v", userID, err)
+		}
+		log.Printf("Locked logins of user %d for %v after %d failed attempts", userID, lock, failures)
+	}
+}
 
-	return &user, nil
+func clearLoginFailures(user *models.User) {
+	if user.FailedLoginCount > 0 || user.LoginLockedUntil != nil {
+		if err := store.Get().Users.ClearLoginFailures(user.ID); err != nil {
+			log.Printf("Error clearing failed logins of user %d: %v", user.ID, err)
+		}
+	}
 }
 
 // ResendVerificationEmail generates a new token and sends verification email
-func ResendVerificationEmail(username string, cfg *config.Config) error {
-	// Get user by username
-	var userID int64
-	var email string
-	var currentToken string
-
-	err := database.DB.QueryRow(
-		"SELECT id, email, email_verification_token FROM users WHERE username = ?",
-		username,
-	).Scan(&userID, &email, &currentToken)
+func ResendVerificationEmail(login string, cfg *config.Config) error {
+	// Get user by username or email
+	user, err := findUserByLogin(login)
 	if err != nil {
 		return fmt.Errorf("user not found")
 	}
@@ -216,16 +278,12 @@ func ResendVerificationEmail(username string, cfg *config.Config) error {
 	}
 
 	// Update token in database
-	_, err = database.DB.Exec(
-		"UPDATE users SET email_verification_token = ? WHERE id = ?",
-		token, userID,
-	)
-	if err != nil {
+	if err := store.Get().Users.SetVerificationToken(user.ID, token); err != nil {
 		return fmt.Errorf("failed to update token: %v", err)
 	}
 
 	// Send verification email (using the email service function)
-	if err := SendVerificationEmail(cfg, email, token); err != nil {
+	if err := SendVerificationEmail(cfg, user.Email, user.Locale, token); err != nil {
 		return fmt.Errorf("failed to send email: %v", err)
 	}
 
@@ -242,14 +300,16 @@ func GeneratePasswordResetCode() (string, error) {
 	return fmt.Sprintf("%06d", n%1000000), nil
 }
 
+// passwordResetTTL is how long a password reset code or link works
+const passwordResetTTL = 15 * time.Minute
+
 // ForgotPasswordSendCode looks up user by email, generates a 6-digit code, stores it with 15min expiry, and sends email
 func ForgotPasswordSendCode(email string, cfg *config.Config) error {
 	email = strings.TrimSpace(strings.ToLower(email))
 	if email == "" {
 		return fmt.Errorf("email is required")
 	}
-	var userID int64
-	err := database.DB.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&userID)
+	user, err := store.Get().Users.GetByEmail(email)
 	if err != nil {
 		return fmt.Errorf("no account found with this email")
 	}
@@ -257,15 +317,12 @@ func ForgotPasswordSendCode(email string, cfg *config.Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to generate code: %v", err)
 	}
-	expires := time.Now().UTC().Add(15 * time.Minute)
-	_, err = database.DB.Exec(
-		"UPDATE users SET password_reset_code = ?, password_reset_expires_at = ?, password_reset_token = NULL WHERE id = ?",
-		code, expires, userID,
-	)
+	expires := time.Now().UTC().Add(passwordResetTTL)
+	err = store.Get().Users.SetPasswordReset(user.ID, store.PasswordReset{Code: code, ExpiresAt: &expires})
 	if err != nil {
 		return fmt.Errorf("database error: %v", err)
 	}
-	if err := SendPasswordResetCode(cfg, email, code); err != nil {
+	if err := SendPasswordResetCode(cfg, email, user.Locale, code); err != nil {
 		return fmt.Errorf("failed to send email: %v", err)
 	}
 	return nil
@@ -281,32 +338,36 @@ func ForgotPasswordVerify(email, code string) (resetToken string, err error) {
 	if len(code) != 6 {
 		return "", fmt.Errorf("code must be 6 digits")
 	}
-	var userID int64
-	var storedCode string
-	var expires *time.Time
-	err = database.DB.QueryRow(
-		"SELECT id, password_reset_code, password_reset_expires_at FROM users WHERE email = ?",
-		email,
-	).Scan(&userID, &storedCode, &expires)
-	if err != nil || storedCode == "" {
+	users := store.Get().Users
+	userID, reset, err := users.GetPasswordReset(email)
+	if err != nil || reset.Code == "" {
 		return "", fmt.Errorf("invalid or expired code")
 	}
-	if expires != nil && time.Now().UTC().After(*expires) {
-		_, _ = database.DB.Exec("UPDATE users SET password_reset_code = NULL, password_reset_expires_at = NULL WHERE id = ?", userID)
+	if reset.ExpiresAt != nil && time.Now().UTC().After(*reset.ExpiresAt) {
+		_ = users.SetPasswordReset(userID, store.PasswordReset{Token: reset.Token})
 		return "", fmt.Errorf("code has expired")
 	}
-	if storedCode != code {
+	// The guess is counted before the code is compared, so concurrent guesses can't get past the limit
+	attempts, err := users.TakePasswordResetAttempt(userID, maxResetCodeAttempts)
+	if err != nil {
+		return "", fmt.Errorf("database error: %v", err)
+	}
+	if attempts == 0 {
+		return "", fmt.Errorf("too many wrong codes, request a new one")
+	}
+	if subtle.ConstantTimeCompare([]byte(reset.Code), []byte(code)) != 1 {
+		if attempts >= maxResetCodeAttempts {
+			// Discard the code; guessing on would need a new one, which is rate limited
+			_ = users.SetPasswordReset(userID, store.PasswordReset{})
+			return "", fmt.Errorf("too many wrong codes, request a new one")
+		}
 		return "", fmt.Errorf("invalid code")
 	}
 	resetToken, err = GenerateVerificationToken()
 	if err != nil {
 		return "", fmt.Errorf("failed to generate reset token: %v", err)
 	}
-	_, err = database.DB.Exec(
-		"UPDATE users SET password_reset_code = NULL, password_reset_expires_at = NULL, password_reset_token = ? WHERE id = ?",
-		resetToken, userID,
-	)
-	if err != nil {
+	if err := users.SetPasswordReset(userID, store.PasswordReset{Token: resetToken}); err != nil {
 		return "", fmt.Errorf("database error: %v", err)
 	}
 	return resetToken, nil
@@ -329,41 +390,37 @@ func ForgotPasswordReset(resetToken, newPassword string) error {
 		return fmt.Errorf("failed to hash password: %v", err)
 	}
 	// Only update if token exists and is not expired (expires_at NULL = no expiry, e.g. from code flow)
-	res, err := database.DB.Exec(
-		"UPDATE users SET password_hash = ?, password_reset_token = NULL, password_reset_expires_at = NULL WHERE password_reset_token = ? AND (password_reset_expires_at IS NULL OR password_reset_expires_at > datetime('now'))",
-		hash, resetToken,
-	)
+	userID, err := store.Get().Users.ResetPassword(resetToken, hash)
 	if err != nil {
 		return fmt.Errorf("database error: %v", err)
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
+	if userID == 0 {
 		return fmt.Errorf("invalid or expired reset link")
 	}
+	// Whoever knew the old password is logged out everywhere
+	if _, err := EndAllSessions(userID, 0, SessionRevokedPasswordChange); err != nil {
+		log.Printf("Error revoking sessions of user %d: %v", userID, err)
+	}
 	return nil
 }
 
 // SendPasswordResetLinkForUser generates a reset token for the given user, stores it with expiry, and sends an email with the reset link. Used when the user is logged in (e.g. from Profile).
 func SendPasswordResetLinkForUser(userID int64, cfg *config.Config) error {
-	var email string
-	err := database.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email)
-	if err != nil || email == "" {
+	user, err := store.Get().Users.GetByID(userID)
+	if err != nil || user.Email == "" {
 		return fmt.Errorf("user not found")
 	}
 	resetToken, err := GenerateVerificationToken()
 	if err != nil {
 		return fmt.Errorf("failed to generate reset token: %v", err)
 	}
-	expires := time.Now().UTC().Add(15 * time.Minute)
-	_, err = database.DB.Exec(
-		"UPDATE users SET password_reset_code = NULL, password_reset_expires_at = ?, password_reset_token = ? WHERE id = ?",
-		expires, resetToken, userID,
-	)
+	expires := time.Now().UTC().Add(passwordResetTTL)
+	err = store.Get().Users.SetPasswordReset(userID, store.PasswordReset{Token: resetToken, ExpiresAt: &expires})
 	if err != nil {
 		return fmt.Errorf("database error: %v", err)
 	}
-	resetLink := strings.TrimSuffix(cfg.FrontendURL, "/") + "/reset-password?token=" + resetToken
-	if err := SendPasswordResetLink(cfg, email, resetLink); err != nil {
+	resetLink := frontendLink(cfg, "/reset-password?token="+url.QueryEscape(resetToken))
+	if err := SendPasswordResetLink(cfg, user.Email, user.Locale, resetLink); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"matcha/internal/store"
+)
+
+// startPasswordReset gives the user a pending reset code and returns their email
+func startPasswordReset(t *testing.T, userID int64, code string) string {
+	t.Helper()
+	users := store.Get().Users
+	user, err := users.GetByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().UTC().Add(passwordResetTTL)
+	if err := users.SetPasswordReset(userID, store.PasswordReset{Code: code, ExpiresAt: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	return user.Email
+}
+
+func TestForgotPasswordVerifyLimitsGuesses(t *testing.T) {
+	email := startPasswordReset(t, createTestUser(t), "123456")
+
+	for i := 1; i < maxResetCodeAttempts; i++ {
+		if _, err := ForgotPasswordVerify(email, fmt.Sprintf("%06d", i)); err == nil {
+			t.Fatalf("wrong code %d was accepted", i)
+		}
+	}
+	token, err := ForgotPasswordVerify(email, "123456")
+	if err != nil || token == "" {
+		t.Fatalf("right code after %d wrong ones: %v", maxResetCodeAttempts-1, err)
+	}
+
+	email = startPasswordReset(t, createTestUser(t), "123456")
+	for i := 1; i <= maxResetCodeAttempts; i++ {
+		ForgotPasswordVerify(email, fmt.Sprintf("%06d", i))
+	}
+	if _, err := ForgotPasswordVerify(email, "123456"); err == nil {
+		t.Error("right code accepted after too many wrong ones")
+	}
+}
+
+func TestPasswordResetAttemptsAreAtomic(t *testing.T) {
+	userID := createTestUser(t)
+	startPasswordReset(t, userID, "123456")
+
+	// Guesses racing each other get maxResetCodeAttempts checks between them, no more
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempts, err := store.Get().Users.TakePasswordResetAttempt(userID, maxResetCodeAttempts)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if attempts > 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != maxResetCodeAttempts {
+		t.Errorf("%d of 20 concurrent guesses were allowed, want %d", allowed, maxResetCodeAttempts)
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"matcha/internal/config"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// Users who aren't online learn about their unread notifications from an email digest, sent daily or
+// weekly as they choose (digest_frequency, "off" for none). A digest covers the notifications since the
+// previous one, and is only sent if there are any. Every digest has the same unsubscribe link.
+
+const (
+	digestCheckInterval = time.Hour
+	digestBatchSize     = 100
+	// digestListLimit is how many notifications a digest lists; the rest are counted
+	digestListLimit = 10
+	// digestFirstWindow is how far back the first digest of a user looks
+	digestFirstWindow = 7 * 24 * time.Hour
+	// digestOnlineWindow: users seen more recently than this are online and get no digest
+	digestOnlineWindow = 15 * time.Minute
+)
+
+var ErrDigestTokenInvalid = errors.New("unsubscribe link is invalid")
+
+// IsDigestFrequency reports whether frequency is off, daily or weekly
+func IsDigestFrequency(frequency string) bool {
+	switch frequency {
+	case models.DigestOff, models.DigestDaily, models.DigestWeekly:
+		return true
+	}
+	return false
+}
+
+// StartDigestJob sends the email digests that are due every digestCheckInterval until ctx is cancelled
+func StartDigestJob(ctx context.Context, cfg *config.Config) {
+	go func() {
+		ticker := time.NewTicker(digestCheckInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := SendDueDigests(cfg, time.Now().UTC()); err != nil {
+				log.Printf("Error sending email digests: %v", err)
+			} else if n > 0 {
+				log.Printf("Queued %d email digest(s)", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// SendDueDigests queues a digest for every user due one at now, and returns how many were queued
+func SendDueDigests(cfg *config.Config, now time.Time) (int, error) {
+	// A little slack, so that a digest sent a few minutes late doesn't push the next one back an hour
+	dailyBefore := now.Add(-24*time.Hour + digestCheckInterval/2)
+	weeklyBefore := now.Add(-7*24*time.Hour + digestCheckInterval/2)
+	firstSince := now.Add(-digestFirstWindow)
+	seenBefore := now.Add(-digestOnlineWindow)
+
+	sent := 0
+	for {
+		recipients, err := store.Get().Digests.Due(dailyBefore, weeklyBefore, firstSince, seenBefore, digestBatchSize)
+		if err != nil {
+			return sent, fmt.Errorf("database error: %v", err)
+		}
+		for _, recipient := range recipients {
+			since := firstSince
+			if recipient.LastDigestAt != nil {
+				since = *recipient.LastDigestAt
+			}
+			queued, err := sendDigest(cfg, recipient, since)
+			if err != nil {
+				// Marked sent anyway, or the batch would be loaded again; they get the next one
+				log.Printf("Error sending email digest to user %d: %v", recipient.UserID, err)
+			} else if queued {
+				sent++
+			}
+			if err := store.Get().Digests.MarkSent(recipient.UserID, now); err != nil {
+				return sent, fmt.Errorf("database error: %v", err)
+			}
+		}
+		if len(recipients) < digestBatchSize {
+			return sent, nil
+		}
+	}
+}
+
+// sendDigest queues the digest of the recipient's unread notifications since the given time; false if
+// they were read meanwhile
+func sendDigest(cfg *config.Config, recipient models.DigestRecipient, since time.Time) (bool, error) {
+	digests := store.Get().Digests
+	total, notifications, err := digests.UnreadNotifications(recipient.UserID, since, digestListLimit)
+	if err != nil {
+		return false, fmt.Errorf("database error: %v", err)
+	}
+	if total == 0 {
+		return false, nil
+	}
+	messages, senders, err := digests.UnreadMessages(recipient.UserID)
+	if err != nil {
+		return false, fmt.Errorf("database error: %v", err)
+	}
+
+	token := recipient.Token
+	if token == "" {
+		newToken, err := oidcRandom()
+		if err != nil {
+			return false, err
+		}
+		if token, err = digests.EnsureToken(recipient.UserID, newToken); err != nil {
+			return false, fmt.Errorf("database error: %v", err)
+		}
+	}
+	err = SendDigestEmail(cfg, recipient, DigestSummary{
+		Total:          total,
+		Notifications:  notifications,
+		UnreadMessages: messages,
+		Senders:        senders,
+	}, token)
+	return err == nil, err
+}
+
+// UpdateDigestFrequency sets the digest frequency of the user with the unsubscribe token (from the
+// link in a digest), which works without logging in
+func UpdateDigestFrequency(token, frequency string) error {
+	if !IsDigestFrequency(frequency) {
+		return fmt.Errorf("invalid digest frequency %q", frequency)
+	}
+	ok, err := store.Get().Digests.SetFrequencyByToken(token, frequency)
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	if !ok {
+		return ErrDigestTokenInvalid
+	}
+	return nil
+}
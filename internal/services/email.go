@@ -1,101 +1,96 @@
 package services
 
 import (
-	"fmt"
-	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
 	"matcha/internal/config"
+	"matcha/internal/models"
 )
 
-// SendVerificationEmail sends an email verification link via MailHog
-func SendVerificationEmail(cfg *config.Config, email, token string) error {
-	// MailHog SMTP configuration (no auth required)
-	addr := fmt.Sprintf("%s:%s", cfg.SMTPHost, cfg.SMTPPort)
-	var auth smtp.Auth
-	if cfg.SMTPUser != "" && cfg.SMTPPass != "" {
-		auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPass, cfg.SMTPHost)
-	}
-
-	// Email content
-	to := []string{email}
-	subject := "Verify your Matcha account"
-	verificationURL := fmt.Sprintf("http://localhost:3000/verify-email?token=%s", token)
-	body := fmt.Sprintf(`
-Welcome to Matcha!
-
-Please verify your email address by clicking the link below:
-
-%s
-
-If you did not create an account, please ignore this email.
-
-Best regards,
-The Matcha Team
-`, verificationURL)
+// The emails the server sends. Their text is in templates/email (see mail.go); locale is the
+// recipient's (models.User.Locale), "" for the default.
 
-	msg := []byte(fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", email, subject, body))
-
-	// Send email
-	err := smtp.SendMail(addr, auth, cfg.FromEmail, to, msg)
-	if err != nil {
-		return fmt.Errorf("failed to send email: %v", err)
-	}
-
-	return nil
+// SendVerificationEmail sends an email verification link
+func SendVerificationEmail(cfg *config.Config, email, locale, token string) error {
+	return sendTemplateEmail(cfg, email, locale, "verification", map[string]interface{}{
+		"Link": frontendLink(cfg, "/verify-email?token="+url.QueryEscape(token)),
+	})
 }
 
 // SendPasswordResetCode sends a 6-digit code to the user's email for password reset
-func SendPasswordResetCode(cfg *config.Config, email, code string) error {
-	addr := fmt.Sprintf("%s:%s", cfg.SMTPHost, cfg.SMTPPort)
-	var auth smtp.Auth
-	if cfg.SMTPUser != "" && cfg.SMTPPass != "" {
-		auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPass, cfg.SMTPHost)
-	}
-	to := []string{email}
-	subject := "Matcha – Your password reset code"
-	body := fmt.Sprintf(`
-Hi,
-
-Your password reset code is: %s
-
-This code expires in 15 minutes. If you didn't request a reset, please ignore this email.
-
-Best regards,
-The Matcha Team
-`, code)
-	msg := []byte(fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", email, subject, body))
-	err := smtp.SendMail(addr, auth, cfg.FromEmail, to, msg)
-	if err != nil {
-		return fmt.Errorf("failed to send email: %v", err)
-	}
-	return nil
+func SendPasswordResetCode(cfg *config.Config, email, locale, code string) error {
+	return sendTemplateEmail(cfg, email, locale, "password_reset_code", map[string]interface{}{
+		"Code":    code,
+		"Minutes": int(passwordResetTTL.Minutes()),
+	})
 }
 
 // SendPasswordResetLink sends an email with a link to reset the password
-func SendPasswordResetLink(cfg *config.Config, email, resetLink string) error {
-	addr := fmt.Sprintf("%s:%s", cfg.SMTPHost, cfg.SMTPPort)
-	var auth smtp.Auth
-	if cfg.SMTPUser != "" && cfg.SMTPPass != "" {
-		auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPass, cfg.SMTPHost)
-	}
-	to := []string{email}
-	subject := "Matcha – Reset your password"
-	body := fmt.Sprintf(`
-Hi,
+func SendPasswordResetLink(cfg *config.Config, email, locale, resetLink string) error {
+	return sendTemplateEmail(cfg, email, locale, "password_reset_link", map[string]interface{}{
+		"Link":    resetLink,
+		"Minutes": int(passwordResetTTL.Minutes()),
+	})
+}
 
-You requested to reset your password. Click the link below to choose a new password:
+// SendMagicLink sends an email with a link that signs the user in without a password
+func SendMagicLink(cfg *config.Config, email, locale, link string, ttl time.Duration) error {
+	return sendTemplateEmail(cfg, email, locale, "magic_link", map[string]interface{}{
+		"Link":    link,
+		"Minutes": int(ttl.Minutes()),
+	})
+}
+
+// SendEmailChangeConfirmation sends a link to the new address that confirms an email change
+func SendEmailChangeConfirmation(cfg *config.Config, newEmail, locale, link string) error {
+	return sendTemplateEmail(cfg, newEmail, locale, "email_change_confirm", map[string]interface{}{
+		"Link":  link,
+		"Hours": int(emailChangeTTL.Hours()),
+	})
+}
 
-%s
+// SendEmailChangeNotice tells the old address about an email change, with a link to undo it
+func SendEmailChangeNotice(cfg *config.Config, oldEmail, locale, newEmail, revertLink string) error {
+	return sendTemplateEmail(cfg, oldEmail, locale, "email_change_notice", map[string]interface{}{
+		"NewEmail": newEmail,
+		"Link":     revertLink,
+		"Days":     int(emailChangeRevertTTL.Hours() / 24),
+	})
+}
 
-This link expires in 15 minutes. If you didn't request a reset, please ignore this email.
+// DigestSummary is what an email digest reports
+type DigestSummary struct {
+	Total          int                   // Unread notifications since the last digest
+	Notifications  []models.Notification // The latest of them
+	UnreadMessages int                   // Unread messages, from Senders users
+	Senders        int
+}
 
-Best regards,
-The Matcha Team
-`, resetLink)
-	msg := []byte(fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", email, subject, body))
-	err := smtp.SendMail(addr, auth, cfg.FromEmail, to, msg)
+// SendDigestEmail sends a digest of unread notifications, with a link (carrying the unsubscribe token)
+// to change how often digests come
+func SendDigestEmail(cfg *config.Config, recipient models.DigestRecipient, summary DigestSummary, token string) error {
+	unsubscribeLink := frontendLink(cfg, "/unsubscribe?token="+url.QueryEscape(token))
+	email, err := RenderEmail(cfg, recipient.Email, recipient.Locale, "digest", map[string]interface{}{
+		"FirstName":       recipient.FirstName,
+		"Weekly":          recipient.Frequency == models.DigestWeekly,
+		"Total":           summary.Total,
+		"Notifications":   summary.Notifications,
+		"More":            summary.Total - len(summary.Notifications),
+		"UnreadMessages":  summary.UnreadMessages,
+		"Senders":         summary.Senders,
+		"Link":            frontendLink(cfg, "/"),
+		"UnsubscribeLink": unsubscribeLink,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to send email: %v", err)
+		return err
 	}
-	return nil
+	email.ListUnsubscribe = "<" + unsubscribeLink + ">"
+	return queueEmail(cfg, "digest", email)
 }
 
+// frontendLink returns the frontend URL of path (which starts with a slash)
+func frontendLink(cfg *config.Config, path string) string {
+	return strings.TrimSuffix(cfg.FrontendURL, "/") + path
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"matcha/internal/config"
+	"matcha/internal/store"
+)
+
+// Changing the account email. The new address only replaces the old one once a link sent to it is
+// followed, and the old address is told about the change with a link that cancels it, or undoes it
+// for a week after it went through. Undoing it also logs the account out everywhere, since whoever
+// changed the address may still have a session.
+
+const (
+	emailChangeTTL       = 24 * time.Hour
+	emailChangeRevertTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrEmailTaken         = errors.New("email is already taken")
+	ErrEmailChangeInvalid = errors.New("email change link is invalid, already used or expired")
+)
+
+// NormalizeEmail returns the email as stored: trimmed and lower case
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// RequestEmailChange starts changing the user's email to newEmail: it emails a confirmation link to
+// the new address and a notice with a revert link to the current one. Returns false (and sends
+// nothing) if newEmail is the current email.
+func RequestEmailChange(cfg *config.Config, userID int64, newEmail string) (bool, error) {
+	newEmail = NormalizeEmail(newEmail)
+	user, err := store.Get().Users.GetByID(userID)
+	if err != nil {
+		return false, fmt.Errorf("database error: %v", err)
+	}
+	if newEmail == user.Email {
+		return false, nil
+	}
+	if addr, err := mail.ParseAddress(newEmail); err != nil || addr.Address != newEmail {
+		return false, ErrInvalidEmail
+	}
+	taken, err := store.Get().Users.EmailInUse(newEmail, userID)
+	if err != nil {
+		return false, fmt.Errorf("database error: %v", err)
+	}
+	if taken {
+		return false, ErrEmailTaken
+	}
+
+	confirmToken, err := emailChangeToken()
+	if err != nil {
+		return false, err
+	}
+	revertToken, err := emailChangeToken()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	err = store.Get().EmailChanges.Create(store.EmailChange{
+		UserID:          userID,
+		OldEmail:        user.Email,
+		NewEmail:        newEmail,
+		ExpiresAt:       now.Add(emailChangeTTL),
+		RevertExpiresAt: now.Add(emailChangeRevertTTL),
+	}, hashRefreshToken(confirmToken), hashRefreshToken(revertToken))
+	if err != nil {
+		return false, fmt.Errorf("database error: %v", err)
+	}
+
+	// Without both emails the change can't be confirmed, or can't be noticed by the owner of the old address
+	err = SendEmailChangeConfirmation(cfg, newEmail, user.Locale,
+		frontendLink(cfg, "/email-change?action=confirm&token="+url.QueryEscape(confirmToken)))
+	if err == nil {
+		err = SendEmailChangeNotice(cfg, user.Email, user.Locale, newEmail,
+			frontendLink(cfg, "/email-change?action=revert&token="+url.QueryEscape(revertToken)))
+	}
+	if err != nil {
+		if _, cancelErr := store.Get().EmailChanges.Cancel(userID); cancelErr != nil {
+			log.Printf("Error cancelling email change of user %d: %v", userID, cancelErr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func emailChangeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PendingEmail returns the address the user is changing their email to; empty if none
+func PendingEmail(userID int64) (string, error) {
+	change, err := store.Get().EmailChanges.GetPending(userID)
+	if err == store.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return change.NewEmail, nil
+}
+
+// CancelEmailChange drops the user's pending email change; false if there was none
+func CancelEmailChange(userID int64) (bool, error) {
+	return store.Get().EmailChanges.Cancel(userID)
+}
+
+// ConfirmEmailChange applies the change whose confirmation link was followed and returns the new email
+func ConfirmEmailChange(token string) (string, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", ErrEmailChangeInvalid
+	}
+	change, err := store.Get().EmailChanges.Confirm(hashRefreshToken(token))
+	switch err {
+	case nil:
+		return change.NewEmail, nil
+	case store.ErrNotFound:
+		return "", ErrEmailChangeInvalid
+	case store.ErrEmailInUse:
+		return "", ErrEmailTaken
+	default:
+		return "", fmt.Errorf("database error: %v", err)
+	}
+}
+
+// RevertEmailChange cancels or undoes the change whose revert link was followed. If the change had
+// gone through, the old email is back and all of the user's sessions are revoked. Returns whether it
+// had gone through.
+func RevertEmailChange(token string) (bool, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return false, ErrEmailChangeInvalid
+	}
+	change, err := store.Get().EmailChanges.Revert(hashRefreshToken(token))
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		return false, ErrEmailChangeInvalid
+	case store.ErrEmailInUse:
+		return false, ErrEmailTaken
+	default:
+		return false, fmt.Errorf("database error: %v", err)
+	}
+	if change.ConfirmedAt == nil {
+		return false, nil
+	}
+	if _, err := EndAllSessions(change.UserID, 0, SessionRevokedEmailRevert); err != nil {
+		log.Printf("Error revoking sessions of user %d after reverting an email change: %v", change.UserID, err)
+	}
+	return true, nil
+}
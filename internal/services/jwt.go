@@ -4,45 +4,72 @@ import (
 	"errors"
 	"time"
 
+	"matcha/internal/store"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("matcha-secret-key-change-in-production") // TODO: Move to config
+// ErrSessionEnded is returned for a well-formed access token whose session was revoked or has expired
+var ErrSessionEnded = errors.New("session has ended")
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID int64 `json:"user_id"`
+	UserID    int64 `json:"user_id"`
+	SessionID int64 `json:"sid"` // The login session the token was issued for (see StartSession)
 	jwt.RegisteredClaims
 }
 
-// GenerateToken generates a JWT token for a user
-func GenerateToken(userID int64) (string, error) {
+// GenerateToken generates a JWT access token for a user's session, valid for ttl
+func GenerateToken(userID, sessionID int64, ttl time.Duration) (string, error) {
 	claims := Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	if jwtKeys == nil {
+		return "", errors.New("JWT keys are not loaded")
+	}
+	return jwtKeys.sign(claims)
 }
 
-// ValidateToken validates a JWT token and returns the user ID
-func ValidateToken(tokenString string) (int64, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-
+// AuthenticateToken validates an access token and checks that its session is still active, so
+// logging out takes effect before the token expires
+func AuthenticateToken(tokenString string) (*Claims, error) {
+	if jwtKeys == nil {
+		return nil, errors.New("JWT keys are not loaded")
+	}
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, jwtKeys.keyFunc)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims.UserID, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid || claims.SessionID == 0 {
+		return nil, errors.New("invalid token")
 	}
 
-	return 0, errors.New("invalid token")
+	session, err := store.Get().Sessions.Get(claims.SessionID)
+	if err == store.ErrNotFound {
+		return nil, ErrSessionEnded
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != claims.UserID || !session.Active() {
+		return nil, ErrSessionEnded
+	}
+	return claims, nil
 }
 
+// ValidateToken validates a JWT token and returns the user ID
+func ValidateToken(tokenString string) (int64, error) {
+	claims, err := AuthenticateToken(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
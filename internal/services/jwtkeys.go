@@ -0,0 +1,245 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"matcha/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWT signing keys. Tokens are signed with one key and carry its id in the `kid` header; any key in the
+// set can verify, so a key can be rotated out by adding its successor as the signing key and keeping the
+// old one (verification only) until the tokens it signed have expired.
+
+// devJWTSecret is only used when no key is configured, so a fresh checkout runs without setup
+const devJWTSecret = "matcha-secret-key-change-in-production"
+
+// minHMACSecretLength is the shortest accepted HS256 secret, in bytes
+const minHMACSecretLength = 32
+
+// jwtKey is one signing or verification key
+type jwtKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{} // nil for verification-only keys
+	VerifyKey interface{}
+}
+
+// JWTKeySet is the set of keys tokens are signed and verified with
+type JWTKeySet struct {
+	signing *jwtKey
+	keys    map[string]*jwtKey
+}
+
+// jwtKeyFile is the format of JWT_KEYS_FILE. PEM keys may be given inline or as a file path relative to
+// the key file.
+type jwtKeyFile struct {
+	SigningKey string `json:"signing_key"` // kid of the key new tokens are signed with
+	Keys       []struct {
+		ID             string `json:"kid"`
+		Alg            string `json:"alg"`    // "HS256", "RS256" or "EdDSA"
+		Secret         string `json:"secret"` // HS256
+		PrivateKey     string `json:"private_key"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKey      string `json:"public_key"` // For verification-only RS256/EdDSA keys
+		PublicKeyFile  string `json:"public_key_file"`
+	} `json:"keys"`
+}
+
+var jwtKeys *JWTKeySet
+
+// InitJWTKeys loads the keys used by GenerateToken and AuthenticateToken. Call once at startup.
+func InitJWTKeys(cfg *config.Config) error {
+	keys, err := LoadJWTKeys(cfg)
+	if err != nil {
+		return err
+	}
+	jwtKeys = keys
+	return nil
+}
+
+// LoadJWTKeys builds the key set from JWT_KEYS_FILE if set, otherwise from JWT_SECRET (one HS256 key)
+func LoadJWTKeys(cfg *config.Config) (*JWTKeySet, error) {
+	if cfg.JWTKeysFile != "" {
+		return loadJWTKeyFile(cfg.JWTKeysFile)
+	}
+	secret := cfg.JWTSecret
+	if secret == "" {
+		log.Printf("Warning: JWT_SECRET and JWT_KEYS_FILE are not set; signing tokens with the insecure development key")
+		secret = devJWTSecret
+	}
+	key, err := newHMACKey(cfg.JWTKeyID, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTKeySet{signing: key, keys: map[string]*jwtKey{key.ID: key}}, nil
+}
+
+func loadJWTKeyFile(path string) (*JWTKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key file: %v", err)
+	}
+	var file jwtKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid JWT key file %s: %v", path, err)
+	}
+	// pem returns an inline PEM key or reads it from its file
+	dir := filepath.Dir(path)
+	pem := func(inline, file string) ([]byte, error) {
+		if inline != "" || file == "" {
+			return []byte(inline), nil
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return os.ReadFile(file)
+	}
+
+	set := &JWTKeySet{keys: make(map[string]*jwtKey)}
+	for _, k := range file.Keys {
+		if k.ID == "" {
+			return nil, fmt.Errorf("JWT key file %s: every key needs a kid", path)
+		}
+		if set.keys[k.ID] != nil {
+			return nil, fmt.Errorf("JWT key file %s: duplicate kid %q", path, k.ID)
+		}
+		var key *jwtKey
+		switch k.Alg {
+		case "HS256":
+			key, err = newHMACKey(k.ID, k.Secret)
+		case "RS256", "EdDSA":
+			var private, public []byte
+			if private, err = pem(k.PrivateKey, k.PrivateKeyFile); err == nil {
+				if public, err = pem(k.PublicKey, k.PublicKeyFile); err == nil {
+					key, err = newAsymmetricKey(k.ID, k.Alg, private, public)
+				}
+			}
+		default:
+			err = fmt.Errorf("unsupported alg %q", k.Alg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %v", k.ID, err)
+		}
+		set.keys[k.ID] = key
+	}
+
+	set.signing = set.keys[file.SigningKey]
+	if set.signing == nil {
+		return nil, fmt.Errorf("JWT key file %s: signing_key %q is not one of the keys", path, file.SigningKey)
+	}
+	if set.signing.SignKey == nil {
+		return nil, fmt.Errorf("JWT key file %s: signing key %q has no private key", path, file.SigningKey)
+	}
+	return set, nil
+}
+
+func newHMACKey(id, secret string) (*jwtKey, error) {
+	if len(secret) < minHMACSecretLength {
+		return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minHMACSecretLength)
+	}
+	return &jwtKey{ID: id, Method: jwt.SigningMethodHS256, SignKey: []byte(secret), VerifyKey: []byte(secret)}, nil
+}
+
+// newAsymmetricKey parses an RS256 or EdDSA key from PEM. With a private key the public key is derived
+// from it; with only a public key the key can verify but not sign.
+func newAsymmetricKey(id, alg string, privatePEM, publicPEM []byte) (*jwtKey, error) {
+	key := &jwtKey{ID: id}
+	var private crypto.Signer
+	var err error
+	if alg == "RS256" {
+		key.Method = jwt.SigningMethodRS256
+		if len(privatePEM) > 0 {
+			private, err = jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+		} else if len(publicPEM) > 0 {
+			key.VerifyKey, err = jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+		}
+	} else {
+		key.Method = jwt.SigningMethodEdDSA
+		if len(privatePEM) > 0 {
+			var k crypto.PrivateKey
+			if k, err = jwt.ParseEdPrivateKeyFromPEM(privatePEM); err == nil {
+				private = k.(ed25519.PrivateKey)
+			}
+		} else if len(publicPEM) > 0 {
+			key.VerifyKey, err = jwt.ParseEdPublicKeyFromPEM(publicPEM)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if private != nil {
+		key.SignKey = private
+		key.VerifyKey = private.Public()
+	}
+	if key.VerifyKey == nil {
+		return nil, fmt.Errorf("needs a private_key or public_key")
+	}
+	return key, nil
+}
+
+// sign signs claims with the signing key and sets the kid header
+func (s *JWTKeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.SignKey)
+}
+
+// keyFunc picks the verification key named by the token's kid (the signing key if it has none), and
+// rejects tokens whose alg doesn't match that key
+func (s *JWTKeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := s.signing
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key = s.keys[kid]; key == nil {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.VerifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"` // RSA
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"` // Ed25519
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys of the current key set, for other services to verify tokens with.
+// HS256 keys are secret and never listed.
+func JWKS() []JWK {
+	list := []JWK{}
+	if jwtKeys == nil {
+		return list
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	for id, key := range jwtKeys.keys {
+		switch pub := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			list = append(list, JWK{Kty: "RSA", Kid: id, Alg: "RS256", Use: "sig",
+				N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())})
+		case ed25519.PublicKey:
+			list = append(list, JWK{Kty: "OKP", Kid: id, Alg: "EdDSA", Use: "sig", Crv: "Ed25519", X: b64(pub)})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Kid < list[j].Kid })
+	return list
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"matcha/internal/config"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// Passwordless sign-in: a link with a random token is emailed to the user, and following it (the
+// frontend posts the token to /api/login/magic-link/verify) logs them in. Only the token's hash is
+// stored, and each link works once.
+
+var ErrMagicLinkInvalid = errors.New("sign-in link is invalid, already used or expired")
+
+// ErrNoMagicLinkAccount is returned when there is no verified account to send a link to; callers hide
+// it so the endpoint doesn't reveal which accounts exist
+var ErrNoMagicLinkAccount = errors.New("no verified account found")
+
+// SendMagicLinkEmail emails a sign-in link to the user with this username or email
+func SendMagicLinkEmail(cfg *config.Config, login, ipAddress string) error {
+	user, err := findUserByLogin(login)
+	if err == store.ErrNotFound {
+		return ErrNoMagicLinkAccount
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	// Verifying the address is the job of the verification email
+	if !user.IsEmailVerified {
+		return ErrNoMagicLinkAccount
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	expires := time.Now().UTC().Add(cfg.MagicLinkTTL)
+	if err := store.Get().LoginLinks.Create(user.ID, hashRefreshToken(token), ipAddress, expires); err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+
+	link := frontendLink(cfg, "/login/magic?token="+url.QueryEscape(token))
+	if err := SendMagicLink(cfg, user.Email, user.Locale, link, cfg.MagicLinkTTL); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
+
+// ConsumeMagicLink uses up a sign-in link and returns its user. The caller still has to ask for the
+// second factor if the user has one.
+func ConsumeMagicLink(token string) (*models.User, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrMagicLinkInvalid
+	}
+	userID, err := store.Get().LoginLinks.Consume(hashRefreshToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	if userID == 0 {
+		return nil, ErrMagicLinkInvalid
+	}
+	user, err := store.Get().Users.GetByID(userID)
+	if err == store.ErrNotFound {
+		return nil, ErrMagicLinkInvalid
+	}
+	return user, err
+}
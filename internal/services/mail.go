@@ -0,0 +1,244 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"matcha/internal/config"
+)
+
+// Outgoing email is rendered from the templates in templates/email/<locale>/. A message NAME has
+// NAME.txt, defining its "subject" and plain text body ("text"), and NAME.html, defining the "content"
+// of the HTML layout; layout.txt and layout.html wrap the bodies. Both versions are sent together as
+// multipart/alternative. A locale without a message's templates falls back to defaultLocale.
+// Rendered emails are queued and sent by the mail worker (mail_queue.go).
+
+// defaultLocale is used for users without a locale, and for messages missing in theirs
+const defaultLocale = "en"
+
+//go:embed templates/email
+var emailTemplateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// emailHTMLFuncs are available to the HTML templates; {{template "button" (button .Link "Label")}}
+// renders a link as a button
+var emailHTMLFuncs = htmltemplate.FuncMap{
+	"button": func(url, label string) map[string]string {
+		return map[string]string{"URL": url, "Label": label}
+	},
+}
+
+// emailTemplates maps locale, then message name, to its templates
+var emailTemplates = mustLoadEmailTemplates()
+
+func mustLoadEmailTemplates() map[string]map[string]*emailTemplate {
+	root := "templates/email"
+	locales, err := fs.ReadDir(emailTemplateFS, root)
+	if err != nil {
+		panic(err)
+	}
+	all := make(map[string]map[string]*emailTemplate)
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+		dir := path.Join(root, locale.Name())
+		texts, err := fs.Glob(emailTemplateFS, dir+"/*.txt")
+		if err != nil {
+			panic(err)
+		}
+		all[locale.Name()] = make(map[string]*emailTemplate)
+		for _, file := range texts {
+			name := strings.TrimSuffix(path.Base(file), ".txt")
+			if name == "layout" {
+				continue
+			}
+			all[locale.Name()][name] = &emailTemplate{
+				text: texttemplate.Must(texttemplate.ParseFS(emailTemplateFS, dir+"/layout.txt", file)),
+				html: htmltemplate.Must(htmltemplate.New(name).Funcs(emailHTMLFuncs).
+					ParseFS(emailTemplateFS, dir+"/layout.html", dir+"/"+name+".html")),
+			}
+		}
+	}
+	if len(all[defaultLocale]) == 0 {
+		panic("no email templates for the default locale " + defaultLocale)
+	}
+	return all
+}
+
+// IsSupportedLocale reports whether there are email templates for the locale
+func IsSupportedLocale(locale string) bool {
+	return len(emailTemplates[locale]) > 0
+}
+
+// MatchLocale picks the supported locale the client prefers from an Accept-Language header; "" if
+// none of them is supported
+func MatchLocale(acceptLanguage string) string {
+	type choice struct {
+		locale string
+		q      float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		// Templates are per language: "fr-CA" gets "fr"
+		language, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if q > 0 && IsSupportedLocale(language) {
+			choices = append(choices, choice{language, q})
+		}
+	}
+	if len(choices) == 0 {
+		return ""
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].locale
+}
+
+// Email is a rendered message
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// ListUnsubscribe is the List-Unsubscribe header ("<URL>") of emails users can opt out of; "" = none
+	ListUnsubscribe string
+}
+
+// RenderEmail renders the message name in the locale for the address to. Templates see data, plus
+// FrontendURL and Subject.
+func RenderEmail(cfg *config.Config, to, locale, name string, data map[string]interface{}) (*Email, error) {
+	tmpl := emailTemplates[locale][name]
+	if tmpl == nil {
+		tmpl = emailTemplates[defaultLocale][name]
+	}
+	if tmpl == nil {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+	values := map[string]interface{}{"FrontendURL": strings.TrimSuffix(cfg.FrontendURL, "/")}
+	for k, v := range data {
+		values[k] = v
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", values); err != nil {
+		return nil, fmt.Errorf("email %s: %v", name, err)
+	}
+	values["Subject"] = strings.TrimSpace(subject.String())
+	if err := tmpl.text.ExecuteTemplate(&text, "layout", values); err != nil {
+		return nil, fmt.Errorf("email %s: %v", name, err)
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", values); err != nil {
+		return nil, fmt.Errorf("email %s: %v", name, err)
+	}
+	return &Email{
+		To:      to,
+		Subject: values["Subject"].(string),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// BuildMessage returns the email as a MIME message (RFC 5322 headers, multipart/alternative body)
+func BuildMessage(cfg *config.Config, email *Email, date time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		// Clients show the last alternative they support, so the richer one goes last
+		{"text/plain; charset=UTF-8", email.Text},
+		{"text/html; charset=UTF-8", email.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := io.WriteString(qp, part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	messageID, err := newMessageID(cfg.FromEmail)
+	if err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	for _, header := range [][2]string{
+		{"From", (&mail.Address{Name: "Matcha", Address: cfg.FromEmail}).String()},
+		{"To", email.To},
+		{"Subject", mime.QEncoding.Encode("UTF-8", email.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", `multipart/alternative; boundary="` + parts.Boundary() + `"`},
+		{"List-Unsubscribe", email.ListUnsubscribe},
+	} {
+		if header[1] == "" {
+			continue
+		}
+		fmt.Fprintf(&msg, "%s: %s\r\n", header[0], header[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// newMessageID returns a unique Message-ID in the domain of the sender address
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "matcha.local"
+	if _, d, ok := strings.Cut(from, "@"); ok && d != "" {
+		domain = d
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">", nil
+}
+
+// sendTemplateEmail renders the message name for the address to and queues it for the mail worker
+func sendTemplateEmail(cfg *config.Config, to, locale, name string, data map[string]interface{}) error {
+	email, err := RenderEmail(cfg, to, locale, name, data)
+	if err != nil {
+		return err
+	}
+	return queueEmail(cfg, name, email)
+}
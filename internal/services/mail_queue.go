@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"matcha/internal/config"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// Emails are queued in the email_outbox table and delivered by a background worker, so requests never
+// wait on the mail server. A failed send is retried with exponential backoff; after mailMaxAttempts the
+// email is dead and stays in the table for inspection (GET /api/admin/email-outbox) until requeued.
+
+const (
+	mailPollInterval = 10 * time.Second
+	mailBatchSize    = 20
+	mailMaxAttempts  = 10
+	mailBaseBackoff  = 30 * time.Second
+	mailMaxBackoff   = 6 * time.Hour
+	// A claimed email is retried after this long if the worker dies while sending it
+	mailClaimLease = 5 * time.Minute
+	// Sent emails are kept this long (without their message) for the admin view
+	mailSentRetention = 7 * 24 * time.Hour
+)
+
+// mailWake nudges the worker to deliver newly queued emails right away
+var mailWake = make(chan struct{}, 1)
+
+// queueEmail builds the message and stores it for the worker to send
+func queueEmail(cfg *config.Config, template string, email *Email) error {
+	msg, err := BuildMessage(cfg, email, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = store.Get().EmailOutbox.Enqueue(&models.QueuedEmail{
+		To:       email.To,
+		Template: template,
+		Subject:  email.Subject,
+		Message:  string(msg),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue email: %v", err)
+	}
+	select {
+	case mailWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// InitMailer sets the mailer configured by MAILER. Call once at startup, before StartMailWorker.
+func InitMailer(cfg *config.Config) error {
+	m, err := NewMailer(cfg)
+	if err != nil {
+		return err
+	}
+	SetMailer(m)
+	if cfg.Mailer != "" && cfg.Mailer != "smtp" {
+		log.Printf("Emails are delivered with the %s mailer", cfg.Mailer)
+	}
+	return nil
+}
+
+// StartMailWorker delivers queued emails in the background until ctx is cancelled
+func StartMailWorker(ctx context.Context, cfg *config.Config) {
+	go func() {
+		ticker := time.NewTicker(mailPollInterval)
+		defer ticker.Stop()
+		var lastCleanup time.Time
+		for {
+			deliverDueEmails(cfg)
+			if time.Since(lastCleanup) > time.Hour {
+				if _, err := store.Get().EmailOutbox.DeleteSent(time.Now().UTC().Add(-mailSentRetention)); err != nil {
+					log.Printf("Error deleting old sent emails: %v", err)
+				}
+				lastCleanup = time.Now()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-mailWake:
+			}
+		}
+	}()
+}
+
+// deliverDueEmails sends the emails whose next attempt is due, a batch at a time
+func deliverDueEmails(cfg *config.Config) {
+	for {
+		emails, err := store.Get().EmailOutbox.Due(time.Now().UTC(), mailBatchSize)
+		if err != nil {
+			log.Printf("Error loading queued emails: %v", err)
+			return
+		}
+		for _, email := range emails {
+			deliverEmail(cfg, email)
+		}
+		if len(emails) < mailBatchSize {
+			return
+		}
+	}
+}
+
+// deliverEmail sends one email and records the outcome
+func deliverEmail(cfg *config.Config, email models.QueuedEmail) {
+	outbox := store.Get().EmailOutbox
+	claimed, err := outbox.Claim(email.ID, time.Now().UTC().Add(mailClaimLease))
+	if err != nil {
+		log.Printf("Error claiming email %d: %v", email.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	m := currentMailer()
+	if m == nil {
+		err = fmt.Errorf("no mailer configured")
+	} else {
+		err = m.Send(cfg.FromEmail, email.To, []byte(email.Message))
+	}
+	if err == nil {
+		if err := outbox.MarkSent(email.ID); err != nil {
+			log.Printf("Error marking email %d sent: %v", email.ID, err)
+		}
+		return
+	}
+
+	attempts := email.Attempts + 1
+	dead := attempts >= mailMaxAttempts
+	next := time.Now().UTC().Add(mailBackoff(attempts))
+	if dead {
+		log.Printf("Email %d (%s) to %s failed %d times, giving up: %v", email.ID, email.Template, email.To, attempts, err)
+	} else {
+		log.Printf("Email %d (%s) failed, attempt %d, retrying at %s: %v",
+			email.ID, email.Template, attempts, next.Format(time.RFC3339), err)
+	}
+	if err := outbox.MarkFailed(email.ID, attempts, err.Error(), next, dead); err != nil {
+		log.Printf("Error recording failure of email %d: %v", email.ID, err)
+	}
+}
+
+// mailBackoff doubles from mailBaseBackoff per attempt, capped at mailMaxBackoff
+func mailBackoff(attempts int) time.Duration {
+	backoff := mailBaseBackoff
+	for i := 1; i < attempts && backoff < mailMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > mailMaxBackoff {
+		backoff = mailMaxBackoff
+	}
+	return backoff
+}
+
+// EmailOutboxStatus is the state of the email queue, for admins
+type EmailOutboxStatus struct {
+	Counts map[string]int       `json:"counts"`
+	Emails []models.QueuedEmail `json:"emails"`
+}
+
+// GetEmailOutboxStatus returns the number of emails per status and the latest emails with the status
+// ("" = any)
+func GetEmailOutboxStatus(status string, limit int) (*EmailOutboxStatus, error) {
+	counts, err := store.Get().EmailOutbox.CountByStatus()
+	if err != nil {
+		return nil, err
+	}
+	emails, err := store.Get().EmailOutbox.List(status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailOutboxStatus{Counts: counts, Emails: emails}, nil
+}
+
+// RetryEmail queues a dead email again; false if there is no dead email with this id
+func RetryEmail(id int64) (bool, error) {
+	requeued, err := store.Get().EmailOutbox.Requeue(id)
+	if requeued {
+		select {
+		case mailWake <- struct{}{}:
+		default:
+		}
+	}
+	return requeued, err
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"matcha/internal/config"
+	"matcha/internal/database"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// failingMailer refuses every message
+type failingMailer struct{}
+
+func (failingMailer) Send(from, to string, message []byte) error {
+	return errors.New("connection refused")
+}
+
+func useMailer(t *testing.T, m Mailer) {
+	previous := currentMailer()
+	SetMailer(m)
+	t.Cleanup(func() { SetMailer(previous) })
+}
+
+func getQueuedEmail(t *testing.T, id int64) models.QueuedEmail {
+	t.Helper()
+	emails, err := store.Get().EmailOutbox.List("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range emails {
+		if e.ID == id {
+			return e
+		}
+	}
+	t.Fatalf("email %d not found", id)
+	return models.QueuedEmail{}
+}
+
+// makeEmailDue moves the next attempt of an email to now, as if its backoff had passed
+func makeEmailDue(t *testing.T, id int64) {
+	t.Helper()
+	if _, err := database.DB.Exec(`UPDATE email_outbox SET next_attempt_at = ? WHERE id = ?`, time.Now().UTC(), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMailBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, mailBaseBackoff},
+		{2, 2 * mailBaseBackoff},
+		{3, 4 * mailBaseBackoff},
+		{9, 256 * mailBaseBackoff},
+		{10, 512 * mailBaseBackoff},
+		{11, mailMaxBackoff},
+		{50, mailMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := mailBackoff(tt.attempts); got != tt.want {
+			t.Errorf("mailBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestMailWorkerRetriesUntilDead(t *testing.T) {
+	cfg := &config.Config{FromEmail: "noreply@example.com"}
+	id, err := store.Get().EmailOutbox.Enqueue(&models.QueuedEmail{
+		To:       "someone@example.com",
+		Template: "test",
+		Subject:  "Hello",
+		Message:  "Subject: Hello\r\n\r\nHi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useMailer(t, failingMailer{})
+	before := time.Now().UTC()
+	deliverDueEmails(cfg)
+	after := time.Now().UTC()
+
+	email := getQueuedEmail(t, id)
+	if email.Status != models.EmailPending || email.Attempts != 1 {
+		t.Fatalf("after one failure: status %s, attempts %d; want pending, 1", email.Status, email.Attempts)
+	}
+	if email.LastError != "connection refused" {
+		t.Errorf("last error = %q", email.LastError)
+	}
+	if email.NextAttemptAt.Before(before.Add(mailBaseBackoff).Truncate(time.Second)) ||
+		email.NextAttemptAt.After(after.Add(mailBaseBackoff)) {
+		t.Errorf("next attempt at %v, want %v after the failure", email.NextAttemptAt, mailBaseBackoff)
+	}
+
+	// Not retried before its backoff is over
+	deliverDueEmails(cfg)
+	if email := getQueuedEmail(t, id); email.Attempts != 1 {
+		t.Fatalf("retried before the backoff: attempts %d", email.Attempts)
+	}
+
+	for attempt := 2; attempt <= mailMaxAttempts; attempt++ {
+		makeEmailDue(t, id)
+		deliverDueEmails(cfg)
+		email = getQueuedEmail(t, id)
+		if email.Attempts != attempt {
+			t.Fatalf("attempts = %d, want %d", email.Attempts, attempt)
+		}
+		want := models.EmailPending
+		if attempt == mailMaxAttempts {
+			want = models.EmailDead
+		}
+		if email.Status != want {
+			t.Fatalf("after %d failures: status %s, want %s", attempt, email.Status, want)
+		}
+	}
+
+	// Dead emails aren't tried again
+	makeEmailDue(t, id)
+	deliverDueEmails(cfg)
+	if email := getQueuedEmail(t, id); email.Status != models.EmailDead || email.Attempts != mailMaxAttempts {
+		t.Fatalf("dead email was retried: status %s, attempts %d", email.Status, email.Attempts)
+	}
+
+	// Until an admin requeues it
+	if requeued, err := RetryEmail(id); err != nil || !requeued {
+		t.Fatalf("RetryEmail = %v, %v; want true", requeued, err)
+	}
+	email = getQueuedEmail(t, id)
+	if email.Status != models.EmailPending || email.Attempts != 0 {
+		t.Fatalf("after requeueing: status %s, attempts %d; want pending, 0", email.Status, email.Attempts)
+	}
+	if requeued, err := RetryEmail(id); err != nil || requeued {
+		t.Errorf("RetryEmail of a pending email = %v, %v; want false", requeued, err)
+	}
+
+	mailer := &MemoryMailer{}
+	useMailer(t, mailer)
+	deliverDueEmails(cfg)
+	email = getQueuedEmail(t, id)
+	if email.Status != models.EmailSent || email.SentAt == nil || email.Message != "" {
+		t.Errorf("after delivery: status %s, sent at %v, message %q", email.Status, email.SentAt, email.Message)
+	}
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "someone@example.com" || sent[0].From != cfg.FromEmail {
+		t.Errorf("sent = %+v, want one email to someone@example.com", sent)
+	}
+}
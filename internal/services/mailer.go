@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"matcha/internal/config"
+)
+
+// Mailer delivers MIME messages (built by BuildMessage). The mail worker hands queued emails to the
+// configured one.
+type Mailer interface {
+	Send(from, to string, message []byte) error
+}
+
+// SMTPMailer sends through an SMTP server, with PLAIN auth if User is set
+type SMTPMailer struct {
+	Host string
+	Port string
+	User string
+	Pass string
+}
+
+func (m *SMTPMailer) Send(from, to string, message []byte) error {
+	var auth smtp.Auth
+	if m.User != "" && m.Pass != "" {
+		auth = smtp.PlainAuth("", m.User, m.Pass, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, from, []string{to}, message)
+}
+
+// FileMailer writes each message to an .eml file in Dir, for development
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(from, to string, message []byte) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), filepath.Base(to))
+	return os.WriteFile(filepath.Join(m.Dir, name), message, 0600)
+}
+
+// WriterMailer writes messages to W (e.g. os.Stdout), for development
+type WriterMailer struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+func (m *WriterMailer) Send(from, to string, message []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.W, "----- email from %s to %s -----\n%s\n", from, to, message)
+	return err
+}
+
+// SentMail is a message a MemoryMailer received
+type SentMail struct {
+	From    string
+	To      string
+	Message []byte
+}
+
+// MemoryMailer keeps the messages in memory, for tests
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []SentMail
+}
+
+func (m *MemoryMailer) Send(from, to string, message []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, SentMail{From: from, To: to, Message: append([]byte(nil), message...)})
+	return nil
+}
+
+// Sent returns the messages received so far
+func (m *MemoryMailer) Sent() []SentMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SentMail(nil), m.sent...)
+}
+
+// NewMailer returns the mailer cfg.Mailer names
+func NewMailer(cfg *config.Config) (Mailer, error) {
+	switch cfg.Mailer {
+	case "", "smtp":
+		return &SMTPMailer{Host: cfg.SMTPHost, Port: cfg.SMTPPort, User: cfg.SMTPUser, Pass: cfg.SMTPPass}, nil
+	case "file":
+		return &FileMailer{Dir: cfg.MailDir}, nil
+	case "stdout":
+		return &WriterMailer{W: os.Stdout}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q (want smtp, file, stdout or memory)", cfg.Mailer)
+	}
+}
+
+var (
+	mailerMu sync.RWMutex
+	mailer   Mailer
+)
+
+// SetMailer replaces the mailer queued emails are delivered with (e.g. a MemoryMailer in tests)
+func SetMailer(m Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	mailer = m
+}
+
+func currentMailer() Mailer {
+	mailerMu.RLock()
+	defer mailerMu.RUnlock()
+	return mailer
+}
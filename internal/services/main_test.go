@@ -0,0 +1,65 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"matcha/internal/database"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// The tests share one migrated SQLite database: the write queue binds to the first database opened in
+// the process, so it can't be reopened per test. Tests create their own users instead of cleaning up.
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	log.SetOutput(io.Discard)
+	dir, err := os.MkdirTemp("", "matcha-services-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	if err := database.Init(database.DriverSQLite, filepath.Join(dir, "test.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer database.Close()
+	if _, err := database.MigrateUp(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := store.Init(database.DriverSQLite, database.DB); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return m.Run()
+}
+
+var testUserCount int64
+
+// createTestUser stores a new user with a unique username and returns its id
+func createTestUser(t *testing.T) int64 {
+	t.Helper()
+	n := atomic.AddInt64(&testUserCount, 1)
+	id, err := store.Get().Users.Create(&models.User{
+		Username:  fmt.Sprintf("user%d", n),
+		Email:     fmt.Sprintf("user%d@example.com", n),
+		FirstName: "Test",
+		LastName:  "User",
+		Locale:    "en",
+	})
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	return id
+}
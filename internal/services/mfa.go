@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"matcha/internal/models"
+	"matcha/internal/store"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Two-step login: when the password of a user with two-factor authentication is right, LoginAPI
+// answers with an MFA challenge token instead of a session. The client sends it back with a code, and
+// only then is the session started.
+
+// MFAChallengeTTL is how long the user has to enter their code
+const MFAChallengeTTL = 5 * time.Minute
+
+// mfaAudience marks challenge tokens. They carry no session id, so AuthenticateToken refuses them as
+// access tokens.
+const mfaAudience = "mfa"
+
+var ErrMFAChallengeInvalid = errors.New("login challenge is invalid or has expired, log in again")
+
+type mfaClaims struct {
+	UserID int64 `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// IssueMFAChallenge returns a challenge token for a user whose password was just checked
+func IssueMFAChallenge(userID int64) (string, error) {
+	if jwtKeys == nil {
+		return "", errors.New("JWT keys are not loaded")
+	}
+	now := time.Now()
+	return jwtKeys.sign(mfaClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFAChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+}
+
+// CompleteMFALogin checks the code (TOTP or recovery) for a challenge token and returns the user. Wrong
+// codes count towards the account lockout like wrong passwords.
+func CompleteMFALogin(challenge, code string) (*models.User, error) {
+	if jwtKeys == nil {
+		return nil, errors.New("JWT keys are not loaded")
+	}
+	var claims mfaClaims
+	token, err := jwt.ParseWithClaims(challenge, &claims, jwtKeys.keyFunc, jwt.WithAudience(mfaAudience))
+	if err != nil || !token.Valid || claims.UserID == 0 {
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	user, err := store.Get().Users.GetByID(claims.UserID)
+	if err == store.ErrNotFound {
+		return nil, ErrMFAChallengeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.LoginLockedUntil != nil && time.Now().Before(*user.LoginLockedUntil) {
+		return nil, &AccountLockedError{Until: *user.LoginLockedUntil}
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	if err := verifySecondFactor(user.ID, code); err != nil {
+		if err == ErrInvalidTOTPCode {
+			recordLoginFailure(user.ID)
+		}
+		return nil, err
+	}
+	clearLoginFailures(user)
+	return user, nil
+}
@@ -0,0 +1,432 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"matcha/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OpenID Connect relying party: the authorization code flow with PKCE. Each provider's endpoints and
+// signing keys come from its discovery document (ISSUER/.well-known/openid-configuration) and are
+// cached; the keys are fetched again when an ID token names a key that isn't known yet (rotation).
+
+const (
+	oidcDiscoveryTTL = time.Hour
+	oidcKeysRefetch  = time.Minute // At most this often when an unknown kid shows up
+)
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// OIDCProvider is an OpenID Connect provider users can log in with
+type OIDCProvider struct {
+	Name         string   `json:"name"`         // Used in the API paths, and stored with linked identities
+	DisplayName  string   `json:"display_name"` // For the login button
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"` // Empty for public clients (PKCE only)
+	Scopes       []string `json:"scopes"`        // Default: openid email profile
+
+	mu           sync.Mutex
+	discovery    *oidcDiscovery
+	discoveredAt time.Time
+	keys         map[string]interface{}
+	keysFetched  time.Time
+}
+
+// oidcDiscovery is the part of the discovery document the flow uses
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// oidcProvidersFile is the format of OIDC_PROVIDERS_FILE
+type oidcProvidersFile struct {
+	Providers []*OIDCProvider `json:"providers"`
+}
+
+// IDTokenClaims are the claims of an ID token the login uses
+type IDTokenClaims struct {
+	Nonce             string      `json:"nonce"`
+	AuthorizedParty   string      `json:"azp"`
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"` // Some providers send "true" as a string
+	PreferredUsername string      `json:"preferred_username"`
+	Name              string      `json:"name"`
+	GivenName         string      `json:"given_name"`
+	FamilyName        string      `json:"family_name"`
+	jwt.RegisteredClaims
+}
+
+// IsEmailVerified reports whether the provider vouches for the email address
+func (c *IDTokenClaims) IsEmailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+var (
+	oidcMu        sync.RWMutex
+	oidcProviders = map[string]*OIDCProvider{}
+	oidcOrder     []string
+)
+
+// InitOIDC loads the configured OpenID Connect providers. Call once at startup; none configured is fine.
+func InitOIDC(cfg *config.Config) error {
+	providers, err := loadOIDCProviders(cfg)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*OIDCProvider, len(providers))
+	order := make([]string, 0, len(providers))
+	for _, p := range providers {
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+			return fmt.Errorf("OIDC provider %q: name, issuer and client_id are required", p.Name)
+		}
+		if byName[p.Name] != nil {
+			return fmt.Errorf("OIDC provider %q is listed twice", p.Name)
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = p.Name
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		byName[p.Name] = p
+		order = append(order, p.Name)
+	}
+	oidcMu.Lock()
+	oidcProviders, oidcOrder = byName, order
+	oidcMu.Unlock()
+	if len(order) > 0 {
+		log.Printf("OIDC login enabled for: %s", strings.Join(order, ", "))
+	}
+	return nil
+}
+
+func loadOIDCProviders(cfg *config.Config) ([]*OIDCProvider, error) {
+	if cfg.OIDCProvidersFile != "" {
+		data, err := os.ReadFile(cfg.OIDCProvidersFile)
+		if err != nil {
+			return nil, err
+		}
+		var file oidcProvidersFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.OIDCProvidersFile, err)
+		}
+		return file.Providers, nil
+	}
+	if cfg.OIDCIssuer == "" {
+		return nil, nil
+	}
+	return []*OIDCProvider{{
+		Name:         cfg.OIDCProviderName,
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+	}}, nil
+}
+
+// ListOIDCProviders returns the configured providers, in configuration order
+func ListOIDCProviders() []*OIDCProvider {
+	oidcMu.RLock()
+	defer oidcMu.RUnlock()
+	list := make([]*OIDCProvider, 0, len(oidcOrder))
+	for _, name := range oidcOrder {
+		list = append(list, oidcProviders[name])
+	}
+	return list
+}
+
+// GetOIDCProvider returns the provider with this name, or nil
+func GetOIDCProvider(name string) *OIDCProvider {
+	oidcMu.RLock()
+	defer oidcMu.RUnlock()
+	return oidcProviders[name]
+}
+
+// getJSON fetches a JSON document
+func getJSON(rawURL string, v interface{}) error {
+	resp, err := oidcHTTPClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Discover returns the provider's discovery document, fetching it if the cached one is too old
+func (p *OIDCProvider) Discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := getJSON(strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+	// The document must be about the issuer we trust, or its tokens would be accepted as ours
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery: issuer is %q, expected %q", d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery: authorization_endpoint, token_endpoint and jwks_uri are required")
+	}
+	p.discovery, p.discoveredAt = &d, time.Now()
+	return p.discovery, nil
+}
+
+// AuthorizationURL is where the browser is sent to log in at the provider
+func (p *OIDCProvider) AuthorizationURL(redirectURI, state, nonce, codeChallenge string) (string, error) {
+	d, err := p.Discover()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("authorization_endpoint: %v", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades an authorization code for the provider's tokens and returns the raw ID token
+func (p *OIDCProvider) Exchange(code, redirectURI, codeVerifier string) (string, error) {
+	d, err := p.Discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.ClientID)
+
+	// client_secret_basic is the default; send the secret in the form only to providers that only
+	// take client_secret_post
+	secretInForm := len(d.TokenAuthMethods) > 0 && !containsString(d.TokenAuthMethods, "client_secret_basic") &&
+		containsString(d.TokenAuthMethods, "client_secret_post")
+	if p.ClientSecret != "" && secretInForm {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" && !secretInForm {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token response: %s: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token request: %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, expiry and nonce, and returns its claims
+func (p *OIDCProvider) VerifyIDToken(raw, nonce string) (*IDTokenClaims, error) {
+	var claims IDTokenClaims
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if _, err := parser.ParseWithClaims(raw, &claims, p.idTokenKey); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: no sub")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	// With several audiences, the token must have been issued to us
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, errors.New("invalid ID token: azp mismatch")
+	}
+	return &claims, nil
+}
+
+// idTokenKey is the jwt.Keyfunc for ID tokens: the provider key named by kid
+func (p *OIDCProvider) idTokenKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	d, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.lookupKey(kid)
+	if !ok && time.Since(p.keysFetched) > oidcKeysRefetch {
+		if err := p.fetchKeys(d.JWKSURI); err != nil {
+			return nil, err
+		}
+		key, ok = p.lookupKey(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// lookupKey finds a key by kid; a token without kid may use the only key. Call with p.mu held.
+func (p *OIDCProvider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+// fetchKeys loads the provider's JWKS. Call with p.mu held.
+func (p *OIDCProvider) fetchKeys(jwksURI string) error {
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	p.keysFetched = time.Now()
+	if err := getJSON(jwksURI, &set); err != nil {
+		return fmt.Errorf("jwks: %v", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, raw := range set.Keys {
+		kid, key, err := parseJWK(raw)
+		if err != nil {
+			log.Printf("OIDC provider %s: skipping key: %v", p.Name, err)
+			continue
+		}
+		if key != nil {
+			keys[kid] = key
+		}
+	}
+	p.keys = keys
+	return nil
+}
+
+// parseJWK turns a JSON Web Key into a public key; nil for keys that aren't for signatures
+func parseJWK(raw json.RawMessage) (string, interface{}, error) {
+	var k struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return "", nil, err
+	}
+	if k.Use != "" && k.Use != "sig" {
+		return k.Kid, nil, nil
+	}
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err1 := b64(k.N)
+		e, err2 := b64(k.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 {
+			return "", nil, fmt.Errorf("key %q: bad RSA parameters", k.Kid)
+		}
+		return k.Kid, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", nil, fmt.Errorf("key %q: unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err1 := b64(k.X)
+		y, err2 := b64(k.Y)
+		if err1 != nil || err2 != nil {
+			return "", nil, fmt.Errorf("key %q: bad EC parameters", k.Kid)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return "", nil, fmt.Errorf("key %q: point is not on the curve", k.Kid)
+		}
+		return k.Kid, pub, nil
+	case "OKP":
+		x, err := b64(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return "", nil, fmt.Errorf("key %q: unsupported OKP key", k.Kid)
+		}
+		return k.Kid, ed25519.PublicKey(x), nil
+	}
+	return "", nil, fmt.Errorf("key %q: unsupported key type %q", k.Kid, k.Kty)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"matcha/internal/config"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// Logging in (or linking an account) through an OpenID Connect provider. StartOIDCLogin remembers the
+// state, nonce and PKCE verifier of the attempt and returns the provider's login URL; the provider
+// sends the browser back to the frontend's callback page, which posts the code and state to
+// FinishOIDCLogin. The state is also kept in a cookie of the browser that started the attempt, and
+// must come back with it: otherwise an attacker could have a victim's browser finish the attacker's
+// own login (logging the victim in as the attacker, or linking the victim's provider account to them).
+//
+// The identity is matched by the provider's subject. An identity seen for the first time is linked to
+// the user with the same email if both sides have verified it, and otherwise gets a new user.
+
+// OIDCStateTTL is how long the user has to log in at the provider
+const OIDCStateTTL = 10 * time.Minute
+
+var (
+	ErrOIDCUnknownProvider = errors.New("unknown login provider")
+	ErrOIDCStateInvalid    = errors.New("login attempt is invalid or has expired, try again")
+	ErrOIDCNoEmail         = errors.New("the provider did not share a verified email address")
+	ErrOIDCEmailTaken      = errors.New("an account with this email exists but hasn't verified it; log in to it and verify the email, or link the provider from your profile")
+	ErrOIDCLinkedElsewhere = errors.New("this provider account is already linked to another user")
+	ErrOIDCLastLogin       = errors.New("set a password before unlinking your only login provider")
+)
+
+// OIDCResult is the outcome of FinishOIDCLogin
+type OIDCResult struct {
+	User    *models.User
+	Linked  bool // A logged in user linked the identity; no login to complete
+	Created bool // The user was created for this identity
+}
+
+// oidcRandom returns a random URL-safe string
+func oidcRandom() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// StartOIDCLogin begins a login at the provider and returns the URL to send the browser to, and the
+// state to keep in the browser's cookie. With linkUserID set, the identity is linked to that (logged in)
+// user instead.
+func StartOIDCLogin(cfg *config.Config, providerName string, linkUserID int64) (authURL, state string, err error) {
+	provider := GetOIDCProvider(providerName)
+	if provider == nil {
+		return "", "", ErrOIDCUnknownProvider
+	}
+	if state, err = oidcRandom(); err != nil {
+		return "", "", err
+	}
+	nonce, err := oidcRandom()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidcRandom()
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	authURL, err = provider.AuthorizationURL(cfg.OIDCRedirectURL, state, nonce,
+		base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		return "", "", fmt.Errorf("provider %s: %v", provider.Name, err)
+	}
+	err = store.Get().Identities.CreateLoginState(store.OIDCLoginState{
+		StateHash:    hashRefreshToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().UTC().Add(OIDCStateTTL),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("database error: %v", err)
+	}
+	return authURL, state, nil
+}
+
+// FinishOIDCLogin completes a login with the code and state the provider sent back, and returns the
+// user. browserState is the state from the cookie of the browser finishing the login; it must be the
+// same as state, so that only the browser that started the login can finish it.
+func FinishOIDCLogin(cfg *config.Config, providerName, code, state, browserState string) (*OIDCResult, error) {
+	provider := GetOIDCProvider(providerName)
+	if provider == nil {
+		return nil, ErrOIDCUnknownProvider
+	}
+	if code == "" || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return nil, ErrOIDCStateInvalid
+	}
+	loginState, err := store.Get().Identities.ConsumeLoginState(hashRefreshToken(state))
+	if err == store.ErrNotFound || (err == nil && loginState.Provider != provider.Name) {
+		return nil, ErrOIDCStateInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	rawIDToken, err := provider.Exchange(code, cfg.OIDCRedirectURL, loginState.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %v", provider.Name, err)
+	}
+	claims, err := provider.VerifyIDToken(rawIDToken, loginState.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %v", provider.Name, err)
+	}
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+
+	identities := store.Get().Identities
+	users := store.Get().Users
+	identity, err := identities.Get(provider.Name, claims.Subject)
+	if err != nil && err != store.ErrNotFound {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	// Known identity: log in as (or confirm the link to) its user
+	if identity != nil {
+		if loginState.LinkUserID != 0 && identity.UserID != loginState.LinkUserID {
+			return nil, ErrOIDCLinkedElsewhere
+		}
+		if err := identities.RecordLogin(identity.ID, email); err != nil {
+			log.Printf("Error recording OIDC login of identity %d: %v", identity.ID, err)
+		}
+		user, err := users.GetByID(identity.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		return &OIDCResult{User: user, Linked: loginState.LinkUserID != 0}, nil
+	}
+
+	newIdentity := &models.UserIdentity{Provider: provider.Name, Subject: claims.Subject, Email: email}
+
+	// Linking from the profile
+	if loginState.LinkUserID != 0 {
+		newIdentity.UserID = loginState.LinkUserID
+		if err := identities.Link(newIdentity); err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		user, err := users.GetByID(loginState.LinkUserID)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		return &OIDCResult{User: user, Linked: true}, nil
+	}
+
+	if email == "" || !claims.IsEmailVerified() {
+		return nil, ErrOIDCNoEmail
+	}
+
+	// Same email as an existing user: link only if they proved it's theirs too, otherwise whoever
+	// registered the address first (unverified) would get the provider account's logins
+	existing, err := users.GetByEmail(email)
+	if err != nil && err != store.ErrNotFound {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	if existing != nil {
+		if !existing.IsEmailVerified {
+			return nil, ErrOIDCEmailTaken
+		}
+		newIdentity.UserID = existing.ID
+		if err := identities.Link(newIdentity); err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		return &OIDCResult{User: existing}, nil
+	}
+
+	// New user; they go through profile setup like anyone who registered
+	username, err := freeUsername(claims.PreferredUsername, email)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+	if firstName == "" {
+		firstName = username
+	}
+	user := &models.User{
+		Username:        username,
+		Email:           email,
+		FirstName:       firstName,
+		LastName:        strings.TrimSpace(lastName),
+		IsEmailVerified: true,
+	}
+	userID, err := identities.CreateUser(user, newIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+	created, err := users.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	return &OIDCResult{User: created, Created: true}, nil
+}
+
+var usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// freeUsername derives an unused username from the provider's preferred username or the email
+func freeUsername(preferred, email string) (string, error) {
+	base := usernameDisallowed.ReplaceAllString(preferred, "")
+	if base == "" {
+		local, _, _ := strings.Cut(email, "@")
+		base = usernameDisallowed.ReplaceAllString(local, "")
+	}
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	users := store.Get().Users
+	for i := 1; i < 1000; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+		taken, err := users.UsernameExists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
+
+// ListIdentities returns the provider accounts linked to a user
+func ListIdentities(userID int64) ([]models.UserIdentity, error) {
+	return store.Get().Identities.ListByUser(userID)
+}
+
+// UnlinkIdentity removes the user's link to a provider. A user without a password keeps at least one.
+func UnlinkIdentity(userID int64, providerName string) (bool, error) {
+	user, err := store.Get().Users.GetByID(userID)
+	if err != nil {
+		return false, err
+	}
+	withPassword, err := store.Get().Users.GetByUsername(user.Username)
+	if err != nil {
+		return false, err
+	}
+	if withPassword.PasswordHash == "" {
+		identities, err := store.Get().Identities.ListByUser(userID)
+		if err != nil {
+			return false, err
+		}
+		if len(identities) <= 1 {
+			return false, ErrOIDCLastLogin
+		}
+	}
+	return store.Get().Identities.Unlink(userID, providerName)
+}
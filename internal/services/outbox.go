@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"matcha/internal/store"
+)
+
+// Outbox: side effects of a write (notifications, fame, bot logs) are stored as rows in the same
+// transaction as the write itself, then executed by a dispatcher that retries them with backoff. An
+// event that fails outboxMaxAttempts times is dead and left for inspection; processed events are
+// deleted after outboxProcessedRetention.
+
+const (
+	outboxPollInterval = 5 * time.Second
+	outboxBatchSize    = 50
+	outboxMaxBackoff   = time.Hour
+	outboxMaxAttempts  = 20
+	// Processed events are deleted after this long
+	outboxProcessedRetention = 7 * 24 * time.Hour
+	// New events are run inline right after commit; the background dispatcher only picks them up
+	// after this delay (i.e. if the process died or the inline attempt failed)
+	outboxClaimDelay = 30 * time.Second
+)
+
+// OutboxEvent is one pending side effect
+type OutboxEvent = store.OutboxEvent
+
+// OutboxHandler performs the side effect of one event type. It may be called more than once for the
+// same event (at-least-once); handlers that write should call ClaimOutboxEvent in their transaction.
+type OutboxHandler func(event OutboxEvent) error
+
+var (
+	outboxHandlersMu sync.RWMutex
+	outboxHandlers   = make(map[string]OutboxHandler)
+)
+
+// RegisterOutboxHandler sets the handler for an event type
+func RegisterOutboxHandler(eventType string, handler OutboxHandler) {
+	outboxHandlersMu.Lock()
+	defer outboxHandlersMu.Unlock()
+	outboxHandlers[eventType] = handler
+}
+
+// AddOutboxEvent stores an event inside the caller's transaction and returns its id
+func AddOutboxEvent(tx *sql.Tx, eventType string, payload interface{}) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	return store.Get().Outbox.AddTx(tx, eventType, data, time.Now().UTC().Add(outboxClaimDelay))
+}
+
+// ClaimOutboxEvent marks the event processed inside tx. It returns false if it was already processed,
+// in which case the handler must not repeat its write; committing tx makes the side effect exactly-once.
+func ClaimOutboxEvent(tx *sql.Tx, eventID int64) (bool, error) {
+	return store.Get().Outbox.ClaimTx(tx, eventID)
+}
+
+// ProcessOutboxEvents runs the given (just committed) events now, in order.
+// Failures are recorded and left to the background dispatcher.
+func ProcessOutboxEvents(eventIDs ...int64) {
+	for _, id := range eventIDs {
+		event, err := store.Get().Outbox.GetPending(id)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		} else if err != nil {
+			log.Printf("Error loading outbox event %d: %v", id, err)
+			continue
+		}
+		processOutboxEvent(*event)
+	}
+}
+
+// processOutboxEvent runs the handler and records success or schedules a retry
+func processOutboxEvent(event OutboxEvent) {
+	outboxHandlersMu.RLock()
+	handler, ok := outboxHandlers[event.Type]
+	outboxHandlersMu.RUnlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for outbox event type %q", event.Type)
+	} else {
+		err = handler(event)
+	}
+
+	if err == nil {
+		// No-op if the handler already claimed the event in its own transaction
+		if err := store.Get().Outbox.MarkProcessed(event.ID); err != nil {
+			log.Printf("Error marking outbox event %d processed: %v", event.ID, err)
+		}
+		return
+	}
+
+	attempts := event.Attempts + 1
+	nextAttempt := time.Now().UTC().Add(outboxBackoff(attempts))
+	dead := attempts >= outboxMaxAttempts
+	if dead {
+		log.Printf("Outbox event %d (%s) failed %d times, giving up: %v", event.ID, event.Type, attempts, err)
+	} else {
+		log.Printf("Outbox event %d (%s) failed, attempt %d, retrying at %s: %v",
+			event.ID, event.Type, attempts, nextAttempt.Format(time.RFC3339), err)
+	}
+	if err := store.Get().Outbox.MarkFailed(event.ID, attempts, err.Error(), nextAttempt, dead); err != nil {
+		log.Printf("Error recording failure of outbox event %d: %v", event.ID, err)
+	}
+}
+
+// outboxBackoff doubles from 5s per attempt, capped at outboxMaxBackoff
+func outboxBackoff(attempts int) time.Duration {
+	backoff := 5 * time.Second
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
+
+// StartOutboxDispatcher retries due events in the background until ctx is cancelled
+func StartOutboxDispatcher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+		var lastCleanup time.Time
+		for {
+			dispatchDueOutboxEvents()
+			if time.Since(lastCleanup) > time.Hour {
+				deleteProcessedOutboxEvents(time.Now().UTC().Add(-outboxProcessedRetention))
+				lastCleanup = time.Now()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// dispatchDueOutboxEvents processes one batch of events whose next attempt is due
+func dispatchDueOutboxEvents() {
+	events, err := store.Get().Outbox.Due(time.Now().UTC(), outboxBatchSize)
+	if err != nil {
+		log.Printf("Error loading due outbox events: %v", err)
+		return
+	}
+	for _, event := range events {
+		processOutboxEvent(event)
+	}
+}
+
+// deleteProcessedOutboxEvents removes events processed before the given time
+func deleteProcessedOutboxEvents(before time.Time) {
+	n, err := store.Get().Outbox.DeleteProcessed(before)
+	if err != nil {
+		log.Printf("Error deleting processed outbox events: %v", err)
+	} else if n > 0 {
+		log.Printf("Deleted %d processed outbox event(s)", n)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiting: token buckets keyed by what is being limited (an IP address, an account). Each bucket
+// holds up to Burst tokens and regains one every Every; a request takes one token or is refused. The
+// buckets live in a RateLimitStore, in memory by default; several server instances would need a shared
+// store to enforce one limit between them.
+
+// RateLimit is a token bucket rule
+type RateLimit struct {
+	Name  string // Prefix of the bucket keys, so rules don't share buckets
+	Burst int
+	Every time.Duration
+}
+
+// Limits of the auth endpoints
+var (
+	LimitLoginPerIP            = RateLimit{Name: "login-ip", Burst: 20, Every: 6 * time.Second}
+	LimitLoginPerAccount       = RateLimit{Name: "login-account", Burst: 10, Every: time.Minute}
+	LimitResetSendPerIP        = RateLimit{Name: "reset-send-ip", Burst: 5, Every: 5 * time.Minute}
+	LimitResetSendPerAccount   = RateLimit{Name: "reset-send-account", Burst: 3, Every: 10 * time.Minute}
+	LimitResetVerifyPerIP      = RateLimit{Name: "reset-verify-ip", Burst: 10, Every: time.Minute}
+	LimitResetVerifyPerAccount = RateLimit{Name: "reset-verify-account", Burst: 10, Every: 2 * time.Minute}
+	LimitResendVerification    = RateLimit{Name: "resend-verification-ip", Burst: 5, Every: 5 * time.Minute}
+	LimitMagicLinkPerIP        = RateLimit{Name: "magic-link-ip", Burst: 5, Every: 5 * time.Minute}
+	LimitMagicLinkPerAccount   = RateLimit{Name: "magic-link-account", Burst: 3, Every: 10 * time.Minute}
+	LimitTwoFactorPerUser      = RateLimit{Name: "2fa-user", Burst: 5, Every: 30 * time.Second}
+	LimitEmailChangePerUser    = RateLimit{Name: "email-change-user", Burst: 3, Every: 10 * time.Minute}
+)
+
+// RateLimitStore keeps the buckets
+type RateLimitStore interface {
+	// Take removes one token from the bucket key of rule; if it's empty, returns false and how long until
+	// it has a token again
+	Take(key string, rule RateLimit, now time.Time) (bool, time.Duration)
+}
+
+// MemoryRateLimitStore keeps buckets in process memory
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // When it will be full again; it can be dropped after that
+}
+
+// NewMemoryRateLimitStore returns an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+// Take implements RateLimitStore
+func (s *MemoryRateLimitStore) Take(key string, rule RateLimit, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Full buckets are the same as no bucket, so drop them now and then to bound memory
+	if now.Sub(s.lastPrune) > time.Minute {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	b := s.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(rule.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+float64(now.Sub(b.updated))/float64(rule.Every))
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(rule.Every))
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(rule.Burst) - b.tokens) * float64(rule.Every)))
+	return true, 0
+}
+
+var (
+	rateLimitMu    sync.RWMutex
+	rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+)
+
+// SetRateLimitStore replaces the store used by AllowRequest (e.g. with a shared one)
+func SetRateLimitStore(s RateLimitStore) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	rateLimitStore = s
+}
+
+// AllowRequest takes a token from the bucket of rule for key (case-insensitive). If the bucket is
+// empty it returns false and how long the caller should wait.
+func AllowRequest(rule RateLimit, key string) (bool, time.Duration) {
+	rateLimitMu.RLock()
+	store := rateLimitStore
+	rateLimitMu.RUnlock()
+	return store.Take(rule.Name+":"+strings.ToLower(key), rule, time.Now())
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreBurstAndRefill(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	rule := RateLimit{Name: "test", Burst: 3, Every: 10 * time.Second}
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < rule.Burst; i++ {
+		if ok, _ := s.Take("key", rule, now); !ok {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+	ok, wait := s.Take("key", rule, now)
+	if ok {
+		t.Fatal("request after the burst was allowed")
+	}
+	if wait != rule.Every {
+		t.Errorf("wait = %v, want %v", wait, rule.Every)
+	}
+
+	// Other keys have their own bucket
+	if ok, _ := s.Take("other", rule, now); !ok {
+		t.Error("a different key was refused")
+	}
+
+	// Partly refilled: still empty, and the wait shrinks
+	ok, wait = s.Take("key", rule, now.Add(4*time.Second))
+	if ok {
+		t.Error("request before a token was back was allowed")
+	}
+	if wait != 6*time.Second {
+		t.Errorf("wait = %v, want %v", wait, 6*time.Second)
+	}
+
+	// One token back, then empty again
+	now = now.Add(rule.Every)
+	if ok, _ := s.Take("key", rule, now); !ok {
+		t.Error("request after one refill period was refused")
+	}
+	if ok, _ := s.Take("key", rule, now); ok {
+		t.Error("second request after one refill period was allowed")
+	}
+
+	// A long pause refills up to Burst, no more
+	now = now.Add(100 * rule.Every)
+	for i := 0; i < rule.Burst; i++ {
+		if ok, _ := s.Take("key", rule, now); !ok {
+			t.Fatalf("request %d after a long pause was refused", i+1)
+		}
+	}
+	if ok, _ := s.Take("key", rule, now); ok {
+		t.Error("bucket refilled past its burst")
+	}
+}
+
+func TestMemoryRateLimitStorePrunesFullBuckets(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	rule := RateLimit{Name: "test", Burst: 2, Every: time.Second}
+	now := time.Unix(1700000000, 0)
+
+	s.Take("a", rule, now)
+	s.Take("b", rule, now)
+	if len(s.buckets) != 2 {
+		t.Fatalf("%d buckets, want 2", len(s.buckets))
+	}
+
+	// Both are full again long before the next prune, which keeps only the bucket being taken from
+	s.Take("a", rule, now.Add(2*time.Minute))
+	if len(s.buckets) != 1 || s.buckets["a"] == nil {
+		t.Errorf("buckets after pruning = %v, want only a", s.buckets)
+	}
+}
+
+func TestLoginLockDuration(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{loginLockThreshold - 1, 0},
+		{loginLockThreshold, loginLockBase},
+		{loginLockThreshold + 1, 2 * loginLockBase},
+		{loginLockThreshold + 2, 4 * loginLockBase},
+		{loginLockThreshold + 5, 32 * loginLockBase},
+		{loginLockThreshold + 6, loginLockMax},
+		{loginLockThreshold + 100, loginLockMax},
+	}
+	for _, tt := range tests {
+		if got := loginLockDuration(tt.failures); got != tt.want {
+			t.Errorf("loginLockDuration(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
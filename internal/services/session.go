@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"matcha/internal/config"
+	"matcha/internal/models"
+	"matcha/internal/store"
+)
+
+// Sessions: logging in starts a session and returns a short-lived access token (JWT) plus a refresh
+// token. The refresh token is exchanged for a new pair before the access token expires; each exchange
+// rotates it, and presenting a refresh token that was already rotated means it leaked, so the whole
+// session is revoked. The exception is a token rotated less than refreshGracePeriod ago: two tabs
+// refreshing at once send the same token, and the later one gets the current pair too. That's possible
+// without storing tokens because each is derived from the previous one with the session's refresh key.
+
+// Why a session was revoked (sessions.revoke_reason)
+const (
+	SessionRevokedLogout         = "logout"
+	SessionRevokedLogoutAll      = "logout_all"
+	SessionRevokedReuse          = "reuse"
+	SessionRevokedPasswordChange = "password_change"
+	SessionRevokedEmailRevert    = "email_change_reverted"
+)
+
+const (
+	sessionCleanupInterval = time.Hour
+	// Ended sessions are kept this long (reuse of their tokens is still detected meanwhile)
+	sessionRetention = 7 * 24 * time.Hour
+	// A rotated refresh token still gets the current pair for this long
+	refreshGracePeriod = 10 * time.Second
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for an unknown refresh token or one whose session has ended
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when a rotated refresh token is presented again; its session is revoked
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+// TokenPair is what a client receives when logging in or refreshing
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
+	SessionID    int64  `json:"-"`
+}
+
+// generateRefreshToken returns a new random refresh token and the hash stored for it
+func generateRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+// nextRefreshToken returns the refresh token that replaces token in the session: derived from it with
+// the session's key, or random for sessions without one
+func nextRefreshToken(session *models.Session, token string) (string, string, error) {
+	if session.RefreshKey == "" {
+		return generateRefreshToken()
+	}
+	key, err := hex.DecodeString(session.RefreshKey)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid refresh key of session %d", session.ID)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	next := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return next, hashRefreshToken(next), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSession starts a session for a user who just logged in and returns its first token pair
+func StartSession(cfg *config.Config, userID int64, userAgent, ipAddress string) (*TokenPair, error) {
+	refreshToken, hash, err := generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate refresh key: %v", err)
+	}
+	sessionID, err := store.Get().Sessions.Create(&models.Session{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		ExpiresAt:  time.Now().UTC().Add(cfg.RefreshTokenTTL),
+		RefreshKey: hex.EncodeToString(key),
+	}, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+	return issueTokenPair(cfg, userID, sessionID, refreshToken)
+}
+
+// RefreshSession exchanges a refresh token for a new token pair
+func RefreshSession(cfg *config.Config, refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrRefreshTokenInvalid
+	}
+	oldHash := hashRefreshToken(refreshToken)
+	sessions := store.Get().Sessions
+	session, rotatedAt, err := sessions.GetByRefreshToken(oldHash)
+	if err == store.ErrNotFound {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !session.Active() {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if rotatedAt != nil {
+		return refreshRotated(cfg, session, refreshToken, *rotatedAt)
+	}
+
+	newToken, newHash, err := nextRefreshToken(session, refreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	ok, err := sessions.Rotate(session.ID, oldHash, newHash, time.Now().UTC().Add(cfg.RefreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// Another request rotated the same token in the meantime
+		return refreshRotated(cfg, session, refreshToken, time.Now())
+	}
+	return issueTokenPair(cfg, session.UserID, session.ID, newToken)
+}
+
+// refreshRotated answers a refresh with a token that was already rotated: with the current pair if it
+// was rotated within refreshGracePeriod and its successor is still current, otherwise by revoking the
+// session
+func refreshRotated(cfg *config.Config, session *models.Session, refreshToken string, rotatedAt time.Time) (*TokenPair, error) {
+	if session.RefreshKey == "" || time.Since(rotatedAt) > refreshGracePeriod {
+		return nil, revokeReusedSession(session)
+	}
+	current, currentHash, err := nextRefreshToken(session, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	_, currentRotatedAt, err := store.Get().Sessions.GetByRefreshToken(currentHash)
+	if err == store.ErrNotFound || (err == nil && currentRotatedAt != nil) {
+		// Rotated more than once since: not a concurrent refresh
+		return nil, revokeReusedSession(session)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return issueTokenPair(cfg, session.UserID, session.ID, current)
+}
+
+func revokeReusedSession(session *models.Session) error {
+	log.Printf("Refresh token reuse detected for session %d of user %d; revoking it", session.ID, session.UserID)
+	if _, err := store.Get().Sessions.Revoke(session.ID, SessionRevokedReuse); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func issueTokenPair(cfg *config.Config, userID, sessionID int64, refreshToken string) (*TokenPair, error) {
+	accessToken, err := GenerateToken(userID, sessionID, cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(cfg.AccessTokenTTL.Seconds()),
+		SessionID:    sessionID,
+	}, nil
+}
+
+// SessionForRefreshToken returns the session a refresh token belongs to (logout without an access token)
+func SessionForRefreshToken(refreshToken string) (*models.Session, error) {
+	session, _, err := store.Get().Sessions.GetByRefreshToken(hashRefreshToken(refreshToken))
+	return session, err
+}
+
+// EndSession revokes one session (logout)
+func EndSession(sessionID int64) error {
+	_, err := store.Get().Sessions.Revoke(sessionID, SessionRevokedLogout)
+	return err
+}
+
+// EndAllSessions revokes all of the user's sessions except exceptSessionID (0 = none) and returns how many
+func EndAllSessions(userID, exceptSessionID int64, reason string) (int64, error) {
+	return store.Get().Sessions.RevokeAll(userID, exceptSessionID, reason)
+}
+
+// StartSessionCleanup periodically deletes sessions and sign-in links that ended more than
+// sessionRetention ago, abandoned OIDC logins, and email changes that can no longer be reverted
+func StartSessionCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(sessionCleanupInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := store.Get().Sessions.DeleteExpired(time.Now().UTC().Add(-sessionRetention)); err != nil {
+				log.Printf("Error deleting old sessions: %v", err)
+			} else if n > 0 {
+				log.Printf("Deleted %d old session(s)", n)
+			}
+			if n, err := store.Get().LoginLinks.DeleteExpired(time.Now().UTC().Add(-sessionRetention)); err != nil {
+				log.Printf("Error deleting old sign-in links: %v", err)
+			} else if n > 0 {
+				log.Printf("Deleted %d old sign-in link(s)", n)
+			}
+			if _, err := store.Get().Identities.DeleteExpiredLoginStates(time.Now().UTC()); err != nil {
+				log.Printf("Error deleting expired OIDC login states: %v", err)
+			}
+			if _, err := store.Get().EmailChanges.DeleteExpired(time.Now().UTC()); err != nil {
+				log.Printf("Error deleting old email changes: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"matcha/internal/store"
+)
+
+// TOTP (RFC 6238) with the parameters every authenticator app supports: HMAC-SHA1, 6 digits, 30
+// second steps. A code is accepted one step early or late to allow for clock drift, and each step
+// only once.
+const (
+	totpIssuer        = "Matcha"
+	totpDigits        = 6
+	totpPeriod        = 30 // seconds
+	totpSkew          = 1  // steps
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTOTPNotStarted     = errors.New("start two-factor setup first")
+	ErrInvalidTOTPCode    = errors.New("invalid authentication code")
+	ErrPasswordIncorrect  = errors.New("password is incorrect")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is what the user needs to add the account to an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`      // For typing in by hand
+	URI    string `json:"otpauth_uri"` // For a QR code
+}
+
+// TOTPStatus is a user's two-factor setup
+type TOTPStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode computes the code of a time step (RFC 4226 HOTP with the step as counter)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000)
+}
+
+// matchTOTP returns the step whose code is code, if it is within totpSkew steps of now
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI is the otpauth:// URI authenticator apps import (usually from a QR code)
+func totpURI(account, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// generateRecoveryCodes returns new recovery codes (xxxxx-xxxxx) and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a code as typed, ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashRefreshToken(code)
+}
+
+// GetTOTPStatus returns whether the user has two-factor authentication on
+func GetTOTPStatus(userID int64) (*TOTPStatus, error) {
+	totp, err := store.Get().TwoFactor.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	status := &TOTPStatus{Enabled: totp.Enabled}
+	if totp.Enabled {
+		if status.RecoveryCodesLeft, err = store.Get().TwoFactor.CountRecoveryCodes(userID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// BeginTOTPEnrollment generates a new secret for the user. It takes effect once ConfirmTOTPEnrollment
+// is called with a code from it.
+func BeginTOTPEnrollment(userID int64) (*TOTPEnrollment, error) {
+	user, err := store.Get().Users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(b)
+	if err := store.Get().TwoFactor.Begin(userID, secret); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{Secret: secret, URI: totpURI(user.Username, secret)}, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication if code matches the secret from
+// BeginTOTPEnrollment, and returns the recovery codes. They are only stored hashed, so this is the
+// only time they can be shown.
+func ConfirmTOTPEnrollment(userID int64, code string) ([]string, error) {
+	totp, err := store.Get().TwoFactor.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if totp.Secret == "" {
+		return nil, ErrTOTPNotStarted
+	}
+	step, ok := matchTOTP(totp.Secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Get().TwoFactor.Enable(userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off; it takes the password and a current code (or a
+// recovery code), so a stolen session alone can't
+func DisableTOTP(userID int64, password, code string) error {
+	if err := checkPasswordAndSecondFactor(userID, password, code); err != nil {
+		return err
+	}
+	return store.Get().TwoFactor.Disable(userID)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, e.g. when they are running out
+func RegenerateRecoveryCodes(userID int64, password, code string) ([]string, error) {
+	if err := checkPasswordAndSecondFactor(userID, password, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Get().TwoFactor.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func checkPasswordAndSecondFactor(userID int64, password, code string) error {
+	user, err := store.Get().Users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	full, err := store.Get().Users.GetByUsername(user.Username)
+	if err != nil {
+		return err
+	}
+	if !CheckPassword(password, full.PasswordHash) {
+		return ErrPasswordIncorrect
+	}
+	return verifySecondFactor(userID, code)
+}
+
+// verifySecondFactor accepts a current TOTP code that wasn't used before, or an unused recovery code
+// (which is then used up)
+func verifySecondFactor(userID int64, code string) error {
+	code = strings.TrimSpace(code)
+	twoFactor := store.Get().TwoFactor
+	if len(code) == totpDigits {
+		totp, err := twoFactor.Get(userID)
+		if err != nil {
+			return err
+		}
+		step, ok := matchTOTP(totp.Secret, code, time.Now())
+		if !ok {
+			return ErrInvalidTOTPCode
+		}
+		fresh, err := twoFactor.AcceptStep(userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+	used, err := twoFactor.UseRecoveryCode(userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"matcha/internal/store"
+)
+
+// The SHA1 test key of RFC 6238 appendix B
+var rfc6238Key = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tt := range tests {
+		step := totpStep(time.Unix(tt.unix, 0))
+		if got := totpCode(rfc6238Key, step); got != tt.code {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Key)
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"one step early", -1, true},
+		{"one step late", 1, true},
+		{"two steps early", -2, false},
+		{"two steps late", 2, false},
+	}
+	for _, tt := range tests {
+		code := totpCode(rfc6238Key, current+tt.offset)
+		step, ok := matchTOTP(secret, code, now)
+		if ok != tt.ok {
+			t.Errorf("%s: matched = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("%s: step = %d, want %d", tt.name, step, current+tt.offset)
+		}
+	}
+
+	if _, ok := matchTOTP(secret, "05047", now); ok {
+		t.Error("a 5-digit code matched")
+	}
+	if _, ok := matchTOTP("not base32!", totpCode(rfc6238Key, current), now); ok {
+		t.Error("a code matched an invalid secret")
+	}
+}
+
+func TestVerifySecondFactorUsesEachStepOnce(t *testing.T) {
+	userID := createTestUser(t)
+	secret := totpEncoding.EncodeToString(rfc6238Key)
+	twoFactor := store.Get().TwoFactor
+	if err := twoFactor.Begin(userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	current := totpStep(time.Now())
+	if err := twoFactor.Enable(userID, current-totpSkew-1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifySecondFactor(userID, totpCode(rfc6238Key, current)); err != nil {
+		t.Fatalf("first use of the current code: %v", err)
+	}
+	if err := verifySecondFactor(userID, totpCode(rfc6238Key, current)); err != ErrInvalidTOTPCode {
+		t.Errorf("second use of the current code: err = %v, want %v", err, ErrInvalidTOTPCode)
+	}
+	// Still within the skew, but older than a step already used
+	if err := verifySecondFactor(userID, totpCode(rfc6238Key, current-1)); err != ErrInvalidTOTPCode {
+		t.Errorf("code of an earlier step: err = %v, want %v", err, ErrInvalidTOTPCode)
+	}
+	if err := verifySecondFactor(userID, totpCode(rfc6238Key, current+1)); err != nil {
+		t.Errorf("code of the next step: %v", err)
+	}
+}
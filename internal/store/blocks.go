@@ -0,0 +1,61 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type blockStore struct {
+	b backend
+}
+
+func (s *blockStore) Create(blockerID, blockedID int64) (bool, error) {
+	created := false
+	err := s.b.tx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			INSERT INTO blocks (blocker_id, blocked_id, created_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT DO NOTHING
+		`, blockerID, blockedID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		created = true
+		_, err = tx.Exec(`
+			DELETE FROM likes
+			WHERE (from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?)
+		`, blockerID, blockedID, blockedID, blockerID)
+		return err
+	})
+	return created, err
+}
+
+func (s *blockStore) Delete(blockerID, blockedID int64) (bool, error) {
+	n, err := s.b.exec(`DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?`, blockerID, blockedID)
+	return n > 0, err
+}
+
+func (s *blockStore) Report(reporterID, reportedID int64, reason string) error {
+	_, err := s.b.exec(`
+		INSERT INTO reports (reporter_id, reported_id, reason, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`, reporterID, reportedID, reason)
+	return err
+}
+
+func (s *blockStore) BlockedAt(blockerID, blockedID int64) (*time.Time, error) {
+	var createdAt sql.NullTime
+	err := s.b.db().QueryRow(
+		`SELECT created_at FROM blocks WHERE blocker_id = ? AND blocked_id = ?`, blockerID, blockedID,
+	).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &createdAt.Time, nil
+}
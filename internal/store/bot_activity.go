@@ -0,0 +1,71 @@
+package store
+
+import "database/sql"
+
+type botActivityStore struct {
+	b backend
+}
+
+func (s *botActivityStore) CreateTx(tx *sql.Tx, a *BotActivity) error {
+	_, err := tx.Exec(`
+		INSERT INTO bot_activity_log (bot_id, bot_username, action_type, target_user_id, target_username, details)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, a.BotID, a.BotUsername, a.ActionType, a.TargetUserID, a.TargetUsername, a.Details)
+	return err
+}
+
+func (s *botActivityStore) List(limit, offset int) ([]BotActivity, error) {
+	rows, err := s.b.db().Query(`
+		SELECT
+			bal.id,
+			bal.bot_id,
+			bal.bot_username,
+			COALESCE(bot_user.first_name || ' ' || bot_user.last_name, ''),
+			bal.action_type,
+			bal.target_user_id,
+			bal.target_username,
+			COALESCE(target_user.first_name || ' ' || target_user.last_name, ''),
+			bal.details,
+			bal.created_at
+		FROM bot_activity_log bal
+		LEFT JOIN users bot_user ON bal.bot_id = bot_user.id
+		LEFT JOIN users target_user ON bal.target_user_id = target_user.id
+		ORDER BY bal.created_at DESC, bal.id DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := []BotActivity{}
+	for rows.Next() {
+		var a BotActivity
+		var targetUserID sql.NullInt64
+		var targetUsername, details sql.NullString
+		var createdAt sql.NullTime
+		err := rows.Scan(&a.ID, &a.BotID, &a.BotUsername, &a.BotDisplayName, &a.ActionType,
+			&targetUserID, &targetUsername, &a.TargetDisplayName, &details, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		if targetUserID.Valid {
+			a.TargetUserID = &targetUserID.Int64
+		}
+		if targetUsername.Valid {
+			a.TargetUsername = &targetUsername.String
+		}
+		if details.Valid {
+			a.Details = &details.String
+		}
+		a.CreatedAt = createdAt.Time
+		activities = append(activities, a)
+	}
+	return activities, rows.Err()
+}
+
+func (s *botActivityStore) Count() (int, error) {
+	var count int
+	err := s.b.db().QueryRow(`SELECT COUNT(*) FROM bot_activity_log`).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,97 @@
+package store
+
+import "database/sql"
+
+type conversationStore struct {
+	b backend
+}
+
+func (s *conversationStore) List(userID int64, archived bool) ([]Conversation, error) {
+	rows, err := s.b.db().Query(`
+		SELECT DISTINCT `+userColumns+`,
+			(SELECT CASE
+				WHEN deleted_at IS NOT NULL THEN 'Message deleted'
+				WHEN content = '' AND EXISTS (SELECT 1 FROM message_attachments WHERE message_id = messages.id) THEN 'Sent a photo'
+				ELSE content END
+			 FROM messages
+			 WHERE (from_user_id = ? AND to_user_id = u.id) OR (from_user_id = u.id AND to_user_id = ?)
+			 ORDER BY created_at DESC LIMIT 1) as last_message,
+			(SELECT created_at FROM messages
+			 WHERE (from_user_id = ? AND to_user_id = u.id) OR (from_user_id = u.id AND to_user_id = ?)
+			 ORDER BY created_at DESC LIMIT 1) as last_message_time,
+			(SELECT COUNT(*) FROM messages
+			 WHERE from_user_id = u.id AND to_user_id = ? AND is_read = 0) as unread_count,
+			cs.muted_until,
+			COALESCE(cs.is_archived, FALSE) as is_archived,
+			COALESCE(cs.is_pinned, FALSE) as is_pinned
+		FROM users u
+		INNER JOIN likes l1 ON l1.from_user_id = ? AND l1.to_user_id = u.id
+		INNER JOIN likes l2 ON l2.from_user_id = u.id AND l2.to_user_id = ?
+		LEFT JOIN conversation_settings cs ON cs.user_id = ? AND cs.other_user_id = u.id
+		WHERE u.is_setup = 1 AND u.is_email_verified = 1
+			AND COALESCE(cs.is_archived, FALSE) = ?
+		ORDER BY is_pinned DESC, last_message_time DESC NULLS LAST
+	`, userID, userID, userID, userID, userID, userID, userID, userID, archived)
+	if err != nil {
+		return nil, err
+	}
+
+	type extra struct {
+		conversation    Conversation
+		lastMessage     sql.NullString
+		lastMessageTime sql.NullString // Only selected to order by
+		mutedUntil      sql.NullTime
+	}
+	var extras []*extra
+	users, err := scanUsers(rows, func() []interface{} {
+		e := &extra{}
+		extras = append(extras, e)
+		return []interface{}{&e.lastMessage, &e.lastMessageTime, &e.conversation.UnreadCount,
+			&e.mutedUntil, &e.conversation.Settings.IsArchived, &e.conversation.Settings.IsPinned}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]Conversation, len(users))
+	for i, u := range users {
+		c := extras[i].conversation
+		c.User = u
+		c.LastMessage = extras[i].lastMessage.String
+		c.Settings.MutedUntil = timePtr(extras[i].mutedUntil)
+		conversations[i] = c
+	}
+	return conversations, nil
+}
+
+func (s *conversationStore) GetSettings(userID, otherUserID int64) (ConversationSettings, error) {
+	var settings ConversationSettings
+	var mutedUntil sql.NullTime
+	err := s.b.db().QueryRow(`
+		SELECT muted_until, is_archived, is_pinned
+		FROM conversation_settings
+		WHERE user_id = ? AND other_user_id = ?
+	`, userID, otherUserID).Scan(&mutedUntil, &settings.IsArchived, &settings.IsPinned)
+	if err == sql.ErrNoRows {
+		return ConversationSettings{}, nil
+	}
+	settings.MutedUntil = timePtr(mutedUntil)
+	return settings, err
+}
+
+func (s *conversationStore) SaveSettings(userID, otherUserID int64, settings ConversationSettings) error {
+	var mutedUntil interface{}
+	if settings.MutedUntil != nil {
+		mutedUntil = settings.MutedUntil.UTC()
+	}
+	_, err := s.b.exec(`
+		INSERT INTO conversation_settings (user_id, other_user_id, muted_until, is_archived, is_pinned, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, other_user_id) DO UPDATE SET
+			muted_until = excluded.muted_until,
+			is_archived = excluded.is_archived,
+			is_pinned = excluded.is_pinned,
+			updated_at = CURRENT_TIMESTAMP
+	`, userID, otherUserID, mutedUntil, settings.IsArchived, settings.IsPinned)
+	return err
+}
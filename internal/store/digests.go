@@ -0,0 +1,115 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"matcha/internal/models"
+)
+
+type digestStore struct {
+	b backend
+}
+
+func (s *digestStore) Due(dailyBefore, weeklyBefore, firstSince, seenBefore time.Time, limit int) ([]models.DigestRecipient, error) {
+	// is_online isn't used: it stays set for users who close the app without logging out
+	rows, err := s.b.db().Query(`
+		SELECT u.id, u.email, u.first_name, u.locale, u.digest_frequency, COALESCE(u.digest_token, ''), u.digest_sent_at
+		FROM users u
+		WHERE u.is_email_verified = 1 AND COALESCE(u.is_bot, 0) = 0
+		  AND (u.last_seen IS NULL OR u.last_seen < ?)
+		  AND ((u.digest_frequency = ? AND (u.digest_sent_at IS NULL OR u.digest_sent_at <= ?))
+		    OR (u.digest_frequency = ? AND (u.digest_sent_at IS NULL OR u.digest_sent_at <= ?)))
+		  AND EXISTS (
+		    SELECT 1 FROM notifications n
+		    WHERE n.user_id = u.id AND COALESCE(n.is_read, 0) = 0
+		      AND n.created_at > COALESCE(u.digest_sent_at, ?)
+		  )
+		ORDER BY u.id
+		LIMIT ?
+	`, seenBefore.UTC(), models.DigestDaily, dailyBefore.UTC(), models.DigestWeekly, weeklyBefore.UTC(), firstSince.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []models.DigestRecipient{}
+	for rows.Next() {
+		var r models.DigestRecipient
+		var sentAt sql.NullTime
+		if err := rows.Scan(&r.UserID, &r.Email, &r.FirstName, &r.Locale, &r.Frequency, &r.Token, &sentAt); err != nil {
+			return nil, err
+		}
+		r.LastDigestAt = timePtr(sentAt)
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+func (s *digestStore) UnreadNotifications(userID int64, since time.Time, limit int) (int, []models.Notification, error) {
+	var total int
+	err := s.b.db().QueryRow(`
+		SELECT COUNT(*) FROM notifications
+		WHERE user_id = ? AND COALESCE(is_read, 0) = 0 AND created_at > ?
+	`, userID, since.UTC()).Scan(&total)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := s.b.db().Query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		WHERE user_id = ? AND COALESCE(is_read, 0) = 0 AND created_at > ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, userID, since.UTC(), limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	notifications := []models.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return 0, nil, err
+		}
+		notifications = append(notifications, *n)
+	}
+	return total, notifications, rows.Err()
+}
+
+func (s *digestStore) UnreadMessages(userID int64) (messages, senders int, err error) {
+	err = s.b.db().QueryRow(`
+		SELECT COUNT(*), COUNT(DISTINCT from_user_id) FROM messages
+		WHERE to_user_id = ? AND COALESCE(is_read, 0) = 0 AND deleted_at IS NULL
+	`, userID).Scan(&messages, &senders)
+	return messages, senders, err
+}
+
+func (s *digestStore) EnsureToken(userID int64, token string) (string, error) {
+	var current string
+	err := s.b.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE users SET digest_token = ? WHERE id = ? AND digest_token IS NULL`, token, userID); err != nil {
+			return err
+		}
+		err := tx.QueryRow(`SELECT COALESCE(digest_token, '') FROM users WHERE id = ?`, userID).Scan(&current)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	})
+	return current, err
+}
+
+func (s *digestStore) MarkSent(userID int64, at time.Time) error {
+	_, err := s.b.exec(`UPDATE users SET digest_sent_at = ? WHERE id = ?`, at.UTC(), userID)
+	return err
+}
+
+func (s *digestStore) SetFrequencyByToken(token, frequency string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	n, err := s.b.exec(`UPDATE users SET digest_frequency = ? WHERE digest_token = ?`, frequency, token)
+	return n > 0, err
+}
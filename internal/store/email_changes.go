@@ -0,0 +1,168 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type emailChangeStore struct {
+	b backend
+}
+
+const emailChangeColumns = `id, user_id, old_email, new_email, expires_at, revert_expires_at, confirmed_at, reverted_at`
+
+func scanEmailChange(row scanner) (*EmailChange, error) {
+	var c EmailChange
+	var confirmedAt, revertedAt sql.NullTime
+	err := row.Scan(&c.ID, &c.UserID, &c.OldEmail, &c.NewEmail, &c.ExpiresAt, &c.RevertExpiresAt, &confirmedAt, &revertedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.ConfirmedAt = timePtr(confirmedAt)
+	c.RevertedAt = timePtr(revertedAt)
+	return &c, nil
+}
+
+// checkEmailFree returns ErrEmailInUse if a user other than userID has the email
+func checkEmailFree(tx *sql.Tx, email string, userID int64) error {
+	var one int
+	err := tx.QueryRow(`SELECT 1 FROM users WHERE email = ? AND id != ?`, email, userID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err == nil {
+		return ErrEmailInUse
+	}
+	return err
+}
+
+func (s *emailChangeStore) Create(change EmailChange, confirmTokenHash, revertTokenHash string) error {
+	return s.b.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM email_changes WHERE user_id = ? AND confirmed_at IS NULL`, change.UserID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO email_changes (user_id, old_email, new_email, confirm_token_hash, revert_token_hash, created_at, expires_at, revert_expires_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			change.UserID, change.OldEmail, change.NewEmail, confirmTokenHash, revertTokenHash,
+			time.Now().UTC(), change.ExpiresAt.UTC(), change.RevertExpiresAt.UTC(),
+		)
+		return err
+	})
+}
+
+func (s *emailChangeStore) GetPending(userID int64) (*EmailChange, error) {
+	return scanEmailChange(s.b.db().QueryRow(
+		`SELECT `+emailChangeColumns+` FROM email_changes
+		 WHERE user_id = ? AND confirmed_at IS NULL AND reverted_at IS NULL AND expires_at > ?
+		 ORDER BY id DESC LIMIT 1`,
+		userID, time.Now().UTC(),
+	))
+}
+
+func (s *emailChangeStore) Confirm(confirmTokenHash string) (*EmailChange, error) {
+	var change *EmailChange
+	err := s.b.tx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		var err error
+		change, err = scanEmailChange(tx.QueryRow(
+			`SELECT `+emailChangeColumns+` FROM email_changes
+			 WHERE confirm_token_hash = ? AND confirmed_at IS NULL AND reverted_at IS NULL AND expires_at > ?`,
+			confirmTokenHash, now,
+		))
+		if err != nil {
+			return err
+		}
+		if err := checkEmailFree(tx, change.NewEmail, change.UserID); err != nil {
+			return err
+		}
+		// Only from the address the change was requested for; a change confirmed meanwhile wins
+		res, err := tx.Exec(
+			`UPDATE users SET email = ?, is_email_verified = 1, email_verification_token = NULL, updated_at = ?
+			 WHERE id = ? AND email = ?`,
+			change.NewEmail, now, change.UserID, change.OldEmail,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		change.ConfirmedAt = &now
+		_, err = tx.Exec(`UPDATE email_changes SET confirmed_at = ? WHERE id = ?`, now, change.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func (s *emailChangeStore) Revert(revertTokenHash string) (*EmailChange, error) {
+	var change *EmailChange
+	err := s.b.tx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		var err error
+		change, err = scanEmailChange(tx.QueryRow(
+			`SELECT `+emailChangeColumns+` FROM email_changes
+			 WHERE revert_token_hash = ? AND reverted_at IS NULL AND revert_expires_at > ?`,
+			revertTokenHash, now,
+		))
+		if err != nil {
+			return err
+		}
+		if change.ConfirmedAt != nil {
+			if err := checkEmailFree(tx, change.OldEmail, change.UserID); err != nil {
+				return err
+			}
+			_, err = tx.Exec(
+				`UPDATE users SET email = ?, is_email_verified = 1, email_verification_token = NULL,
+				 password_reset_code = NULL, password_reset_token = NULL, password_reset_expires_at = NULL, updated_at = ?
+				 WHERE id = ?`,
+				change.OldEmail, now, change.UserID,
+			)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`DELETE FROM login_links WHERE user_id = ? AND used_at IS NULL`, change.UserID)
+			if err != nil {
+				return err
+			}
+		}
+		change.RevertedAt = &now
+		_, err = tx.Exec(`UPDATE email_changes SET reverted_at = ? WHERE id = ?`, now, change.ID)
+		if err != nil {
+			return err
+		}
+		// Also whatever else was requested from the account meanwhile
+		_, err = tx.Exec(
+			`UPDATE email_changes SET reverted_at = ? WHERE user_id = ? AND confirmed_at IS NULL AND reverted_at IS NULL`,
+			now, change.UserID,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func (s *emailChangeStore) Cancel(userID int64) (bool, error) {
+	n, err := s.b.exec(
+		`UPDATE email_changes SET reverted_at = ? WHERE user_id = ? AND confirmed_at IS NULL AND reverted_at IS NULL`,
+		time.Now().UTC(), userID,
+	)
+	return n > 0, err
+}
+
+func (s *emailChangeStore) DeleteExpired(before time.Time) (int64, error) {
+	return s.b.exec(`DELETE FROM email_changes WHERE revert_expires_at < ?`, before.UTC())
+}
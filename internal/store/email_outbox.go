@@ -0,0 +1,135 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"matcha/internal/database"
+	"matcha/internal/models"
+)
+
+type emailOutboxStore struct {
+	b backend
+}
+
+const queuedEmailColumns = `id, to_address, template, subject, message, status, attempts, last_error, next_attempt_at, created_at, sent_at`
+
+func scanQueuedEmails(rows *sql.Rows) ([]models.QueuedEmail, error) {
+	defer rows.Close()
+	emails := []models.QueuedEmail{}
+	for rows.Next() {
+		var e models.QueuedEmail
+		var lastError sql.NullString
+		var createdAt, sentAt sql.NullTime
+		err := rows.Scan(&e.ID, &e.To, &e.Template, &e.Subject, &e.Message, &e.Status, &e.Attempts,
+			&lastError, &e.NextAttemptAt, &createdAt, &sentAt)
+		if err != nil {
+			return nil, err
+		}
+		e.LastError = lastError.String
+		e.CreatedAt = createdAt.Time
+		e.SentAt = timePtr(sentAt)
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
+
+func (s *emailOutboxStore) Enqueue(email *models.QueuedEmail) (int64, error) {
+	var id int64
+	err := s.b.tx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		var err error
+		id, err = database.InsertReturningID(tx,
+			`INSERT INTO email_outbox (to_address, template, subject, message, status, next_attempt_at, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			email.To, email.Template, email.Subject, email.Message, models.EmailPending, now, now,
+		)
+		return err
+	})
+	return id, err
+}
+
+func (s *emailOutboxStore) Due(now time.Time, limit int) ([]models.QueuedEmail, error) {
+	rows, err := s.b.db().Query(
+		`SELECT `+queuedEmailColumns+` FROM email_outbox
+		 WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT ?`,
+		models.EmailPending, now.UTC(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanQueuedEmails(rows)
+}
+
+func (s *emailOutboxStore) Claim(id int64, until time.Time) (bool, error) {
+	n, err := s.b.exec(
+		`UPDATE email_outbox SET next_attempt_at = ? WHERE id = ? AND status = ? AND next_attempt_at <= ?`,
+		until.UTC(), id, models.EmailPending, time.Now().UTC(),
+	)
+	return n > 0, err
+}
+
+func (s *emailOutboxStore) MarkSent(id int64) error {
+	_, err := s.b.exec(
+		`UPDATE email_outbox SET status = ?, message = '', sent_at = ?, attempts = attempts + 1, last_error = NULL
+		 WHERE id = ?`,
+		models.EmailSent, time.Now().UTC(), id,
+	)
+	return err
+}
+
+func (s *emailOutboxStore) MarkFailed(id int64, attempts int, lastError string, nextAttemptAt time.Time, dead bool) error {
+	status := models.EmailPending
+	if dead {
+		status = models.EmailDead
+	}
+	_, err := s.b.exec(
+		`UPDATE email_outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+		status, attempts, lastError, nextAttemptAt.UTC(), id,
+	)
+	return err
+}
+
+func (s *emailOutboxStore) Requeue(id int64) (bool, error) {
+	n, err := s.b.exec(
+		`UPDATE email_outbox SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status = ?`,
+		models.EmailPending, time.Now().UTC(), id, models.EmailDead,
+	)
+	return n > 0, err
+}
+
+func (s *emailOutboxStore) CountByStatus() (map[string]int, error) {
+	rows, err := s.b.db().Query(`SELECT status, COUNT(*) FROM email_outbox GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{models.EmailPending: 0, models.EmailSent: 0, models.EmailDead: 0}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+func (s *emailOutboxStore) List(status string, limit int) ([]models.QueuedEmail, error) {
+	query := `SELECT ` + queuedEmailColumns + ` FROM email_outbox`
+	args := []interface{}{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	rows, err := s.b.db().Query(query+` ORDER BY id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanQueuedEmails(rows)
+}
+
+func (s *emailOutboxStore) DeleteSent(before time.Time) (int64, error) {
+	return s.b.exec(`DELETE FROM email_outbox WHERE status = ? AND sent_at < ?`, models.EmailSent, before.UTC())
+}
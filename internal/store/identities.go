@@ -0,0 +1,156 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"matcha/internal/database"
+	"matcha/internal/models"
+)
+
+type identityStore struct {
+	b backend
+}
+
+const identityColumns = `id, user_id, provider, subject, email, created_at, last_login_at`
+
+func scanIdentity(row scanner) (*models.UserIdentity, error) {
+	var i models.UserIdentity
+	var createdAt, lastLoginAt sql.NullTime
+	err := row.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &createdAt, &lastLoginAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.CreatedAt = createdAt.Time
+	i.LastLoginAt = timePtr(lastLoginAt)
+	return &i, nil
+}
+
+func (s *identityStore) Get(provider, subject string) (*models.UserIdentity, error) {
+	return scanIdentity(s.b.db().QueryRow(
+		`SELECT `+identityColumns+` FROM user_identities WHERE provider = ? AND subject = ?`, provider, subject,
+	))
+}
+
+func (s *identityStore) ListByUser(userID int64) ([]models.UserIdentity, error) {
+	rows, err := s.b.db().Query(
+		`SELECT `+identityColumns+` FROM user_identities WHERE user_id = ? ORDER BY created_at`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []models.UserIdentity{}
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *i)
+	}
+	return identities, rows.Err()
+}
+
+func (s *identityStore) Link(identity *models.UserIdentity) error {
+	return s.b.tx(func(tx *sql.Tx) error {
+		return insertIdentity(tx, identity)
+	})
+}
+
+func insertIdentity(tx *sql.Tx, identity *models.UserIdentity) error {
+	now := time.Now().UTC()
+	_, err := tx.Exec(
+		`INSERT INTO user_identities (user_id, provider, subject, email, created_at, last_login_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		identity.UserID, identity.Provider, identity.Subject, identity.Email, now, now,
+	)
+	return err
+}
+
+func (s *identityStore) CreateUser(u *models.User, identity *models.UserIdentity) (int64, error) {
+	var id int64
+	verified := 0
+	if u.IsEmailVerified {
+		verified = 1
+	}
+	err := s.b.tx(func(tx *sql.Tx) error {
+		var err error
+		// No password: password_hash '' matches no password (see services.CheckPassword)
+		id, err = database.InsertReturningID(tx,
+			`INSERT INTO users (username, email, password_hash, first_name, last_name, locale, digest_frequency, is_setup, is_email_verified, fame_rating, created_at, updated_at)
+			 VALUES (?, ?, '', ?, ?, ?, ?, 0, ?, 1.0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+			u.Username, u.Email, u.FirstName, u.LastName, u.Locale, models.DigestDaily, verified,
+		)
+		if err != nil {
+			return err
+		}
+		identity.UserID = id
+		return insertIdentity(tx, identity)
+	})
+	return id, err
+}
+
+func (s *identityStore) Unlink(userID int64, provider string) (bool, error) {
+	n, err := s.b.exec(`DELETE FROM user_identities WHERE user_id = ? AND provider = ?`, userID, provider)
+	return n > 0, err
+}
+
+func (s *identityStore) RecordLogin(id int64, email string) error {
+	_, err := s.b.exec(
+		`UPDATE user_identities SET last_login_at = ?, email = ? WHERE id = ?`, time.Now().UTC(), email, id,
+	)
+	return err
+}
+
+func (s *identityStore) CreateLoginState(state OIDCLoginState) error {
+	var linkUserID interface{}
+	if state.LinkUserID != 0 {
+		linkUserID = state.LinkUserID
+	}
+	_, err := s.b.exec(
+		`INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, link_user_id, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		state.StateHash, state.Provider, state.Nonce, state.CodeVerifier, linkUserID, time.Now().UTC(), state.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+func (s *identityStore) ConsumeLoginState(stateHash string) (*OIDCLoginState, error) {
+	var state OIDCLoginState
+	found := false
+	err := s.b.tx(func(tx *sql.Tx) error {
+		var linkUserID sql.NullInt64
+		err := tx.QueryRow(
+			`SELECT state_hash, provider, nonce, code_verifier, link_user_id, expires_at
+			 FROM oidc_login_states WHERE state_hash = ?`, stateHash,
+		).Scan(&state.StateHash, &state.Provider, &state.Nonce, &state.CodeVerifier, &linkUserID, &state.ExpiresAt)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		state.LinkUserID = linkUserID.Int64
+		res, err := tx.Exec(`DELETE FROM oidc_login_states WHERE state_hash = ?`, stateHash)
+		if err != nil {
+			return err
+		}
+		// Zero rows: another callback with the same state got there first
+		n, err := res.RowsAffected()
+		found = n > 0
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found || time.Now().After(state.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &state, nil
+}
+
+func (s *identityStore) DeleteExpiredLoginStates(before time.Time) (int64, error) {
+	return s.b.exec(`DELETE FROM oidc_login_states WHERE expires_at < ?`, before.UTC())
+}
@@ -0,0 +1,102 @@
+package store
+
+import "database/sql"
+
+type likeStore struct {
+	b backend
+}
+
+func (s *likeStore) Exists(fromUserID, toUserID int64) (bool, error) {
+	return exists(s.b.db(), `SELECT 1 FROM likes WHERE from_user_id = ? AND to_user_id = ?`, fromUserID, toUserID)
+}
+
+func (s *likeStore) ExistsTx(tx *sql.Tx, fromUserID, toUserID int64) (bool, error) {
+	return exists(tx, `SELECT 1 FROM likes WHERE from_user_id = ? AND to_user_id = ?`, fromUserID, toUserID)
+}
+
+func (s *likeStore) Create(fromUserID, toUserID int64) (bool, error) {
+	var created bool
+	err := s.b.tx(func(tx *sql.Tx) error {
+		var err error
+		created, err = s.CreateTx(tx, fromUserID, toUserID)
+		return err
+	})
+	return created, err
+}
+
+func (s *likeStore) CreateTx(tx *sql.Tx, fromUserID, toUserID int64) (bool, error) {
+	res, err := tx.Exec(`
+		INSERT INTO likes (from_user_id, to_user_id, created_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING
+	`, fromUserID, toUserID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *likeStore) Delete(fromUserID, toUserID int64) (bool, error) {
+	n, err := s.b.exec(`DELETE FROM likes WHERE from_user_id = ? AND to_user_id = ?`, fromUserID, toUserID)
+	return n > 0, err
+}
+
+func (s *likeStore) DeleteTx(tx *sql.Tx, fromUserID, toUserID int64) (bool, error) {
+	res, err := tx.Exec(`DELETE FROM likes WHERE from_user_id = ? AND to_user_id = ?`, fromUserID, toUserID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *likeStore) AreConnected(userA, userB int64) (bool, error) {
+	var count int
+	err := s.b.db().QueryRow(`
+		SELECT COUNT(*) FROM likes
+		WHERE (from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?)
+	`, userA, userB, userB, userA).Scan(&count)
+	return count == 2, err
+}
+
+func (s *likeStore) CountReceived(userID int64) (int64, error) {
+	var count int64
+	err := s.b.db().QueryRow(`SELECT COUNT(*) FROM likes WHERE to_user_id = ?`, userID).Scan(&count)
+	return count, err
+}
+
+func (s *likeStore) Connections(userID int64) ([]Connection, error) {
+	// Ordered by the later of the two likes, i.e. when they connected
+	rows, err := s.b.db().Query(`
+		SELECT `+userColumns+`, l1.created_at, l2.created_at
+		FROM users u
+		INNER JOIN likes l1 ON l1.from_user_id = ? AND l1.to_user_id = u.id
+		INNER JOIN likes l2 ON l2.from_user_id = u.id AND l2.to_user_id = ?
+		WHERE u.is_setup = 1 AND u.is_email_verified = 1
+		ORDER BY CASE WHEN l1.created_at > l2.created_at THEN l1.created_at ELSE l2.created_at END DESC
+	`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var likedAt []*sql.NullTime
+	users, err := scanUsers(rows, func() []interface{} {
+		given, received := &sql.NullTime{}, &sql.NullTime{}
+		likedAt = append(likedAt, given, received)
+		return []interface{}{given, received}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	connections := make([]Connection, len(users))
+	for i, u := range users {
+		given, received := likedAt[2*i], likedAt[2*i+1]
+		connectedAt := given.Time
+		if received.Time.After(connectedAt) {
+			connectedAt = received.Time
+		}
+		connections[i] = Connection{User: u, ConnectedAt: connectedAt}
+	}
+	return connections, nil
+}
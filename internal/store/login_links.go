@@ -0,0 +1,40 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type loginLinkStore struct {
+	b backend
+}
+
+func (s *loginLinkStore) Create(userID int64, tokenHash, ipAddress string, expiresAt time.Time) error {
+	_, err := s.b.exec(
+		`INSERT INTO login_links (user_id, token_hash, ip_address, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, tokenHash, ipAddress, time.Now().UTC(), expiresAt.UTC(),
+	)
+	return err
+}
+
+func (s *loginLinkStore) Consume(tokenHash string) (int64, error) {
+	var userID int64
+	err := s.b.tx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE login_links SET used_at = ? WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`,
+			time.Now().UTC(), tokenHash, time.Now().UTC(),
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return tx.QueryRow(`SELECT user_id FROM login_links WHERE token_hash = ?`, tokenHash).Scan(&userID)
+	})
+	return userID, err
+}
+
+func (s *loginLinkStore) DeleteExpired(before time.Time) (int64, error) {
+	return s.b.exec(`DELETE FROM login_links WHERE expires_at < ?`, before.UTC())
+}
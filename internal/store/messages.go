@@ -0,0 +1,239 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"matcha/internal/database"
+	"matcha/internal/models"
+)
+
+type messageStore struct {
+	b backend
+}
+
+const messageColumns = `id, from_user_id, to_user_id, content, COALESCE(is_read, 0), read_at, edited_at, deleted_at, created_at`
+
+func scanMessage(row scanner) (*models.Message, error) {
+	var m models.Message
+	var isRead int
+	var readAt, editedAt, deletedAt, createdAt sql.NullTime
+	if err := row.Scan(&m.ID, &m.FromUserID, &m.ToUserID, &m.Content, &isRead,
+		&readAt, &editedAt, &deletedAt, &createdAt); err != nil {
+		return nil, err
+	}
+	m.IsRead = isRead == 1
+	m.ReadAt = timePtr(readAt)
+	m.EditedAt = timePtr(editedAt)
+	m.DeletedAt = timePtr(deletedAt)
+	m.CreatedAt = createdAt.Time
+	return &m, nil
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (s *messageStore) CreateTx(tx *sql.Tx, m *models.Message) (int64, error) {
+	return database.InsertReturningID(tx, `
+		INSERT INTO messages (from_user_id, to_user_id, content, is_read, created_at)
+		VALUES (?, ?, ?, 0, CURRENT_TIMESTAMP)
+	`, m.FromUserID, m.ToUserID, m.Content)
+}
+
+func (s *messageStore) AddAttachmentTx(tx *sql.Tx, a *models.MessageAttachment) (int64, error) {
+	return database.InsertReturningID(tx, `
+		INSERT INTO message_attachments (message_id, uploader_id, file_path, original_name, content_type, size_bytes)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, a.MessageID, a.UploaderID, a.FilePath, a.OriginalName, a.ContentType, a.SizeBytes)
+}
+
+func (s *messageStore) Get(id int64) (*models.Message, error) {
+	m, err := scanMessage(s.b.db().QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return m, err
+}
+
+func (s *messageStore) List(userA, userB int64, page MessagePage) ([]models.Message, error) {
+	// Ordered by id rather than created_at: ids are monotonic, timestamps only have second precision
+	query := `SELECT ` + messageColumns + ` FROM messages
+		WHERE ((from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?))`
+	args := []interface{}{userA, userB, userB, userA}
+	switch {
+	case page.Forward:
+		query += ` AND id > ? ORDER BY id ASC`
+		args = append(args, page.After)
+	case page.Before > 0:
+		query += ` AND id < ? ORDER BY id DESC`
+		args = append(args, page.Before)
+	default:
+		query += ` ORDER BY id DESC`
+	}
+	query += ` LIMIT ?`
+	args = append(args, page.Limit)
+
+	rows, err := s.b.db().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *m)
+	}
+	return messages, rows.Err()
+}
+
+const attachmentColumns = `a.id, a.message_id, a.uploader_id, a.file_path, COALESCE(a.original_name, ''), a.content_type, a.size_bytes, a.created_at`
+
+func scanAttachment(row scanner, extra ...interface{}) (*models.MessageAttachment, error) {
+	var a models.MessageAttachment
+	var createdAt sql.NullTime
+	dest := []interface{}{&a.ID, &a.MessageID, &a.UploaderID, &a.FilePath, &a.OriginalName, &a.ContentType,
+		&a.SizeBytes, &createdAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	a.CreatedAt = createdAt.Time
+	return &a, nil
+}
+
+func (s *messageStore) Attachments(messageIDs []int64) (map[int64][]models.MessageAttachment, error) {
+	result := make(map[int64][]models.MessageAttachment)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messageIDs)), ",")
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := s.b.db().Query(`
+		SELECT `+attachmentColumns+`
+		FROM message_attachments a
+		WHERE a.message_id IN (`+placeholders+`)
+		ORDER BY a.id ASC
+	`, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return result, err
+		}
+		result[a.MessageID] = append(result[a.MessageID], *a)
+	}
+	return result, rows.Err()
+}
+
+func (s *messageStore) GetAttachment(id int64) (*models.MessageAttachment, *models.Message, error) {
+	var m models.Message
+	var deletedAt sql.NullTime
+	a, err := scanAttachment(s.b.db().QueryRow(`
+		SELECT `+attachmentColumns+`, m.id, m.from_user_id, m.to_user_id, m.deleted_at
+		FROM message_attachments a
+		INNER JOIN messages m ON m.id = a.message_id
+		WHERE a.id = ?
+	`, id), &m.ID, &m.FromUserID, &m.ToUserID, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	m.DeletedAt = timePtr(deletedAt)
+	return a, &m, nil
+}
+
+func (s *messageStore) Edit(id, senderID int64, content, previousContent string, editedAt time.Time) (bool, error) {
+	return s.change(`UPDATE messages SET content = ?, edited_at = ?
+		WHERE id = ? AND from_user_id = ? AND deleted_at IS NULL`,
+		[]interface{}{content, editedAt, id, senderID}, id, senderID, "edit", previousContent)
+}
+
+func (s *messageStore) Delete(id, senderID int64, previousContent string, deletedAt time.Time) (bool, error) {
+	return s.change(`UPDATE messages SET content = '', deleted_at = ?
+		WHERE id = ? AND from_user_id = ? AND deleted_at IS NULL`,
+		[]interface{}{deletedAt, id, senderID}, id, senderID, "delete", previousContent)
+}
+
+// change runs an edit or delete and, only if it changed the message, records the previous content in
+// message_edits, in one transaction
+func (s *messageStore) change(update string, args []interface{}, id, editorID int64, action, previousContent string) (bool, error) {
+	changed := false
+	err := s.b.tx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(update, args...)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		changed = true
+		_, err = tx.Exec(`
+			INSERT INTO message_edits (message_id, editor_id, action, previous_content)
+			VALUES (?, ?, ?, ?)
+		`, id, editorID, action, previousContent)
+		return err
+	})
+	return changed, err
+}
+
+func (s *messageStore) MarkRead(readerID, senderID, fromID, upToID int64, readAt time.Time) (int64, error) {
+	query := `
+		UPDATE messages
+		SET is_read = 1, read_at = ?
+		WHERE from_user_id = ? AND to_user_id = ? AND is_read = 0 AND id >= ?`
+	args := []interface{}{readAt, senderID, readerID, fromID}
+	if upToID > 0 {
+		query += ` AND id <= ?`
+		args = append(args, upToID)
+	}
+	return s.b.exec(query, args...)
+}
+
+func (s *messageStore) Index(id int64, content string) error {
+	if strings.TrimSpace(content) == "" {
+		return s.b.unindexMessage(id)
+	}
+	return s.b.indexMessage(id, content)
+}
+
+func (s *messageStore) Unindex(id int64) error {
+	return s.b.unindexMessage(id)
+}
+
+func (s *messageStore) Search(userID int64, q string, limit, offset int) ([]MessageSearchResult, error) {
+	return s.b.searchMessages(userID, q, limit, offset)
+}
+
+// scanSearchResults reads the rows of a backend's searchMessages query
+func scanSearchResults(rows *sql.Rows) ([]MessageSearchResult, error) {
+	defer rows.Close()
+	results := []MessageSearchResult{}
+	for rows.Next() {
+		var r MessageSearchResult
+		var createdAt sql.NullTime
+		if err := rows.Scan(&r.Message.ID, &r.Message.FromUserID, &r.Message.ToUserID, &createdAt, &r.Snippet); err != nil {
+			return nil, err
+		}
+		r.Message.CreatedAt = createdAt.Time
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
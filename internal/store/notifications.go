@@ -0,0 +1,109 @@
+package store
+
+import (
+	"database/sql"
+
+	"matcha/internal/database"
+	"matcha/internal/models"
+)
+
+type notificationStore struct {
+	b backend
+}
+
+const notificationColumns = `id, user_id, type, message, COALESCE(is_read, 0), created_at, COALESCE(related_user_id, 0)`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanNotification(row scanner) (*models.Notification, error) {
+	var n models.Notification
+	var isRead int
+	var createdAt sql.NullTime
+	if err := row.Scan(&n.ID, &n.UserID, &n.Type, &n.Message, &isRead, &createdAt, &n.RelatedUserID); err != nil {
+		return nil, err
+	}
+	n.IsRead = isRead == 1
+	n.CreatedAt = createdAt.Time
+	return &n, nil
+}
+
+func (s *notificationStore) Create(n *models.Notification) (int64, error) {
+	var id int64
+	err := s.b.tx(func(tx *sql.Tx) error {
+		var err error
+		id, err = s.CreateTx(tx, n)
+		return err
+	})
+	return id, err
+}
+
+func (s *notificationStore) CreateTx(tx *sql.Tx, n *models.Notification) (int64, error) {
+	return database.InsertReturningID(tx, `
+		INSERT INTO notifications (user_id, type, message, is_read, related_user_id)
+		VALUES (?, ?, ?, 0, ?)
+	`, n.UserID, n.Type, n.Message, n.RelatedUserID)
+}
+
+func (s *notificationStore) Get(id int64) (*models.Notification, error) {
+	n, err := scanNotification(s.b.db().QueryRow(`SELECT `+notificationColumns+` FROM notifications WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return n, err
+}
+
+func (s *notificationStore) List(userID int64, limit, offset int) ([]models.Notification, error) {
+	return s.query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+}
+
+func (s *notificationStore) ListAfter(userID, afterID int64, limit int) ([]models.Notification, error) {
+	return s.query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		WHERE user_id = ? AND id > ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, userID, afterID, limit)
+}
+
+func (s *notificationStore) query(query string, args ...interface{}) ([]models.Notification, error) {
+	rows, err := s.b.db().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, *n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *notificationStore) CountUnread(userID int64) (int, error) {
+	var unread int
+	err := s.b.db().QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = ? AND is_read = 0`, userID).Scan(&unread)
+	return unread, err
+}
+
+func (s *notificationStore) MarkRead(id, userID int64) (bool, error) {
+	n, err := s.b.exec(`UPDATE notifications SET is_read = 1 WHERE id = ? AND user_id = ?`, id, userID)
+	return n > 0, err
+}
+
+func (s *notificationStore) MarkAllRead(userID int64) error {
+	_, err := s.b.exec(`UPDATE notifications SET is_read = 1 WHERE user_id = ? AND is_read = 0`, userID)
+	return err
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"matcha/internal/database"
+)
+
+type outboxStore struct {
+	b backend
+}
+
+func scanOutboxEvent(row scanner) (*OutboxEvent, error) {
+	var e OutboxEvent
+	var payload string
+	if err := row.Scan(&e.ID, &e.Type, &payload, &e.Attempts); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	e.Payload = json.RawMessage(payload)
+	return &e, nil
+}
+
+func (s *outboxStore) AddTx(tx *sql.Tx, eventType string, payload []byte, nextAttemptAt time.Time) (int64, error) {
+	return database.InsertReturningID(tx, `
+		INSERT INTO outbox (event_type, payload, next_attempt_at)
+		VALUES (?, ?, ?)
+	`, eventType, string(payload), nextAttemptAt.UTC())
+}
+
+func (s *outboxStore) ClaimTx(tx *sql.Tx, id int64) (bool, error) {
+	res, err := tx.Exec(`
+		UPDATE outbox SET processed_at = ?, last_error = NULL
+		WHERE id = ? AND processed_at IS NULL
+	`, time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *outboxStore) GetPending(id int64) (*OutboxEvent, error) {
+	return scanOutboxEvent(s.b.db().QueryRow(`
+		SELECT id, event_type, payload, attempts FROM outbox
+		WHERE id = ? AND processed_at IS NULL AND dead_at IS NULL
+	`, id))
+}
+
+func (s *outboxStore) Due(now time.Time, limit int) ([]OutboxEvent, error) {
+	rows, err := s.b.db().Query(`
+		SELECT id, event_type, payload, attempts FROM outbox
+		WHERE processed_at IS NULL AND dead_at IS NULL AND next_attempt_at <= ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []OutboxEvent{}
+	for rows.Next() {
+		e, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+func (s *outboxStore) MarkProcessed(id int64) error {
+	_, err := s.b.exec(`
+		UPDATE outbox SET processed_at = ?, last_error = NULL
+		WHERE id = ? AND processed_at IS NULL
+	`, time.Now().UTC(), id)
+	return err
+}
+
+func (s *outboxStore) MarkFailed(id int64, attempts int, lastError string, nextAttemptAt time.Time, dead bool) error {
+	var deadAt interface{}
+	if dead {
+		deadAt = time.Now().UTC()
+	}
+	_, err := s.b.exec(`
+		UPDATE outbox SET attempts = ?, last_error = ?, next_attempt_at = ?, dead_at = ?
+		WHERE id = ? AND processed_at IS NULL
+	`, attempts, lastError, nextAttemptAt.UTC(), deadAt, id)
+	return err
+}
+
+func (s *outboxStore) DeleteProcessed(before time.Time) (int64, error) {
+	return s.b.exec(`DELETE FROM outbox WHERE processed_at < ?`, before.UTC())
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"database/sql"
+
+	"matcha/internal/models"
+)
+
+type pictureStore struct {
+	b backend
+}
+
+func (s *pictureStore) List(userID int64) ([]models.UserPicture, error) {
+	rows, err := s.b.db().Query(`
+		SELECT id, user_id, file_path, COALESCE(is_profile, 0), order_index, created_at
+		FROM user_pictures
+		WHERE user_id = ?
+		ORDER BY order_index ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pictures := []models.UserPicture{}
+	for rows.Next() {
+		var p models.UserPicture
+		var isProfile int
+		var createdAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.UserID, &p.FilePath, &isProfile, &p.Order, &createdAt); err != nil {
+			return nil, err
+		}
+		p.IsProfile = isProfile == 1
+		p.CreatedAt = createdAt.Time
+		pictures = append(pictures, p)
+	}
+	return pictures, rows.Err()
+}
+
+func (s *pictureStore) Save(userID int64, slot int, filePath string, isProfile bool) (string, error) {
+	var replaced string
+	// Picture row and profile_picture_id change together
+	err := s.b.tx(func(tx *sql.Tx) error {
+		replaced = ""
+		var existingID int64
+		err := tx.QueryRow(`
+			SELECT id, file_path FROM user_pictures
+			WHERE user_id = ? AND order_index = ?
+		`, userID, slot).Scan(&existingID, &replaced)
+		if err == nil {
+			_, err = tx.Exec(`
+				UPDATE user_pictures
+				SET file_path = ?, is_profile = ?
+				WHERE id = ?
+			`, filePath, boolInt(isProfile), existingID)
+		} else if err == sql.ErrNoRows {
+			replaced = ""
+			_, err = tx.Exec(`
+				INSERT INTO user_pictures (user_id, file_path, is_profile, order_index, created_at)
+				VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+			`, userID, filePath, boolInt(isProfile), slot)
+		}
+		if err != nil {
+			return err
+		}
+
+		if isProfile {
+			return updateProfilePictureID(tx, userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return replaced, nil
+}
+
+func (s *pictureStore) Swap(userID int64, slotA, slotB int) error {
+	return s.b.tx(func(tx *sql.Tx) error {
+		// Through a temporary slot so the two never share an order_index
+		for _, move := range [][2]int{{slotA, -1}, {slotB, slotA}, {-1, slotB}} {
+			if _, err := tx.Exec(`
+				UPDATE user_pictures
+				SET order_index = ?
+				WHERE user_id = ? AND order_index = ?
+			`, move[1], userID, move[0]); err != nil {
+				return err
+			}
+		}
+		if slotA == 0 || slotB == 0 {
+			return updateProfilePictureID(tx, userID)
+		}
+		return nil
+	})
+}
+
+// updateProfilePictureID points users.profile_picture_id at the picture in slot 0, if there is one
+func updateProfilePictureID(tx *sql.Tx, userID int64) error {
+	_, err := tx.Exec(`
+		UPDATE users SET profile_picture_id = (
+			SELECT id FROM user_pictures WHERE user_id = ? AND order_index = 0
+		) WHERE id = ? AND EXISTS (SELECT 1 FROM user_pictures WHERE user_id = ? AND order_index = 0)
+	`, userID, userID, userID)
+	return err
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// postgresBackend writes directly (PostgreSQL handles concurrent writers) and searches messages with the
+// generated messages.search_vector column, which PostgreSQL keeps in sync by itself.
+type postgresBackend struct {
+	conn *sql.DB
+}
+
+func (b *postgresBackend) db() *sql.DB {
+	return b.conn
+}
+
+func (b *postgresBackend) exec(query string, args ...interface{}) (int64, error) {
+	res, err := b.conn.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (b *postgresBackend) tx(fn func(tx *sql.Tx) error) error {
+	tx, err := b.conn.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// indexMessage and unindexMessage have nothing to do: search_vector is a generated column
+func (b *postgresBackend) indexMessage(id int64, content string) error {
+	return nil
+}
+
+func (b *postgresBackend) unindexMessage(id int64) error {
+	return nil
+}
+
+func (b *postgresBackend) searchMessages(userID int64, q string, limit, offset int) ([]MessageSearchResult, error) {
+	options := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=%d, MinWords=1, HighlightAll=false",
+		SnippetStart, SnippetEnd, snippetWords)
+	rows, err := b.conn.Query(`
+		SELECT
+			m.id, m.from_user_id, m.to_user_id, m.created_at,
+			ts_headline('simple', m.content, to_tsquery('simple', ?), ?) AS snippet
+		FROM messages m
+		WHERE m.search_vector @@ to_tsquery('simple', ?)
+			AND (m.from_user_id = ? OR m.to_user_id = ?)
+			AND m.deleted_at IS NULL
+		ORDER BY m.id DESC
+		LIMIT ? OFFSET ?
+	`, buildTSQuery(q), options, buildTSQuery(q), userID, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanSearchResults(rows)
+}
+
+// buildTSQuery turns free text into a safe tsquery: every word must match, as a prefix.
+// Each word is a quoted lexeme, so user input can't inject tsquery operators (|, !, <->...).
+func buildTSQuery(q string) string {
+	terms := []string{}
+	for _, word := range strings.Fields(q) {
+		word = strings.ReplaceAll(word, `\`, `\\`)
+		word = strings.ReplaceAll(word, `'`, `''`)
+		terms = append(terms, `'`+word+`':*`)
+	}
+	return strings.Join(terms, " & ")
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"matcha/internal/database"
+	"matcha/internal/models"
+)
+
+type sessionStore struct {
+	b backend
+}
+
+const sessionColumns = `id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at, COALESCE(revoke_reason, ''), refresh_key`
+
+func scanSession(row scanner) (*models.Session, error) {
+	var s models.Session
+	var createdAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IPAddress, &createdAt, &lastUsedAt, &s.ExpiresAt, &revokedAt, &s.RevokeReason, &s.RefreshKey)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.CreatedAt = createdAt.Time
+	s.LastUsedAt = lastUsedAt.Time
+	s.RevokedAt = timePtr(revokedAt)
+	return &s, nil
+}
+
+func (s *sessionStore) Create(session *models.Session, tokenHash string) (int64, error) {
+	var id int64
+	now := time.Now().UTC()
+	err := s.b.tx(func(tx *sql.Tx) error {
+		var err error
+		id, err = database.InsertReturningID(tx, `
+			INSERT INTO sessions (user_id, user_agent, ip_address, created_at, last_used_at, expires_at, refresh_key)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, session.UserID, session.UserAgent, session.IPAddress, now, now, session.ExpiresAt.UTC(), session.RefreshKey)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO refresh_tokens (session_id, token_hash, created_at) VALUES (?, ?, ?)`, id, tokenHash, now)
+		return err
+	})
+	return id, err
+}
+
+func (s *sessionStore) Get(id int64) (*models.Session, error) {
+	return scanSession(s.b.db().QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+}
+
+func (s *sessionStore) GetByRefreshToken(tokenHash string) (*models.Session, *time.Time, error) {
+	var sessionID int64
+	var rotatedAt sql.NullTime
+	err := s.b.db().QueryRow(
+		`SELECT session_id, rotated_at FROM refresh_tokens WHERE token_hash = ?`, tokenHash,
+	).Scan(&sessionID, &rotatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := s.Get(sessionID)
+	return session, timePtr(rotatedAt), err
+}
+
+func (s *sessionStore) Rotate(sessionID int64, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	rotated := false
+	now := time.Now().UTC()
+	err := s.b.tx(func(tx *sql.Tx) error {
+		// Only the current token can be rotated, so of two concurrent refreshes with it one fails
+		res, err := tx.Exec(`
+			UPDATE refresh_tokens SET rotated_at = ?
+			WHERE session_id = ? AND token_hash = ? AND rotated_at IS NULL
+		`, now, sessionID, oldHash)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO refresh_tokens (session_id, token_hash, created_at) VALUES (?, ?, ?)`, sessionID, newHash, now,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`UPDATE sessions SET last_used_at = ?, expires_at = ? WHERE id = ?`, now, expiresAt.UTC(), sessionID,
+		); err != nil {
+			return err
+		}
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+func (s *sessionStore) Revoke(id int64, reason string) (bool, error) {
+	n, err := s.b.exec(
+		`UPDATE sessions SET revoked_at = ?, revoke_reason = ? WHERE id = ? AND revoked_at IS NULL`,
+		time.Now().UTC(), reason, id,
+	)
+	return n > 0, err
+}
+
+func (s *sessionStore) RevokeAll(userID, exceptID int64, reason string) (int64, error) {
+	return s.b.exec(
+		`UPDATE sessions SET revoked_at = ?, revoke_reason = ? WHERE user_id = ? AND id != ? AND revoked_at IS NULL`,
+		time.Now().UTC(), reason, userID, exceptID,
+	)
+}
+
+func (s *sessionStore) DeleteExpired(before time.Time) (int64, error) {
+	// refresh_tokens rows go with their session (ON DELETE CASCADE needs foreign keys on in SQLite,
+	// so delete them explicitly)
+	var deleted int64
+	err := s.b.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM refresh_tokens WHERE session_id IN (
+				SELECT id FROM sessions WHERE expires_at < ? OR revoked_at < ?
+			)
+		`, before.UTC(), before.UTC()); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`DELETE FROM sessions WHERE expires_at < ? OR revoked_at < ?`, before.UTC(), before.UTC())
+		if err != nil {
+			return err
+		}
+		deleted, _ = res.RowsAffected()
+		return nil
+	})
+	return deleted, err
+}
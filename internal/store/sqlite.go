@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+
+	"matcha/internal/database"
+)
+
+// sqliteBackend sends every write through the database write queue (SQLite allows one writer at a time)
+// and searches messages with the messages_fts FTS5 table, which the application keeps in sync.
+// Without FTS5 (built without -tags sqlite_fts5) there is no such table and no search.
+type sqliteBackend struct {
+	conn *sql.DB
+	fts  bool
+}
+
+func (b *sqliteBackend) db() *sql.DB {
+	return b.conn
+}
+
+func (b *sqliteBackend) exec(query string, args ...interface{}) (int64, error) {
+	res := database.GetWriteQueue().Enqueue(query, args...)
+	return res.RowsAffected, res.Error
+}
+
+func (b *sqliteBackend) tx(fn func(tx *sql.Tx) error) error {
+	return database.GetWriteQueue().EnqueueTx(fn)
+}
+
+func (b *sqliteBackend) indexMessage(id int64, content string) error {
+	if !b.fts {
+		return nil
+	}
+	_, err := b.exec(`INSERT OR REPLACE INTO messages_fts (rowid, content) VALUES (?, ?)`, id, content)
+	return err
+}
+
+func (b *sqliteBackend) unindexMessage(id int64) error {
+	if !b.fts {
+		return nil
+	}
+	_, err := b.exec(`DELETE FROM messages_fts WHERE rowid = ?`, id)
+	return err
+}
+
+func (b *sqliteBackend) searchMessages(userID int64, q string, limit, offset int) ([]MessageSearchResult, error) {
+	if !b.fts {
+		return nil, ErrSearchUnavailable
+	}
+	rows, err := b.conn.Query(`
+		SELECT
+			m.id, m.from_user_id, m.to_user_id, m.created_at,
+			snippet(messages_fts, 0, ?, ?, '…', ?) AS snippet
+		FROM messages_fts
+		INNER JOIN messages m ON m.id = messages_fts.rowid
+		WHERE messages_fts MATCH ?
+			AND (m.from_user_id = ? OR m.to_user_id = ?)
+			AND m.deleted_at IS NULL
+		ORDER BY m.id DESC
+		LIMIT ? OFFSET ?
+	`, SnippetStart, SnippetEnd, snippetWords, buildFTSQuery(q), userID, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanSearchResults(rows)
+}
+
+// buildFTSQuery turns free text into a safe FTS5 query: every word must match, as a prefix.
+// Quotes are doubled so user input can't inject FTS5 operators (OR, NEAR, column filters...).
+func buildFTSQuery(q string) string {
+	terms := []string{}
+	for _, word := range strings.Fields(q) {
+		word = strings.ReplaceAll(word, `"`, `""`)
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
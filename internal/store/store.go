@@ -0,0 +1,649 @@
+// Package store is the repository layer for users, likes, views, messages, notifications, tags, pictures,
+// blocks and login sessions. Repositories take and return internal/models types.
+//
+// Each repository is an interface with one SQL implementation, written in SQL that SQLite and PostgreSQL
+// both accept. What does differ between the two (how writes are run, full-text search) is behind backend,
+// with a SQLite and a PostgreSQL version; config.Config.DBDriver picks one at startup.
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"matcha/internal/database"
+	"matcha/internal/models"
+)
+
+// ErrNotFound is returned when the requested row doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrEmailInUse is returned when a write would give a user an email another user has
+var ErrEmailInUse = errors.New("email in use by another user")
+
+// ErrSearchUnavailable is returned by MessageStore.Search when SQLite was built without FTS5
+var ErrSearchUnavailable = errors.New("message search is not available")
+
+// UserStore manages accounts and profiles
+type UserStore interface {
+	// Create inserts an unverified, not set up user from u's username, email, password hash, names and
+	// verification token, and returns the new id
+	Create(u *models.User) (int64, error)
+	GetByID(id int64) (*models.User, error)
+	// GetByUsername and GetByEmail also fill PasswordHash and EmailVerificationToken (login, resending
+	// verification)
+	GetByUsername(username string) (*models.User, error)
+	GetByEmail(email string) (*models.User, error)
+	UsernameExists(username string) (bool, error)
+	// EmailInUse reports whether a user other than exceptUserID (0 = any user) has this email
+	EmailInUse(email string, exceptUserID int64) (bool, error)
+
+	// VerifyEmail marks the user holding token as verified; false if no user has it
+	VerifyEmail(token string) (bool, error)
+	SetVerificationToken(userID int64, token string) error
+
+	GetPasswordReset(email string) (int64, PasswordReset, error)
+	// SetPasswordReset stores a new pending reset (or clears it) and zeroes its attempt counter
+	SetPasswordReset(userID int64, reset PasswordReset) error
+	// TakePasswordResetAttempt counts a guess of the reset code, before it is checked, and returns the
+	// count so far; 0 if max guesses were made already. Atomic, so concurrent guesses can't exceed max.
+	TakePasswordResetAttempt(userID int64, max int) (int, error)
+	// ResetPassword sets the password of the user holding an unexpired reset token and clears the token.
+	// Returns the user's id, 0 if no user has it.
+	ResetPassword(token, passwordHash string) (int64, error)
+	UpdatePassword(userID int64, passwordHash string) error
+
+	// RecordLoginFailure counts a failed login and returns the number of consecutive failures
+	RecordLoginFailure(userID int64) (int, error)
+	// LockLogin refuses the user's logins until the given time
+	LockLogin(userID int64, until time.Time) error
+	// ClearLoginFailures resets the failure count and lifts any lock (after a successful login)
+	ClearLoginFailures(userID int64) error
+
+	// UpdateProfile applies the set fields of changes and replaces the user's tags, in one transaction
+	UpdateProfile(userID int64, changes ProfileChanges, tags []string) error
+	// ResetProfile clears everything except names, username, email and password, removes the user's
+	// tags and sends them back to profile setup
+	ResetProfile(userID int64) error
+	// CompleteSetup marks the profile set up; false if there is no such user
+	CompleteSetup(userID int64) (bool, error)
+
+	// SetRole sets the role of the user with this username; false if there is none
+	SetRole(username, role string) (bool, error)
+
+	// SetPresence sets is_online and last_seen (login, logout)
+	SetPresence(userID int64, online bool) error
+	MarkOnline(userID int64) error
+	TouchLastSeen(userID int64) error
+
+	// Discover lists the set up, verified users matching f (browse, search)
+	Discover(f DiscoverFilter) ([]models.User, error)
+
+	// Ranking returns a page of the set up users, most famous first; CountSetUp counts them all
+	Ranking(limit, offset int) ([]models.User, error)
+	CountSetUp() (int, error)
+	// CountByTrait counts users per value of a profile trait (compared case-insensitively), most first
+	CountByTrait(trait Trait) ([]TraitCount, error)
+	// Activity counts what the user's fame rating is made of
+	Activity(userID int64) (Activity, error)
+	SetFameRating(userID int64, rating float64) error
+}
+
+// Trait is a profile field users can be counted by
+type Trait string
+
+const (
+	TraitMBTI             Trait = "mbti"
+	TraitGender           Trait = "gender"
+	TraitSexualPreference Trait = "sexual_preference"
+)
+
+// TraitCount is how many users have one value of a trait
+type TraitCount struct {
+	Value string
+	Count int64
+}
+
+// Activity is what a user did and got, for their fame rating
+type Activity struct {
+	LikesGiven    int
+	LikesReceived int
+	MessagesSent  int
+	Pictures      int
+	Connections   int
+	ViewsReceived int
+}
+
+// DiscoverFilter narrows UserStore.Discover; zero fields don't filter
+type DiscoverFilter struct {
+	ViewerID      int64  // Leaves out the viewer, their connections and users blocked either way
+	Gender        string // Only users of this gender
+	SeekingGender string // Only users interested in this gender, in both, or who haven't said
+	MinAge        int    // Ages count by year of birth; users without a birth date always match
+	MaxAge        int
+	MinFame       float64
+	AnyTags       []string // Only users with at least one of these tags (compared case-insensitively)
+	// Only users whose location contains this text (case-insensitive)
+	LocationContains string
+	OrderByFame      bool // Most famous first; otherwise in no particular order
+}
+
+// PasswordReset is a user's pending password reset. Empty strings and a nil ExpiresAt are stored as NULL.
+type PasswordReset struct {
+	Code      string // 6-digit code sent by email (forgot password)
+	Token     string // Token that allows setting a new password
+	ExpiresAt *time.Time
+	Attempts  int // Guesses of Code so far (read only; SetPasswordReset zeroes it)
+}
+
+// ProfileChanges lists the profile fields to update; nil fields are left as they are
+type ProfileChanges struct {
+	FirstName         *string
+	LastName          *string
+	Gender            *string
+	SexualPreference  *string
+	Biography         *string
+	Openness          *string
+	Conscientiousness *string
+	Extraversion      *string
+	Agreeableness     *string
+	Neuroticism       *string
+	Siblings          *string
+	MBTI              *string
+	CaliperProfile    *string
+	Locale            *string
+	DigestFrequency   *string
+	Latitude          *float64 // Setting either coordinate also updates location_updated_at
+	Longitude         *float64
+	Location          *string
+}
+
+// LikeStore manages likes; two users who like each other are connected
+type LikeStore interface {
+	Exists(fromUserID, toUserID int64) (bool, error)
+	// ExistsTx is Exists inside the caller's transaction (see Store.Tx)
+	ExistsTx(tx *sql.Tx, fromUserID, toUserID int64) (bool, error)
+	// Create records that fromUserID likes toUserID; false if they already did
+	Create(fromUserID, toUserID int64) (bool, error)
+	// CreateTx is Create inside the caller's transaction (see Store.Tx)
+	CreateTx(tx *sql.Tx, fromUserID, toUserID int64) (bool, error)
+	// Delete removes the like; false if there was none
+	Delete(fromUserID, toUserID int64) (bool, error)
+	// DeleteTx is Delete inside the caller's transaction (see Store.Tx)
+	DeleteTx(tx *sql.Tx, fromUserID, toUserID int64) (bool, error)
+	AreConnected(userA, userB int64) (bool, error)
+	CountReceived(userID int64) (int64, error)
+	// Connections returns the set up, verified users connected with userID, most recently connected first
+	Connections(userID int64) ([]Connection, error)
+}
+
+// Connection is a user the caller is connected with
+type Connection struct {
+	User        models.User
+	ConnectedAt time.Time // The later of the two likes
+}
+
+// ViewStore records who viewed whose profile
+type ViewStore interface {
+	// Record logs that viewerID viewed viewedID's profile; false if it was ignored as a duplicate
+	Record(viewerID, viewedID int64) (bool, error)
+	// RecordTx is Record inside the caller's transaction (see Store.Tx)
+	RecordTx(tx *sql.Tx, viewerID, viewedID int64) (bool, error)
+	Exists(viewerID, viewedID int64) (bool, error)
+	// Visitors returns the latest limit users who viewed userID's profile, by their first view, newest first
+	Visitors(userID int64, limit int) ([]Visitor, error)
+}
+
+// Visitor is a user who viewed the caller's profile
+type Visitor struct {
+	User      models.User
+	ViewedAt  time.Time  // Their first view
+	LikedAt   *time.Time // When they liked the caller, nil if they haven't
+	LikedBack bool       // Whether the caller likes them
+}
+
+// ConversationStore lists a user's conversations and keeps their per-conversation settings
+type ConversationStore interface {
+	// List returns the user's connections with their last message, pinned ones first, then by latest
+	// message. Archived conversations are only listed (alone) with archived set.
+	List(userID int64, archived bool) ([]Conversation, error)
+	// GetSettings returns userID's settings for the conversation with otherUserID (defaults if never set)
+	GetSettings(userID, otherUserID int64) (ConversationSettings, error)
+	SaveSettings(userID, otherUserID int64, settings ConversationSettings) error
+}
+
+// Conversation is one entry of a user's chat list
+type Conversation struct {
+	User        models.User
+	LastMessage string // Its content, "Message deleted" or "Sent a photo"; "" if there are no messages
+	UnreadCount int64
+	Settings    ConversationSettings
+}
+
+// ConversationSettings is one user's preferences for one conversation
+type ConversationSettings struct {
+	MutedUntil *time.Time
+	IsArchived bool
+	IsPinned   bool
+}
+
+// BotActivityStore keeps the log of what simulated users did, for the simulator dashboard
+type BotActivityStore interface {
+	// CreateTx logs an action inside the caller's transaction (see Store.Tx)
+	CreateTx(tx *sql.Tx, a *BotActivity) error
+	// List returns a page of the log, newest first; Count counts all of it
+	List(limit, offset int) ([]BotActivity, error)
+	Count() (int, error)
+}
+
+// BotActivity is one action of a bot. Display names are read only, from the users' current names.
+type BotActivity struct {
+	ID                int64
+	BotID             int64
+	BotUsername       string
+	BotDisplayName    string
+	ActionType        string
+	TargetUserID      *int64
+	TargetUsername    *string
+	TargetDisplayName string
+	Details           *string
+	CreatedAt         time.Time
+}
+
+// OutboxEvent is one side effect stored by a write, run later by services' dispatcher
+type OutboxEvent struct {
+	ID       int64
+	Type     string
+	Payload  json.RawMessage
+	Attempts int
+}
+
+// OutboxStore keeps the events of the transactional outbox
+type OutboxStore interface {
+	// AddTx stores an event inside the caller's transaction, first due at nextAttemptAt, and returns its id
+	AddTx(tx *sql.Tx, eventType string, payload []byte, nextAttemptAt time.Time) (int64, error)
+	// ClaimTx marks the event processed inside the caller's transaction; false if it already was
+	ClaimTx(tx *sql.Tx, id int64) (bool, error)
+	// GetPending returns the event if it is neither processed nor dead; ErrNotFound otherwise
+	GetPending(id int64) (*OutboxEvent, error)
+	// Due returns up to limit pending events whose next attempt is due, oldest first
+	Due(now time.Time, limit int) ([]OutboxEvent, error)
+	// MarkProcessed records success; a no-op if the event was already claimed
+	MarkProcessed(id int64) error
+	// MarkFailed records a failed attempt: the event is retried at nextAttemptAt, or with dead set,
+	// not anymore
+	MarkFailed(id int64, attempts int, lastError string, nextAttemptAt time.Time, dead bool) error
+	// DeleteProcessed removes events processed before the given time
+	DeleteProcessed(before time.Time) (int64, error)
+}
+
+// BlockStore manages blocks and reports between users
+type BlockStore interface {
+	// Create blocks blockedID for blockerID and removes any likes between the two; false if already blocked
+	Create(blockerID, blockedID int64) (bool, error)
+	// Delete lifts the block; false if there was none
+	Delete(blockerID, blockedID int64) (bool, error)
+	// BlockedAt returns when blockerID blocked blockedID, nil if they haven't
+	BlockedAt(blockerID, blockedID int64) (*time.Time, error)
+	Report(reporterID, reportedID int64, reason string) error
+}
+
+// SessionStore manages login sessions and their refresh tokens (stored as SHA-256 hashes). Each session
+// has one current refresh token; refreshing rotates it, and the rotated ones are kept to detect reuse.
+type SessionStore interface {
+	// Create starts a session whose current refresh token has tokenHash, and returns its id
+	Create(s *models.Session, tokenHash string) (int64, error)
+	Get(id int64) (*models.Session, error)
+	// GetByRefreshToken returns the session a refresh token belongs to, and when that token was
+	// rotated (nil if it's the current one)
+	GetByRefreshToken(tokenHash string) (*models.Session, *time.Time, error)
+	// Rotate replaces the session's current refresh token oldHash with newHash and extends the session
+	// to expiresAt; false if oldHash isn't the current token (anymore)
+	Rotate(sessionID int64, oldHash, newHash string, expiresAt time.Time) (bool, error)
+	// Revoke ends the session; false if it was already revoked
+	Revoke(id int64, reason string) (bool, error)
+	// RevokeAll ends all of the user's active sessions except exceptID (0 = none), and returns how many
+	RevokeAll(userID, exceptID int64, reason string) (int64, error)
+	// DeleteExpired removes sessions that expired or were revoked before the given time
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+// TOTP is a user's TOTP enrollment. A Secret that is not Enabled is an enrollment waiting for its
+// first code.
+type TOTP struct {
+	Secret   string // Base32, as shown to the authenticator app
+	Enabled  bool
+	LastStep int64 // Time step of the last accepted code
+}
+
+// OIDCLoginState is a login in progress at an OpenID Connect provider
+type OIDCLoginState struct {
+	StateHash    string // SHA-256 of the state parameter sent to the provider
+	Provider     string
+	Nonce        string
+	CodeVerifier string // PKCE
+	LinkUserID   int64  // The logged in user linking an identity; 0 when logging in
+	ExpiresAt    time.Time
+}
+
+type IdentityStore interface {
+	Get(provider, subject string) (*models.UserIdentity, error)
+	ListByUser(userID int64) ([]models.UserIdentity, error)
+	// Link stores a new identity of an existing user
+	Link(identity *models.UserIdentity) error
+	// CreateUser inserts a user without a password from u's username, email, names and IsEmailVerified,
+	// and links identity to them, in one transaction. Returns the new user's id.
+	CreateUser(u *models.User, identity *models.UserIdentity) (int64, error)
+	// Unlink removes the user's identity at provider; false if they have none
+	Unlink(userID int64, provider string) (bool, error)
+	// RecordLogin sets last_login_at and the email the provider reported
+	RecordLogin(id int64, email string) error
+
+	CreateLoginState(state OIDCLoginState) error
+	// ConsumeLoginState removes and returns an unexpired login state; ErrNotFound if there is none
+	ConsumeLoginState(stateHash string) (*OIDCLoginState, error)
+	// DeleteExpiredLoginStates removes login states that expired before the given time
+	DeleteExpiredLoginStates(before time.Time) (int64, error)
+}
+
+// EmailChange is a user's request to change their email
+type EmailChange struct {
+	ID              int64
+	UserID          int64
+	OldEmail        string
+	NewEmail        string
+	ExpiresAt       time.Time // Deadline for confirming from the new address
+	RevertExpiresAt time.Time // Deadline for undoing it from the old address
+	ConfirmedAt     *time.Time
+	RevertedAt      *time.Time
+}
+
+type EmailChangeStore interface {
+	// Create stores a pending change with the hashes of its confirm and revert tokens, replacing the
+	// user's other pending (unconfirmed) changes
+	Create(change EmailChange, confirmTokenHash, revertTokenHash string) error
+	// GetPending returns the user's unconfirmed, unexpired change; ErrNotFound if there is none
+	GetPending(userID int64) (*EmailChange, error)
+	// Confirm applies a pending change: the user gets the new email, verified. ErrNotFound if there is
+	// no such unexpired change, ErrEmailInUse if another user has the new email by now.
+	Confirm(confirmTokenHash string) (*EmailChange, error)
+	// Revert cancels a pending change, or undoes a confirmed one: the old email is restored and the
+	// password reset and sign-in links sent to the new one stop working. ErrNotFound if the revert
+	// link is unknown, used or expired, ErrEmailInUse if another user took the old email meanwhile.
+	Revert(revertTokenHash string) (*EmailChange, error)
+	// Cancel cancels the user's pending changes; false if there were none
+	Cancel(userID int64) (bool, error)
+	// DeleteExpired removes changes that can't be reverted since before the given time
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+type EmailOutboxStore interface {
+	// Enqueue stores a pending email, due now, and returns its id
+	Enqueue(email *models.QueuedEmail) (int64, error)
+	// Due returns up to limit pending emails whose next attempt is due, oldest first
+	Due(now time.Time, limit int) ([]models.QueuedEmail, error)
+	// Claim takes a due pending email for sending by pushing its next attempt to until, so no other
+	// worker picks it up meanwhile; false if it isn't pending and due anymore
+	Claim(id int64, until time.Time) (bool, error)
+	// MarkSent records a successful send and empties the stored message
+	MarkSent(id int64) error
+	// MarkFailed records a failed attempt: the email is retried at nextAttemptAt, or with dead set,
+	// not anymore
+	MarkFailed(id int64, attempts int, lastError string, nextAttemptAt time.Time, dead bool) error
+	// Requeue makes a dead email pending again, due now, with its attempts reset; false if it isn't dead
+	Requeue(id int64) (bool, error)
+	// CountByStatus returns the number of emails per status
+	CountByStatus() (map[string]int, error)
+	// List returns the most recent emails with the status ("" = any), newest first
+	List(status string, limit int) ([]models.QueuedEmail, error)
+	// DeleteSent removes emails sent before the given time
+	DeleteSent(before time.Time) (int64, error)
+}
+
+// DigestStore finds who is due for an email digest of unread notifications, and what goes in it
+type DigestStore interface {
+	// Due returns up to limit verified users (not bots), not seen since seenBefore, whose digest is due: a
+	// daily one if the last was sent before dailyBefore, a weekly one before weeklyBefore. Only users with
+	// unread notifications since their last digest (or since firstSince, for a first one) are returned.
+	Due(dailyBefore, weeklyBefore, firstSince, seenBefore time.Time, limit int) ([]models.DigestRecipient, error)
+	// UnreadNotifications returns the number of the user's unread notifications created after since,
+	// and the latest limit of them, newest first
+	UnreadNotifications(userID int64, since time.Time, limit int) (int, []models.Notification, error)
+	// UnreadMessages returns the number of unread messages to the user, and how many users sent them
+	UnreadMessages(userID int64) (messages, senders int, err error)
+	// EnsureToken gives the user the unsubscribe token if they have none, and returns their token
+	EnsureToken(userID int64, token string) (string, error)
+	// MarkSent records that the user got a digest at the given time
+	MarkSent(userID int64, at time.Time) error
+	// SetFrequencyByToken sets the digest frequency of the user with the unsubscribe token; false if
+	// no user has it
+	SetFrequencyByToken(token, frequency string) (bool, error)
+}
+
+type LoginLinkStore interface {
+	// Create stores a sign-in link for the user, requested from ipAddress
+	Create(userID int64, tokenHash, ipAddress string, expiresAt time.Time) error
+	// Consume marks an unused, unexpired link as used and returns its user; 0 if there is no such link
+	Consume(tokenHash string) (int64, error)
+	// DeleteExpired removes links that expired before the given time
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+type TwoFactorStore interface {
+	// Get returns the user's enrollment; an empty TOTP if they have none
+	Get(userID int64) (TOTP, error)
+	// Begin stores a new, not yet enabled secret, replacing any unconfirmed one
+	Begin(userID int64, secret string) error
+	// Enable turns the stored secret on as of step and replaces the recovery codes with codeHashes
+	Enable(userID int64, step int64, codeHashes []string) error
+	// Disable removes the secret and the recovery codes
+	Disable(userID int64) error
+	// AcceptStep records step as used; false if that step or a later one was already used (replay)
+	AcceptStep(userID int64, step int64) (bool, error)
+	// ReplaceRecoveryCodes discards the user's recovery codes and stores codeHashes instead
+	ReplaceRecoveryCodes(userID int64, codeHashes []string) error
+	// UseRecoveryCode marks an unused code as used; false if the user has no such unused code
+	UseRecoveryCode(userID int64, codeHash string) (bool, error)
+	// CountRecoveryCodes returns how many unused recovery codes the user has left
+	CountRecoveryCodes(userID int64) (int, error)
+}
+
+// NotificationStore manages users' notifications
+type NotificationStore interface {
+	// Create stores n as unread and returns its id
+	Create(n *models.Notification) (int64, error)
+	// CreateTx is Create inside the caller's transaction (see Store.Tx)
+	CreateTx(tx *sql.Tx, n *models.Notification) (int64, error)
+	Get(id int64) (*models.Notification, error)
+	// List returns a page of the user's notifications, newest first
+	List(userID int64, limit, offset int) ([]models.Notification, error)
+	// ListAfter returns up to limit of the user's notifications with id > afterID, oldest first
+	ListAfter(userID, afterID int64, limit int) ([]models.Notification, error)
+	CountUnread(userID int64) (int, error)
+	// MarkRead marks one of the user's notifications read; false if they have no such notification
+	MarkRead(id, userID int64) (bool, error)
+	MarkAllRead(userID int64) error
+}
+
+// TagStore manages users' interest tags
+type TagStore interface {
+	List(userID int64) ([]string, error)
+	Count(userID int64) (int, error)
+	// Add gives the user a tag; false if they already have it
+	Add(userID int64, tag string) (bool, error)
+	// Remove takes a tag from the user; false if they didn't have it
+	Remove(userID int64, tag string) (bool, error)
+	// Popular returns the most used tags (compared case-insensitively), most users first
+	Popular(limit int) ([]models.TagCount, error)
+	// CountUsersSharingTags counts the other set up, verified users with at least one of the user's tags
+	// (compared case-insensitively)
+	CountUsersSharingTags(userID int64) (int64, error)
+}
+
+// PictureStore manages profile pictures, kept in slots 0-4 (slot 0 is the profile picture)
+type PictureStore interface {
+	// List returns the user's pictures by slot
+	List(userID int64) ([]models.UserPicture, error)
+	// Save puts filePath in the slot, replacing the picture there, and keeps users.profile_picture_id
+	// pointing at slot 0. Returns the replaced file path, or "" if the slot was empty.
+	Save(userID int64, slot int, filePath string, isProfile bool) (string, error)
+	// Swap exchanges the pictures of two slots
+	Swap(userID int64, slotA, slotB int) error
+}
+
+// MessageStore manages chat messages, their attachments and edit history
+type MessageStore interface {
+	// CreateTx inserts an unread message inside the caller's transaction (see Store.Tx) and returns its id
+	CreateTx(tx *sql.Tx, m *models.Message) (int64, error)
+	// AddAttachmentTx stores an attachment's metadata inside the caller's transaction and returns its id
+	AddAttachmentTx(tx *sql.Tx, a *models.MessageAttachment) (int64, error)
+	Get(id int64) (*models.Message, error)
+	// List returns one page of the conversation between two users
+	List(userA, userB int64, page MessagePage) ([]models.Message, error)
+	// Attachments returns the attachments of the given messages by message id, oldest first
+	Attachments(messageIDs []int64) (map[int64][]models.MessageAttachment, error)
+	// GetAttachment returns an attachment and the message it was sent with
+	GetAttachment(id int64) (*models.MessageAttachment, *models.Message, error)
+
+	// Edit replaces the content of the sender's message and keeps the previous content in the edit history;
+	// false if the message was deleted meanwhile
+	Edit(id, senderID int64, content, previousContent string, editedAt time.Time) (bool, error)
+	// Delete soft-deletes the sender's message (content cleared, kept in the edit history);
+	// false if it was already deleted
+	Delete(id, senderID int64, previousContent string, deletedAt time.Time) (bool, error)
+	// MarkRead marks unread messages from senderID to readerID with fromID <= id <= upToID
+	// (upToID 0 = no upper bound) as read, and returns how many changed
+	MarkRead(readerID, senderID, fromID, upToID int64, readAt time.Time) (int64, error)
+
+	// Index makes a message findable by Search after it is sent or edited; Unindex removes it
+	Index(id int64, content string) error
+	Unindex(id int64) error
+	// Search finds the user's own (sent or received), not deleted messages containing every word of q
+	// as a prefix, newest first. ErrSearchUnavailable without FTS5 (SQLite only).
+	Search(userID int64, q string, limit, offset int) ([]MessageSearchResult, error)
+}
+
+// MessagePage selects a page of a conversation by message id. Without Forward the page ends before Before
+// (0 = the latest messages) and is returned newest first; with Forward it starts after After, oldest first.
+type MessagePage struct {
+	Before  int64
+	After   int64
+	Forward bool
+	Limit   int
+}
+
+// Markers around the matched words in MessageSearchResult.Snippet (control characters can't appear in
+// trimmed message text)
+const (
+	SnippetStart = "\x02"
+	SnippetEnd   = "\x03"
+)
+
+// snippetWords is roughly how many words of context a search snippet has
+const snippetWords = 16
+
+// MessageSearchResult is a message that matched a search, with a snippet of its content
+type MessageSearchResult struct {
+	Message models.Message
+	Snippet string
+}
+
+// Store holds one implementation of every repository
+type Store struct {
+	Users         UserStore
+	Likes         LikeStore
+	Views         ViewStore
+	Blocks        BlockStore
+	Notifications NotificationStore
+	Tags          TagStore
+	Pictures      PictureStore
+	Messages      MessageStore
+	Sessions      SessionStore
+	TwoFactor     TwoFactorStore
+	LoginLinks    LoginLinkStore
+	Identities    IdentityStore
+	EmailChanges  EmailChangeStore
+	EmailOutbox   EmailOutboxStore
+	Digests       DigestStore
+	Conversations ConversationStore
+	BotActivity   BotActivityStore
+	Outbox        OutboxStore
+
+	backend backend
+}
+
+// Tx runs fn in one write transaction, for writes that span repositories (the *Tx methods) or
+// other tables. With SQLite it runs on the write queue, so fn must not call methods that write.
+func (s *Store) Tx(fn func(tx *sql.Tx) error) error {
+	return s.backend.tx(fn)
+}
+
+// New returns the store for a database opened with database.Init(driver, ...)
+func New(driver string, db *sql.DB) (*Store, error) {
+	var b backend
+	switch driver {
+	case database.DriverSQLite, "":
+		fts, err := database.HasFTS5(db)
+		if err != nil {
+			return nil, err
+		}
+		b = &sqliteBackend{conn: db, fts: fts}
+	case database.DriverPostgres:
+		b = &postgresBackend{conn: db}
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+	return &Store{
+		Users:         &userStore{b},
+		Likes:         &likeStore{b},
+		Views:         &viewStore{b},
+		Blocks:        &blockStore{b},
+		Notifications: &notificationStore{b},
+		Tags:          &tagStore{b},
+		Pictures:      &pictureStore{b},
+		Messages:      &messageStore{b},
+		Sessions:      &sessionStore{b},
+		TwoFactor:     &twoFactorStore{b},
+		LoginLinks:    &loginLinkStore{b},
+		Identities:    &identityStore{b},
+		EmailChanges:  &emailChangeStore{b},
+		EmailOutbox:   &emailOutboxStore{b},
+		Digests:       &digestStore{b},
+		Conversations: &conversationStore{b},
+		BotActivity:   &botActivityStore{b},
+		Outbox:        &outboxStore{b},
+		backend:       b,
+	}, nil
+}
+
+var current *Store
+
+// Init creates the store used by Get. Call once at startup, after database.Init.
+func Init(driver string, db *sql.DB) error {
+	s, err := New(driver, db)
+	if err != nil {
+		return err
+	}
+	current = s
+	return nil
+}
+
+// Get returns the store created by Init
+func Get() *Store {
+	return current
+}
+
+// backend is what differs between SQLite and PostgreSQL. Reads go straight to db(); writes go through
+// exec and tx so SQLite can serialize them on the write queue.
+type backend interface {
+	db() *sql.DB
+	// exec runs one write and returns the number of rows it changed
+	exec(query string, args ...interface{}) (int64, error)
+	tx(fn func(tx *sql.Tx) error) error
+
+	indexMessage(id int64, content string) error
+	unindexMessage(id int64) error
+	searchMessages(userID int64, q string, limit, offset int) ([]MessageSearchResult, error)
+}
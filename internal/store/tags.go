@@ -0,0 +1,79 @@
+package store
+
+import "matcha/internal/models"
+
+type tagStore struct {
+	b backend
+}
+
+func (s *tagStore) List(userID int64) ([]string, error) {
+	rows, err := s.b.db().Query(`SELECT tag FROM user_tags WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (s *tagStore) Count(userID int64) (int, error) {
+	var count int
+	err := s.b.db().QueryRow(`SELECT COUNT(*) FROM user_tags WHERE user_id = ?`, userID).Scan(&count)
+	return count, err
+}
+
+func (s *tagStore) Add(userID int64, tag string) (bool, error) {
+	n, err := s.b.exec(`INSERT INTO user_tags (user_id, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`, userID, tag)
+	return n > 0, err
+}
+
+func (s *tagStore) Remove(userID int64, tag string) (bool, error) {
+	n, err := s.b.exec(`DELETE FROM user_tags WHERE user_id = ? AND tag = ?`, userID, tag)
+	return n > 0, err
+}
+
+func (s *tagStore) Popular(limit int) ([]models.TagCount, error) {
+	rows, err := s.b.db().Query(`
+		SELECT MIN(TRIM(tag)), COUNT(DISTINCT user_id) as user_count
+		FROM user_tags
+		WHERE tag IS NOT NULL AND TRIM(tag) != ''
+		GROUP BY LOWER(TRIM(tag))
+		ORDER BY user_count DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.TagCount{}
+	for rows.Next() {
+		var t models.TagCount
+		if err := rows.Scan(&t.Tag, &t.UserCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func (s *tagStore) CountUsersSharingTags(userID int64) (int64, error) {
+	var count int64
+	err := s.b.db().QueryRow(`
+		SELECT COUNT(DISTINCT ut.user_id)
+		FROM user_tags ut
+		INNER JOIN user_tags mine ON LOWER(TRIM(ut.tag)) = LOWER(TRIM(mine.tag))
+		WHERE ut.user_id != ?
+		AND mine.user_id = ?
+		AND ut.user_id IN (SELECT id FROM users WHERE is_setup = 1 AND is_email_verified = 1)
+	`, userID, userID).Scan(&count)
+	return count, err
+}
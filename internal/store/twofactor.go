@@ -0,0 +1,102 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type twoFactorStore struct {
+	b backend
+}
+
+func (s *twoFactorStore) Get(userID int64) (TOTP, error) {
+	var t TOTP
+	var secret sql.NullString
+	var enabled int
+	err := s.b.db().QueryRow(
+		`SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?`, userID,
+	).Scan(&secret, &enabled, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return t, ErrNotFound
+	}
+	if err != nil {
+		return t, err
+	}
+	t.Secret = secret.String
+	t.Enabled = enabled == 1
+	return t, nil
+}
+
+func (s *twoFactorStore) Begin(userID int64, secret string) error {
+	_, err := s.b.exec(
+		`UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE id = ? AND totp_enabled = 0`,
+		secret, userID,
+	)
+	return err
+}
+
+func (s *twoFactorStore) Enable(userID int64, step int64, codeHashes []string) error {
+	return s.b.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			`UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ? AND totp_secret IS NOT NULL`,
+			step, userID,
+		); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+func (s *twoFactorStore) Disable(userID int64) error {
+	return s.b.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			`UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?`, userID,
+		); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+		return err
+	})
+}
+
+func (s *twoFactorStore) AcceptStep(userID int64, step int64) (bool, error) {
+	n, err := s.b.exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	return n > 0, err
+}
+
+func (s *twoFactorStore) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	return s.b.tx(func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(
+			`INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)`, userID, h, now,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *twoFactorStore) UseRecoveryCode(userID int64, codeHash string) (bool, error) {
+	n, err := s.b.exec(
+		`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now().UTC(), userID, codeHash,
+	)
+	return n > 0, err
+}
+
+func (s *twoFactorStore) CountRecoveryCodes(userID int64) (int, error) {
+	var n int
+	err := s.b.db().QueryRow(
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID,
+	).Scan(&n)
+	return n, err
+}
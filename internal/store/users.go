@@ -0,0 +1,561 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"matcha/internal/database"
+	"matcha/internal/models"
+)
+
+type userStore struct {
+	b backend
+}
+
+// userColumns is what scanUser reads, in order. Queries select them from users aliased as u.
+const userColumns = `u.id, u.username, u.email, u.first_name, u.last_name,
+	COALESCE(u.gender, ''), COALESCE(u.sexual_preference, ''), COALESCE(u.biography, ''), u.birth_date,
+	COALESCE(u.fame_rating, 0), u.latitude, u.longitude, u.location_updated_at, COALESCE(u.location, ''),
+	COALESCE(u.openness, ''), COALESCE(u.conscientiousness, ''), COALESCE(u.extraversion, ''),
+	COALESCE(u.agreeableness, ''), COALESCE(u.neuroticism, ''), COALESCE(u.siblings, ''), COALESCE(u.mbti, ''),
+	COALESCE(u.caliper_profile, ''),
+	COALESCE((SELECT p.file_path FROM user_pictures p WHERE p.user_id = u.id AND p.is_profile = 1 AND p.order_index = 0 LIMIT 1), ''),
+	COALESCE(u.is_email_verified, 0), COALESCE(u.is_setup, 0), COALESCE(u.is_online, 0), COALESCE(u.is_bot, 0),
+	COALESCE(u.role, 'user'), u.failed_login_count, u.login_locked_until, u.totp_enabled, u.locale, u.digest_frequency, u.last_seen, COALESCE(u.profile_picture_id, 0), u.created_at, u.updated_at`
+
+func scanUser(row scanner, extra ...interface{}) (*models.User, error) {
+	var u models.User
+	var birthDate, locationUpdatedAt, loginLockedUntil, lastSeen, createdAt, updatedAt sql.NullTime
+	var latitude, longitude sql.NullFloat64
+	var isEmailVerified, isSetup, isOnline, isBot, totpEnabled int
+	dest := []interface{}{
+		&u.ID, &u.Username, &u.Email, &u.FirstName, &u.LastName,
+		&u.Gender, &u.SexualPreference, &u.Biography, &birthDate,
+		&u.FameRating, &latitude, &longitude, &locationUpdatedAt, &u.Location,
+		&u.Openness, &u.Conscientiousness, &u.Extraversion,
+		&u.Agreeableness, &u.Neuroticism, &u.Siblings, &u.MBTI,
+		&u.CaliperProfile,
+		&u.ProfilePicture,
+		&isEmailVerified, &isSetup, &isOnline, &isBot,
+		&u.Role, &u.FailedLoginCount, &loginLockedUntil, &totpEnabled, &u.Locale, &u.DigestFrequency, &lastSeen, &u.ProfilePictureID, &createdAt, &updatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	u.BirthDate = birthDate.Time
+	if latitude.Valid {
+		u.Latitude = &latitude.Float64
+	}
+	if longitude.Valid {
+		u.Longitude = &longitude.Float64
+	}
+	u.LocationUpdatedAt = timePtr(locationUpdatedAt)
+	u.LoginLockedUntil = timePtr(loginLockedUntil)
+	u.LastSeen = lastSeen.Time
+	u.CreatedAt = createdAt.Time
+	u.UpdatedAt = updatedAt.Time
+	u.IsEmailVerified = isEmailVerified == 1
+	u.IsSetup = isSetup == 1
+	u.IsOnline = isOnline == 1
+	u.IsBot = isBot == 1
+	u.TOTPEnabled = totpEnabled == 1
+	return &u, nil
+}
+
+// scanUsers reads rows of userColumns followed by extra; extra is called before each row to get
+// the destinations of the extra columns
+func scanUsers(rows *sql.Rows, extra func() []interface{}) ([]models.User, error) {
+	defer rows.Close()
+	users := []models.User{}
+	for rows.Next() {
+		var dest []interface{}
+		if extra != nil {
+			dest = extra()
+		}
+		u, err := scanUser(rows, dest...)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+func (s *userStore) Create(u *models.User) (int64, error) {
+	var id int64
+	// Initial fame rating of 1.0 (Level 1). New accounts get daily digests; the column defaults to off so
+	// that accounts from before digests weren't opted in.
+	err := s.b.tx(func(tx *sql.Tx) error {
+		var err error
+		id, err = database.InsertReturningID(tx,
+			`INSERT INTO users (username, email, password_hash, first_name, last_name, email_verification_token, locale, digest_frequency, is_setup, is_email_verified, fame_rating, created_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 1.0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+			u.Username, u.Email, u.PasswordHash, u.FirstName, u.LastName, u.EmailVerificationToken, u.Locale, models.DigestDaily,
+		)
+		return err
+	})
+	return id, err
+}
+
+func (s *userStore) GetByID(id int64) (*models.User, error) {
+	return scanUser(s.b.db().QueryRow(`SELECT `+userColumns+` FROM users u WHERE u.id = ?`, id))
+}
+
+func (s *userStore) GetByUsername(username string) (*models.User, error) {
+	return s.getWithCredentials(`u.username = ?`, username)
+}
+
+func (s *userStore) GetByEmail(email string) (*models.User, error) {
+	return s.getWithCredentials(`u.email = ?`, email)
+}
+
+// getWithCredentials returns the user matching where, with PasswordHash and EmailVerificationToken
+func (s *userStore) getWithCredentials(where string, arg interface{}) (*models.User, error) {
+	var passwordHash, token sql.NullString
+	u, err := scanUser(s.b.db().QueryRow(
+		`SELECT `+userColumns+`, u.password_hash, u.email_verification_token FROM users u WHERE `+where, arg,
+	), &passwordHash, &token)
+	if err != nil {
+		return nil, err
+	}
+	u.PasswordHash = passwordHash.String
+	u.EmailVerificationToken = token.String
+	return u, nil
+}
+
+func (s *userStore) UsernameExists(username string) (bool, error) {
+	return exists(s.b.db(), `SELECT 1 FROM users WHERE username = ?`, username)
+}
+
+func (s *userStore) EmailInUse(email string, exceptUserID int64) (bool, error) {
+	return exists(s.b.db(), `SELECT 1 FROM users WHERE email = ? AND id != ?`, email, exceptUserID)
+}
+
+func (s *userStore) VerifyEmail(token string) (bool, error) {
+	n, err := s.b.exec(
+		`UPDATE users SET is_email_verified = 1, email_verification_token = NULL WHERE email_verification_token = ?`,
+		token,
+	)
+	return n > 0, err
+}
+
+func (s *userStore) SetVerificationToken(userID int64, token string) error {
+	_, err := s.b.exec(`UPDATE users SET email_verification_token = ? WHERE id = ?`, token, userID)
+	return err
+}
+
+func (s *userStore) GetPasswordReset(email string) (int64, PasswordReset, error) {
+	var userID int64
+	var code, token sql.NullString
+	var expires sql.NullTime
+	var attempts int
+	err := s.b.db().QueryRow(
+		`SELECT id, password_reset_code, password_reset_token, password_reset_expires_at, password_reset_attempts
+		 FROM users WHERE email = ?`,
+		email,
+	).Scan(&userID, &code, &token, &expires, &attempts)
+	if err == sql.ErrNoRows {
+		return 0, PasswordReset{}, ErrNotFound
+	}
+	if err != nil {
+		return 0, PasswordReset{}, err
+	}
+	reset := PasswordReset{Code: code.String, Token: token.String, Attempts: attempts}
+	if expires.Valid {
+		reset.ExpiresAt = &expires.Time
+	}
+	return userID, reset, nil
+}
+
+func (s *userStore) SetPasswordReset(userID int64, reset PasswordReset) error {
+	_, err := s.b.exec(
+		`UPDATE users SET password_reset_code = ?, password_reset_token = ?, password_reset_expires_at = ?,
+		 password_reset_attempts = 0 WHERE id = ?`,
+		nullString(reset.Code), nullString(reset.Token), reset.ExpiresAt, userID,
+	)
+	return err
+}
+
+func (s *userStore) TakePasswordResetAttempt(userID int64, max int) (int, error) {
+	var n int
+	err := s.b.tx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE users SET password_reset_attempts = password_reset_attempts + 1
+			 WHERE id = ? AND password_reset_attempts < ?`,
+			userID, max,
+		)
+		if err != nil {
+			return err
+		}
+		if changed, err := res.RowsAffected(); err != nil || changed == 0 {
+			return err
+		}
+		return tx.QueryRow(`SELECT password_reset_attempts FROM users WHERE id = ?`, userID).Scan(&n)
+	})
+	return n, err
+}
+
+func (s *userStore) RecordLoginFailure(userID int64) (int, error) {
+	return s.increment(userID, "failed_login_count")
+}
+
+// increment adds one to an integer column of the user and returns the new value
+func (s *userStore) increment(userID int64, column string) (int, error) {
+	var n int
+	err := s.b.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE users SET `+column+` = `+column+` + 1 WHERE id = ?`, userID); err != nil {
+			return err
+		}
+		return tx.QueryRow(`SELECT `+column+` FROM users WHERE id = ?`, userID).Scan(&n)
+	})
+	return n, err
+}
+
+func (s *userStore) LockLogin(userID int64, until time.Time) error {
+	_, err := s.b.exec(`UPDATE users SET login_locked_until = ? WHERE id = ?`, until.UTC(), userID)
+	return err
+}
+
+func (s *userStore) ClearLoginFailures(userID int64) error {
+	_, err := s.b.exec(`UPDATE users SET failed_login_count = 0, login_locked_until = NULL WHERE id = ?`, userID)
+	return err
+}
+
+func (s *userStore) ResetPassword(token, passwordHash string) (int64, error) {
+	var userID int64
+	err := s.b.tx(func(tx *sql.Tx) error {
+		// expires_at NULL = no expiry (e.g. from the code flow)
+		err := tx.QueryRow(
+			`SELECT id FROM users
+			 WHERE password_reset_token = ? AND (password_reset_expires_at IS NULL OR password_reset_expires_at > ?)`,
+			token, time.Now().UTC(),
+		).Scan(&userID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`UPDATE users SET password_hash = ?, password_reset_token = NULL, password_reset_expires_at = NULL
+			 WHERE id = ?`,
+			passwordHash, userID,
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+func (s *userStore) UpdatePassword(userID int64, passwordHash string) error {
+	_, err := s.b.exec(`UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		passwordHash, userID)
+	return err
+}
+
+func (s *userStore) UpdateProfile(userID int64, changes ProfileChanges, tags []string) error {
+	updates := []string{}
+	args := []interface{}{}
+	set := func(column string, value interface{}) {
+		updates = append(updates, column+" = ?")
+		args = append(args, value)
+	}
+	for _, f := range []struct {
+		column string
+		value  *string
+	}{
+		{"first_name", changes.FirstName},
+		{"last_name", changes.LastName},
+		{"gender", changes.Gender},
+		{"sexual_preference", changes.SexualPreference},
+		{"biography", changes.Biography},
+		{"openness", changes.Openness},
+		{"conscientiousness", changes.Conscientiousness},
+		{"extraversion", changes.Extraversion},
+		{"agreeableness", changes.Agreeableness},
+		{"neuroticism", changes.Neuroticism},
+		{"siblings", changes.Siblings},
+		{"mbti", changes.MBTI},
+		{"caliper_profile", changes.CaliperProfile},
+		{"locale", changes.Locale},
+		{"digest_frequency", changes.DigestFrequency},
+		{"location", changes.Location},
+	} {
+		if f.value != nil {
+			set(f.column, *f.value)
+		}
+	}
+	if changes.Latitude != nil {
+		set("latitude", *changes.Latitude)
+	}
+	if changes.Longitude != nil {
+		set("longitude", *changes.Longitude)
+	}
+	if changes.Latitude != nil || changes.Longitude != nil {
+		updates = append(updates, "location_updated_at = CURRENT_TIMESTAMP")
+	}
+	updates = append(updates, "updated_at = CURRENT_TIMESTAMP")
+	args = append(args, userID)
+
+	// Profile fields and tags change together, so a failure never leaves a user without tags
+	return s.b.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("UPDATE users SET "+strings.Join(updates, ", ")+" WHERE id = ?", args...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM user_tags WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			// DO NOTHING: the same tag twice in the list is not an error
+			if _, err := tx.Exec(`INSERT INTO user_tags (user_id, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+				userID, tag); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *userStore) ResetProfile(userID int64) error {
+	return s.b.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`UPDATE users SET
+				gender = NULL,
+				sexual_preference = NULL,
+				biography = NULL,
+				birth_date = NULL,
+				fame_rating = 0.0,
+				latitude = NULL,
+				longitude = NULL,
+				location = NULL,
+				is_setup = 0,
+				profile_picture_id = NULL,
+				openness = NULL,
+				conscientiousness = NULL,
+				extraversion = NULL,
+				agreeableness = NULL,
+				neuroticism = NULL,
+				siblings = NULL,
+				mbti = NULL,
+				caliper_profile = NULL,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`,
+			userID,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM user_tags WHERE user_id = ?`, userID)
+		return err
+	})
+}
+
+func (s *userStore) CompleteSetup(userID int64) (bool, error) {
+	n, err := s.b.exec(`UPDATE users SET is_setup = 1 WHERE id = ?`, userID)
+	return n > 0, err
+}
+
+func (s *userStore) SetRole(username, role string) (bool, error) {
+	n, err := s.b.exec(`UPDATE users SET role = ?, updated_at = ? WHERE username = ?`, role, time.Now().UTC(), username)
+	return n > 0, err
+}
+
+func (s *userStore) SetPresence(userID int64, online bool) error {
+	_, err := s.b.exec(`UPDATE users SET is_online = ?, last_seen = CURRENT_TIMESTAMP WHERE id = ?`,
+		boolInt(online), userID)
+	return err
+}
+
+func (s *userStore) MarkOnline(userID int64) error {
+	_, err := s.b.exec(`UPDATE users SET is_online = 1 WHERE id = ?`, userID)
+	return err
+}
+
+func (s *userStore) TouchLastSeen(userID int64) error {
+	_, err := s.b.exec(`UPDATE users SET last_seen = CURRENT_TIMESTAMP WHERE id = ?`, userID)
+	return err
+}
+
+// exists reports whether query returns a row
+// queryRower is a *sql.DB or *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func exists(db queryRower, query string, args ...interface{}) (bool, error) {
+	var one int
+	err := db.QueryRow(query, args...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// nullString stores "" as NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// boolInt is the 0/1 the INTEGER flag columns hold
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (s *userStore) Discover(f DiscoverFilter) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.is_setup = 1 AND u.is_email_verified = 1`
+	args := []interface{}{}
+
+	if f.ViewerID > 0 {
+		query += ` AND u.id != ?
+			AND NOT EXISTS (
+				SELECT 1 FROM likes l1
+				WHERE l1.from_user_id = ? AND l1.to_user_id = u.id
+				AND EXISTS (SELECT 1 FROM likes l2 WHERE l2.from_user_id = u.id AND l2.to_user_id = ?)
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM blocks b
+				WHERE (b.blocker_id = ? AND b.blocked_id = u.id) OR (b.blocker_id = u.id AND b.blocked_id = ?)
+			)`
+		args = append(args, f.ViewerID, f.ViewerID, f.ViewerID, f.ViewerID, f.ViewerID)
+	}
+
+	if f.Gender != "" {
+		query += " AND u.gender = ?"
+		args = append(args, f.Gender)
+	}
+	if f.SeekingGender != "" {
+		query += " AND (u.sexual_preference = ? OR u.sexual_preference = 'both' OR u.sexual_preference IS NULL OR u.sexual_preference = '')"
+		args = append(args, f.SeekingGender)
+	}
+
+	// Ages count in years of birth, so birth dates compare as YYYY-MM-DD strings
+	year := time.Now().Year()
+	if f.MinAge > 0 {
+		query += " AND (u.birth_date IS NULL OR u.birth_date < ?)"
+		args = append(args, fmt.Sprintf("%04d-01-01", year-f.MinAge+1))
+	}
+	if f.MaxAge > 0 {
+		query += " AND (u.birth_date IS NULL OR u.birth_date >= ?)"
+		args = append(args, fmt.Sprintf("%04d-01-01", year-f.MaxAge))
+	}
+	if f.MinFame > 0 {
+		query += " AND u.fame_rating >= ?"
+		args = append(args, f.MinFame)
+	}
+
+	if len(f.AnyTags) > 0 {
+		placeholders := make([]string, len(f.AnyTags))
+		for i, tag := range f.AnyTags {
+			placeholders[i] = "?"
+			args = append(args, strings.ToLower(tag))
+		}
+		query += " AND EXISTS (SELECT 1 FROM user_tags ut WHERE ut.user_id = u.id AND LOWER(ut.tag) IN (" + strings.Join(placeholders, ",") + "))"
+	}
+	if f.LocationContains != "" {
+		query += " AND (u.location IS NOT NULL AND u.location != '' AND LOWER(u.location) LIKE ?)"
+		args = append(args, "%"+strings.ToLower(f.LocationContains)+"%")
+	}
+
+	if f.OrderByFame {
+		query += " ORDER BY u.fame_rating DESC"
+	}
+
+	rows, err := s.b.db().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows, nil)
+}
+
+func (s *userStore) Ranking(limit, offset int) ([]models.User, error) {
+	rows, err := s.b.db().Query(`
+		SELECT `+userColumns+` FROM users u
+		WHERE u.is_setup = 1
+		ORDER BY u.fame_rating DESC, u.id ASC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows, nil)
+}
+
+func (s *userStore) CountSetUp() (int, error) {
+	var count int
+	err := s.b.db().QueryRow(`SELECT COUNT(*) FROM users WHERE is_setup = 1`).Scan(&count)
+	return count, err
+}
+
+// traitGroups is how the values of each trait are grouped: personality types are upper case
+var traitGroups = map[Trait]string{
+	TraitMBTI:             "UPPER(TRIM(mbti))",
+	TraitGender:           "LOWER(TRIM(gender))",
+	TraitSexualPreference: "LOWER(TRIM(sexual_preference))",
+}
+
+func (s *userStore) CountByTrait(trait Trait) ([]TraitCount, error) {
+	group, ok := traitGroups[trait]
+	if !ok {
+		return nil, fmt.Errorf("unknown trait %q", trait)
+	}
+	column := string(trait)
+	rows, err := s.b.db().Query(`
+		SELECT MIN(TRIM(` + column + `)), COUNT(*) as cnt
+		FROM users
+		WHERE ` + column + ` IS NOT NULL AND TRIM(` + column + `) != ''
+		GROUP BY ` + group + `
+		ORDER BY cnt DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TraitCount{}
+	for rows.Next() {
+		var c TraitCount
+		if err := rows.Scan(&c.Value, &c.Count); err != nil {
+			return nil, err
+		}
+		c.Value = strings.TrimSpace(c.Value)
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+func (s *userStore) Activity(userID int64) (Activity, error) {
+	var a Activity
+	// A connection is a like given that was liked back
+	err := s.b.db().QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM likes WHERE from_user_id = ?),
+			(SELECT COUNT(*) FROM likes WHERE to_user_id = ?),
+			(SELECT COUNT(*) FROM messages WHERE from_user_id = ?),
+			(SELECT COUNT(*) FROM user_pictures WHERE user_id = ?),
+			(SELECT COUNT(*) FROM likes l1
+			 INNER JOIN likes l2 ON l1.from_user_id = l2.to_user_id AND l1.to_user_id = l2.from_user_id
+			 WHERE l1.from_user_id = ?),
+			(SELECT COUNT(*) FROM views WHERE viewed_id = ?)
+	`, userID, userID, userID, userID, userID, userID).Scan(
+		&a.LikesGiven, &a.LikesReceived, &a.MessagesSent, &a.Pictures, &a.Connections, &a.ViewsReceived,
+	)
+	return a, err
+}
+
+func (s *userStore) SetFameRating(userID int64, rating float64) error {
+	_, err := s.b.exec(`UPDATE users SET fame_rating = ? WHERE id = ?`, rating, userID)
+	return err
+}
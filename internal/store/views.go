@@ -0,0 +1,77 @@
+package store
+
+import "database/sql"
+
+type viewStore struct {
+	b backend
+}
+
+func (s *viewStore) Record(viewerID, viewedID int64) (bool, error) {
+	var recorded bool
+	err := s.b.tx(func(tx *sql.Tx) error {
+		var err error
+		recorded, err = s.RecordTx(tx, viewerID, viewedID)
+		return err
+	})
+	return recorded, err
+}
+
+func (s *viewStore) RecordTx(tx *sql.Tx, viewerID, viewedID int64) (bool, error) {
+	res, err := tx.Exec(`
+		INSERT INTO views (viewer_id, viewed_id, created_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING
+	`, viewerID, viewedID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *viewStore) Exists(viewerID, viewedID int64) (bool, error) {
+	return exists(s.b.db(), `SELECT 1 FROM views WHERE viewer_id = ? AND viewed_id = ?`, viewerID, viewedID)
+}
+
+func (s *viewStore) Visitors(userID int64, limit int) ([]Visitor, error) {
+	rows, err := s.b.db().Query(`
+		SELECT `+userColumns+`, v.created_at, liked.created_at,
+			CASE WHEN liked_back.from_user_id IS NULL THEN 0 ELSE 1 END
+		FROM (
+			SELECT MIN(id) as id FROM views WHERE viewed_id = ? GROUP BY viewer_id
+		) first_views
+		INNER JOIN views v ON v.id = first_views.id
+		INNER JOIN users u ON u.id = v.viewer_id
+		LEFT JOIN likes liked ON liked.from_user_id = u.id AND liked.to_user_id = ?
+		LEFT JOIN likes liked_back ON liked_back.from_user_id = ? AND liked_back.to_user_id = u.id
+		ORDER BY v.created_at DESC, v.id DESC
+		LIMIT ?
+	`, userID, userID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	type extra struct {
+		viewedAt, likedAt sql.NullTime
+		likedBack         int
+	}
+	var extras []*extra
+	users, err := scanUsers(rows, func() []interface{} {
+		e := &extra{}
+		extras = append(extras, e)
+		return []interface{}{&e.viewedAt, &e.likedAt, &e.likedBack}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	visitors := make([]Visitor, len(users))
+	for i, u := range users {
+		visitors[i] = Visitor{
+			User:      u,
+			ViewedAt:  extras[i].viewedAt.Time,
+			LikedAt:   timePtr(extras[i].likedAt),
+			LikedBack: extras[i].likedBack == 1,
+		}
+	}
+	return visitors, nil
+}
@@ -0,0 +1,164 @@
+-- Matcha Database Schema: everything before versioned migrations
+-- (legacy/schema.sql plus the legacy/add_*.sql columns and tables, folded in)
+
+-- Users table
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT UNIQUE NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    first_name TEXT NOT NULL,
+    last_name TEXT NOT NULL,
+    gender TEXT,
+    sexual_preference TEXT,
+    biography TEXT,
+    birth_date DATE,
+    fame_rating REAL DEFAULT 0.0,
+    latitude REAL,
+    longitude REAL,
+    location TEXT,
+    location_updated_at DATETIME,
+    is_email_verified INTEGER DEFAULT 0,
+    email_verification_token TEXT,
+    set_up INTEGER DEFAULT 0, -- unused, kept for compatibility (see legacy/remove_set_up_column.sql)
+    is_setup INTEGER DEFAULT 0,
+    is_online INTEGER DEFAULT 0,
+    is_bot INTEGER DEFAULT 0,
+    last_seen DATETIME,
+    profile_picture_id INTEGER,
+    -- Personality
+    openness TEXT,
+    conscientiousness TEXT,
+    extraversion TEXT,
+    agreeableness TEXT,
+    neuroticism TEXT,
+    siblings TEXT,
+    mbti TEXT,
+    caliper_profile TEXT,
+    -- Password reset
+    password_reset_code TEXT,
+    password_reset_expires_at DATETIME,
+    password_reset_token TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- User pictures table
+CREATE TABLE user_pictures (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    is_profile INTEGER DEFAULT 0,
+    order_index INTEGER DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- User tags/interests table
+CREATE TABLE user_tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, tag)
+);
+
+-- Likes table
+CREATE TABLE likes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    from_user_id INTEGER NOT NULL,
+    to_user_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (from_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (to_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(from_user_id, to_user_id)
+);
+
+-- Profile views table
+CREATE TABLE views (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    viewer_id INTEGER NOT NULL,
+    viewed_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (viewer_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (viewed_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Messages table
+CREATE TABLE messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    from_user_id INTEGER NOT NULL,
+    to_user_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    is_read INTEGER DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (from_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (to_user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Notifications table
+CREATE TABLE notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    message TEXT NOT NULL,
+    is_read INTEGER DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    related_user_id INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Blocks and reports
+CREATE TABLE blocks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    blocker_id INTEGER NOT NULL,
+    blocked_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(blocker_id, blocked_id)
+);
+
+CREATE TABLE reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reporter_id INTEGER NOT NULL,
+    reported_id INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (reported_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Bot activity (bot simulator dashboard)
+CREATE TABLE bot_activity_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    bot_id INTEGER NOT NULL,
+    bot_username TEXT NOT NULL,
+    action_type TEXT NOT NULL,
+    target_user_id INTEGER,
+    target_username TEXT,
+    details TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (bot_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (target_user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Indexes for better performance
+CREATE INDEX idx_users_username ON users(username);
+CREATE INDEX idx_users_email ON users(email);
+CREATE INDEX idx_users_location ON users(latitude, longitude);
+CREATE INDEX idx_users_is_bot ON users(is_bot);
+CREATE INDEX idx_likes_from_user ON likes(from_user_id);
+CREATE INDEX idx_likes_to_user ON likes(to_user_id);
+CREATE INDEX idx_views_viewer ON views(viewer_id);
+CREATE INDEX idx_views_viewed ON views(viewed_id);
+CREATE INDEX idx_messages_users ON messages(from_user_id, to_user_id);
+CREATE INDEX idx_notifications_user ON notifications(user_id, is_read);
+CREATE INDEX idx_notifications_related_user ON notifications(related_user_id);
+CREATE INDEX idx_blocks_blocker ON blocks(blocker_id);
+CREATE INDEX idx_blocks_blocked ON blocks(blocked_id);
+CREATE INDEX idx_reports_reporter ON reports(reporter_id);
+CREATE INDEX idx_reports_reported ON reports(reported_id);
+CREATE INDEX idx_bot_activity_bot_id ON bot_activity_log(bot_id);
+CREATE INDEX idx_bot_activity_created_at ON bot_activity_log(created_at DESC);
+CREATE INDEX idx_bot_activity_action_type ON bot_activity_log(action_type);
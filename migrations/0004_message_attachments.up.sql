@@ -0,0 +1,16 @@
+-- Chat attachments (images). Files live outside the public /uploads tree (data/attachments/<user_id>/)
+-- and are only served to the two participants through /api/attachments/:id
+CREATE TABLE message_attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    uploader_id INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    original_name TEXT,
+    content_type TEXT NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_message_attachments_message ON message_attachments(message_id);
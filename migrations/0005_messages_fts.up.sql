@@ -0,0 +1,13 @@
+-- migrate:requires fts5
+-- Full-text index over chat messages (FTS5). rowid = messages.id.
+-- Kept in sync by the application (send/edit/delete). A binary built without -tags sqlite_fts5
+-- skips this migration and has no chat search; it runs on the next `migrate up` with FTS5.
+CREATE VIRTUAL TABLE messages_fts USING fts5(
+    content,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+-- Backfill existing messages
+INSERT INTO messages_fts (rowid, content)
+SELECT id, content FROM messages
+WHERE deleted_at IS NULL AND content != '';
@@ -0,0 +1,14 @@
+-- Transactional outbox: side effects (notifications, fame, bot logs) written in the same transaction as
+-- the change that causes them, then run and retried by the server's dispatcher until they succeed
+CREATE TABLE outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL, -- JSON
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at DATETIME NOT NULL,
+    processed_at DATETIME, -- NULL = pending
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_outbox_pending ON outbox(processed_at, next_attempt_at);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN password_reset_attempts;
+ALTER TABLE users DROP COLUMN login_locked_until;
+ALTER TABLE users DROP COLUMN failed_login_count;
@@ -0,0 +1,27 @@
+-- Accounts at external OpenID Connect providers linked to users, so they can log in through them.
+-- A provider identifies the account by subject ("sub"), which never changes, unlike the email.
+CREATE TABLE user_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    provider TEXT NOT NULL, -- Name of the provider in the OIDC configuration
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '', -- As the provider last reported it
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_login_at DATETIME,
+    UNIQUE (provider, subject),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_identities_user ON user_identities(user_id);
+
+-- Logins in progress at a provider: what the callback needs to finish them. Each works once.
+CREATE TABLE oidc_login_states (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    state_hash TEXT NOT NULL UNIQUE, -- SHA-256 of the state parameter
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL, -- Must come back in the ID token
+    code_verifier TEXT NOT NULL, -- PKCE
+    link_user_id INTEGER, -- Set when a logged in user is linking an identity instead of logging in
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL
+);
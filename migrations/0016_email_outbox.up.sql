@@ -0,0 +1,17 @@
+-- Outgoing emails, sent by a background worker so requests don't wait on the mail server. Failed sends
+-- are retried with backoff; after the last attempt an email is dead (kept for inspection and retry).
+CREATE TABLE email_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    to_address TEXT NOT NULL,
+    template TEXT NOT NULL, -- Name of the email template, for inspection
+    subject TEXT NOT NULL,
+    message TEXT NOT NULL, -- The MIME message; emptied once sent, since it may carry login links
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, sent or dead
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    sent_at DATETIME
+);
+
+CREATE INDEX idx_email_outbox_due ON email_outbox(status, next_attempt_at);
@@ -0,0 +1,4 @@
+DROP INDEX idx_users_digest_token;
+ALTER TABLE users DROP COLUMN digest_token;
+ALTER TABLE users DROP COLUMN digest_sent_at;
+ALTER TABLE users DROP COLUMN digest_frequency;
@@ -0,0 +1,9 @@
+-- Email digests of unread notifications: how often the user gets one (off, daily or weekly), when the
+-- last was sent, and the token of the unsubscribe link in them. The token is stored as is since the
+-- same link is in every digest; it can only change the digest frequency. Existing users are not opted
+-- in; new accounts are created with daily digests.
+ALTER TABLE users ADD COLUMN digest_frequency TEXT NOT NULL DEFAULT 'off';
+ALTER TABLE users ADD COLUMN digest_sent_at DATETIME;
+ALTER TABLE users ADD COLUMN digest_token TEXT;
+
+CREATE UNIQUE INDEX idx_users_digest_token ON users(digest_token);
@@ -0,0 +1,4 @@
+-- Key from which each session derives its next refresh token (HMAC of the previous one), so that a
+-- refresh token presented again right after its rotation can be answered with the current one.
+-- Sessions started before this migration have none and get random tokens.
+ALTER TABLE sessions ADD COLUMN refresh_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL schema migrations so the server binary can apply them itself.
+//
+// Files are named NNNN_description.up.sql / NNNN_description.down.sql; NNNN is the schema version.
+// The top-level files are for SQLite; postgres/ has the same versions written for PostgreSQL.
+// legacy/ holds the unversioned scripts used before, kept only to adopt databases created with them.
+package migrations
+
+import "embed"
+
+//go:embed *.sql postgres/*.sql legacy/*.sql
+var FS embed.FS
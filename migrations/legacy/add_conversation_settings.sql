@@ -0,0 +1,12 @@
+-- Per-conversation preferences, one row per (user, other participant); missing row = defaults
+CREATE TABLE IF NOT EXISTS conversation_settings (
+    user_id INTEGER NOT NULL,
+    other_user_id INTEGER NOT NULL,
+    muted_until DATETIME, -- NULL = not muted; no message notifications while in the future
+    is_archived BOOLEAN NOT NULL DEFAULT 0,
+    is_pinned BOOLEAN NOT NULL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, other_user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (other_user_id) REFERENCES users(id) ON DELETE CASCADE
+);
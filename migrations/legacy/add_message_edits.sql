@@ -0,0 +1,17 @@
+-- Message editing and soft delete: messages keep their row, previous versions go to message_edits
+ALTER TABLE messages ADD COLUMN edited_at DATETIME;
+ALTER TABLE messages ADD COLUMN deleted_at DATETIME;
+
+-- Audit trail: one row per edit or delete with the content as it was before
+CREATE TABLE IF NOT EXISTS message_edits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    editor_id INTEGER NOT NULL,
+    action TEXT NOT NULL, -- 'edit' or 'delete'
+    previous_content TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (editor_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits(message_id);
@@ -0,0 +1,13 @@
+-- migrate:requires fts5
+-- Full-text index over chat messages (FTS5). rowid = messages.id.
+-- Kept in sync by the application (send/edit/delete), so a binary built without FTS5
+-- still sends messages; only search is unavailable. Build with -tags sqlite_fts5.
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+    content,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+-- Backfill existing messages (idempotent: skips ones already indexed)
+INSERT INTO messages_fts (rowid, content)
+SELECT id, content FROM messages
+WHERE deleted_at IS NULL AND content != '' AND id NOT IN (SELECT rowid FROM messages_fts);
@@ -0,0 +1,10 @@
+DROP TABLE bot_activity_log;
+DROP TABLE reports;
+DROP TABLE blocks;
+DROP TABLE notifications;
+DROP TABLE messages;
+DROP TABLE views;
+DROP TABLE likes;
+DROP TABLE user_tags;
+DROP TABLE user_pictures;
+DROP TABLE users;
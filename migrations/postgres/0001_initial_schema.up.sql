@@ -0,0 +1,149 @@
+-- Matcha Database Schema (PostgreSQL): same tables and columns as ../0001_initial_schema.up.sql.
+-- Flags stay INTEGER 0/1 like in SQLite, since queries compare them with = 1.
+
+-- Users table
+CREATE TABLE users (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT UNIQUE NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    first_name TEXT NOT NULL,
+    last_name TEXT NOT NULL,
+    gender TEXT,
+    sexual_preference TEXT,
+    biography TEXT,
+    birth_date DATE,
+    fame_rating DOUBLE PRECISION DEFAULT 0.0,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    location TEXT,
+    location_updated_at TIMESTAMPTZ,
+    is_email_verified INTEGER DEFAULT 0,
+    email_verification_token TEXT,
+    set_up INTEGER DEFAULT 0, -- unused, kept so both schemas have the same columns
+    is_setup INTEGER DEFAULT 0,
+    is_online INTEGER DEFAULT 0,
+    is_bot INTEGER DEFAULT 0,
+    last_seen TIMESTAMPTZ,
+    profile_picture_id BIGINT,
+    -- Personality
+    openness TEXT,
+    conscientiousness TEXT,
+    extraversion TEXT,
+    agreeableness TEXT,
+    neuroticism TEXT,
+    siblings TEXT,
+    mbti TEXT,
+    caliper_profile TEXT,
+    -- Password reset
+    password_reset_code TEXT,
+    password_reset_expires_at TIMESTAMPTZ,
+    password_reset_token TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- User pictures table
+CREATE TABLE user_pictures (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_path TEXT NOT NULL,
+    is_profile INTEGER DEFAULT 0,
+    order_index INTEGER DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- User tags/interests table
+CREATE TABLE user_tags (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tag TEXT NOT NULL,
+    UNIQUE(user_id, tag)
+);
+
+-- Likes table
+CREATE TABLE likes (
+    id BIGSERIAL PRIMARY KEY,
+    from_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    to_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(from_user_id, to_user_id)
+);
+
+-- Profile views table
+CREATE TABLE views (
+    id BIGSERIAL PRIMARY KEY,
+    viewer_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    viewed_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Messages table
+CREATE TABLE messages (
+    id BIGSERIAL PRIMARY KEY,
+    from_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    to_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    is_read INTEGER DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Notifications table
+CREATE TABLE notifications (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    message TEXT NOT NULL,
+    is_read INTEGER DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    related_user_id BIGINT
+);
+
+-- Blocks and reports
+CREATE TABLE blocks (
+    id BIGSERIAL PRIMARY KEY,
+    blocker_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(blocker_id, blocked_id)
+);
+
+CREATE TABLE reports (
+    id BIGSERIAL PRIMARY KEY,
+    reporter_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reported_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Bot activity (bot simulator dashboard)
+CREATE TABLE bot_activity_log (
+    id BIGSERIAL PRIMARY KEY,
+    bot_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    bot_username TEXT NOT NULL,
+    action_type TEXT NOT NULL,
+    target_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    target_username TEXT,
+    details TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for better performance
+CREATE INDEX idx_users_username ON users(username);
+CREATE INDEX idx_users_email ON users(email);
+CREATE INDEX idx_users_location ON users(latitude, longitude);
+CREATE INDEX idx_users_is_bot ON users(is_bot);
+CREATE INDEX idx_likes_from_user ON likes(from_user_id);
+CREATE INDEX idx_likes_to_user ON likes(to_user_id);
+CREATE INDEX idx_views_viewer ON views(viewer_id);
+CREATE INDEX idx_views_viewed ON views(viewed_id);
+CREATE INDEX idx_messages_users ON messages(from_user_id, to_user_id);
+CREATE INDEX idx_notifications_user ON notifications(user_id, is_read);
+CREATE INDEX idx_notifications_related_user ON notifications(related_user_id);
+CREATE INDEX idx_blocks_blocker ON blocks(blocker_id);
+CREATE INDEX idx_blocks_blocked ON blocks(blocked_id);
+CREATE INDEX idx_reports_reporter ON reports(reporter_id);
+CREATE INDEX idx_reports_reported ON reports(reported_id);
+CREATE INDEX idx_bot_activity_bot_id ON bot_activity_log(bot_id);
+CREATE INDEX idx_bot_activity_created_at ON bot_activity_log(created_at DESC);
+CREATE INDEX idx_bot_activity_action_type ON bot_activity_log(action_type);
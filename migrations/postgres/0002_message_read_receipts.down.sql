@@ -0,0 +1,2 @@
+DROP INDEX idx_messages_unread;
+ALTER TABLE messages DROP COLUMN read_at;
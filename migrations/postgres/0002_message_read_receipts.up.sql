@@ -0,0 +1,7 @@
+-- Read receipts: when the recipient read each message (is_read stays for unread counts)
+ALTER TABLE messages ADD COLUMN read_at TIMESTAMPTZ;
+
+-- Messages already marked read before this migration get their send time as best guess
+UPDATE messages SET read_at = created_at WHERE is_read = 1 AND read_at IS NULL;
+
+CREATE INDEX idx_messages_unread ON messages(to_user_id, from_user_id, is_read);
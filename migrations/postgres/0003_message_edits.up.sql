@@ -0,0 +1,15 @@
+-- Message editing and soft delete: messages keep their row, previous versions go to message_edits
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- Audit trail: one row per edit or delete with the content as it was before
+CREATE TABLE message_edits (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    editor_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    action TEXT NOT NULL, -- 'edit' or 'delete'
+    previous_content TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_message_edits_message ON message_edits(message_id);
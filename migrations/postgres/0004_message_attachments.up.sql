@@ -0,0 +1,14 @@
+-- Chat attachments (images). Files live outside the public /uploads tree (data/attachments/<user_id>/)
+-- and are only served to the two participants through /api/attachments/:id
+CREATE TABLE message_attachments (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    uploader_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_path TEXT NOT NULL,
+    original_name TEXT,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_message_attachments_message ON message_attachments(message_id);
@@ -0,0 +1,6 @@
+-- Full-text index over chat messages. Unlike SQLite's FTS5 table this is a generated column,
+-- so PostgreSQL keeps it in sync on send/edit/delete by itself.
+ALTER TABLE messages ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED;
+
+CREATE INDEX idx_messages_search ON messages USING GIN (search_vector);
@@ -0,0 +1,10 @@
+-- Per-conversation preferences, one row per (user, other participant); missing row = defaults
+CREATE TABLE conversation_settings (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    other_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    muted_until TIMESTAMPTZ, -- NULL = not muted; no message notifications while in the future
+    is_archived BOOLEAN NOT NULL DEFAULT FALSE,
+    is_pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, other_user_id)
+);
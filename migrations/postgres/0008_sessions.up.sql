@@ -0,0 +1,27 @@
+-- Login sessions. Each login starts a session holding a chain of refresh tokens: a refresh token is
+-- exchanged (rotated) for a new one, and presenting an already rotated token revokes the whole session.
+CREATE TABLE sessions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ, -- NULL = active (until expires_at)
+    revoke_reason TEXT, -- "logout", "logout_all", "reuse", "password_change"
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_sessions_user ON sessions(user_id);
+
+CREATE TABLE refresh_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    session_id BIGINT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE, -- SHA-256 of the token; the token itself is never stored
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    rotated_at TIMESTAMPTZ, -- NULL = the session's current token
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_refresh_tokens_session ON refresh_tokens(session_id);
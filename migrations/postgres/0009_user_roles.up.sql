@@ -0,0 +1,2 @@
+-- Roles for route authorization: "user" (everyone) or "admin" (admin-only routes)
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
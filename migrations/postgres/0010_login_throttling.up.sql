@@ -0,0 +1,5 @@
+-- Brute-force protection: consecutive failed logins (the account is locked for a growing time after
+-- too many) and wrong guesses of the current password reset code
+ALTER TABLE users ADD COLUMN failed_login_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN login_locked_until TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN password_reset_attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,18 @@
+-- TOTP two-factor authentication (RFC 6238). totp_secret is set when enrollment starts and totp_enabled
+-- once a code from it has been confirmed; totp_last_step is the time step of the last accepted code,
+-- so a code can't be used twice.
+ALTER TABLE users ADD COLUMN totp_secret TEXT;
+ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- One-time recovery codes, for when the authenticator is lost
+CREATE TABLE recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    code_hash TEXT NOT NULL, -- SHA-256 of the code; the code itself is only shown once
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMPTZ, -- NULL = unused
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recovery_codes_user ON recovery_codes(user_id);
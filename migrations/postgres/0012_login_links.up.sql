@@ -0,0 +1,13 @@
+-- Passwordless sign-in links sent by email. Each works once, until it expires.
+CREATE TABLE login_links (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE, -- SHA-256 of the token; the token itself is only in the email
+    ip_address TEXT NOT NULL DEFAULT '', -- Where the link was requested from
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ, -- NULL = not used yet
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_login_links_user ON login_links(user_id);
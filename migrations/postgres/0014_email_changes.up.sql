@@ -0,0 +1,18 @@
+-- Email changes waiting for (or past) confirmation. The new address confirms the change, and the old
+-- address gets a link to undo it, so a stolen session can't quietly take over the account's email.
+CREATE TABLE email_changes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    old_email TEXT NOT NULL,
+    new_email TEXT NOT NULL,
+    confirm_token_hash TEXT NOT NULL UNIQUE, -- SHA-256 of the token sent to the new address
+    revert_token_hash TEXT NOT NULL UNIQUE, -- SHA-256 of the token sent to the old address
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL, -- Until then the new address can confirm
+    revert_expires_at TIMESTAMPTZ NOT NULL, -- Until then the old address can undo the change
+    confirmed_at TIMESTAMPTZ, -- NULL = still pending
+    reverted_at TIMESTAMPTZ, -- Set when cancelled or undone from the old address
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_email_changes_user ON email_changes(user_id);
@@ -0,0 +1,2 @@
+-- Language of the emails sent to the user (e.g. "en", "fr"); empty = the default
+ALTER TABLE users ADD COLUMN locale TEXT NOT NULL DEFAULT '';
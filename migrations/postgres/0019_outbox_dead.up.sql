@@ -0,0 +1,2 @@
+-- Outbox events that failed outboxMaxAttempts times are dead: no longer retried, kept for inspection
+ALTER TABLE outbox ADD COLUMN dead_at TIMESTAMPTZ;
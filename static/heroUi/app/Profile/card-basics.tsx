@@ -23,6 +23,7 @@ interface CardBasicsProps {
   firstName: string;
   lastName: string;
   email: string;
+  pendingEmail?: string;
   user: { username?: string } | null;
   selectedGender: string;
   selectedPreference: string;
@@ -75,6 +76,7 @@ export default function CardBasics({
   firstName,
   lastName,
   email,
+  pendingEmail,
   user,
   selectedGender,
   selectedPreference,
@@ -142,6 +144,11 @@ export default function CardBasics({
           <TextField isRequired name="email" type="email" value={email} onChange={setEmail}>
             <Label>Email</Label>
             <Input variant="secondary" placeholder="Email" type="email" />
+            <Description>
+              {pendingEmail
+                ? `Waiting for you to confirm ${pendingEmail} from the link we sent there`
+                : "A new email is used once you confirm it from the link we send there"}
+            </Description>
           </TextField>
         </section>
 
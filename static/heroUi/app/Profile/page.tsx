@@ -136,6 +136,7 @@ export default function Component() {
   const [firstName, setFirstName] = React.useState<string>("");
   const [lastName, setLastName] = React.useState<string>("");
   const [email, setEmail] = React.useState<string>("");
+  const [pendingEmail, setPendingEmail] = React.useState<string>("");
   const [selectedGender, setSelectedGender] = React.useState<string>("");
   const [selectedPreference, setSelectedPreference] = React.useState<string>("");
   const [bio, setBio] = React.useState<string>("");
@@ -174,6 +175,7 @@ export default function Component() {
           setFirstName(profile.first_name || "");
           setLastName(profile.last_name || "");
           setEmail(profile.email || "");
+          setPendingEmail(profile.pending_email || "");
           setSelectedGender(profile.gender || "");
           // Default to "both" (bisexuality) if no preference is set
           setSelectedPreference(profile.sexual_preference || "both");
@@ -325,7 +327,7 @@ export default function Component() {
 
       addToast({
         title: "Profile updated successfully",
-        description: "Your profile has been saved.",
+        description: data.data?.pending_email ? data.data.message : "Your profile has been saved.",
         color: "primary",
       });
       // Refetch profile so tags and all fields stay in sync with server (prevents tags resetting)
@@ -458,6 +460,7 @@ export default function Component() {
             firstName={firstName}
             lastName={lastName}
             email={email}
+            pendingEmail={pendingEmail}
             user={user}
             selectedGender={selectedGender}
             selectedPreference={selectedPreference}
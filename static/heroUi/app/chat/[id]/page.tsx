@@ -36,6 +36,9 @@ export default function ChatPage() {
   const [chatUser, setChatUser] = React.useState<ChatUser | null>(null);
   const [isLoadingUser, setIsLoadingUser] = React.useState(true);
   const [isLoadingMessages, setIsLoadingMessages] = React.useState(true);
+  // Cursor for the page before the oldest loaded message (null when the start of the conversation is loaded)
+  const [olderCursor, setOlderCursor] = React.useState<number | null>(null);
+  const [isLoadingOlder, setIsLoadingOlder] = React.useState(false);
   const [isSending, setIsSending] = React.useState(false);
   const [messageText, setMessageText] = React.useState("");
   const [currentTime, setCurrentTime] = React.useState(new Date());
@@ -92,6 +95,24 @@ export default function ChatPage() {
     loadUser();
   }, [chatId, token, isInitialized, router]);
 
+  // Acknowledge a page that is shown: its unread messages from the other user are marked as read
+  // (the server sends them a read receipt)
+  const acknowledgeMessages = React.useCallback((page: Message[]) => {
+    if (!chatId || !token) return;
+    if (!page.some((m) => !m.is_from_current_user && !m.is_read)) return;
+    fetch(getApiUrl(`/api/messages/${chatId}/read`), {
+      method: "POST",
+      headers: {
+        Authorization: `Bearer ${token}`,
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({
+        from_message_id: page[0].id,
+        up_to_message_id: page[page.length - 1].id,
+      }),
+    }).catch(() => {});
+  }, [chatId, token]);
+
   // Load messages function (reusable); handles network errors without throwing
   const loadMessages = React.useCallback(async (showLoading = true) => {
     if (!chatId || !token) return;
@@ -109,8 +130,16 @@ export default function ChatPage() {
       if (messagesResponse.ok) {
         const messagesData = await messagesResponse.json();
         if (messagesData.success && messagesData.data) {
-          const newMessages = messagesData.data.messages || [];
+          const latest: Message[] = messagesData.data.messages || [];
+          acknowledgeMessages(latest);
+          if (showLoading) {
+            setOlderCursor(messagesData.data.next_cursor ?? null);
+          }
           setMessages((prevMessages) => {
+            // The latest page replaces what it covers; older pages loaded with "load older" are kept
+            const oldestLatestId = latest.length > 0 ? latest[0].id : Infinity;
+            const older = showLoading ? [] : prevMessages.filter((m) => m.id < oldestLatestId);
+            const newMessages = [...older, ...latest];
             if (prevMessages.length !== newMessages.length) return newMessages;
             const hasChanges = prevMessages.some((prev, idx) => {
               const newMsg = newMessages[idx];
@@ -143,7 +172,47 @@ export default function ChatPage() {
         setIsLoadingMessages(false);
       }
     }
-  }, [chatId, token]);
+  }, [chatId, token, acknowledgeMessages]);
+
+  // Load the page before the oldest loaded message
+  const loadOlderMessages = async () => {
+    if (!chatId || !token || olderCursor === null || isLoadingOlder) return;
+
+    setIsLoadingOlder(true);
+    try {
+      const response = await fetch(getApiUrl(`/api/messages/${chatId}?before=${olderCursor}`), {
+        headers: {
+          Authorization: `Bearer ${token}`,
+        },
+      });
+      if (!response.ok) {
+        addToast({
+          title: "Error",
+          description: "Failed to load older messages",
+          color: "danger",
+        });
+        return;
+      }
+      const data = await response.json();
+      if (data.success && data.data) {
+        const page: Message[] = data.data.messages || [];
+        acknowledgeMessages(page);
+        setMessages((prevMessages) => {
+          const loaded = new Set(prevMessages.map((m) => m.id));
+          return [...page.filter((m) => !loaded.has(m.id)), ...prevMessages];
+        });
+        setOlderCursor(data.data.next_cursor ?? null);
+      }
+    } catch (_err) {
+      addToast({
+        title: "Connection error",
+        description: "Could not load older messages. Check your connection.",
+        color: "danger",
+      });
+    } finally {
+      setIsLoadingOlder(false);
+    }
+  };
 
   // Initial load of messages
   React.useEffect(() => {
@@ -196,10 +265,11 @@ export default function ChatPage() {
     };
   }, [chatId, token, isInitialized, loadMessages]);
 
-  // Auto-scroll to bottom when messages change
+  // Auto-scroll to bottom when a new message arrives (not when older ones are loaded above)
+  const lastMessageId = messages.length > 0 ? messages[messages.length - 1].id : null;
   React.useEffect(() => {
     messagesEndRef.current?.scrollIntoView({ behavior: "smooth" });
-  }, [messages]);
+  }, [lastMessageId]);
 
   // Update timestamps dynamically (frontend only, no API calls)
   React.useEffect(() => {
@@ -407,6 +477,18 @@ export default function ChatPage() {
                 </div>
               ) : (
                 <div className="flex flex-col gap-3">
+                  {olderCursor !== null && (
+                    <div className="flex justify-center">
+                      <Button
+                        variant="ghost"
+                        size="sm"
+                        isPending={isLoadingOlder}
+                        onPress={loadOlderMessages}
+                      >
+                        Load older messages
+                      </Button>
+                    </div>
+                  )}
                   {messages.length === 0 ? (
                     <div className="flex flex-col items-center justify-center py-12 text-center">
                       <Icon icon="solar:chat-round-line-linear" className="text-6xl text-default-400 mb-4" />
@@ -0,0 +1,98 @@
+"use client";
+
+import React, { useEffect, useRef, useState, Suspense } from "react";
+import { useSearchParams } from "next/navigation";
+import { Link, Card } from "@heroui/react";
+import { Icon } from "@iconify/react";
+import { getApiUrl } from "@/lib/apiUrl";
+
+// Opened from the email change links: ?action=confirm from the new address, ?action=revert from the
+// old one. The token is posted (not fetched with GET) so that mail scanners opening the link don't use it up.
+function EmailChangeContent() {
+  const searchParams = useSearchParams();
+  const [status, setStatus] = useState<"working" | "success" | "error">("working");
+  const [message, setMessage] = useState("");
+  const [reverted, setReverted] = useState(false);
+  // A link works once: don't post it twice when effects run twice in development
+  const started = useRef(false);
+
+  useEffect(() => {
+    if (started.current) return;
+    started.current = true;
+
+    const action = searchParams.get("action");
+    const token = searchParams.get("token");
+    if (!token || (action !== "confirm" && action !== "revert")) {
+      setStatus("error");
+      setMessage("This link is incomplete.");
+      return;
+    }
+
+    const submit = async () => {
+      try {
+        const response = await fetch(getApiUrl(`/api/email-change/${action}`), {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ token }),
+        });
+        const data = await response.json();
+
+        if (!response.ok) {
+          setStatus("error");
+          setMessage(data.error || "This link is invalid, already used or expired.");
+          return;
+        }
+        setStatus("success");
+        setMessage(data.data?.message || "Done.");
+        setReverted(data.data?.reverted === true);
+      } catch (err) {
+        setStatus("error");
+        setMessage("An error occurred. Please try again.");
+      }
+    };
+
+    submit();
+  }, [searchParams]);
+
+  return (
+    <div className="flex h-full w-full items-center justify-center min-h-screen">
+      <Card className="w-full max-w-md mx-4 shadow-xl border border-default-200/50 dark:border-default-100/20">
+        <Card.Content className="p-8 md:p-10">
+          <div className="flex flex-col items-center gap-6 text-center">
+            <Icon icon="solar:letter-bold" className="text-6xl text-pink-500" />
+            {status === "working" && (
+              <>
+                <div className="animate-spin rounded-full h-14 w-14 border-2 border-primary/30 border-t-pink-500"></div>
+                <p className="text-default-500">Updating your email...</p>
+              </>
+            )}
+            {status === "success" && (
+              <>
+                <p className="text-success font-medium">{message}</p>
+                <Link href={reverted ? "/forgot-password" : "/login"} className="text-pink-500 underline">
+                  {reverted ? "Reset your password" : "Go to log in"}
+                </Link>
+              </>
+            )}
+            {status === "error" && (
+              <>
+                <p className="text-danger font-medium">{message}</p>
+                <Link href="/login" className="text-pink-500 underline">
+                  Back to log in
+                </Link>
+              </>
+            )}
+          </div>
+        </Card.Content>
+      </Card>
+    </div>
+  );
+}
+
+export default function EmailChangePage() {
+  return (
+    <Suspense fallback={<div className="flex min-h-screen w-full items-center justify-center">Loading...</div>}>
+      <EmailChangeContent />
+    </Suspense>
+  );
+}
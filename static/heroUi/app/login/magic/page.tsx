@@ -0,0 +1,100 @@
+"use client";
+
+import React, { useEffect, useRef, useState, Suspense } from "react";
+import { useRouter, useSearchParams } from "next/navigation";
+import { Link, Card } from "@heroui/react";
+import { Icon } from "@iconify/react";
+import { getApiUrl } from "@/lib/apiUrl";
+import { useAuth } from "@/contexts/AuthContext";
+
+// Opened from the sign-in link in the email. The token is posted (not fetched with GET) so that
+// mail scanners opening the link don't use it up.
+function MagicLinkContent() {
+  const router = useRouter();
+  const searchParams = useSearchParams();
+  const { login } = useAuth();
+  const [error, setError] = useState("");
+  // A link works once: don't post it twice when effects run twice in development
+  const started = useRef(false);
+
+  useEffect(() => {
+    if (started.current) return;
+    started.current = true;
+
+    const token = searchParams.get("token");
+    if (!token) {
+      setError("No sign-in token provided");
+      return;
+    }
+
+    const signIn = async () => {
+      try {
+        const response = await fetch(getApiUrl("/api/login/magic-link/verify"), {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ token }),
+        });
+        const data = await response.json();
+
+        if (!response.ok) {
+          setError(data.error || "This sign-in link is invalid or has expired.");
+          return;
+        }
+
+        // Two-factor authentication: the login page asks for the code
+        if (data.data?.mfa_required) {
+          sessionStorage.setItem("matcha_mfa_token", data.data.mfa_token);
+          router.replace("/login?mfa=1");
+          return;
+        }
+
+        const user = data.data.user;
+        login(data.data.token, {
+          id: user.id,
+          username: user.username,
+          email: user.email,
+          set_up: user.set_up,
+          is_setup: user.is_setup || false,
+        }, data.data.refresh_token, data.data.expires_in);
+        router.replace(user.is_setup ? "/discover" : "/runway");
+      } catch (err) {
+        setError("An error occurred while signing in. Please try again.");
+      }
+    };
+
+    signIn();
+  }, [searchParams, login, router]);
+
+  return (
+    <div className="flex h-full w-full items-center justify-center min-h-screen">
+      <Card className="w-full max-w-md mx-4 shadow-xl border border-default-200/50 dark:border-default-100/20">
+        <Card.Content className="p-8 md:p-10">
+          <div className="flex flex-col items-center gap-6 text-center">
+            <Icon icon="solar:key-minimalistic-bold" className="text-6xl text-pink-500" />
+            {error ? (
+              <>
+                <p className="text-danger font-medium">{error}</p>
+                <Link href="/login" className="text-pink-500 underline">
+                  Back to log in
+                </Link>
+              </>
+            ) : (
+              <>
+                <div className="animate-spin rounded-full h-14 w-14 border-2 border-primary/30 border-t-pink-500"></div>
+                <p className="text-default-500">Signing you in...</p>
+              </>
+            )}
+          </div>
+        </Card.Content>
+      </Card>
+    </div>
+  );
+}
+
+export default function MagicLinkPage() {
+  return (
+    <Suspense fallback={<div className="flex min-h-screen w-full items-center justify-center">Loading...</div>}>
+      <MagicLinkContent />
+    </Suspense>
+  );
+}
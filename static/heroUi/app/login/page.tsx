@@ -20,6 +20,12 @@ function LoginContent() {
   const [loading, setLoading] = React.useState(false);
   const [resending, setResending] = React.useState(false);
   const [emailNotVerified, setEmailNotVerified] = React.useState(false);
+  // Set when the account has two-factor authentication: the password was right, now a code is needed
+  const [mfaToken, setMfaToken] = React.useState("");
+  const [mfaCode, setMfaCode] = React.useState("");
+  const [sendingLink, setSendingLink] = React.useState(false);
+  const [providers, setProviders] = React.useState<{ name: string; display_name: string }[]>([]);
+  const [startingProvider, setStartingProvider] = React.useState("");
   const registered = searchParams.get("registered") === "true";
   const resetSuccess = searchParams.get("reset") === "success";
 
@@ -27,11 +33,87 @@ function LoginContent() {
     if (resetSuccess) setSuccess("Your password has been reset. You can now log in.");
   }, [resetSuccess]);
 
+  // Coming from a sign-in link of an account with two-factor authentication (see login/magic)
+  React.useEffect(() => {
+    if (searchParams.get("mfa") !== "1") return;
+    const token = sessionStorage.getItem("matcha_mfa_token");
+    sessionStorage.removeItem("matcha_mfa_token");
+    if (token) setMfaToken(token);
+  }, [searchParams]);
+
+  // Login providers (OpenID Connect) configured on the server
+  React.useEffect(() => {
+    fetch(getApiUrl("/api/oidc/providers"))
+      .then((response) => response.json())
+      .then((data) => setProviders(data.data?.providers || []))
+      .catch(() => setProviders([]));
+  }, []);
+
+  const handleProviderLogin = async (provider: string) => {
+    setStartingProvider(provider);
+    setError("");
+    setSuccess("");
+
+    try {
+      const response = await fetch(getApiUrl(`/api/oidc/${provider}/start`), {
+        method: "POST",
+        // The API sets the cookie the callback checks
+        credentials: "include",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({}),
+      });
+
+      const data = await response.json();
+
+      if (response.ok && data.data?.authorization_url) {
+        // The callback page needs to know which provider sent the user back
+        sessionStorage.setItem("matcha_oidc_provider", provider);
+        window.location.href = data.data.authorization_url;
+        return;
+      }
+      setError(data.error || "Failed to start login");
+    } catch (err) {
+      setError("An error occurred. Please try again.");
+    }
+    setStartingProvider("");
+  };
+
+  const handleSendMagicLink = async () => {
+    if (!username.trim()) {
+      setError("Please enter your username or email first");
+      return;
+    }
+
+    setSendingLink(true);
+    setError("");
+    setSuccess("");
+
+    try {
+      const response = await fetch(getApiUrl("/api/login/magic-link"), {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ username }),
+      });
+
+      const data = await response.json();
+
+      if (response.ok) {
+        setSuccess("If your account exists and is verified, a sign-in link is on its way to your email.");
+      } else {
+        setError(data.error || "Failed to send sign-in link");
+      }
+    } catch (err) {
+      setError("An error occurred. Please try again.");
+    } finally {
+      setSendingLink(false);
+    }
+  };
+
   const toggleVisibility = () => setIsVisible(!isVisible);
 
   const handleResendVerification = async () => {
     if (!username.trim()) {
-      setError("Please enter your username first");
+      setError("Please enter your username or email first");
       return;
     }
 
@@ -65,6 +147,65 @@ function LoginContent() {
     }
   };
 
+  // Stores the session of a successful login and moves on
+  const finishLogin = (data: any) => {
+    // Store token and user in auth context
+    if (data.data?.token && data.data?.user) {
+      const userData = {
+        id: data.data.user.id,
+        username: data.data.user.username || username, // Fallback to form username if not in response
+        email: data.data.user.email,
+        set_up: data.data.user.set_up,
+        is_setup: data.data.user.is_setup || false,
+      };
+      
+      login(data.data.token, userData, data.data.refresh_token, data.data.expires_in);
+    }
+
+    // Check if user needs to set up profile
+    if (data.data?.user?.is_setup === false || data.data?.user?.is_setup === 0) {
+      router.push("/runway");
+    } else {
+      router.push("/discover");
+    }
+  };
+
+  const handleMfaSubmit = async (event: React.FormEvent<HTMLFormElement>) => {
+    event.preventDefault();
+    setError("");
+
+    if (!mfaCode.trim()) {
+      setError("Enter the code from your authenticator app");
+      return;
+    }
+
+    setLoading(true);
+
+    try {
+      const response = await fetch(getApiUrl("/api/login/mfa"), {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ mfa_token: mfaToken, code: mfaCode.trim() }),
+      });
+
+      const data = await response.json();
+
+      if (response.ok) {
+        finishLogin(data);
+      } else {
+        setError(data.error || "Login failed");
+        setMfaCode("");
+        if (response.status === 401 && data.error?.includes("log in again")) {
+          setMfaToken("");
+        }
+      }
+    } catch (err) {
+      setError("An error occurred. Please try again.");
+    } finally {
+      setLoading(false);
+    }
+  };
+
   const handleSubmit = async (event: React.FormEvent<HTMLFormElement>) => {
     event.preventDefault();
     setError("");
@@ -73,7 +214,7 @@ function LoginContent() {
 
     // Basic required field check
     if (!username.trim() || !password) {
-      setError("Username and password are required");
+      setError("Username or email and password are required");
       return;
     }
 
@@ -96,25 +237,14 @@ function LoginContent() {
           return;
         }
 
-        // Store token and user in auth context
-        if (data.data?.token && data.data?.user) {
-          const userData = {
-            id: data.data.user.id,
-            username: data.data.user.username || username, // Fallback to form username if not in response
-            email: data.data.user.email,
-            set_up: data.data.user.set_up,
-            is_setup: data.data.user.is_setup || false,
-          };
-          
-          login(data.data.token, userData);
+        // Two-factor authentication: ask for a code
+        if (data.data?.mfa_required) {
+          setMfaToken(data.data.mfa_token);
+          setMfaCode("");
+          return;
         }
 
-        // Check if user needs to set up profile
-        if (data.data?.user?.is_setup === false || data.data?.user?.is_setup === 0) {
-          router.push("/runway");
-        } else {
-          router.push("/discover");
-        }
+        finishLogin(data);
       } else {
         setError(data.error || "Login failed");
         setEmailNotVerified(false);
@@ -169,6 +299,34 @@ function LoginContent() {
           </div>
         )}
 
+        {mfaToken ? (
+        <Form className="flex flex-col gap-4" validationBehavior="native" onSubmit={handleMfaSubmit}>
+          <TextField
+            isRequired
+            name="code"
+            value={mfaCode}
+            onChange={(v) => {
+              setMfaCode(v);
+              if (error) setError("");
+            }}
+          >
+            <Label>Authentication code</Label>
+            <Input
+              placeholder="6-digit code or a recovery code"
+              type="text"
+              variant="secondary"
+              autoComplete="one-time-code"
+              autoFocus
+            />
+          </TextField>
+          <Button className="w-full bg-pink-500 text-white hover:bg-pink-600" type="submit" isPending={loading}>
+            Verify
+          </Button>
+          <Button type="button" variant="ghost" onPress={() => { setMfaToken(""); setError(""); }}>
+            Back
+          </Button>
+        </Form>
+        ) : (
         <Form className="flex flex-col gap-4" validationBehavior="native" onSubmit={handleSubmit}>
           <TextField
             isRequired
@@ -180,9 +338,9 @@ function LoginContent() {
               if (success) setSuccess("");
             }}
           >
-            <Label>Username</Label>
+            <Label>Username or email</Label>
             <Input
-              placeholder="Enter your username"
+              placeholder="Enter your username or email"
               type="text"
               variant="secondary"
               autoComplete="username"
@@ -229,7 +387,23 @@ function LoginContent() {
           <Button className="w-full bg-pink-500 text-white hover:bg-pink-600" type="submit" isPending={loading}>
             Log In
           </Button>
+          <Button type="button" variant="ghost" className="w-full" onPress={handleSendMagicLink} isPending={sendingLink}>
+            Email me a sign-in link
+          </Button>
+          {providers.map((provider) => (
+            <Button
+              key={provider.name}
+              type="button"
+              variant="secondary"
+              className="w-full"
+              onPress={() => handleProviderLogin(provider.name)}
+              isPending={startingProvider === provider.name}
+            >
+              Continue with {provider.display_name}
+            </Button>
+          ))}
         </Form>
+        )}
         <p className="text-small text-center">
           <Link href="/register">
             Create an account
@@ -0,0 +1,125 @@
+"use client";
+
+import React, { useEffect, useRef, useState, Suspense } from "react";
+import { useSearchParams } from "next/navigation";
+import { Button, Link, Card } from "@heroui/react";
+import { Icon } from "@iconify/react";
+import { getApiUrl } from "@/lib/apiUrl";
+
+type Frequency = "off" | "daily" | "weekly";
+
+// Opened from the unsubscribe link in email digests (?token=). Turns digests off right away, then offers
+// to get them daily or weekly instead.
+function UnsubscribeContent() {
+  const searchParams = useSearchParams();
+  const token = searchParams.get("token");
+  const [status, setStatus] = useState<"working" | "success" | "error">("working");
+  const [message, setMessage] = useState("");
+  const [frequency, setFrequency] = useState<Frequency>("off");
+  const [saving, setSaving] = useState<Frequency | null>(null);
+  // Don't post twice when effects run twice in development
+  const started = useRef(false);
+
+  const update = async (next: Frequency) => {
+    try {
+      const response = await fetch(getApiUrl("/api/email-digest/unsubscribe"), {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token, frequency: next }),
+      });
+      const data = await response.json();
+
+      if (!response.ok) {
+        setStatus("error");
+        setMessage(data.error || "This unsubscribe link is invalid.");
+        return;
+      }
+      setStatus("success");
+      setFrequency(next);
+      setMessage(data.data?.message || "Done.");
+    } catch (err) {
+      setStatus("error");
+      setMessage("An error occurred. Please try again.");
+    }
+  };
+
+  useEffect(() => {
+    if (started.current) return;
+    started.current = true;
+
+    if (!token) {
+      setStatus("error");
+      setMessage("This link is incomplete.");
+      return;
+    }
+    update("off");
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [token]);
+
+  const choose = async (next: Frequency) => {
+    setSaving(next);
+    await update(next);
+    setSaving(null);
+  };
+
+  return (
+    <div className="flex h-full w-full items-center justify-center min-h-screen">
+      <Card className="w-full max-w-md mx-4 shadow-xl border border-default-200/50 dark:border-default-100/20">
+        <Card.Content className="p-8 md:p-10">
+          <div className="flex flex-col items-center gap-6 text-center">
+            <Icon icon="solar:bell-off-bold" className="text-6xl text-pink-500" />
+            {status === "working" && (
+              <>
+                <div className="animate-spin rounded-full h-14 w-14 border-2 border-primary/30 border-t-pink-500"></div>
+                <p className="text-default-500">Updating your email preferences...</p>
+              </>
+            )}
+            {status === "success" && (
+              <>
+                <p className="text-success font-medium">{message}</p>
+                <p className="text-default-500 text-sm">
+                  {frequency === "off"
+                    ? "Rather get a summary of what you missed now and then?"
+                    : "You can change this at any time."}
+                </p>
+                <div className="flex gap-2">
+                  {(["daily", "weekly", "off"] as Frequency[])
+                    .filter((option) => option !== frequency)
+                    .map((option) => (
+                      <Button
+                        key={option}
+                        variant="ghost"
+                        isPending={saving === option}
+                        onPress={() => choose(option)}
+                      >
+                        {option === "off" ? "Unsubscribe" : option === "daily" ? "Daily digest" : "Weekly digest"}
+                      </Button>
+                    ))}
+                </div>
+                <Link href="/login" className="text-pink-500 underline">
+                  Go to Matcha
+                </Link>
+              </>
+            )}
+            {status === "error" && (
+              <>
+                <p className="text-danger font-medium">{message}</p>
+                <Link href="/login" className="text-pink-500 underline">
+                  Back to log in
+                </Link>
+              </>
+            )}
+          </div>
+        </Card.Content>
+      </Card>
+    </div>
+  );
+}
+
+export default function UnsubscribePage() {
+  return (
+    <Suspense fallback={<div className="flex min-h-screen w-full items-center justify-center">Loading...</div>}>
+      <UnsubscribeContent />
+    </Suspense>
+  );
+}
@@ -1,6 +1,6 @@
 "use client";
 
-import React, { createContext, useContext, useState, useEffect } from "react";
+import React, { createContext, useContext, useState, useEffect, useRef } from "react";
 import { User } from "@/types";
 import { setAuthCookie, clearAuthCookie } from "@/lib/authCookie";
 
@@ -9,11 +9,16 @@ interface AuthContextType {
   token: string | null;
   isAuthenticated: boolean;
   isInitialized: boolean;
-  login: (token: string, user: User) => void;
+  login: (token: string, user: User, refreshToken?: string, expiresIn?: number) => void;
   logout: () => void;
   checkAuth: () => Promise<void>;
 }
 
+// Refresh the access token this long before it expires
+const REFRESH_MARGIN_MS = 60 * 1000;
+// Tabs share the tokens in localStorage; this lock lets one of them refresh at a time
+const REFRESH_LOCK = "matcha_token_refresh";
+
 const AuthContext = createContext<AuthContextType | undefined>(undefined);
 
 export function AuthProvider({ children }: { children: React.ReactNode }) {
@@ -21,22 +26,114 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
   const [token, setToken] = useState<string | null>(null);
   const [isInitialized, setIsInitialized] = useState(false);
 
-  const login = (newToken: string, userData: User) => {
-    setToken(newToken);
-    setUser(userData);
-    localStorage.setItem("token", newToken);
-    localStorage.setItem("user", JSON.stringify(userData));
-    setAuthCookie(newToken);
-  };
+  const refreshTimer = useRef<ReturnType<typeof setTimeout> | null>(null);
 
-  const logout = () => {
+  const clearAuth = () => {
+    if (refreshTimer.current) clearTimeout(refreshTimer.current);
     setToken(null);
     setUser(null);
     localStorage.removeItem("token");
     localStorage.removeItem("user");
+    localStorage.removeItem("refresh_token");
+    localStorage.removeItem("token_expires_at");
     clearAuthCookie();
   };
 
+  // Exchange the refresh token for a new access token shortly before the current one expires
+  const scheduleRefresh = (expiresAt: number) => {
+    if (refreshTimer.current) clearTimeout(refreshTimer.current);
+    const delay = Math.max(expiresAt - Date.now() - REFRESH_MARGIN_MS, 0);
+    refreshTimer.current = setTimeout(refreshSession, delay);
+  };
+
+  const storeTokens = (newToken: string, refreshToken: string, expiresIn: number) => {
+    const expiresAt = Date.now() + expiresIn * 1000;
+    setToken(newToken);
+    // "token" last: other tabs pick up the new tokens when it changes
+    localStorage.setItem("refresh_token", refreshToken);
+    localStorage.setItem("token_expires_at", String(expiresAt));
+    localStorage.setItem("token", newToken);
+    setAuthCookie(newToken);
+    scheduleRefresh(expiresAt);
+  };
+
+  // Adopt the tokens another tab stored (after refreshing, or logging in again)
+  const adoptStoredTokens = () => {
+    const storedToken = localStorage.getItem("token");
+    const expiresAt = Number(localStorage.getItem("token_expires_at"));
+    if (!storedToken) return;
+    setToken(storedToken);
+    setAuthCookie(storedToken);
+    if (expiresAt) scheduleRefresh(expiresAt);
+  };
+
+  // Only one tab refreshes: the others wait for the lock, then find the new tokens it stored
+  const refreshSession = async () => {
+    if (typeof navigator !== "undefined" && navigator.locks) {
+      await navigator.locks.request(REFRESH_LOCK, refreshIfDue);
+    } else {
+      await refreshIfDue();
+    }
+  };
+
+  const refreshIfDue = async () => {
+    const refreshToken = localStorage.getItem("refresh_token");
+    if (!refreshToken) return;
+    const expiresAt = Number(localStorage.getItem("token_expires_at"));
+    if (expiresAt && expiresAt - Date.now() > REFRESH_MARGIN_MS) {
+      // Another tab refreshed meanwhile
+      adoptStoredTokens();
+      return;
+    }
+    try {
+      const response = await fetch("/api/token/refresh", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      });
+      if (response.status === 401) {
+        // Session ended (logged out elsewhere, password changed, or the token was reused)
+        clearAuth();
+        return;
+      }
+      const data = await response.json();
+      if (!response.ok || !data.success) throw new Error(data.error || "Refresh failed");
+      storeTokens(data.data.token, data.data.refresh_token, data.data.expires_in);
+    } catch (err) {
+      // Network or server error: try again in a little while
+      refreshTimer.current = setTimeout(refreshSession, 30 * 1000);
+    }
+  };
+
+  const login = (newToken: string, userData: User, refreshToken?: string, expiresIn?: number) => {
+    setUser(userData);
+    localStorage.setItem("user", JSON.stringify(userData));
+    if (refreshToken && expiresIn) {
+      storeTokens(newToken, refreshToken, expiresIn);
+    } else {
+      setToken(newToken);
+      localStorage.setItem("token", newToken);
+      setAuthCookie(newToken);
+    }
+  };
+
+  const logout = () => {
+    // End the session on the server too, so its tokens stop working
+    const storedToken = localStorage.getItem("token");
+    const refreshToken = localStorage.getItem("refresh_token");
+    if (storedToken || refreshToken) {
+      fetch("/api/logout", {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          ...(storedToken ? { Authorization: `Bearer ${storedToken}` } : {}),
+        },
+        body: JSON.stringify({ refresh_token: refreshToken || "" }),
+      }).catch(() => {});
+    }
+    clearAuth();
+  };
+
   const checkAuth = async () => {
     const storedToken = localStorage.getItem("token");
     if (!storedToken) {
@@ -76,6 +173,10 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
         setToken(storedToken);
         setUser(userData);
         setAuthCookie(storedToken);
+        const expiresAt = Number(localStorage.getItem("token_expires_at"));
+        if (localStorage.getItem("refresh_token") && expiresAt) {
+          scheduleRefresh(expiresAt);
+        }
         // Optionally verify token is still valid
         checkAuth();
       } catch (err) {
@@ -85,6 +186,23 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
       }
     }
     setIsInitialized(true);
+
+    // Follow the other tabs: new tokens when one refreshed, no tokens when one logged out
+    const onStorage = (event: StorageEvent) => {
+      if (event.storageArea !== localStorage || event.key !== "token") return;
+      if (event.newValue) {
+        adoptStoredTokens();
+      } else {
+        if (refreshTimer.current) clearTimeout(refreshTimer.current);
+        setToken(null);
+        setUser(null);
+      }
+    };
+    window.addEventListener("storage", onStorage);
+    return () => {
+      window.removeEventListener("storage", onStorage);
+      if (refreshTimer.current) clearTimeout(refreshTimer.current);
+    };
     // eslint-disable-next-line react-hooks/exhaustive-deps
   }, []);
 
@@ -23,6 +23,8 @@ const KNOWN_PREFIXES: string[] = [
   "/forgot-password",
   "/reset-password",
   "/verify-email",
+  "/email-change",
+  "/unsubscribe",
   "/sign-up",
   "/trends",
   "/help",